@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var LIST_AUDIT_EVENTS_SUPPORTED_SORT = data.AuditSortSafeList
+
+// List the audit events matching the filters, most recent first by default
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	input.UserID = int64(app.readInt(queryStringValues, "user_id", 0, v))
+	input.RequestID = app.readString(queryStringValues, "request_id", "")
+	input.Action = app.readString(queryStringValues, "action", "")
+	input.Resource = app.readString(queryStringValues, "resource", "")
+	input.ResourceID = int64(app.readInt(queryStringValues, "resource_id", 0, v))
+	input.CreatedAfter = app.readTime(queryStringValues, "created_after", v)
+	input.CreatedBefore = app.readTime(queryStringValues, "created_before", v)
+	input.Filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	input.Filters.Sort = app.readString(queryStringValues, "sort", "-id")
+	input.SortSafeList = LIST_AUDIT_EVENTS_SUPPORTED_SORT
+
+	data.ValidateAuditFilter(v, input.AuditFilter)
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(input.AuditFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Reads an RFC 3339 timestamp from the query string, the zero time if the key is
+// missing. If it can't be parsed, the error is added to the validator
+func (app *application) readTime(queryStringValues url.Values, key string, v *validator.Validator) time.Time {
+	s := queryStringValues.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
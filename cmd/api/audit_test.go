@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+func TestListAuditEvents(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	// A create, an update and a delete, each with a request ID
+	changes := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodPost, "/v1/movies", map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}},
+		{http.MethodPatch, "/v1/movies/1", map[string]any{"title": "Moana 2"}},
+		{http.MethodDelete, "/v1/movies/1", nil},
+	}
+	for i, change := range changes {
+		headers := admin.Clone()
+		headers.Set("X-Request-ID", fmt.Sprintf("request-%d", i+1))
+
+		res, body := doTestRequest(t, ts, change.method, change.path, headers, change.body)
+		if res.StatusCode >= http.StatusBadRequest {
+			t.Fatalf("%s %s: got status %d: %s", change.method, change.path, res.StatusCode, body)
+		}
+	}
+
+	list := func(t *testing.T, query string) ([]*data.AuditEvent, data.Metadata) {
+		t.Helper()
+
+		res, body := doTestRequest(t, ts, http.MethodGet, "/v1/audit?"+query, admin, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+
+		var response struct {
+			Events   []*data.AuditEvent `json:"events"`
+			Metadata data.Metadata      `json:"metadata"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Events, response.Metadata
+	}
+
+	events, _ := list(t, "")
+	if len(events) != 3 || events[0].UserID == nil {
+		t.Fatalf("got %d events, want 3 by the admin", len(events))
+	}
+	adminID := *events[0].UserID
+
+	tests := []struct {
+		name    string
+		query   string
+		actions []string
+	}{
+		{name: "most recent first", query: "", actions: []string{data.AuditDelete, data.AuditUpdate, data.AuditCreate}},
+		{name: "sorted", query: "sort=id", actions: []string{data.AuditCreate, data.AuditUpdate, data.AuditDelete}},
+		{name: "action", query: "action=update", actions: []string{data.AuditUpdate}},
+		{name: "request", query: "request_id=request-1", actions: []string{data.AuditCreate}},
+		{name: "user", query: fmt.Sprintf("user_id=%d", adminID), actions: []string{data.AuditDelete, data.AuditUpdate, data.AuditCreate}},
+		{name: "another user", query: fmt.Sprintf("user_id=%d", adminID+1), actions: []string{}},
+		{name: "resource", query: "resource=movie&resource_id=1", actions: []string{data.AuditDelete, data.AuditUpdate, data.AuditCreate}},
+		{name: "another resource", query: "resource_id=2", actions: []string{}},
+		{name: "created before", query: "created_before=2000-01-01T00:00:00Z", actions: []string{}},
+		{name: "created after", query: "created_after=2000-01-01T00:00:00Z", actions: []string{data.AuditDelete, data.AuditUpdate, data.AuditCreate}},
+		{name: "page", query: "page=2&page_size=1", actions: []string{data.AuditUpdate}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, _ := list(t, tt.query)
+
+			actions := []string{}
+			for _, event := range events {
+				actions = append(actions, event.Action)
+			}
+			if fmt.Sprint(actions) != fmt.Sprint(tt.actions) {
+				t.Errorf("got actions %v, want %v", actions, tt.actions)
+			}
+		})
+	}
+
+	if _, metadata := list(t, "page=2&page_size=1"); metadata.TotalRecords != 3 || metadata.LastPage != 3 {
+		t.Errorf("got metadata %+v, want 3 pages of 1 event", metadata)
+	}
+
+	for _, query := range []string{"action=rename", "created_after=yesterday", "user_id=-1", "sort=action"} {
+		res, body := doTestRequest(t, ts, http.MethodGet, "/v1/audit?"+query, admin, nil)
+		if res.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d, want 422: %s", query, res.StatusCode, body)
+		}
+	}
+}
+
+func TestListAuditEventsRequiresAdmin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	userToken := createTestUser(t, app, "user@example.com", data.RoleUser)
+
+	res, body := doTestRequest(t, ts, http.MethodGet, "/v1/audit", nil, nil)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d, want 401: %s", res.StatusCode, body)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodGet, "/v1/audit", http.Header{"Authorization": {"Bearer " + userToken}}, nil)
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("user: got status %d, want 403: %s", res.StatusCode, body)
+	}
+}
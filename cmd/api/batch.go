@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Maximum number of operations accepted in a single batch request
+const BATCH_MOVIES_MAX_OPERATIONS = 100
+
+var BATCH_MOVIES_SUPPORTED_OPS = []string{"create", "patch", "delete"}
+
+// A single operation of a batch request. Movie holds a movieInput for creates and
+// a moviePatch for patches. If Version is set, patches and deletes fail with a
+// conflict unless the movie is at that version
+type batchOperation struct {
+	Op      string          `json:"op"`
+	ID      int64           `json:"id"`
+	Version *int32          `json:"version"`
+	Movie   json.RawMessage `json:"movie"`
+}
+
+type batchResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  any         `json:"error,omitempty"`
+}
+
+func (result batchResult) failed() bool {
+	return result.Status >= http.StatusBadRequest
+}
+
+// Returned from within the transaction of an atomic batch to roll it back
+var errBatchOperationFailed = errors.New("batch operation failed")
+
+// Create, patch and delete movies in a single request. With atomic=true every
+// operation runs in one transaction, which is rolled back if any of them fails.
+// Otherwise each operation runs in a transaction of its own, so its version check
+// and write can't be split by another request, and reports its own status
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	atomic := false
+	if s := r.URL.Query().Get("atomic"); s != "" {
+		atomic, err = strconv.ParseBool(s)
+		v.Check(err == nil, "atomic", "must be a boolean value")
+	}
+
+	v.Check(len(input.Operations) > 0, "operations", "must be provided")
+	v.Check(
+		len(input.Operations) <= BATCH_MOVIES_MAX_OPERATIONS,
+		"operations",
+		fmt.Sprintf("must not contain more than %d operations", BATCH_MOVIES_MAX_OPERATIONS),
+	)
+	for i, op := range input.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+		v.Check(validator.PermittedValue(op.Op, BATCH_MOVIES_SUPPORTED_OPS...), key+".op", "must be one of create, patch or delete")
+		v.Check(op.Op == "create" || op.ID > 0, key+".id", "must be provided")
+		v.Check(op.Op == "delete" || op.Movie != nil, key+".movie", "must be provided")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Loaded up front, an atomic batch holds the only connection while it runs
+	genres, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	results := make([]batchResult, 0, len(input.Operations))
+
+	if atomic {
+		err = app.movieModel(r).InTx(func(tx data.MovieTx) error {
+			for i, op := range input.Operations {
+				result, err := app.runBatchOperation(tx, genres, i, op)
+				if err != nil {
+					return err
+				}
+
+				results = append(results, result)
+				if result.failed() {
+					return errBatchOperationFailed
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, errBatchOperationFailed):
+				failed := results[len(results)-1]
+				env := envelope{
+					"error":     fmt.Sprintf("operation %d failed, no changes were applied", failed.Index),
+					"operation": failed,
+				}
+				err = app.writeResponse(w, r, failed.Status, env, nil)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+				}
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	} else {
+		for i, op := range input.Operations {
+			var result batchResult
+			err := app.movieModel(r).InTx(func(tx data.MovieTx) (err error) {
+				result, err = app.runBatchOperation(tx, genres, i, op)
+				return err
+			})
+			if err != nil {
+				app.logError(r, err)
+				result = batchResult{
+					Index:  i,
+					Op:     op.Op,
+					Status: http.StatusInternalServerError,
+					Error:  "the server encountered a problem and could not process the operation",
+				}
+			}
+			results = append(results, result)
+		}
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Run a single operation within tx, validating genres against the given vocabulary.
+// Client errors are reported in the result, only unexpected errors are returned
+func (app *application) runBatchOperation(tx data.MovieTx, genres data.GenreVocabulary, index int, op batchOperation) (batchResult, error) {
+	result := batchResult{Index: index, Op: op.Op}
+
+	fail := func(status int, message any) (batchResult, error) {
+		result.Status = status
+		result.Error = message
+		return result, nil
+	}
+
+	v := validator.New()
+
+	switch op.Op {
+	case "create":
+		var input movieInput
+		if err := decodeBatchMovie(op.Movie, &input); err != nil {
+			return fail(http.StatusBadRequest, err.Error())
+		}
+
+		if v.Struct(input); !v.Valid() {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+
+		movie := input.movie()
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+
+		if err := tx.Insert(movie); err != nil {
+			if errors.Is(err, data.ErrDuplicateExternalID) {
+				return fail(http.StatusUnprocessableEntity, map[string]string{
+					"external_id": "a movie with this external id already exists",
+				})
+			}
+			return result, err
+		}
+
+		result.Status = http.StatusCreated
+		result.Movie = movie
+
+	case "patch":
+		var patch moviePatch
+		if err := decodeBatchMovie(op.Movie, &patch); err != nil {
+			return fail(http.StatusBadRequest, err.Error())
+		}
+
+		if v.Struct(patch); !v.Valid() {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+
+		movie, err := tx.Get(op.ID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return fail(http.StatusNotFound, "the requested resource could not be found")
+			}
+			return result, err
+		}
+
+		if op.Version != nil && *op.Version != movie.Version {
+			return fail(http.StatusConflict, "unable to update the record due to an edit conflict")
+		}
+
+		patch.apply(movie)
+
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			return fail(http.StatusUnprocessableEntity, v.Errors)
+		}
+
+		err = tx.Update(movie)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				return fail(http.StatusConflict, "unable to update the record due to an edit conflict")
+			}
+			return result, err
+		}
+
+		result.Status = http.StatusOK
+		result.Movie = movie
+
+	case "delete":
+		if op.Version != nil {
+			movie, err := tx.Get(op.ID)
+			if err != nil {
+				if errors.Is(err, data.ErrRecordNotFound) {
+					return fail(http.StatusNotFound, "the requested resource could not be found")
+				}
+				return result, err
+			}
+
+			if *op.Version != movie.Version {
+				return fail(http.StatusConflict, "unable to delete the record due to an edit conflict")
+			}
+		}
+
+		err := tx.Delete(op.ID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return fail(http.StatusNotFound, "the requested resource could not be found")
+			}
+			return result, err
+		}
+
+		result.Status = http.StatusOK
+	}
+
+	return result, nil
+}
+
+// Decode the movie of an operation, as strictly as readJSON decodes request bodies
+func decodeBatchMovie(raw json.RawMessage, dst any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return jsonDecodeError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// A create which succeeds, then a delete of a missing movie which fails
+var failingBatch = map[string]any{
+	"operations": []map[string]any{
+		{"op": "create", "movie": map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}},
+		{"op": "delete", "id": 404},
+	},
+}
+
+func TestBatchAtomic(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/movies/batch?atomic=true", admin, failingBatch)
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("got status %d, want the status of the failed operation: %s", res.StatusCode, body)
+	}
+
+	var response struct {
+		Operation batchResult `json:"operation"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Operation.Index != 1 {
+		t.Errorf("got failed operation %d, want 1", response.Operation.Index)
+	}
+
+	// The create was rolled back with the failed delete
+	if got := countTestMovies(t, app); got != 0 {
+		t.Errorf("got %d movies, want none", got)
+	}
+}
+
+func TestBatchBestEffort(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/movies/batch", admin, failingBatch)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+
+	var response struct {
+		Results []batchResult `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []int
+	for _, result := range response.Results {
+		statuses = append(statuses, result.Status)
+	}
+	if want := []int{http.StatusCreated, http.StatusNotFound}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("got statuses %v, want %v", statuses, want)
+	}
+
+	if got := countTestMovies(t, app); got != 1 {
+		t.Errorf("got %d movies, want the created one", got)
+	}
+}
+
+func TestBatchVersions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	patched := insertTestMovie(t, app, "Moana")
+	deleted := insertTestMovie(t, app, "Arrival")
+	stale := patched.Version - 1
+
+	batch := map[string]any{
+		"operations": []map[string]any{
+			{"op": "patch", "id": patched.ID, "version": stale, "movie": map[string]any{"title": "Renamed"}},
+			{"op": "delete", "id": deleted.ID, "version": stale},
+			{"op": "patch", "id": patched.ID, "version": patched.Version, "movie": map[string]any{"title": "Renamed"}},
+			{"op": "delete", "id": deleted.ID, "version": deleted.Version},
+		},
+	}
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/movies/batch", admin, batch)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+
+	var response struct {
+		Results []batchResult `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []int
+	for _, result := range response.Results {
+		statuses = append(statuses, result.Status)
+	}
+	want := []int{http.StatusConflict, http.StatusConflict, http.StatusOK, http.StatusOK}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("got statuses %v, want %v", statuses, want)
+	}
+
+	movie, err := app.models.Movies.Get(patched.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.Title != "Renamed" || movie.Version != patched.Version+1 {
+		t.Errorf("got %q at version %d, want %q at version %d", movie.Title, movie.Version, "Renamed", patched.Version+1)
+	}
+
+	_, err = app.models.Movies.Get(deleted.ID)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("got %v for the deleted movie, want ErrRecordNotFound", err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/pkg/client"
+)
+
+// Tests of pkg/client against the real handlers, which it can't import itself
+
+func newTestClient(t *testing.T) (*client.Client, *application) {
+	t.Helper()
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	c := client.New(ts.URL)
+	c.RetryWait = time.Millisecond
+	c.Token = createTestUser(t, app, "admin@example.com", data.RoleAdmin)
+
+	return c, app
+}
+
+func createTestMovies(t *testing.T, c *client.Client, n int) []*client.Movie {
+	t.Helper()
+
+	movies := make([]*client.Movie, 0, n)
+	for i := range n {
+		movie, err := c.CreateMovie(context.Background(), client.Movie{
+			Title:   fmt.Sprintf("Movie %d", i+1),
+			Year:    int32(2000 + i),
+			Runtime: 100,
+			Genres:  []string{"drama"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		movies = append(movies, movie)
+	}
+
+	return movies
+}
+
+func TestClientTypedErrors(t *testing.T) {
+	c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := c.GetMovie(ctx, 404)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetMovie of a missing movie: got %v, want ErrNotFound", err)
+	}
+
+	_, err = c.CreateMovie(ctx, client.Movie{Title: "Moana", Year: 1700, Runtime: 107, Genres: []string{"animation"}})
+	var validationErr *client.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("CreateMovie of an invalid movie: got %v, want a ValidationError", err)
+	}
+	if validationErr.Errors["year"] == "" {
+		t.Errorf("got validation errors %v, want one for year", validationErr.Errors)
+	}
+
+	// Invalid tokens are rejected rather than served anonymously
+	c.Token = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	_, err = c.GetMovie(ctx, 1)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request with an invalid token: got %v, want a 401 APIError", err)
+	}
+}
+
+func TestClientVersionConflicts(t *testing.T) {
+	c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	movie := createTestMovies(t, c, 1)[0]
+	title := "Renamed"
+
+	updated, err := c.UpdateMovie(ctx, movie.ID, movie.Version, client.MovieUpdate{Title: &title})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != title || updated.Version != movie.Version+1 {
+		t.Errorf("got %q at version %d, want %q at version %d", updated.Title, updated.Version, title, movie.Version+1)
+	}
+
+	// The movie moved on since the first version was read
+	_, err = c.UpdateMovie(ctx, movie.ID, movie.Version, client.MovieUpdate{Title: &title})
+	if !errors.Is(err, client.ErrEditConflict) {
+		t.Errorf("UpdateMovie at a stale version: got %v, want ErrEditConflict", err)
+	}
+
+	replacement := *updated
+	replacement.Title = "Replaced"
+	_, err = c.ReplaceMovie(ctx, movie.ID, movie.Version, replacement)
+	if !errors.Is(err, client.ErrEditConflict) {
+		t.Errorf("ReplaceMovie at a stale version: got %v, want ErrEditConflict", err)
+	}
+
+	replaced, err := c.ReplaceMovie(ctx, movie.ID, updated.Version, replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.Title != "Replaced" {
+		t.Errorf("got title %q, want %q", replaced.Title, "Replaced")
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	app := newTestApplication(t)
+	routes := app.routes()
+
+	// The first requests fail with a temporary error, later ones reach the API
+	var attempts, failures atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if failures.Load() > 0 {
+			failures.Add(-1)
+			http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		routes.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	c := client.New(ts.URL)
+	c.RetryWait = time.Millisecond
+	c.Token = createTestUser(t, app, "admin@example.com", data.RoleAdmin)
+	c.MaxRetries = 3
+	ctx := context.Background()
+
+	movie := createTestMovies(t, c, 1)[0]
+
+	t.Run("idempotent requests are retried", func(t *testing.T) {
+		attempts.Store(0)
+		failures.Store(2)
+
+		got, err := c.GetMovie(ctx, movie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != movie.ID {
+			t.Errorf("got movie %d, want %d", got.ID, movie.ID)
+		}
+		if attempts.Load() != 3 {
+			t.Errorf("got %d attempts, want 3", attempts.Load())
+		}
+	})
+
+	t.Run("retries are bounded", func(t *testing.T) {
+		attempts.Store(0)
+		failures.Store(10)
+
+		_, err := c.GetMovie(ctx, movie.ID)
+		var apiErr *client.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("got %v, want a 503 APIError", err)
+		}
+		if attempts.Load() != 4 {
+			t.Errorf("got %d attempts, want 4", attempts.Load())
+		}
+	})
+
+	t.Run("other requests aren't retried", func(t *testing.T) {
+		attempts.Store(0)
+		failures.Store(1)
+
+		_, err := c.CreateMovie(ctx, client.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}})
+		var apiErr *client.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("got %v, want a 503 APIError", err)
+		}
+		if attempts.Load() != 1 {
+			t.Errorf("got %d attempts, want 1", attempts.Load())
+		}
+	})
+
+	t.Run("waits stop with the context", func(t *testing.T) {
+		failures.Store(10)
+		c.RetryWait = time.Hour
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		_, err := c.GetMovie(ctx, movie.ID)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want context.DeadlineExceeded", err)
+		}
+	})
+}
+
+func TestClientMoviePages(t *testing.T) {
+	c, _ := newTestClient(t)
+	ctx := context.Background()
+
+	created := createTestMovies(t, c, 5)
+
+	pages := c.ListMovies(ctx, client.ListMoviesParams{PageSize: 2, Sort: "id"})
+
+	var ids []int64
+	var count int
+	for pages.Next() {
+		count++
+		if got := pages.Metadata().CurrentPage; got != count {
+			t.Errorf("got page %d, want %d", got, count)
+		}
+		for _, movie := range pages.Movies() {
+			ids = append(ids, movie.ID)
+		}
+	}
+	if err := pages.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 3 {
+		t.Errorf("got %d pages, want 3", count)
+	}
+	if len(ids) != len(created) {
+		t.Fatalf("got %d movies, want %d", len(ids), len(created))
+	}
+	for i, movie := range created {
+		if ids[i] != movie.ID {
+			t.Errorf("movie %d: got id %d, want %d", i, ids[i], movie.ID)
+		}
+	}
+
+	// Paging can start further in, and stops at the last page
+	rest, err := c.ListMovies(ctx, client.ListMoviesParams{PageSize: 2, Page: 2}).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 3 {
+		t.Errorf("got %d movies from page 2 on, want 3", len(rest))
+	}
+
+	// No movie matches, so there's no page
+	none := c.ListMovies(ctx, client.ListMoviesParams{Title: "no such movie"})
+	if none.Next() || none.Err() != nil {
+		t.Errorf("got a page or error %v for a listing without results", none.Err())
+	}
+}
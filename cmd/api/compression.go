@@ -0,0 +1,287 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content codings responses are compressed with and request bodies are read in, in
+// order of preference. zstd isn't offered as the standard library has no encoder
+// for it and the module sticks to the standard library and the SQLite driver
+var COMPRESSION_ENCODINGS = []string{"gzip", "deflate"}
+
+// Responses smaller than this are sent as is, compressing them isn't worth it
+const COMPRESSION_MIN_SIZE = 1024
+
+// Media types, or prefixes of them ending in /, that aren't compressed because
+// they're compressed already, or streamed events that must reach clients as is
+var COMPRESSION_SKIPPED_TYPES = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"text/event-stream",
+}
+
+var errUnsupportedContentEncoding = errors.New(
+	"unsupported Content-Encoding, use " + strings.Join(COMPRESSION_ENCODINGS, ", ") + " or identity",
+)
+
+// Return the content coding of COMPRESSION_ENCODINGS the client prefers according
+// to an Accept-Encoding header, or "" to send the response as is. identity only
+// wins when the header weighs it, directly or with *, above the other codings.
+// required is set when the client refused identity with identity;q=0, or *;q=0
+// without listing it, so even small responses must be compressed
+func negotiateEncoding(acceptEncoding string) (encoding string, required bool) {
+	weights := map[string]float64{}
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		weight := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			weight = q
+		}
+		weights[coding] = weight
+	}
+
+	best, bestWeight := "", 0.0
+	for _, coding := range COMPRESSION_ENCODINGS {
+		weight, ok := weights[coding]
+		if !ok {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = coding, weight
+		}
+	}
+
+	identityWeight, ok := weights["identity"]
+	if !ok {
+		identityWeight, ok = weights["*"]
+	}
+	if !ok {
+		// identity is always acceptable, but it's only preferred to the codings
+		// the client lists when it's listed as well
+		return best, false
+	}
+
+	// Codings are preferred to identity on ties, they're why the client listed them
+	if bestWeight < identityWeight {
+		return "", false
+	}
+
+	return best, best != "" && identityWeight == 0
+}
+
+// Compress response bodies with the content coding negotiated from the
+// Accept-Encoding header. Small bodies and the types of COMPRESSION_SKIPPED_TYPES
+// are sent as is
+func (app *application) compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response depends on the Accept-Encoding header, caches must know
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding, required := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding, required: required}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Response writer buffering the start of the body until it knows whether it's
+// worth compressing, then compressing the rest on the fly
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	// Set when the client refused identity, small bodies are compressed too
+	required bool
+	status   int
+	buf      []byte
+	started  bool
+	// Writer compressing the body, nil if it's sent as is
+	compressor io.WriteCloser
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.started {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	// Informational responses go out right away, they have no body
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressResponseWriter) Write(p []byte) (int, error) {
+	if cw.started {
+		if cw.compressor != nil {
+			return cw.compressor.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= COMPRESSION_MIN_SIZE {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Send the buffered body, compressed as long as large is set and its type allows it
+func (cw *compressResponseWriter) start(large bool) error {
+	cw.started = true
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+
+	if large && cw.compressible() {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+
+		switch cw.encoding {
+		case "gzip":
+			cw.compressor = gzip.NewWriter(cw.ResponseWriter)
+		case "deflate":
+			// HTTP's deflate is the zlib format
+			cw.compressor = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err := cw.Write(buf)
+	return err
+}
+
+// Report whether the response can be compressed, given its status and headers
+func (cw *compressResponseWriter) compressible() bool {
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	for _, skipped := range COMPRESSION_SKIPPED_TYPES {
+		if mediaType == skipped || (strings.HasSuffix(skipped, "/") && strings.HasPrefix(mediaType, skipped)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Send what was written so far to the client. A streamed response is compressed
+// even if it's small so far, as more is likely to follow
+func (cw *compressResponseWriter) Flush() {
+	if !cw.started {
+		if err := cw.start(true); err != nil {
+			return
+		}
+	}
+
+	if flusher, ok := cw.compressor.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return
+		}
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Let http.ResponseController reach the underlying writer, e.g. to set deadlines
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Finish the response once the handler returned
+func (cw *compressResponseWriter) close() {
+	if !cw.started {
+		cw.start(cw.required)
+	}
+
+	if cw.compressor != nil {
+		cw.compressor.Close()
+	}
+}
+
+// Return the body of a request, decompressed according to its Content-Encoding
+// header. Both the body as sent and once decompressed are limited to maxBytes, so
+// a small compressed body can't expand into an arbitrarily large one
+func decodeRequestBody(w http.ResponseWriter, r *http.Request, maxBytes int64) (io.ReadCloser, error) {
+	body := http.MaxBytesReader(w, r.Body, maxBytes)
+
+	var decompressed io.ReadCloser
+	var err error
+
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		decompressed, err = gzip.NewReader(body)
+	case "deflate":
+		decompressed, err = zlib.NewReader(body)
+	default:
+		return nil, errUnsupportedContentEncoding
+	}
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return nil, err
+		}
+		return nil, errors.New("body isn't valid for its Content-Encoding")
+	}
+
+	return http.MaxBytesReader(w, decompressed, maxBytes), nil
+}
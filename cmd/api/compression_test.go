@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		required       bool
+	}{
+		{acceptEncoding: "", encoding: ""},
+		{acceptEncoding: "gzip", encoding: "gzip"},
+		{acceptEncoding: "deflate", encoding: "deflate"},
+		{acceptEncoding: "br, zstd", encoding: ""},
+		{acceptEncoding: "gzip, deflate, br", encoding: "gzip"},
+		{acceptEncoding: "GZIP ; q=1", encoding: "gzip"},
+		{acceptEncoding: "gzip;q=0.5, deflate;q=0.8", encoding: "deflate"},
+		{acceptEncoding: "gzip;q=0, deflate", encoding: "deflate"},
+		{acceptEncoding: "gzip;q=0", encoding: ""},
+		{acceptEncoding: "gzip;q=high, deflate;q=0.1", encoding: "deflate"},
+		{acceptEncoding: "*", encoding: "gzip"},
+		{acceptEncoding: "*;q=0.5, gzip;q=0", encoding: "deflate"},
+		{acceptEncoding: "identity", encoding: ""},
+		{acceptEncoding: "identity;q=0.5, gzip;q=0.8", encoding: "gzip"},
+		{acceptEncoding: "identity, gzip;q=0.8", encoding: ""},
+		{acceptEncoding: "identity;q=0.8, deflate;q=0.8", encoding: "deflate"},
+		{acceptEncoding: "identity;q=0, gzip;q=0.1", encoding: "gzip", required: true},
+		{acceptEncoding: "*;q=0, deflate", encoding: "deflate", required: true},
+		{acceptEncoding: "*;q=0, identity, gzip", encoding: "gzip"},
+		// Nothing acceptable is left, the response is sent as is anyway
+		{acceptEncoding: "identity;q=0, br", encoding: ""},
+	}
+
+	for _, tt := range tests {
+		encoding, required := negotiateEncoding(tt.acceptEncoding)
+		if encoding != tt.encoding || required != tt.required {
+			t.Errorf("%q: got %q (required %t), want %q (required %t)", tt.acceptEncoding, encoding, required, tt.encoding, tt.required)
+		}
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	app := &application{}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		status         int
+		size           int
+		encoding       string
+	}{
+		{name: "large", acceptEncoding: "gzip", size: COMPRESSION_MIN_SIZE, encoding: "gzip"},
+		{name: "large deflate", acceptEncoding: "deflate", size: 10 * COMPRESSION_MIN_SIZE, encoding: "deflate"},
+		{name: "small", acceptEncoding: "gzip", size: COMPRESSION_MIN_SIZE - 1, encoding: ""},
+		{name: "not accepted", acceptEncoding: "", size: COMPRESSION_MIN_SIZE, encoding: ""},
+		{name: "small without identity", acceptEncoding: "gzip, identity;q=0", size: 10, encoding: "gzip"},
+		{name: "image", acceptEncoding: "gzip", contentType: "image/png", size: COMPRESSION_MIN_SIZE, encoding: ""},
+		{name: "archive", acceptEncoding: "gzip", contentType: "application/zip", size: COMPRESSION_MIN_SIZE, encoding: ""},
+		{name: "events", acceptEncoding: "gzip", contentType: "text/event-stream", size: COMPRESSION_MIN_SIZE, encoding: ""},
+		{name: "error", acceptEncoding: "gzip", status: http.StatusNotFound, size: COMPRESSION_MIN_SIZE, encoding: "gzip"},
+		{name: "no content", acceptEncoding: "gzip, identity;q=0", status: http.StatusNoContent, encoding: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("a", tt.size)
+			status := tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			handler := app.compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentType := tt.contentType
+				if contentType == "" {
+					contentType = "application/json"
+				}
+				w.Header().Set("Content-Type", contentType)
+				w.WriteHeader(status)
+				// Written in small parts, so the start of the body is buffered
+				for i := 0; i < len(body); i += 100 {
+					io.WriteString(w, body[i:min(i+100, len(body))])
+				}
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != status {
+				t.Errorf("got status %d, want %d", rr.Code, status)
+			}
+			if got := rr.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("got Content-Encoding %q, want %q", got, tt.encoding)
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want Accept-Encoding", got)
+			}
+
+			var reader io.Reader = rr.Body
+			var err error
+			switch tt.encoding {
+			case "gzip":
+				reader, err = gzip.NewReader(rr.Body)
+			case "deflate":
+				reader, err = zlib.NewReader(rr.Body)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("got a body of %d bytes, want %d", len(got), len(body))
+			}
+		})
+	}
+}
+
+// Compress data with a content coding
+func compressTestBody(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecodeRequestBody(t *testing.T) {
+	const maxBytes = 1024
+
+	small := []byte(strings.Repeat("a", maxBytes))
+	// Expands 200 times over the limit, but compresses to a few hundred bytes
+	bomb := make([]byte, 200*maxBytes)
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		want            []byte
+		openErr         bool
+		readErr         bool
+	}{
+		{name: "identity", contentEncoding: "", body: small, want: small},
+		{name: "explicit identity", contentEncoding: "identity", body: small, want: small},
+		{name: "gzip", contentEncoding: "gzip", body: compressTestBody(t, "gzip", small), want: small},
+		{name: "x-gzip", contentEncoding: "X-Gzip", body: compressTestBody(t, "gzip", small), want: small},
+		{name: "deflate", contentEncoding: "deflate", body: compressTestBody(t, "deflate", small), want: small},
+		{name: "unsupported", contentEncoding: "br", body: small, openErr: true},
+		{name: "invalid", contentEncoding: "gzip", body: small, openErr: true},
+		{name: "too large", contentEncoding: "", body: append(small, 'a'), readErr: true},
+		{name: "too large once decompressed", contentEncoding: "gzip", body: compressTestBody(t, "gzip", append(small, 'a')), readErr: true},
+		{name: "bomb", contentEncoding: "gzip", body: compressTestBody(t, "gzip", bomb), readErr: true},
+		{name: "deflate bomb", contentEncoding: "deflate", body: compressTestBody(t, "deflate", bomb), readErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.Contains(tt.name, "bomb") && len(tt.body) >= maxBytes {
+				t.Fatalf("got a compressed bomb of %d bytes, want it under the limit", len(tt.body))
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", tt.contentEncoding)
+
+			body, err := decodeRequestBody(httptest.NewRecorder(), r, maxBytes)
+			if tt.openErr {
+				if err == nil {
+					t.Error("got no error opening the body")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+
+			got, err := io.ReadAll(body)
+			if tt.readErr {
+				var maxBytesError *http.MaxBytesError
+				if !errors.As(err, &maxBytesError) {
+					t.Errorf("got %v reading the body, want a MaxBytesError", err)
+				}
+				if len(got) > maxBytes {
+					t.Errorf("got %d bytes read, want at most %d", len(got), maxBytes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got a body of %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(small))
+	r.Header.Set("Content-Encoding", "br")
+	if _, err := decodeRequestBody(httptest.NewRecorder(), r, maxBytes); !errors.Is(err, errUnsupportedContentEncoding) {
+		t.Errorf("got %v for an unsupported encoding, want errUnsupportedContentEncoding", err)
+	}
+}
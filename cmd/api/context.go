@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+	encodersContextKey  = contextKey("encoders")
+)
+
+// Return a copy of the request with the given User added to its context
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// Retrieve the User from the request context. It's only called when the
+// authenticate middleware ran, so a missing value is an unexpected error
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// Return a copy of the request with the given request ID added to its context
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+// Retrieve the request ID from the request context, empty if the requestID middleware didn't run
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// Return a copy of the request with the response formats acceptable to the client
+// added to its context
+func (app *application) contextSetResponseEncoders(r *http.Request, encoders []responseEncoder) *http.Request {
+	ctx := context.WithValue(r.Context(), encodersContextKey, encoders)
+	return r.WithContext(ctx)
+}
+
+// Retrieve the response formats acceptable to the client, all of them if the
+// negotiateResponses middleware didn't run
+func (app *application) contextGetResponseEncoders(r *http.Request) []responseEncoder {
+	encoders, ok := r.Context().Value(encodersContextKey).([]responseEncoder)
+	if !ok {
+		return RESPONSE_ENCODERS
+	}
+
+	return encoders
+}
+
+// Return who's making the request, as recorded along with the changes it makes
+func (app *application) actor(r *http.Request) data.Actor {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	return data.Actor{
+		UserID:    app.contextGetUser(r).ID,
+		RequestID: app.contextGetRequestID(r),
+		RemoteIP:  remoteIP,
+	}
+}
+
+// Return the movie model recording the actor of the request as the author of changes
+func (app *application) movieModel(r *http.Request) data.MovieModel {
+	return app.models.Movies.WithActor(app.actor(r))
+}
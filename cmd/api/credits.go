@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Data that's expected from the client to credit a person in a movie
+type creditInput struct {
+	PersonID  int64   `json:"person_id"`
+	Role      string  `json:"role"`
+	Character *string `json:"character"`
+}
+
+// Read the credit id from a HTTP request's parameters
+func (app *application) readCreditIDFromRequestParams(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("credit_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid credit id parameter")
+	}
+
+	return id, nil
+}
+
+// Return the credit model recording the actor of the request as the author of changes
+func (app *application) creditModel(r *http.Request) data.CreditModel {
+	return app.models.Credits.WithActor(app.actor(r))
+}
+
+// List the directors, writers and actors of a movie
+func (app *application) listMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	credits, err := app.models.Credits.GetForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Credit a person in a movie
+func (app *application) createMovieCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var input creditInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	credit := &data.Credit{
+		MovieID:   movie.ID,
+		PersonID:  input.PersonID,
+		Role:      input.Role,
+		Character: input.Character,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.creditModel(r).Insert(credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownPerson):
+			v.AddError("person_id", "no person with this id exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateCredit):
+			v.AddError("person_id", "the person is already credited in this role")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/credits", movie.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"credit": credit}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Remove a credit from a movie
+func (app *application) deleteMovieCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := app.readCreditIDFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.creditModel(r).Delete(movie.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "credit successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
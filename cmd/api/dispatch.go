@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Number of deliveries attempted at once
+const WEBHOOK_DISPATCH_BATCH_SIZE = 20
+
+// Time a receiver gets to answer a delivery
+const WEBHOOK_TIMEOUT = 10 * time.Second
+
+// Attempts of a delivery before it's dead
+const WEBHOOK_MAX_ATTEMPTS = 8
+
+// Delay before the second attempt of a delivery, doubled after each failure
+const WEBHOOK_BACKOFF = 30 * time.Second
+
+// Interval at which due deliveries are looked for when nothing wakes the dispatcher,
+// e.g. to retry failed ones or send those queued by other processes
+const WEBHOOK_DISPATCH_INTERVAL = 5 * time.Second
+
+// Bytes of the response of a failed attempt kept as its error
+const WEBHOOK_ERROR_MAX_BYTES = 512
+
+// Body of the requests delivering events to webhooks
+type webhookPayload struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+}
+
+// Client sending deliveries. Redirects aren't followed, a receiver must answer
+// itself
+func newWebhookClient() *http.Client {
+	return &http.Client{
+		Timeout: WEBHOOK_TIMEOUT,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Send the deliveries queued in the outbox to webhooks until the server shuts down.
+// Movie changes made through the API are sent right away, the others and retries
+// within the dispatch interval
+func (app *application) dispatchWebhooks() {
+	ticker := time.NewTicker(WEBHOOK_DISPATCH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		// Taken before reading the outbox, so deliveries queued meanwhile aren't missed
+		changed := app.models.Movies.Changed()
+
+		// More deliveries may be due right away
+		if app.dispatchWebhooksOnce() == WEBHOOK_DISPATCH_BATCH_SIZE {
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-app.webhookWake:
+		case <-ticker.C:
+		case <-app.shutdown:
+			return
+		}
+	}
+}
+
+// Have the dispatcher look for due deliveries now, e.g. after one was queued again
+func (app *application) wakeWebhookDispatcher() {
+	select {
+	case app.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// Attempt a batch of due deliveries, returning how many there were
+func (app *application) dispatchWebhooksOnce() (attempted int) {
+	// A panic in the background must not take the server down
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Printf("webhook dispatch panicked: %s", fmt.Sprint(err))
+		}
+	}()
+
+	deliveries, err := app.models.Webhooks.GetDueDeliveries(WEBHOOK_DISPATCH_BATCH_SIZE)
+	if err != nil {
+		app.logger.Printf("webhook dispatch failed: %s", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.deliverWebhook(delivery)
+		}()
+	}
+	wg.Wait()
+
+	return len(deliveries)
+}
+
+// Attempt a delivery and record its outcome: failed ones are retried with an
+// exponential backoff until they're dead
+func (app *application) deliverWebhook(delivery *data.DueWebhookDelivery) {
+	lastStatus, err := app.sendWebhook(delivery)
+	if err == nil {
+		err = app.models.Webhooks.MarkDelivered(delivery.ID)
+		if err != nil {
+			app.logger.Printf("webhook delivery %d: %s", delivery.ID, err)
+		}
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	dead := attempts >= WEBHOOK_MAX_ATTEMPTS
+	retryAfter := WEBHOOK_BACKOFF << (attempts - 1)
+
+	err = app.models.Webhooks.MarkFailed(delivery.ID, lastStatus, err.Error(), retryAfter, dead)
+	if err != nil {
+		app.logger.Printf("webhook delivery %d: %s", delivery.ID, err)
+		return
+	}
+
+	if dead {
+		app.logger.Printf("webhook delivery %d is dead after %d attempts", delivery.ID, attempts)
+	}
+}
+
+// POST a delivery to its webhook, returning the status of the response if there
+// was one and an error unless it's a 2xx
+func (app *application) sendWebhook(delivery *data.DueWebhookDelivery) (*int, error) {
+	body, err := json.Marshal(webhookPayload{
+		ID:        delivery.Event.ID,
+		Type:      delivery.EventType(),
+		CreatedAt: delivery.Event.CreatedAt,
+		MovieID:   delivery.Event.MovieID,
+		Version:   delivery.Event.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight-Webhooks/1.0")
+	req.Header.Set("X-Greenlight-Event", delivery.EventType())
+	req.Header.Set("X-Greenlight-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Greenlight-Signature", "t="+timestamp+",v1="+webhookSignature(delivery.Secret, timestamp, body))
+
+	res, err := app.webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	status := res.StatusCode
+	if status >= 200 && status < 300 {
+		// Drained so the connection is reused
+		io.Copy(io.Discard, io.LimitReader(res.Body, WEBHOOK_ERROR_MAX_BYTES))
+		return &status, nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(res.Body, WEBHOOK_ERROR_MAX_BYTES))
+	if len(message) == 0 {
+		return &status, fmt.Errorf("receiver responded %s", res.Status)
+	}
+	return &status, fmt.Errorf("receiver responded %s: %s", res.Status, message)
+}
+
+// Sign a delivery: the hex HMAC-SHA256, keyed by the webhook's secret, of the
+// timestamp of the attempt and the body joined by a dot
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+const testWebhookSecret = "0123456789abcdef"
+
+// A delivery as a receiver gets it
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// Start a webhook receiver answering with status, and subscribe it to every event
+func newTestReceiver(t *testing.T, app *application, status int) (*data.Webhook, func() []receivedWebhook) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var received []receivedWebhook
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		received = append(received, receivedWebhook{r.Header.Clone(), body})
+		mu.Unlock()
+
+		w.WriteHeader(status)
+		if status >= http.StatusBadRequest {
+			io.WriteString(w, "receiver is down")
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	webhook := &data.Webhook{URL: ts.URL, Events: []string{}, Secret: testWebhookSecret, Active: true}
+	if err := app.models.Webhooks.Insert(webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	return webhook, func() []receivedWebhook {
+		mu.Lock()
+		defer mu.Unlock()
+		return received
+	}
+}
+
+// The single delivery of a webhook
+func getTestDelivery(t *testing.T, app *application, webhook *data.Webhook) *data.WebhookDelivery {
+	t.Helper()
+
+	filters := data.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: data.WebhookSortSafeList}
+	deliveries, _, err := app.models.Webhooks.GetDeliveries(webhook.ID, "", filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+
+	return deliveries[0]
+}
+
+// Check a signature header the way receivers are told to
+func verifyWebhookSignature(header string, secret string, body []byte) bool {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return timestamp != "" && hmac.Equal([]byte(signature), []byte(expected))
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	app := newTestApplication(t)
+	webhook, received := newTestReceiver(t, app, http.StatusNoContent)
+
+	movie := insertTestMovie(t, app, "Moana")
+
+	if attempted := app.dispatchWebhooksOnce(); attempted != 1 {
+		t.Fatalf("got %d deliveries attempted, want 1", attempted)
+	}
+
+	deliveries := received()
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d requests, want 1", len(deliveries))
+	}
+	req := deliveries[0]
+
+	if !verifyWebhookSignature(req.header.Get("X-Greenlight-Signature"), testWebhookSecret, req.body) {
+		t.Errorf("got signature %q, which doesn't match the body", req.header.Get("X-Greenlight-Signature"))
+	}
+	if verifyWebhookSignature(req.header.Get("X-Greenlight-Signature"), "another secret", req.body) {
+		t.Error("the signature matches another secret")
+	}
+	if got := req.header.Get("X-Greenlight-Event"); got != "movie.created" {
+		t.Errorf("got event %q, want movie.created", got)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Type != "movie.created" || payload.MovieID != movie.ID || payload.Version != movie.Version {
+		t.Errorf("got payload %+v for movie %d at version %d", payload, movie.ID, movie.Version)
+	}
+
+	delivery := getTestDelivery(t, app, webhook)
+	if delivery.Status != data.WebhookDeliveryDelivered || delivery.Attempts != 1 || delivery.DeliveredAt == nil {
+		t.Errorf("got delivery %+v, want it delivered after 1 attempt", delivery)
+	}
+
+	// Nothing is due anymore
+	if attempted := app.dispatchWebhooksOnce(); attempted != 0 {
+		t.Errorf("got %d deliveries attempted, want none", attempted)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	app := newTestApplication(t)
+	webhook, received := newTestReceiver(t, app, http.StatusInternalServerError)
+
+	insertTestMovie(t, app, "Moana")
+
+	start := time.Now()
+	app.dispatchWebhooksOnce()
+
+	delivery := getTestDelivery(t, app, webhook)
+	if delivery.Status != data.WebhookDeliveryPending || delivery.Attempts != 1 {
+		t.Fatalf("got delivery %+v, want it pending after 1 attempt", delivery)
+	}
+	if delivery.LastStatus == nil || *delivery.LastStatus != http.StatusInternalServerError {
+		t.Errorf("got last status %v, want 500", delivery.LastStatus)
+	}
+	if delivery.LastError == nil || !strings.Contains(*delivery.LastError, "receiver is down") {
+		t.Errorf("got last error %v, want the body of the response", delivery.LastError)
+	}
+
+	// The second attempt waits for the backoff, timestamps are to the second
+	if delivery.NextAttemptAt == nil {
+		t.Fatal("got no next attempt")
+	}
+	if wait := delivery.NextAttemptAt.Sub(start); wait < WEBHOOK_BACKOFF-time.Second || wait > WEBHOOK_BACKOFF+time.Second {
+		t.Errorf("got the next attempt in %s, want %s", wait, WEBHOOK_BACKOFF)
+	}
+	if attempted := app.dispatchWebhooksOnce(); attempted != 0 {
+		t.Errorf("got %d deliveries attempted before the backoff, want none", attempted)
+	}
+
+	// The last attempt fails too, so the delivery is dead
+	_, err := app.models.Webhooks.DB.Exec(
+		"UPDATE webhook_deliveries SET attempts = $1, next_attempt_at = datetime('now') WHERE id = $2",
+		WEBHOOK_MAX_ATTEMPTS-1, delivery.ID,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.dispatchWebhooksOnce()
+
+	delivery = getTestDelivery(t, app, webhook)
+	if delivery.Status != data.WebhookDeliveryDead || delivery.Attempts != WEBHOOK_MAX_ATTEMPTS || delivery.NextAttemptAt != nil {
+		t.Errorf("got delivery %+v, want it dead after %d attempts", delivery, WEBHOOK_MAX_ATTEMPTS)
+	}
+	if got := len(received()); got != 2 {
+		t.Errorf("got %d requests, want 2", got)
+	}
+
+	// Queued again, its attempts start over
+	if _, err := app.models.Webhooks.Redeliver(webhook.ID, delivery.ID); err != nil {
+		t.Fatal(err)
+	}
+	if attempted := app.dispatchWebhooksOnce(); attempted != 1 {
+		t.Errorf("got %d deliveries attempted after a redelivery, want 1", attempted)
+	}
+}
+
+func TestWebhookDeliveriesOfPrunedEvents(t *testing.T) {
+	app := newTestApplication(t)
+	webhook, _ := newTestReceiver(t, app, http.StatusNoContent)
+
+	// The first event is delivered, the second is still pending
+	insertTestMovie(t, app, "Moana")
+	app.dispatchWebhooksOnce()
+	insertTestMovie(t, app, "Arrival")
+	insertTestMovie(t, app, "Heat")
+	_, err := app.models.Movies.DB.Exec(`UPDATE webhook_deliveries SET next_attempt_at = datetime('now', '+1 hour') WHERE status = 'pending'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.models.Movies.DB.Exec(`UPDATE movie_events SET created_at = datetime('now', '-2 days')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := app.models.Movies.PruneEvents(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Errorf("got %d events pruned, want the delivered one only", pruned)
+	}
+
+	filters := data.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: data.WebhookSortSafeList}
+	deliveries, _, err := app.models.Webhooks.GetDeliveries(webhook.ID, "", filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Status != data.WebhookDeliveryPending || deliveries[1].Status != data.WebhookDeliveryPending {
+		t.Errorf("got deliveries %+v, want the two pending ones", deliveries)
+	}
+
+	var count int
+	if err := app.models.Movies.DB.QueryRow(`SELECT count(*) FROM webhook_deliveries`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d deliveries in the outbox, want the one of the pruned event deleted", count)
+	}
+}
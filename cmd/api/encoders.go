@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A format responses can be written in
+type responseEncoder struct {
+	ContentType string
+	// Media types selecting the format in Accept headers
+	MediaTypes []string
+	// Write a response, or return errUnrepresentable if the format can't represent it
+	Encode func(w io.Writer, value any) error
+}
+
+var errUnrepresentable = errors.New("response can't be represented in this format")
+
+// Formats of responses, in order of preference when the client has none
+var RESPONSE_ENCODERS = []responseEncoder{
+	{"application/json", []string{"application/json"}, encodeJSONResponse},
+	{"application/xml; charset=utf-8", []string{"application/xml", "text/xml"}, encodeXMLResponse},
+	{"text/csv; charset=utf-8", []string{"text/csv"}, encodeCSVResponse},
+	{MSGPACK_CONTENT_TYPES[0], MSGPACK_CONTENT_TYPES, encodeMsgpackResponse},
+}
+
+// Root element of XML responses
+const XML_ROOT_ELEMENT = "response"
+
+// Return the encoders of RESPONSE_ENCODERS acceptable according to an Accept header,
+// the ones the client prefers first
+func negotiateResponseEncoders(accept string) []responseEncoder {
+	if strings.TrimSpace(accept) == "" {
+		return RESPONSE_ENCODERS
+	}
+
+	type mediaRange struct {
+		mediaType string
+		weight    float64
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		weight := 1.0
+		if q, ok := params["q"]; ok {
+			weight, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, weight})
+	}
+
+	weights := make(map[string]float64, len(RESPONSE_ENCODERS))
+	encoders := []responseEncoder{}
+
+	for _, encoder := range RESPONSE_ENCODERS {
+		// The most specific range matching a format sets its weight
+		specificity, weight := 0, 0.0
+		for _, r := range ranges {
+			kind, _, _ := strings.Cut(encoder.MediaTypes[0], "/")
+
+			s := 0
+			switch {
+			case slices.Contains(encoder.MediaTypes, r.mediaType):
+				s = 3
+			case r.mediaType == kind+"/*":
+				s = 2
+			case r.mediaType == "*/*":
+				s = 1
+			}
+			if s > specificity {
+				specificity, weight = s, r.weight
+			}
+		}
+
+		if weight > 0 {
+			weights[encoder.ContentType] = weight
+			encoders = append(encoders, encoder)
+		}
+	}
+
+	// Stable, so formats the client weighs the same keep the server's order
+	slices.SortStableFunc(encoders, func(a, b responseEncoder) int {
+		switch wa, wb := weights[a.ContentType], weights[b.ContentType]; {
+		case wa > wb:
+			return -1
+		case wa < wb:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return encoders
+}
+
+// Pick the response formats of each request from its Accept header. Requests that
+// make changes are refused with a 406 up front if no format is acceptable at all.
+// Whether an acceptable format can represent a response is only known once it's
+// written, so writeResponse answers those requests in JSON rather than with a 406
+// for a change that was made
+func (app *application) negotiateResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		encoders := negotiateResponseEncoders(r.Header.Get("Accept"))
+
+		// Safe requests go on regardless, as some handlers serve other formats, e.g. exports
+		if len(encoders) == 0 && !isSafeMethod(r.Method) {
+			app.notAcceptableResponse(w, r)
+			return
+		}
+
+		r = app.contextSetResponseEncoders(r, encoders)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Report whether a request method is safe, i.e. requests with it make no changes
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func encodeJSONResponse(w io.Writer, value any) error {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	// Just to make it easier to view in terminal
+	js = append(js, '\n')
+
+	_, err = w.Write(js)
+	return err
+}
+
+// Values are written as their JSON representation would be, e.g. a runtime as
+// "<n> minutes", objects as elements named after their keys and arrays as elements
+// holding an item element per value
+func encodeXMLResponse(w io.Writer, value any) error {
+	tree, err := orderedValue(value)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	if err := writeXMLElement(enc, XML_ROOT_ELEMENT, tree); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+var xmlNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// Write a value as an element. Keys that aren't valid element names are written as
+// an entry element with a key attribute
+func writeXMLElement(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !xmlNameRX.MatchString(name) || strings.HasPrefix(strings.ToLower(name), "xml") {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+
+	if value == nil {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "null"}, Value: "true"})
+	}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case nil:
+	case orderedObject:
+		for _, field := range value {
+			if err := writeXMLElement(enc, field.Key, field.Value); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range value {
+			if err := writeXMLElement(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := enc.EncodeToken(xml.CharData(scalarString(value))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// Write the rows of a list, e.g. the movies of a page without its metadata, or the
+// single record of a response, e.g. a movie. Other responses aren't representable
+func encodeCSVResponse(w io.Writer, value any) error {
+	tree, err := orderedValue(value)
+	if err != nil {
+		return err
+	}
+
+	rows, ok := csvRows(tree)
+	if !ok {
+		return errUnrepresentable
+	}
+
+	// Columns are every key of the rows, in the order they first appear
+	var columns []string
+	for _, row := range rows {
+		for _, field := range row {
+			if !slices.Contains(columns, field.Key) {
+				columns = append(columns, field.Key)
+			}
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(columns)
+
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for _, field := range row {
+			record[slices.Index(columns, field.Key)], err = csvCell(field.Value)
+			if err != nil {
+				return err
+			}
+		}
+		csvWriter.Write(record)
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// Return the records of a response written as CSV: the objects of its only array,
+// else the object it consists of
+func csvRows(tree any) ([]orderedObject, bool) {
+	env, ok := tree.(orderedObject)
+	if !ok {
+		return nil, false
+	}
+
+	var rows []orderedObject
+	arrays := 0
+
+	for _, field := range env {
+		items, ok := field.Value.([]any)
+		if !ok {
+			continue
+		}
+
+		arrays++
+		for _, item := range items {
+			row, ok := item.(orderedObject)
+			if !ok {
+				return nil, false
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	switch {
+	case arrays == 1:
+		return rows, true
+	case arrays == 0 && len(env) == 1:
+		row, ok := env[0].Value.(orderedObject)
+		return []orderedObject{row}, ok
+	default:
+		return nil, false
+	}
+}
+
+// Arrays of scalars, e.g. genres, are joined with |, like imports accept them, and
+// other nested values are written as JSON
+func csvCell(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case orderedObject:
+		js, err := json.Marshal(value)
+		return string(js), err
+	case []any:
+		items := make([]string, len(value))
+		for i, item := range value {
+			switch item.(type) {
+			case orderedObject, []any:
+				js, err := json.Marshal(value)
+				return string(js), err
+			}
+			items[i] = scalarString(item)
+		}
+		return strings.Join(items, "|"), nil
+	default:
+		return scalarString(value), nil
+	}
+}
+
+func encodeMsgpackResponse(w io.Writer, value any) error {
+	tree, err := orderedValue(value)
+	if err != nil {
+		return err
+	}
+
+	return writeMsgpack(w, tree)
+}
+
+// A JSON object whose keys keep their order, so that formats other than JSON list
+// fields in the same order
+type orderedObject []orderedField
+
+type orderedField struct {
+	Key   string
+	Value any
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(field.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// Return the JSON representation of a value decoded as nil, bool, json.Number,
+// string, []any and orderedObject values, so that every format renders values, e.g.
+// runtimes, as JSON does
+func orderedValue(value any) (any, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	return decodeOrderedValue(dec)
+}
+
+func decodeOrderedValue(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := orderedObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, orderedField{key.(string), value})
+		}
+		_, err = dec.Token()
+		return object, err
+
+	case json.Delim('['):
+		array := []any{}
+		for dec.More() {
+			value, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = dec.Token()
+		return array, err
+
+	default:
+		return token, nil
+	}
+}
+
+// Format a bool, json.Number or string decoded by orderedValue
+func scalarString(value any) string {
+	switch value := value.(type) {
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case string:
+		return value
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+func TestNegotiateResponses(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	t.Run("safe request without a representation", func(t *testing.T) {
+		headers := http.Header{"Accept": {"text/csv"}}
+
+		res, body := doTestRequest(t, ts, http.MethodGet, "/v1/healthcheck", headers, nil)
+		if res.StatusCode != http.StatusNotAcceptable {
+			t.Errorf("got status %d, want 406: %s", res.StatusCode, body)
+		}
+	})
+
+	t.Run("change without an acceptable format", func(t *testing.T) {
+		headers := admin.Clone()
+		headers.Set("Accept", "image/png")
+		movie := map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}
+
+		res, body := doTestRequest(t, ts, http.MethodPost, "/v1/movies", headers, movie)
+		if res.StatusCode != http.StatusNotAcceptable {
+			t.Errorf("got status %d, want 406: %s", res.StatusCode, body)
+		}
+
+		// Refused before the handler ran
+		if got := countTestMovies(t, app); got != 0 {
+			t.Errorf("got %d movies, want none", got)
+		}
+	})
+
+	t.Run("change without a representation", func(t *testing.T) {
+		movie := insertTestMovie(t, app, "Arrival")
+		headers := admin.Clone()
+		headers.Set("Accept", "text/csv")
+
+		res, body := doTestRequest(t, ts, http.MethodDelete, fmt.Sprintf("/v1/movies/%d", movie.ID), headers, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, want 200: %s", res.StatusCode, body)
+		}
+		if got := res.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+			t.Errorf("got Content-Type %q, want JSON", got)
+		}
+
+		_, err := app.models.Movies.Get(movie.ID)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			t.Errorf("got %v, want the movie deleted", err)
+		}
+	})
+}
@@ -6,6 +6,10 @@ import (
 )
 
 func (app *application) logError(r *http.Request, err error) {
+	if requestID := app.contextGetRequestID(r); requestID != "" {
+		app.logger.Printf("request %s: %s", requestID, err)
+		return
+	}
 	app.logger.Print(err)
 }
 
@@ -18,7 +22,7 @@ func (app *application) errorResponse(
 ) {
 	env := envelope{"error": message}
 
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeResponse(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -64,3 +68,33 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+// Helper method to be used to send a 401 to the client when its token is invalid
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// Helper method to be used to send a 401 to anonymous clients when a request needs a user
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// Helper method to be used to send a 403 to the client when it's authenticated but not allowed
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+const notAcceptableMessage = "the response can't be sent in a format accepted by the Accept header, " +
+	"use application/json, application/xml, text/csv or application/msgpack"
+
+// Helper method to be used to send a 406 to the client
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusNotAcceptable, notAcceptableMessage)
+}
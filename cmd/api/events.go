@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Delay before clients reconnect to a closed event stream, in milliseconds
+const MOVIE_EVENTS_RETRY = 3000
+
+// Number of events read from the change log at once
+const MOVIE_EVENTS_BATCH_SIZE = 100
+
+// Stream the changes to movies as Server-Sent Events. A client reconnecting with a
+// Last-Event-ID header first receives the events it missed, others only the
+// changes made after they connected. Clients whose last event is older than the
+// change log get a 410, since the events they missed were pruned
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var after int64
+	var err error
+
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		after, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || after < 0 {
+			app.badRequestResponse(w, r, errors.New("invalid Last-Event-ID header"))
+			return
+		}
+
+		oldest, err := app.models.Movies.OldestEventID()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// Ids never skip a value, so a gap means the events of the gap were pruned
+		if after < oldest-1 {
+			app.errorResponse(w, r, http.StatusGone, "the events following Last-Event-ID were pruned from the change log, "+
+				"reload the movies and reconnect without it")
+			return
+		}
+	} else {
+		after, err = app.models.Movies.LatestEventID()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// The stream stays open as long as the client wants it
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", MOVIE_EVENTS_RETRY)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	// The change log is also checked at each heartbeat, for changes made by other processes
+	heartbeat := time.NewTicker(app.config.events.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		// Taken before reading the log, so changes committed meanwhile aren't missed
+		changed := app.models.Movies.Changed()
+
+		events, err := app.models.Movies.Events(after, MOVIE_EVENTS_BATCH_SIZE)
+		if err != nil {
+			// Too late for an error response, the client resumes from its last event
+			app.logError(r, err)
+			return
+		}
+
+		for _, event := range events {
+			js, err := json.Marshal(event)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, js)
+			after = event.ID
+		}
+
+		if len(events) > 0 {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+
+		// Catch up with the rest of the log before waiting
+		if len(events) == MOVIE_EVENTS_BATCH_SIZE {
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-app.shutdown:
+			return
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Open the event stream of a test server, resuming after lastEventID unless it's
+// empty. The stream is closed at the end of the test, or after a few seconds so a
+// missing event fails the test rather than hanging it
+func openTestEventStream(t *testing.T, ts *httptest.Server, lastEventID string) *bufio.Reader {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/v1/movies/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("got Content-Type %q", got)
+	}
+
+	stream := bufio.NewReader(res.Body)
+	if block := readTestEventBlock(t, stream); block[0] != "retry: 3000" {
+		t.Fatalf("got %q, want the retry delay first", block)
+	}
+
+	return stream
+}
+
+// Read the lines of the next block of an event stream, up to the blank line ending it
+func readTestEventBlock(t *testing.T, stream *bufio.Reader) []string {
+	t.Helper()
+
+	var block []string
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("got %v reading the stream after %q", err, block)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return block
+		}
+		block = append(block, line)
+	}
+}
+
+// Read the next event of an event stream, skipping heartbeats
+func readTestEvent(t *testing.T, stream *bufio.Reader) *data.MovieEvent {
+	t.Helper()
+
+	for {
+		block := readTestEventBlock(t, stream)
+		if strings.HasPrefix(block[0], ":") {
+			continue
+		}
+		if len(block) != 3 {
+			t.Fatalf("got event %q, want an id, type and data", block)
+		}
+
+		var event data.MovieEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(block[2], "data: ")), &event); err != nil {
+			t.Fatal(err)
+		}
+		if block[0] != fmt.Sprintf("id: %d", event.ID) || block[1] != "event: "+event.Type {
+			t.Errorf("got event %q, want its id and type to match its data", block)
+		}
+		return &event
+	}
+}
+
+func TestMovieEventsReplay(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	moana := insertTestMovie(t, app, "Moana")
+	first, err := app.models.Movies.LatestEventID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arrival := insertTestMovie(t, app, "Arrival")
+	moana.Title = "Moana 2"
+	if err := app.models.Movies.Update(moana); err != nil {
+		t.Fatal(err)
+	}
+
+	// Missed events come first, then the ones made while connected
+	stream := openTestEventStream(t, ts, fmt.Sprint(first))
+
+	if err := app.models.Movies.Delete(arrival.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		eventType string
+		movieID   int64
+		version   int32
+	}{
+		{data.MovieEventCreated, arrival.ID, 1},
+		{data.MovieEventUpdated, moana.ID, 2},
+		{data.MovieEventDeleted, arrival.ID, 1},
+	}
+	for i, w := range want {
+		event := readTestEvent(t, stream)
+		if event.ID != first+int64(i)+1 || event.Type != w.eventType || event.MovieID != w.movieID || event.Version != w.version {
+			t.Errorf("got event %+v, want %d %s of movie %d at version %d", event, first+int64(i)+1, w.eventType, w.movieID, w.version)
+		}
+	}
+
+	// New streams only get the changes made after they connected
+	stream = openTestEventStream(t, ts, "")
+	insertTestMovie(t, app, "Heat")
+	if event := readTestEvent(t, stream); event.ID != first+4 || event.Type != data.MovieEventCreated {
+		t.Errorf("got event %+v, want the creation of Heat", event)
+	}
+
+	for _, lastEventID := range []string{"latest", "-1"} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/movies/events", nil)
+		req.Header.Set("Last-Event-ID", lastEventID)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("got status %d for Last-Event-ID %q, want 400", res.StatusCode, lastEventID)
+		}
+	}
+}
+
+func TestMovieEventsPruned(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	for _, title := range []string{"Moana", "Arrival", "Heat"} {
+		insertTestMovie(t, app, title)
+	}
+	_, err := app.models.Movies.DB.Exec(`UPDATE movie_events SET created_at = datetime('now', '-2 days')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The latest event is kept, however old
+	pruned, err := app.models.Movies.PruneEvents(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Errorf("got %d events pruned, want 2", pruned)
+	}
+	latest, err := app.models.Movies.LatestEventID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lastEventID := range []int64{0, latest - 2} {
+		res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies/events", http.Header{"Last-Event-ID": {fmt.Sprint(lastEventID)}}, nil)
+		if res.StatusCode != http.StatusGone {
+			t.Errorf("got status %d for Last-Event-ID %d, want 410: %s", res.StatusCode, lastEventID, body)
+		}
+	}
+
+	// Nothing was pruned after the event before the oldest one kept
+	stream := openTestEventStream(t, ts, fmt.Sprint(latest-1))
+	if event := readTestEvent(t, stream); event.ID != latest {
+		t.Errorf("got event %+v, want the latest one", event)
+	}
+}
+
+func TestMovieEventsHeartbeat(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.events.heartbeat = 50 * time.Millisecond
+	ts := newTestServer(t, app)
+
+	stream := openTestEventStream(t, ts, "")
+	for i := 0; i < 2; i++ {
+		if block := readTestEventBlock(t, stream); len(block) != 1 || block[0] != ": heartbeat" {
+			t.Fatalf("got %q, want a heartbeat", block)
+		}
+	}
+
+	// Changes made by other processes show up at the next heartbeat
+	_, err := app.models.Movies.DB.Exec(`INSERT INTO movie_events (type, movie_id, version) VALUES ('created', 42, 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := readTestEvent(t, stream); event.MovieID != 42 {
+		t.Errorf("got event %+v, want the one inserted directly", event)
+	}
+}
+
+func TestMovieEventsShutdown(t *testing.T) {
+	app := newTestApplication(t)
+	// Closed by the test rather than at its end
+	app.shutdown = make(chan struct{})
+	ts := newTestServer(t, app)
+
+	stream := openTestEventStream(t, ts, "")
+	close(app.shutdown)
+
+	if _, err := stream.ReadString('\n'); err != io.EOF {
+		t.Errorf("got %v, want the stream ended by the shutdown", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Related data that can be embedded in movie responses with expand=
+var MOVIE_SUPPORTED_EXPAND = []string{"credits"}
+
+// Fields movie responses can be limited to with fields=
+var MOVIE_SUPPORTED_FIELDS = data.MovieFieldSafeList
+
+// A movie along with the related data requested with expand=
+type expandedMovie struct {
+	*data.Movie
+	Credits []*data.Credit `json:"credits"`
+}
+
+// Read the comma-separated expand parameter, checking every value is supported
+func (app *application) readExpand(queryStringValues url.Values, v *validator.Validator) []string {
+	expand := app.readCSV(queryStringValues, "expand", []string{})
+
+	for _, value := range expand {
+		v.Check(validator.PermittedValue(value, MOVIE_SUPPORTED_EXPAND...), "expand", "invalid expand value")
+	}
+
+	return expand
+}
+
+// Read the comma-separated fields parameter, checking every value is supported
+func (app *application) readFields(queryStringValues url.Values, v *validator.Validator) []string {
+	fields := app.readCSV(queryStringValues, "fields", []string{})
+
+	for _, value := range fields {
+		v.Check(validator.PermittedValue(value, MOVIE_SUPPORTED_FIELDS...), "fields", "invalid fields value")
+	}
+
+	return fields
+}
+
+// Return the movies as they should be written to the response, with the related
+// data of expand embedded and limited to fields, the id and the expansions if
+// there are any fields. Without either, the movies are returned as is
+func (app *application) expandMovies(movies []*data.Movie, expand, fields []string) ([]any, error) {
+	expanded := make([]any, 0, len(movies))
+
+	if len(expand) == 0 {
+		for _, movie := range movies {
+			expanded = append(expanded, movie)
+		}
+	} else {
+		ids := make([]int64, 0, len(movies))
+		for _, movie := range movies {
+			ids = append(ids, movie.ID)
+		}
+
+		credits, err := app.models.Credits.GetForMovies(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, movie := range movies {
+			movieCredits := credits[movie.ID]
+			if movieCredits == nil {
+				movieCredits = []*data.Credit{}
+			}
+			expanded = append(expanded, expandedMovie{Movie: movie, Credits: movieCredits})
+		}
+	}
+
+	if len(fields) == 0 {
+		return expanded, nil
+	}
+
+	keep := slices.Concat([]string{"id"}, fields, expand)
+
+	for i, movie := range expanded {
+		projected, err := projectJSON(movie, keep)
+		if err != nil {
+			return nil, err
+		}
+		expanded[i] = projected
+	}
+
+	return expanded, nil
+}
+
+// Return the JSON object value marshals to, without the keys missing from keep
+func projectJSON(value any, keep []string) (map[string]json.RawMessage, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]json.RawMessage
+
+	err = json.Unmarshal(js, &object)
+	if err != nil {
+		return nil, err
+	}
+
+	for key := range object {
+		if !slices.Contains(keep, key) {
+			delete(object, key)
+		}
+	}
+
+	return object, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+func TestMovieFieldsAndExpand(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	inception := insertTestMovie(t, app, "Inception")
+	insertTestMovie(t, app, "Arrival")
+
+	nolan := &data.Person{Name: "Christopher Nolan"}
+	if err := app.models.People.Insert(nolan); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Credits.Insert(&data.Credit{MovieID: inception.ID, PersonID: nolan.ID, Role: "director"}); err != nil {
+		t.Fatal(err)
+	}
+
+	complete := []string{"genres", "id", "rating_count", "runtime", "title", "version", "year"}
+
+	tests := []struct {
+		name  string
+		query string
+		keys  []string
+	}{
+		{name: "complete", query: "", keys: complete},
+		{name: "fields", query: "fields=title,year", keys: []string{"id", "title", "year"}},
+		{name: "id always kept", query: "fields=version", keys: []string{"id", "version"}},
+		{name: "expand", query: "expand=credits", keys: slices.Concat(complete, []string{"credits"})},
+		{name: "fields and expand", query: "fields=title&expand=credits", keys: []string{"credits", "id", "title"}},
+	}
+
+	keys := func(movie map[string]json.RawMessage) []string {
+		keys := make([]string, 0, len(movie))
+		for key := range movie {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		return keys
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := slices.Clone(tt.keys)
+			slices.Sort(want)
+
+			res, body := doTestRequest(t, ts, http.MethodGet, fmt.Sprintf("/v1/movies/%d?%s", inception.ID, tt.query), nil, nil)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d: %s", res.StatusCode, body)
+			}
+			var single struct {
+				Movie map[string]json.RawMessage `json:"movie"`
+			}
+			if err := json.Unmarshal(body, &single); err != nil {
+				t.Fatal(err)
+			}
+			if got := keys(single.Movie); !reflect.DeepEqual(got, want) {
+				t.Errorf("got movie fields %q, want %q", got, want)
+			}
+
+			res, body = doTestRequest(t, ts, http.MethodGet, "/v1/movies?sort=id&"+tt.query, nil, nil)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d: %s", res.StatusCode, body)
+			}
+			var list struct {
+				Movies []map[string]json.RawMessage `json:"movies"`
+			}
+			if err := json.Unmarshal(body, &list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Movies) != 2 {
+				t.Fatalf("got %d movies, want 2", len(list.Movies))
+			}
+			for _, movie := range list.Movies {
+				if got := keys(movie); !reflect.DeepEqual(got, want) {
+					t.Errorf("got listed movie fields %q, want %q", got, want)
+				}
+			}
+		})
+	}
+
+	// Each movie embeds its own credits, movies without any an empty list
+	res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies?sort=id&fields=title&expand=credits", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var list struct {
+		Movies []struct {
+			Credits []data.Credit `json:"credits"`
+		} `json:"movies"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatal(err)
+	}
+	if credits := list.Movies[0].Credits; len(credits) != 1 || credits[0].Name != "Christopher Nolan" || credits[0].Role != "director" {
+		t.Errorf("got credits %+v, want the director", credits)
+	}
+	if credits := list.Movies[1].Credits; credits == nil || len(credits) != 0 {
+		t.Errorf("got credits %+v, want none", credits)
+	}
+
+	for _, query := range []string{"fields=plot", "fields=title,created_at", "expand=reviews"} {
+		for _, path := range []string{"/v1/movies?", fmt.Sprintf("/v1/movies/%d?", inception.ID)} {
+			res, body := doTestRequest(t, ts, http.MethodGet, path+query, nil, nil)
+			if res.StatusCode != http.StatusUnprocessableEntity {
+				t.Errorf("%s%s: got status %d, want 422: %s", path, query, res.StatusCode, body)
+			}
+		}
+	}
+}
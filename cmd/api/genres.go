@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Data that's expected from the client to create a genre. The slug defaults to the
+// name, both it and the aliases are normalized to lowercase words separated by dashes
+type genreInput struct {
+	Slug    string   `json:"slug"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// Data that's expected from the client to update a genre. The slug can't be changed
+type genrePatch struct {
+	Name    *string  `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+func slugifyAll(names []string) []string {
+	if names == nil {
+		return nil
+	}
+
+	slugs := make([]string, 0, len(names))
+	for _, name := range names {
+		slugs = append(slugs, data.Slugify(name))
+	}
+
+	return slugs
+}
+
+// Validate a movie with data.ValidateMovie against the current genre vocabulary.
+// Only failing to load the vocabulary is returned, validation errors go to v
+func (app *application) validateMovie(v *validator.Validator, movie *data.Movie) error {
+	genres, err := app.models.Genres.Vocabulary()
+	if err != nil {
+		return err
+	}
+
+	data.ValidateMovie(v, movie, genres)
+	return nil
+}
+
+// Return the genre model recording the actor of the request as the author of changes
+func (app *application) genreModel(r *http.Request) data.GenreModel {
+	return app.models.Genres.WithActor(app.actor(r))
+}
+
+// List every genre of the vocabulary along with the number of movies having it
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show a specific genre
+func (app *application) getGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a genre to the vocabulary
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input genreInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Slug == "" {
+		input.Slug = input.Name
+	}
+
+	genre := &data.Genre{
+		Slug:    data.Slugify(input.Slug),
+		Name:    input.Name,
+		Aliases: slugifyAll(input.Aliases),
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.genreModel(r).Insert(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("slug", "the slug or one of the aliases is already used by another genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Rename a genre or replace its aliases
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input genrePatch
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		genre.Name = *input.Name
+	}
+	if input.Aliases != nil {
+		genre.Aliases = slugifyAll(input.Aliases)
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.genreModel(r).Update(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("aliases", "one of the aliases is already used by another genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Remove a genre from the vocabulary, as long as no movie has it
+func (app *application) deleteGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.genreModel(r).Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrGenreInUse):
+			app.errorResponse(w, r, http.StatusConflict, "the genre can't be deleted while movies, including the ones in the trash, have it")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "genre successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
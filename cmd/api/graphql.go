@@ -0,0 +1,550 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/graphql"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Deepest nesting of fields and highest cost of the operations executed on
+// /v1/graphql, each field costing 1 plus the fields selected on it, times the
+// page size for lists of movies
+const (
+	GRAPHQL_MAX_DEPTH      = 6
+	GRAPHQL_MAX_COMPLEXITY = 2000
+)
+
+// Runtime of a movie, serialized like on the REST endpoints ("102 minutes") and
+// given either that way or as a number of minutes
+var graphQLRuntime = &graphql.Scalar{
+	Name:        "Runtime",
+	Description: `Runtime of a movie, e.g. "102 minutes", which can be given as a number of minutes`,
+	Serialize: func(v any) (any, error) {
+		runtime, ok := v.(data.Runtime)
+		if !ok {
+			return nil, fmt.Errorf("Runtime can't represent %v", v)
+		}
+
+		js, err := runtime.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.Unquote(string(js))
+	},
+	Parse: func(v any) (any, error) {
+		var runtime data.Runtime
+
+		switch v := v.(type) {
+		case string:
+			err := runtime.UnmarshalJSON([]byte(strconv.Quote(v)))
+			if err != nil {
+				return nil, err
+			}
+		case int64:
+			runtime = data.Runtime(v)
+		case float64:
+			if v != float64(int32(v)) {
+				return nil, data.ErrInvalidRunTimeFormat
+			}
+			runtime = data.Runtime(v)
+		default:
+			return nil, data.ErrInvalidRunTimeFormat
+		}
+
+		return runtime, nil
+	},
+}
+
+// Point in time, as an RFC 3339 string
+var graphQLTime = &graphql.Scalar{
+	Name:        "Time",
+	Description: "A point in time as an RFC 3339 string, e.g. 2024-01-01T00:00:00Z",
+	Serialize: func(v any) (any, error) {
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("Time can't represent %v", v)
+		}
+		return t.Format(time.RFC3339), nil
+	},
+	Parse: func(v any) (any, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("must be an RFC 3339 time, e.g. 2024-01-01T00:00:00Z")
+		}
+
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, errors.New("must be an RFC 3339 time, e.g. 2024-01-01T00:00:00Z")
+		}
+		return t, nil
+	},
+}
+
+// A page of movies, as returned by the movies query
+type moviePage struct {
+	Movies   []*data.Movie `json:"movies"`
+	Metadata data.Metadata `json:"metadata"`
+}
+
+// Build the schema of /v1/graphql. Fields and arguments are named like the keys
+// of the REST endpoints, and root fields are resolved given the *http.Request
+func (app *application) buildGraphQLSchema() (*graphql.Schema, error) {
+	movie := &graphql.Object{
+		Name: "Movie",
+		Fields: []*graphql.Field{
+			{Name: "id", Type: graphql.NonNullOf(graphql.ID)},
+			{Name: "title", Type: graphql.NonNullOf(graphql.String)},
+			{Name: "year", Type: graphql.NonNullOf(graphql.Int)},
+			{Name: "runtime", Type: graphql.NonNullOf(graphQLRuntime)},
+			{Name: "genres", Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(graphql.String)))},
+			{Name: "external_id", Type: graphql.String},
+			{Name: "rating_avg", Type: graphql.Float, Description: "Average score of the reviews, null until reviewed"},
+			{Name: "rating_count", Type: graphql.NonNullOf(graphql.Int)},
+			{Name: "deleted_at", Type: graphQLTime, Description: "When the movie was moved to the trash"},
+			{Name: "version", Type: graphql.NonNullOf(graphql.Int)},
+		},
+	}
+
+	metadata := &graphql.Object{
+		Name: "Metadata",
+		Fields: []*graphql.Field{
+			{Name: "current_page", Type: graphql.NonNullOf(graphql.Int)},
+			{Name: "page_size", Type: graphql.NonNullOf(graphql.Int)},
+			{Name: "first_page", Type: graphql.NonNullOf(graphql.Int)},
+			{Name: "last_page", Type: graphql.NonNullOf(graphql.Int)},
+			{Name: "total_records", Type: graphql.NonNullOf(graphql.Int)},
+		},
+	}
+
+	moviePageType := &graphql.Object{
+		Name: "MoviePage",
+		Fields: []*graphql.Field{
+			{Name: "movies", Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(movie)))},
+			{Name: "metadata", Type: graphql.NonNullOf(metadata)},
+		},
+	}
+
+	movieInputType := &graphql.InputObject{
+		Name: "MovieInput",
+		Fields: []*graphql.Argument{
+			{Name: "title", Type: graphql.NonNullOf(graphql.String)},
+			{Name: "year", Type: graphql.NonNullOf(graphql.Int)},
+			{Name: "runtime", Type: graphql.NonNullOf(graphQLRuntime)},
+			{Name: "genres", Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(graphql.String)))},
+			{Name: "external_id", Type: graphql.String},
+		},
+	}
+
+	moviePatchType := &graphql.InputObject{
+		Name:        "MoviePatch",
+		Description: "Fields of a movie to change, the others are left as they are",
+		Fields: []*graphql.Argument{
+			{Name: "title", Type: graphql.String},
+			{Name: "year", Type: graphql.Int},
+			{Name: "runtime", Type: graphQLRuntime},
+			{Name: "genres", Type: graphql.ListOf(graphql.NonNullOf(graphql.String))},
+		},
+	}
+
+	moviesArgs := []*graphql.Argument{
+		{Name: "title", Type: graphql.String},
+		{Name: "genres", Type: graphql.ListOf(graphql.NonNullOf(graphql.String)), Description: "Genres the movies all have"},
+		{Name: "genres_any", Type: graphql.ListOf(graphql.NonNullOf(graphql.String)), Description: "Genres the movies have one of"},
+		{Name: "director", Type: graphql.String},
+		{Name: "actor", Type: graphql.String},
+	}
+	for _, field := range []string{"year", "runtime"} {
+		for _, operator := range []string{"gt", "gte", "lt", "lte"} {
+			moviesArgs = append(moviesArgs, &graphql.Argument{Name: field + "_" + operator, Type: graphql.Int})
+		}
+	}
+	moviesArgs = append(moviesArgs,
+		&graphql.Argument{Name: "created_after", Type: graphQLTime},
+		&graphql.Argument{Name: "created_before", Type: graphQLTime},
+		&graphql.Argument{Name: "include_deleted", Type: graphql.Boolean, Default: false, Description: "Admins only"},
+		&graphql.Argument{Name: "page", Type: graphql.Int, Default: 1},
+		&graphql.Argument{Name: "page_size", Type: graphql.Int, Default: 20},
+		&graphql.Argument{Name: "sort", Type: graphql.String, Default: "id"},
+	)
+
+	query := &graphql.Object{
+		Name: "Query",
+		Fields: []*graphql.Field{
+			{
+				Name:        "movie",
+				Description: "The movie with the given id, null if there's none",
+				Type:        movie,
+				Args:        []*graphql.Argument{{Name: "id", Type: graphql.NonNullOf(graphql.ID)}},
+				Resolve:     app.resolveMovie,
+			},
+			{
+				Name:        "movies",
+				Description: "A page of the movies matching the filters",
+				Type:        graphql.NonNullOf(moviePageType),
+				Args:        moviesArgs,
+				Resolve:     app.resolveMovies,
+				// Every movie of the page may be resolved
+				Complexity: func(args map[string]any, childComplexity int) int {
+					pageSize, _ := args["page_size"].(int)
+					return 1 + max(pageSize, 1)*childComplexity
+				},
+			},
+		},
+	}
+
+	mutation := &graphql.Object{
+		Name: "Mutation",
+		Fields: []*graphql.Field{
+			{
+				Name:    "create_movie",
+				Type:    graphql.NonNullOf(movie),
+				Args:    []*graphql.Argument{{Name: "input", Type: graphql.NonNullOf(movieInputType)}},
+				Resolve: app.resolveCreateMovie,
+			},
+			{
+				Name: "update_movie",
+				Type: graphql.NonNullOf(movie),
+				Args: []*graphql.Argument{
+					{Name: "id", Type: graphql.NonNullOf(graphql.ID)},
+					{Name: "input", Type: graphql.NonNullOf(moviePatchType)},
+					{Name: "version", Type: graphql.Int, Description: "Version the movie must be at"},
+				},
+				Resolve: app.resolveUpdateMovie,
+			},
+			{
+				Name:        "delete_movie",
+				Description: "Move a movie to the trash, returning its id",
+				Type:        graphql.NonNullOf(graphql.ID),
+				Args:        []*graphql.Argument{{Name: "id", Type: graphql.NonNullOf(graphql.ID)}},
+				Resolve:     app.resolveDeleteMovie,
+			},
+		},
+	}
+
+	return graphql.NewSchema(query, mutation)
+}
+
+// Convert the errors of models to errors of fields, with a code among their
+// extensions. Unexpected errors are logged and not disclosed
+func (app *application) graphQLError(r *http.Request, err error) error {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return graphQLErrorWithCode("NOT_FOUND", "the requested resource could not be found")
+	case errors.Is(err, data.ErrEditConflict):
+		return graphQLErrorWithCode("EDIT_CONFLICT", "unable to update the record due to an edit conflict, please try again")
+	default:
+		app.logError(r, err)
+		return graphQLErrorWithCode("INTERNAL_SERVER_ERROR", "the server encountered a problem and could not process the request")
+	}
+}
+
+func graphQLErrorWithCode(code, message string) *graphql.Error {
+	return &graphql.Error{Message: message, Extensions: map[string]any{"code": code}}
+}
+
+// Error of a field whose arguments failed validation, keyed like on the REST endpoints
+func graphQLValidationError(errs map[string]string) *graphql.Error {
+	return &graphql.Error{
+		Message:    "the request failed validation",
+		Extensions: map[string]any{"code": "FAILED_VALIDATION", "errors": errs},
+	}
+}
+
+// Error of a field only admins may resolve, nil if the user of r is one. Like
+// requireAdmin, anonymous users are told to authenticate
+func (app *application) graphQLRequireAdmin(r *http.Request) error {
+	user := app.contextGetUser(r)
+
+	switch {
+	case user.IsAnonymous():
+		return graphQLErrorWithCode("UNAUTHENTICATED", "you must be authenticated to access this resource")
+	case !user.IsAdmin():
+		return graphQLErrorWithCode(
+			"FORBIDDEN",
+			"your user account doesn't have the necessary permissions to access this resource",
+		)
+	}
+
+	return nil
+}
+
+// Parse the ID argument of a movie, false if it can't be the id of one
+func graphQLMovieID(args map[string]any) (int64, bool) {
+	id, err := strconv.ParseInt(args["id"].(string), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// Decode an input object argument into the input struct of a REST endpoint,
+// which its fields are named after
+func decodeGraphQLInput(input any, dst any) error {
+	js, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, dst)
+}
+
+func (app *application) resolveMovie(source any, args map[string]any) (any, error) {
+	r := source.(*http.Request)
+
+	id, ok := graphQLMovieID(args)
+	if !ok {
+		return nil, nil
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, app.graphQLError(r, err)
+	}
+
+	return movie, nil
+}
+
+func (app *application) resolveMovies(source any, args map[string]any) (any, error) {
+	r := source.(*http.Request)
+
+	var input struct {
+		data.MovieFilter
+		data.Filters
+	}
+
+	input.Title, _ = args["title"].(string)
+	input.Genres = graphQLStrings(args["genres"])
+	input.GenresAny = graphQLStrings(args["genres_any"])
+	input.Director, _ = args["director"].(string)
+	input.Actor, _ = args["actor"].(string)
+	input.IncludeDeleted, _ = args["include_deleted"].(bool)
+
+	input.Conditions = []data.Condition{}
+	for _, field := range []string{"year", "runtime"} {
+		for _, operator := range []string{"gt", "gte", "lt", "lte"} {
+			if value, ok := args[field+"_"+operator].(int); ok {
+				input.Conditions = append(input.Conditions, data.Condition{Field: field, Operator: operator, Value: value})
+			}
+		}
+	}
+	for _, bound := range []struct{ key, operator string }{
+		{"created_after", "gte"},
+		{"created_before", "lt"},
+	} {
+		if value, ok := args[bound.key].(time.Time); ok {
+			input.Conditions = append(input.Conditions, data.Condition{Field: "created_at", Operator: bound.operator, Value: value})
+		}
+	}
+
+	// Explicit nulls are replaced by the defaults
+	input.Page, _ = args["page"].(int)
+	if args["page"] == nil {
+		input.Page = 1
+	}
+	input.PageSize, _ = args["page_size"].(int)
+	if args["page_size"] == nil {
+		input.PageSize = 20
+	}
+	input.Sort, _ = args["sort"].(string)
+	if args["sort"] == nil {
+		input.Sort = "id"
+	}
+	input.SortSafeList = LIST_MOVIES_SUPPORTED_SORT
+	input.ConditionSafeList = MOVIE_SUPPORTED_CONDITIONS
+
+	v := validator.New()
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		return nil, graphQLValidationError(v.Errors)
+	}
+
+	// Deleted movies are only listed along with the others to admins
+	if input.IncludeDeleted {
+		if err := app.graphQLRequireAdmin(r); err != nil {
+			return nil, err
+		}
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilter, input.Filters)
+	if err != nil {
+		return nil, app.graphQLError(r, err)
+	}
+
+	return moviePage{Movies: movies, Metadata: metadata}, nil
+}
+
+// Convert a list of strings argument, nil if it's not given
+func graphQLStrings(value any) []string {
+	items, ok := value.([]any)
+	if !ok {
+		return nil
+	}
+
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		strs = append(strs, item.(string))
+	}
+	return strs
+}
+
+func (app *application) resolveCreateMovie(source any, args map[string]any) (any, error) {
+	r := source.(*http.Request)
+
+	if err := app.graphQLRequireAdmin(r); err != nil {
+		return nil, err
+	}
+
+	var input movieInput
+	if err := decodeGraphQLInput(args["input"], &input); err != nil {
+		return nil, app.graphQLError(r, err)
+	}
+
+	v := validator.New()
+
+	if v.Struct(input); !v.Valid() {
+		return nil, graphQLValidationError(v.Errors)
+	}
+
+	movie := input.movie()
+
+	err := app.validateMovie(v, movie)
+	if err != nil {
+		return nil, app.graphQLError(r, err)
+	}
+
+	if !v.Valid() {
+		return nil, graphQLValidationError(v.Errors)
+	}
+
+	err = app.movieModel(r).Insert(movie)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateExternalID) {
+			v.AddError("external_id", "a movie with this external id already exists")
+			return nil, graphQLValidationError(v.Errors)
+		}
+		return nil, app.graphQLError(r, err)
+	}
+
+	return movie, nil
+}
+
+func (app *application) resolveUpdateMovie(source any, args map[string]any) (any, error) {
+	r := source.(*http.Request)
+
+	if err := app.graphQLRequireAdmin(r); err != nil {
+		return nil, err
+	}
+
+	id, ok := graphQLMovieID(args)
+	if !ok {
+		return nil, app.graphQLError(r, data.ErrRecordNotFound)
+	}
+
+	var patch moviePatch
+	if err := decodeGraphQLInput(args["input"], &patch); err != nil {
+		return nil, app.graphQLError(r, err)
+	}
+
+	v := validator.New()
+
+	if v.Struct(patch); !v.Valid() {
+		return nil, graphQLValidationError(v.Errors)
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		return nil, app.graphQLError(r, err)
+	}
+
+	// Optional optimistic concurrency check, the client sends the version it last saw
+	if version, ok := args["version"].(int); ok && int32(version) != movie.Version {
+		return nil, app.graphQLError(r, data.ErrEditConflict)
+	}
+
+	patch.apply(movie)
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		return nil, app.graphQLError(r, err)
+	}
+
+	if !v.Valid() {
+		return nil, graphQLValidationError(v.Errors)
+	}
+
+	err = app.movieModel(r).Update(movie)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateExternalID) {
+			v.AddError("external_id", "a movie with this external id already exists")
+			return nil, graphQLValidationError(v.Errors)
+		}
+		return nil, app.graphQLError(r, err)
+	}
+
+	return movie, nil
+}
+
+func (app *application) resolveDeleteMovie(source any, args map[string]any) (any, error) {
+	r := source.(*http.Request)
+
+	if err := app.graphQLRequireAdmin(r); err != nil {
+		return nil, err
+	}
+
+	id, ok := graphQLMovieID(args)
+	if !ok {
+		return nil, app.graphQLError(r, data.ErrRecordNotFound)
+	}
+
+	err := app.movieModel(r).Delete(id)
+	if err != nil {
+		return nil, app.graphQLError(r, err)
+	}
+
+	return id, nil
+}
+
+// Execute a GraphQL query or mutation on movies. Requests that can't be executed
+// get a 400 with their errors, others a 200 with the data and the errors of the
+// fields that couldn't be resolved
+func (app *application) graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var input graphql.Request
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	limits := graphql.Limits{MaxDepth: GRAPHQL_MAX_DEPTH, MaxComplexity: GRAPHQL_MAX_COMPLEXITY}
+
+	result, err := app.graphQLSchema.Execute(input, r, limits)
+	if err != nil {
+		var errs graphql.Errors
+		if !errors.As(err, &errs) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusBadRequest, envelope{"errors": errs}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	response := envelope{"data": result.Data}
+	if len(result.Errors) > 0 {
+		response["errors"] = result.Errors
+	}
+
+	err = app.writeJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
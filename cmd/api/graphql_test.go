@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/graphql"
+)
+
+// Response to a GraphQL request, with the data of each field left raw
+type testGraphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []graphql.Error            `json:"errors"`
+}
+
+// Send a GraphQL operation to a test server, which must answer with a 200
+func doTestGraphQL(t *testing.T, ts *httptest.Server, headers http.Header, query string, variables map[string]any) testGraphQLResponse {
+	t.Helper()
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/graphql", headers, map[string]any{"query": query, "variables": variables})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+
+	var response testGraphQLResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	return response
+}
+
+// The codes of the errors of a response
+func testGraphQLErrorCodes(response testGraphQLResponse) []any {
+	codes := []any{}
+	for _, err := range response.Errors {
+		codes = append(codes, err.Extensions["code"])
+	}
+	return codes
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	response := doTestGraphQL(t, ts, admin, `
+        mutation ($input: MovieInput!) { create_movie(input: $input) { id title version } }`,
+		map[string]any{"input": map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}},
+	)
+	if len(response.Errors) > 0 {
+		t.Fatalf("got errors %+v", response.Errors)
+	}
+	var created struct {
+		ID      string `json:"id"`
+		Title   string `json:"title"`
+		Version int32  `json:"version"`
+	}
+	if err := json.Unmarshal(response.Data["create_movie"], &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Title != "Moana" || created.Version != 1 || countTestMovies(t, app) != 1 {
+		t.Fatalf("got movie %+v, want Moana created", created)
+	}
+
+	update := fmt.Sprintf(`mutation ($version: Int) { update_movie(id: %s, input: {title: "Moana 2"}, version: $version) { title version } }`, created.ID)
+
+	response = doTestGraphQL(t, ts, admin, update, map[string]any{"version": 2})
+	if codes := testGraphQLErrorCodes(response); fmt.Sprint(codes) != "[EDIT_CONFLICT]" {
+		t.Errorf("got errors %v for a stale version, want EDIT_CONFLICT", codes)
+	}
+
+	response = doTestGraphQL(t, ts, admin, update, map[string]any{"version": 1})
+	if got := string(response.Data["update_movie"]); got != `{"title":"Moana 2","version":2}` {
+		t.Errorf("got %s %+v, want the movie updated", got, response.Errors)
+	}
+
+	response = doTestGraphQL(t, ts, admin, `
+        mutation { create_movie(input: {title: "", year: 2016, runtime: "107 mins", genres: ["animation"]}) { id } }`, nil)
+	if codes := testGraphQLErrorCodes(response); fmt.Sprint(codes) != "[FAILED_VALIDATION]" {
+		t.Errorf("got errors %v for an invalid movie, want FAILED_VALIDATION", codes)
+	}
+
+	response = doTestGraphQL(t, ts, admin, fmt.Sprintf(`mutation { delete_movie(id: %s) }`, created.ID), nil)
+	if got := string(response.Data["delete_movie"]); got != fmt.Sprintf("%q", created.ID) || countTestMovies(t, app) != 0 {
+		t.Errorf("got %s %+v, want the movie deleted", got, response.Errors)
+	}
+	response = doTestGraphQL(t, ts, admin, fmt.Sprintf(`mutation { delete_movie(id: %s) }`, created.ID), nil)
+	if codes := testGraphQLErrorCodes(response); fmt.Sprint(codes) != "[NOT_FOUND]" {
+		t.Errorf("got errors %v deleting a deleted movie, want NOT_FOUND", codes)
+	}
+}
+
+func TestGraphQLMutationsRequireAdmin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	movie := insertTestMovie(t, app, "Moana")
+	user := http.Header{"Authorization": {"Bearer " + createTestUser(t, app, "user@example.com", data.RoleUser)}}
+
+	operations := []string{
+		`mutation { create_movie(input: {title: "Heat", year: 1995, runtime: "170 mins", genres: ["drama"]}) { id } }`,
+		fmt.Sprintf(`mutation { update_movie(id: %d, input: {title: "Moana 2"}) { id } }`, movie.ID),
+		fmt.Sprintf(`mutation { delete_movie(id: %d) }`, movie.ID),
+		`{ movies(include_deleted: true) { movies { id } } }`,
+	}
+
+	for _, operation := range operations {
+		for _, tt := range []struct {
+			headers http.Header
+			code    string
+		}{
+			{headers: nil, code: "UNAUTHENTICATED"},
+			{headers: user, code: "FORBIDDEN"},
+		} {
+			response := doTestGraphQL(t, ts, tt.headers, operation, nil)
+			if codes := testGraphQLErrorCodes(response); fmt.Sprint(codes) != fmt.Sprintf("[%s]", tt.code) {
+				t.Errorf("%s: got errors %v, want %s", operation, codes, tt.code)
+			}
+		}
+	}
+
+	// Nothing was changed
+	got, err := app.models.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Moana" || got.Version != 1 || countTestMovies(t, app) != 1 {
+		t.Errorf("got movie %+v and %d movies, want them unchanged", got, countTestMovies(t, app))
+	}
+
+	// Reading movies stays open to everyone
+	response := doTestGraphQL(t, ts, nil, `{ movies { movies { title } } }`, nil)
+	if got := string(response.Data["movies"]); got != `{"movies":[{"title":"Moana"}]}` {
+		t.Errorf("got %s %+v, want the movies", got, response.Errors)
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -27,6 +31,26 @@ func (app *application) readIdFromRequestParams(r *http.Request) (int64, error)
 	return id, nil
 }
 
+// Read the version a client expects a movie to be at, from the request body if it
+// was sent there or from the X-Expected-Version header. Returns 0 if there's none
+func (app *application) readExpectedVersion(r *http.Request, bodyVersion *int32) (int32, error) {
+	if bodyVersion != nil {
+		return *bodyVersion, nil
+	}
+
+	header := r.Header.Get("X-Expected-Version")
+	if header == "" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(header, 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid X-Expected-Version header")
+	}
+
+	return int32(version), nil
+}
+
 // Write JSON to the given ResponseWriter
 func (app *application) writeJSON(
 	w http.ResponseWriter,
@@ -53,61 +77,80 @@ func (app *application) writeJSON(
 	return nil
 }
 
-func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
-	// Limit the size of the request body to 1MB
-	var maxBytes int64 = 1024 * 1024
-	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+// Write a response in the format the client prefers among the ones of
+// RESPONSE_ENCODERS able to represent it. Errors, and responses to requests that
+// made changes by the time they're written, fall back to JSON. Other responses are
+// replaced by a 406 if no acceptable format can represent them
+func (app *application) writeResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	data envelope,
+	headers http.Header,
+) error {
+	var buf bytes.Buffer
 
-	// Set decoder to return error if JSON includes unknown fields
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	for _, encoder := range app.contextGetResponseEncoders(r) {
+		buf.Reset()
 
-	err := dec.Decode(dst)
-	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
-		var maxBytesError *http.MaxBytesError
+		err := encoder.Encode(&buf, data)
+		if errors.Is(err, errUnrepresentable) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-		switch {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf(
-				"body contains badly-formed JSON (at characted %d)",
-				syntaxError.Offset,
-			)
+		for key, value := range headers {
+			w.Header()[key] = value
+		}
 
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+		w.Header().Set("Content-Type", encoder.ContentType)
+		w.WriteHeader(status)
+		w.Write(buf.Bytes())
 
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf(
-					"body contains incorrect JSON type for field %q",
-					unmarshalTypeError.Field,
-				)
-			}
-			return fmt.Errorf(
-				"body contains incorrect JSON type (at characted %d)",
-				unmarshalTypeError.Offset,
-			)
+		return nil
+	}
 
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+	if status < http.StatusBadRequest && isSafeMethod(r.Method) {
+		status = http.StatusNotAcceptable
+		data = envelope{"error": notAcceptableMessage}
+	}
 
-		// Handling unknown JSON fields
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+	return app.writeJSON(w, status, data, headers)
+}
 
-		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	// Limit the size of the request body to 1MB, compressed or not
+	var maxBytes int64 = 1024 * 1024
+	body, err := decodeRequestBody(w, r, maxBytes)
+	if err != nil {
+		return jsonDecodeError(err)
+	}
+	r.Body = body
 
-		case errors.As(err, &invalidUnmarshalError):
-			panic(err)
+	// MessagePack bodies are converted, so they're decoded as strictly as JSON ones
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if slices.Contains(MSGPACK_CONTENT_TYPES, mediaType) {
+		msgpack, err := io.ReadAll(r.Body)
+		if err != nil {
+			return jsonDecodeError(err)
+		}
 
-		default:
+		js, err := msgpackToJSON(msgpack)
+		if err != nil {
 			return err
 		}
+		r.Body = io.NopCloser(bytes.NewReader(js))
+	}
+
+	// Set decoder to return error if JSON includes unknown fields
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(dst)
+	if err != nil {
+		return jsonDecodeError(err)
 	}
 
 	// Calling Decode() again to make sure that there isn't any data left on Body
@@ -120,6 +163,54 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+// Translate an error from decoding JSON into a message suitable for the client
+func jsonDecodeError(err error) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf(
+			"body contains badly-formed JSON (at characted %d)",
+			syntaxError.Offset,
+		)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
+
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf(
+				"body contains incorrect JSON type for field %q",
+				unmarshalTypeError.Field,
+			)
+		}
+		return fmt.Errorf(
+			"body contains incorrect JSON type (at characted %d)",
+			unmarshalTypeError.Offset,
+		)
+
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+
+	// Handling unknown JSON fields
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return fmt.Errorf("body contains unknown key %s", fieldName)
+
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+
+	case errors.As(err, &invalidUnmarshalError):
+		panic(err)
+
+	default:
+		return err
+	}
+}
+
 // Returns a string value from the query string, or the provided default value if no matching key could be found
 func (app *application) readString(
 	queryStringValues url.Values,
@@ -171,3 +262,62 @@ func (app *application) readInt(
 
 	return i
 }
+
+// Number of rows buffered by a flushWriter before they are sent to the client
+const FLUSH_WRITER_ROWS = 100
+
+// Buffers a streamed response and flushes it to the client every few rows
+type flushWriter struct {
+	*bufio.Writer
+	rc   *http.ResponseController
+	rows int
+}
+
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+	return &flushWriter{Writer: bufio.NewWriter(w), rc: http.NewResponseController(w)}
+}
+
+// Record that a row was written, flushing it to the client if enough rows were buffered
+func (fw *flushWriter) rowWritten() error {
+	fw.rows++
+	if fw.rows%FLUSH_WRITER_ROWS != 0 {
+		return nil
+	}
+	return fw.Flush()
+}
+
+// Write buffered data to the client and flush it through the network
+func (fw *flushWriter) Flush() error {
+	err := fw.Writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = fw.rc.Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+// Reads a boolean value from the query string. If no matching key could be found
+// returns the default value, if it isn't a boolean the error is added to the validator
+func (app *application) readBool(
+	queryStringValues url.Values,
+	key string,
+	defaultValue bool,
+	v *validator.Validator,
+) bool {
+	s := queryStringValues.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Longest Idempotency-Key header accepted
+const IDEMPOTENCY_KEY_MAX_LENGTH = 255
+
+// Largest body of a request with an Idempotency-Key, which is read up front to
+// fingerprint the request. It's the limit of readJSON, before decompression
+const IDEMPOTENT_REQUEST_MAX_BYTES = 1024 * 1024
+
+// Response headers that aren't recorded with idempotent responses, as they're set
+// again when replaying them or describe the original response only
+var IDEMPOTENCY_SKIPPED_HEADERS = []string{"Content-Encoding", "Content-Length", "Date", "Vary", "X-Request-Id"}
+
+// Make a handler safe to retry: the response to a request with an Idempotency-Key
+// header is recorded and replayed for the later requests of the user with the same
+// key, until the key expires. Reusing a key for another request fails with a 422,
+// and while the first request is in flight its duplicates fail with a 409. Keys
+// are scoped to users, so anonymous requests with a key are refused with a 401
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > IDEMPOTENCY_KEY_MAX_LENGTH || strings.ContainsFunc(key, func(c rune) bool { return c < ' ' || c > '~' }) {
+			app.badRequestResponse(w, r, errors.New("Idempotency-Key header must be at most 255 printable ASCII characters"))
+			return
+		}
+
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, IDEMPOTENT_REQUEST_MAX_BYTES))
+		if err != nil {
+			app.badRequestResponse(w, r, jsonDecodeError(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		lockTimeout := app.config.idempotency.lockTimeout
+
+		response, err := app.models.Idempotency.Begin(user.ID, key, app.requestFingerprint(r, body), lockTimeout)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyReused):
+				message := "the Idempotency-Key was already used for a different request"
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+			case errors.Is(err, data.ErrIdempotencyKeyInFlight):
+				w.Header().Set("Retry-After", "1")
+				message := "a request with the same Idempotency-Key is still being processed, retry later"
+				app.errorResponse(w, r, http.StatusConflict, message)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if response != nil {
+			maps.Copy(w.Header(), response.Header)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(response.Status)
+			w.Write(response.Body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+
+		// The key is freed unless the response is recorded, e.g. if the handler panics
+		recorded := false
+		defer func() {
+			if !recorded {
+				if err := app.models.Idempotency.Release(user.ID, key); err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		// The key stays locked however long the handler runs, and until lockTimeout
+		// after the server stopped refreshing it
+		stopRefresh := app.refreshIdempotencyKey(r, user.ID, key, lockTimeout)
+		defer stopRefresh()
+
+		next(rec, r)
+
+		// Server errors are worth retrying, so they aren't recorded
+		if rec.status == 0 {
+			rec.WriteHeader(http.StatusOK)
+		}
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+
+		err = app.models.Idempotency.Complete(user.ID, key, &data.IdempotentResponse{
+			Status: rec.status,
+			Header: rec.header,
+			Body:   rec.body.Bytes(),
+		}, app.config.idempotency.ttl)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		recorded = true
+	}
+}
+
+// Extend the lock of an idempotency key every half lockTimeout until the returned
+// function is called, which waits for the last extension to finish
+func (app *application) refreshIdempotencyKey(r *http.Request, userID int64, key string, lockTimeout time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(lockTimeout / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := app.models.Idempotency.Extend(userID, key, lockTimeout); err != nil {
+					app.logError(r, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Identify a request by its method, URL, body, the headers describing the body and
+// the response formats negotiated from its Accept header, as the recorded response
+// is in one of them
+func (app *application) requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+
+	var formats []string
+	for _, encoder := range app.contextGetResponseEncoders(r) {
+		formats = append(formats, encoder.ContentType)
+	}
+
+	for _, part := range []string{
+		r.Method,
+		r.URL.RequestURI(),
+		r.Header.Get("Content-Type"),
+		r.Header.Get("Content-Encoding"),
+		strings.Join(formats, ","),
+	} {
+		io.WriteString(hash, part)
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Response writer recording the response it writes
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 && status >= http.StatusOK {
+		rec.status = status
+
+		rec.header = rec.Header().Clone()
+		for _, name := range IDEMPOTENCY_SKIPPED_HEADERS {
+			rec.header.Del(name)
+		}
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// Let http.ResponseController reach the underlying writer
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+var errTestHandler = errors.New("test handler failure")
+
+func TestIdempotentReplay(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	movie := map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}
+	headers := newTestAdminHeaders(t, app)
+	headers.Set("Idempotency-Key", "create-moana")
+
+	first, firstBody := doTestRequest(t, ts, http.MethodPost, "/v1/movies", headers, movie)
+	if first.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d: %s", first.StatusCode, firstBody)
+	}
+
+	second, secondBody := doTestRequest(t, ts, http.MethodPost, "/v1/movies", headers, movie)
+	if second.StatusCode != http.StatusCreated || !bytes.Equal(secondBody, firstBody) {
+		t.Errorf("got %d %s, want the first response replayed", second.StatusCode, secondBody)
+	}
+	if second.Header.Get("Idempotent-Replayed") != "true" {
+		t.Error("got no Idempotent-Replayed header on the replay")
+	}
+	if second.Header.Get("Location") != first.Header.Get("Location") {
+		t.Errorf("got Location %q, want %q", second.Header.Get("Location"), first.Header.Get("Location"))
+	}
+	if got := countTestMovies(t, app); got != 1 {
+		t.Errorf("got %d movies, want 1", got)
+	}
+
+	// Keys are scoped to the user
+	token := createTestUser(t, app, "other-admin@example.com", data.RoleAdmin)
+	otherHeaders := http.Header{"Idempotency-Key": {"create-moana"}, "Authorization": {"Bearer " + token}}
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/movies", otherHeaders, movie)
+	if res.StatusCode != http.StatusCreated || res.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("got %d %s, want a new movie for another user", res.StatusCode, body)
+	}
+}
+
+func TestIdempotencyKeyReused(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	movie := map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}
+	admin := newTestAdminHeaders(t, app)
+	admin.Set("Idempotency-Key", "key")
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/movies", admin, movie)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+
+	tests := []struct {
+		name    string
+		headers http.Header
+		body    any
+	}{
+		{
+			name:    "different body",
+			headers: admin,
+			body:    map[string]any{"title": "Arrival", "year": 2016, "runtime": "116 mins", "genres": []string{"drama"}},
+		},
+		{
+			// The recorded response is JSON, which the client doesn't accept
+			name:    "different response format",
+			headers: http.Header{"Idempotency-Key": {"key"}, "Authorization": admin["Authorization"], "Accept": {"application/xml"}},
+			body:    movie,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, ts, http.MethodPost, "/v1/movies", tt.headers, tt.body)
+			if res.StatusCode != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d, want 422: %s", res.StatusCode, body)
+			}
+		})
+	}
+
+	if got := countTestMovies(t, app); got != 1 {
+		t.Errorf("got %d movies, want 1", got)
+	}
+}
+
+func TestIdempotentRequestInFlight(t *testing.T) {
+	app := newTestApplication(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls atomic.Int32
+
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		// The first call waits for the duplicate, the next ones fail
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+			app.writeJSON(w, http.StatusCreated, envelope{"created": true}, nil)
+			return
+		}
+		app.serverErrorResponse(w, r, errTestHandler)
+	})
+	ts := httptest.NewServer(app.negotiateResponses(app.authenticate(handler)))
+	t.Cleanup(ts.Close)
+
+	token := createTestUser(t, app, "user@example.com", data.RoleUser)
+	headers := http.Header{"Idempotency-Key": {"slow"}, "Authorization": {"Bearer " + token}}
+
+	// Sent without doTestRequest, which can't fail the test from another goroutine
+	done := make(chan int)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("{}"))
+		req.Header = headers.Clone()
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			done <- 0
+			return
+		}
+		res.Body.Close()
+		done <- res.StatusCode
+	}()
+	<-started
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/", headers, envelope{})
+	if res.StatusCode != http.StatusConflict || res.Header.Get("Retry-After") == "" {
+		t.Errorf("got %d %s, want a 409 with Retry-After while the first request runs", res.StatusCode, body)
+	}
+
+	close(release)
+	if status := <-done; status != http.StatusCreated {
+		t.Fatalf("got status %d for the first request", status)
+	}
+
+	res, _ = doTestRequest(t, ts, http.MethodPost, "/", headers, envelope{})
+	if res.StatusCode != http.StatusCreated || res.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("got status %d, want the first response replayed once it's done", res.StatusCode)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("got %d calls of the handler, want 1", calls.Load())
+	}
+}
+
+func TestIdempotentServerErrorsAreRetried(t *testing.T) {
+	app := newTestApplication(t)
+
+	var calls atomic.Int32
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			app.serverErrorResponse(w, r, errTestHandler)
+			return
+		}
+		app.writeJSON(w, http.StatusCreated, envelope{"created": true}, nil)
+	})
+	ts := httptest.NewServer(app.negotiateResponses(app.authenticate(handler)))
+	t.Cleanup(ts.Close)
+
+	token := createTestUser(t, app, "user@example.com", data.RoleUser)
+	headers := http.Header{"Idempotency-Key": {"flaky"}, "Authorization": {"Bearer " + token}}
+
+	if res, _ := doTestRequest(t, ts, http.MethodPost, "/", headers, envelope{}); res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want 500", res.StatusCode)
+	}
+
+	res, _ := doTestRequest(t, ts, http.MethodPost, "/", headers, envelope{})
+	if res.StatusCode != http.StatusCreated || res.Header.Get("Idempotent-Replayed") != "" {
+		t.Errorf("got status %d, want the request handled again", res.StatusCode)
+	}
+}
+
+func TestIdempotencyKeyRequiresAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+
+	var calls atomic.Int32
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		app.writeJSON(w, http.StatusCreated, envelope{"created": true}, nil)
+	})
+	ts := httptest.NewServer(app.negotiateResponses(app.authenticate(handler)))
+	t.Cleanup(ts.Close)
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/", http.Header{"Idempotency-Key": {"anonymous"}}, envelope{})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d for an anonymous request with a key, want 401: %s", res.StatusCode, body)
+	}
+
+	// Without a key, the handler decides
+	res, body = doTestRequest(t, ts, http.MethodPost, "/", nil, envelope{})
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("got status %d for an anonymous request without a key, want 201: %s", res.StatusCode, body)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("got %d calls of the handler, want 1", calls.Load())
+	}
+}
+
+func TestIdempotencyKeyLockRefreshed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the lock of a key to time out")
+	}
+
+	app := newTestApplication(t)
+	app.config.idempotency.lockTimeout = time.Second
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls atomic.Int32
+
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		app.writeJSON(w, http.StatusCreated, envelope{"created": true}, nil)
+	})
+	ts := httptest.NewServer(app.negotiateResponses(app.authenticate(handler)))
+	t.Cleanup(ts.Close)
+
+	token := createTestUser(t, app, "user@example.com", data.RoleUser)
+	headers := http.Header{"Idempotency-Key": {"slow"}, "Authorization": {"Bearer " + token}}
+
+	done := make(chan int)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("{}"))
+		req.Header = headers.Clone()
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			done <- 0
+			return
+		}
+		res.Body.Close()
+		done <- res.StatusCode
+	}()
+	<-started
+
+	// Well past the lock timeout, the key is still held by the running request
+	time.Sleep(2500 * time.Millisecond)
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/", headers, envelope{})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("got %d %s, want a 409 while the first request runs past the lock timeout", res.StatusCode, body)
+	}
+
+	close(release)
+	if status := <-done; status != http.StatusCreated {
+		t.Fatalf("got status %d for the first request", status)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("got %d calls of the handler, want 1", calls.Load())
+	}
+}
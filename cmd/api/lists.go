@@ -0,0 +1,474 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var LIST_LISTS_SUPPORTED_SORT = data.ListSortSafeList
+var LIST_LIST_ITEMS_SUPPORTED_SORT = data.ListItemSortSafeList
+
+// Data that's expected from the client to create a list
+type listInput struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Public      bool    `json:"public"`
+}
+
+// Data that's expected from the client to update a list. Fields left out are
+// unchanged, an empty description removes it. If Version is set the list must
+// currently be at that version
+type listPatch struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Public      *bool   `json:"public"`
+	Version     *int32  `json:"version"`
+}
+
+// Data that's expected from the client to add a movie to a list
+type listItemInput struct {
+	MovieID  int64 `json:"movie_id"`
+	Position *int  `json:"position"`
+}
+
+// Data that's expected from the client to move a movie within a list
+type listItemPatch struct {
+	Position *int `json:"position"`
+}
+
+// An empty description is the same as no description at all
+func listDescription(description *string) *string {
+	if description != nil && *description == "" {
+		return nil
+	}
+	return description
+}
+
+// Return the list model recording the actor of the request as the author of changes
+func (app *application) listModel(r *http.Request) data.ListModel {
+	return app.models.Lists.WithActor(app.actor(r))
+}
+
+// Fetch the list of the request, writing a not found response if there's none or
+// it's a private list of another user
+func (app *application) readListFromRequestParams(w http.ResponseWriter, r *http.Request) (*data.List, bool) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	list, err := app.models.Lists.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !list.Public && list.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return list, true
+}
+
+// Fetch the list of the request for a change, which only its owner can make.
+// Other users get a 403 for public lists and a 404 for private ones
+func (app *application) readOwnListFromRequestParams(w http.ResponseWriter, r *http.Request) (*data.List, bool) {
+	list, ok := app.readListFromRequestParams(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if list.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return list, true
+}
+
+// Read the movie id of a list item from a HTTP request's parameters
+func (app *application) readMovieIDFromRequestParams(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("movie_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid movie id parameter")
+	}
+
+	return id, nil
+}
+
+// Check the list is at the version of the X-Expected-Version header, if any,
+// writing the error response when it isn't
+func (app *application) checkListVersion(w http.ResponseWriter, r *http.Request, list *data.List) bool {
+	expectedVersion, err := app.readExpectedVersion(r, nil)
+	if err != nil {
+		v := validator.New()
+		v.AddError("version", "X-Expected-Version must be a positive integer")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	if expectedVersion != 0 && expectedVersion != list.Version {
+		app.editConflictResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// Write the response to a failed change of the items of a list
+func (app *application) listItemErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	v := validator.New()
+
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	case errors.Is(err, data.ErrUnknownMovie):
+		v.AddError("movie_id", "no movie with this id exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrDuplicateListItem):
+		v.AddError("movie_id", "the movie is already in the list")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrListFull):
+		v.AddError("movie_id", fmt.Sprintf("a list can't hold more than %d movies", data.MaxListItems))
+		app.failedValidationResponse(w, r, v.Errors)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List the lists of the authenticated user, or the public lists of another user
+// with user_id
+func (app *application) listListsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.ListFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	input.UserID = int64(app.readInt(queryStringValues, "user_id", 0, v))
+	input.Filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	input.Filters.Sort = app.readString(queryStringValues, "sort", "id")
+	input.SortSafeList = LIST_LISTS_SUPPORTED_SORT
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if input.UserID == 0 {
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+		input.UserID = user.ID
+	}
+	input.PublicOnly = input.UserID != user.ID
+
+	lists, metadata, err := app.models.Lists.GetAll(input.ListFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"lists": lists, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Create an empty list owned by the authenticated user
+func (app *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+	var input listInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	list := &data.List{
+		UserID:      app.contextGetUser(r).ID,
+		Name:        input.Name,
+		Description: listDescription(input.Description),
+		Public:      input.Public,
+	}
+
+	v := validator.New()
+
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.listModel(r).Insert(list)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d", list.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show the details of a specific list
+func (app *application) getListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readListFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Update the name, description and visibility of one of the authenticated user's lists
+func (app *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readOwnListFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var input listPatch
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	expectedVersion, err := app.readExpectedVersion(r, input.Version)
+	v.Check(err == nil, "version", "X-Expected-Version must be a positive integer")
+
+	if expectedVersion != 0 && expectedVersion != list.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+	if input.Description != nil {
+		list.Description = listDescription(input.Description)
+	}
+	if input.Public != nil {
+		list.Public = *input.Public
+	}
+
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.listModel(r).Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete one of the authenticated user's lists
+func (app *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readOwnListFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.listModel(r).Delete(list.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show the movies of a specific list, in the list's order by default
+func (app *application) listListItemsHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readListFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	filters.Sort = app.readString(queryStringValues, "sort", "position")
+	filters.SortSafeList = LIST_LIST_ITEMS_SUPPORTED_SORT
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	items, metadata, err := app.models.Lists.GetItems(list.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"items": items, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a movie to one of the authenticated user's lists, at the end unless a
+// position is given
+func (app *application) createListItemHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readOwnListFromRequestParams(w, r)
+	if !ok || !app.checkListVersion(w, r, list) {
+		return
+	}
+
+	var input listItemInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieID > 0, "movie_id", "must be provided")
+	v.Check(input.Position == nil || *input.Position >= 0, "position", "must be zero or greater")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.listModel(r).AddItem(list, input.MovieID, input.Position)
+	if err != nil {
+		app.listItemErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/lists/%d/items", list.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Move a movie to another position in one of the authenticated user's lists
+func (app *application) updateListItemHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readOwnListFromRequestParams(w, r)
+	if !ok || !app.checkListVersion(w, r, list) {
+		return
+	}
+
+	movieID, err := app.readMovieIDFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input listItemPatch
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Position != nil, "position", "must be provided")
+	v.Check(input.Position == nil || *input.Position >= 0, "position", "must be zero or greater")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.listModel(r).MoveItem(list, movieID, *input.Position)
+	if err != nil {
+		app.listItemErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Remove a movie from one of the authenticated user's lists
+func (app *application) deleteListItemHandler(w http.ResponseWriter, r *http.Request) {
+	list, ok := app.readOwnListFromRequestParams(w, r)
+	if !ok || !app.checkListVersion(w, r, list) {
+		return
+	}
+
+	movieID, err := app.readMovieIDFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.listModel(r).RemoveItem(list, movieID)
+	if err != nil {
+		app.listItemErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Create a list through the API, returning its path
+func createTestList(t *testing.T, ts *httptest.Server, headers http.Header, name string, public bool) string {
+	t.Helper()
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/lists", headers, map[string]any{"name": name, "public": public})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+
+	return res.Header.Get("Location")
+}
+
+// Return the titles of the movies in a list, in the list's order, and its item count
+func getTestListItems(t *testing.T, ts *httptest.Server, headers http.Header, path string) ([]string, int) {
+	t.Helper()
+
+	res, body := doTestRequest(t, ts, http.MethodGet, path+"/items", headers, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var items struct {
+		Items []data.ListItem `json:"items"`
+	}
+	if err := json.Unmarshal(body, &items); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := []string{}
+	for _, item := range items.Items {
+		titles = append(titles, item.Movie.Title)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodGet, path, headers, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var list struct {
+		List data.List `json:"list"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatal(err)
+	}
+
+	return titles, list.List.ItemCount
+}
+
+func TestListItems(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	owner := http.Header{"Authorization": {"Bearer " + createTestUser(t, app, "owner@example.com", data.RoleUser)}}
+
+	var movies []*data.Movie
+	for _, title := range []string{"Moana", "Arrival", "Inception", "Heat"} {
+		movies = append(movies, insertTestMovie(t, app, title))
+	}
+	path := createTestList(t, ts, owner, "Watch later", false)
+
+	tests := []struct {
+		name   string
+		method string
+		movie  int
+		body   map[string]any
+		titles []string
+	}{
+		{name: "add", method: http.MethodPost, movie: 0, titles: []string{"Moana"}},
+		{name: "add at the end", method: http.MethodPost, movie: 1, titles: []string{"Moana", "Arrival"}},
+		{name: "add first", method: http.MethodPost, movie: 2, body: map[string]any{"position": 0}, titles: []string{"Inception", "Moana", "Arrival"}},
+		{name: "add past the end", method: http.MethodPost, movie: 3, body: map[string]any{"position": 10}, titles: []string{"Inception", "Moana", "Arrival", "Heat"}},
+		{name: "move down", method: http.MethodPatch, movie: 2, body: map[string]any{"position": 2}, titles: []string{"Moana", "Arrival", "Inception", "Heat"}},
+		{name: "move up", method: http.MethodPatch, movie: 3, body: map[string]any{"position": 0}, titles: []string{"Heat", "Moana", "Arrival", "Inception"}},
+		{name: "remove", method: http.MethodDelete, movie: 0, titles: []string{"Heat", "Arrival", "Inception"}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			itemPath := path + "/items"
+			body := map[string]any{"movie_id": movies[tt.movie].ID}
+			if tt.method != http.MethodPost {
+				itemPath = fmt.Sprintf("%s/items/%d", path, movies[tt.movie].ID)
+				body = map[string]any{}
+			}
+			for key, value := range tt.body {
+				body[key] = value
+			}
+			if tt.method == http.MethodDelete {
+				body = nil
+			}
+
+			res, resBody := doTestRequest(t, ts, tt.method, itemPath, owner, body)
+			if res.StatusCode >= http.StatusBadRequest {
+				t.Fatalf("got status %d: %s", res.StatusCode, resBody)
+			}
+
+			var response struct {
+				List data.List `json:"list"`
+			}
+			if err := json.Unmarshal(resBody, &response); err != nil {
+				t.Fatal(err)
+			}
+			// Every change bumps the version of the list
+			if response.List.Version != int32(i+2) {
+				t.Errorf("got version %d, want %d", response.List.Version, i+2)
+			}
+
+			titles, count := getTestListItems(t, ts, owner, path)
+			if !reflect.DeepEqual(titles, tt.titles) || count != len(tt.titles) {
+				t.Errorf("got %d movies %q, want %q", count, titles, tt.titles)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name    string
+		method  string
+		path    string
+		headers http.Header
+		body    map[string]any
+		status  int
+	}{
+		{name: "duplicate", method: http.MethodPost, path: path + "/items", body: map[string]any{"movie_id": movies[1].ID}, status: http.StatusUnprocessableEntity},
+		{name: "unknown movie", method: http.MethodPost, path: path + "/items", body: map[string]any{"movie_id": 404}, status: http.StatusUnprocessableEntity},
+		{name: "negative position", method: http.MethodPost, path: path + "/items", body: map[string]any{"movie_id": movies[0].ID, "position": -1}, status: http.StatusUnprocessableEntity},
+		{name: "move a missing movie", method: http.MethodPatch, path: fmt.Sprintf("%s/items/%d", path, movies[0].ID), body: map[string]any{"position": 0}, status: http.StatusNotFound},
+		{name: "stale version", method: http.MethodPost, path: path + "/items", headers: http.Header{"X-Expected-Version": {"1"}}, body: map[string]any{"movie_id": movies[0].ID}, status: http.StatusConflict},
+	}
+	for _, tt := range invalid {
+		headers := owner.Clone()
+		for key, values := range tt.headers {
+			headers[key] = values
+		}
+		res, body := doTestRequest(t, ts, tt.method, tt.path, headers, tt.body)
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: got status %d, want %d: %s", tt.name, res.StatusCode, tt.status, body)
+		}
+	}
+
+	// Movies in the trash are hidden until they're restored, and removed when purged
+	if err := app.models.Movies.Delete(movies[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if titles, count := getTestListItems(t, ts, owner, path); !reflect.DeepEqual(titles, []string{"Heat", "Inception"}) || count != 2 {
+		t.Errorf("got %d movies %q, want the deleted one hidden", count, titles)
+	}
+	if _, err := app.models.Movies.Restore(movies[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if titles, count := getTestListItems(t, ts, owner, path); !reflect.DeepEqual(titles, []string{"Heat", "Arrival", "Inception"}) || count != 3 {
+		t.Errorf("got %d movies %q, want the restored one back in place", count, titles)
+	}
+
+	if err := app.models.Movies.Delete(movies[3].ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err := app.models.Movies.DB.Exec(`UPDATE movies SET deleted_at = datetime('now', '-2 days') WHERE id = $1`, movies[3].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.models.Movies.Purge(24 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if titles, count := getTestListItems(t, ts, owner, path); !reflect.DeepEqual(titles, []string{"Arrival", "Inception"}) || count != 2 {
+		t.Errorf("got %d movies %q, want the purged one removed", count, titles)
+	}
+}
+
+func TestListVisibility(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	ownerToken := createTestUser(t, app, "owner@example.com", data.RoleUser)
+	owner := http.Header{"Authorization": {"Bearer " + ownerToken}}
+	other := http.Header{"Authorization": {"Bearer " + createTestUser(t, app, "other@example.com", data.RoleUser)}}
+
+	movie := insertTestMovie(t, app, "Moana")
+	private := createTestList(t, ts, owner, "Watch later", false)
+	public := createTestList(t, ts, owner, "Favourites", true)
+
+	for _, path := range []string{private, public} {
+		res, body := doTestRequest(t, ts, http.MethodPost, path+"/items", owner, map[string]any{"movie_id": movie.ID})
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+	}
+
+	reads := []struct {
+		name    string
+		path    string
+		headers http.Header
+		status  int
+	}{
+		{name: "private by its owner", path: private, headers: owner, status: http.StatusOK},
+		{name: "private items by its owner", path: private + "/items", headers: owner, status: http.StatusOK},
+		{name: "private by another user", path: private, headers: other, status: http.StatusNotFound},
+		{name: "private items by another user", path: private + "/items", headers: other, status: http.StatusNotFound},
+		{name: "private anonymously", path: private, status: http.StatusNotFound},
+		{name: "public by another user", path: public, headers: other, status: http.StatusOK},
+		{name: "public items anonymously", path: public + "/items", status: http.StatusOK},
+		{name: "own lists anonymously", path: "/v1/lists", status: http.StatusUnauthorized},
+	}
+	for _, tt := range reads {
+		res, body := doTestRequest(t, ts, http.MethodGet, tt.path, tt.headers, nil)
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: got status %d, want %d: %s", tt.name, res.StatusCode, tt.status, body)
+		}
+	}
+
+	// Public lists are only changed by their owner
+	changes := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodPatch, public, map[string]any{"name": "Renamed"}},
+		{http.MethodDelete, public, nil},
+		{http.MethodPost, public + "/items", map[string]any{"movie_id": movie.ID}},
+		{http.MethodDelete, fmt.Sprintf("%s/items/%d", public, movie.ID), nil},
+	}
+	for _, change := range changes {
+		res, body := doTestRequest(t, ts, change.method, change.path, other, change.body)
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s: got status %d, want 403: %s", change.method, change.path, res.StatusCode, body)
+		}
+	}
+
+	lists := func(t *testing.T, query string, headers http.Header) []string {
+		t.Helper()
+
+		res, body := doTestRequest(t, ts, http.MethodGet, "/v1/lists"+query, headers, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+		var response struct {
+			Lists []data.List `json:"lists"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, list := range response.Lists {
+			names = append(names, list.Name)
+		}
+		return names
+	}
+
+	if names := lists(t, "", owner); !reflect.DeepEqual(names, []string{"Watch later", "Favourites"}) {
+		t.Errorf("got own lists %q, want both", names)
+	}
+	user, err := app.models.Users.GetForToken(ownerToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := lists(t, fmt.Sprintf("?user_id=%d", user.ID), other); !reflect.DeepEqual(names, []string{"Favourites"}) {
+		t.Errorf("got lists %q of another user, want the public one only", names)
+	}
+}
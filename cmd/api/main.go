@@ -9,11 +9,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
 	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/graphql"
 )
 
 // Temporarily having this hardcoded
@@ -29,12 +32,33 @@ type config struct {
 		maxIdleTime    string
 		DBQueryTimeout time.Duration
 	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	events struct {
+		retention     time.Duration
+		pruneInterval time.Duration
+		heartbeat     time.Duration
+	}
+	idempotency struct {
+		ttl         time.Duration
+		lockTimeout time.Duration
+	}
 }
 
 type application struct {
-	config config
-	logger *log.Logger
-	models data.Models
+	config      config
+	logger      *log.Logger
+	models      data.Models
+	openAPISpec envelope
+	// Schema of the movies served on /v1/graphql
+	graphQLSchema *graphql.Schema
+	// Closed when the server starts shutting down, so long-lived responses end
+	shutdown chan struct{}
+	// Sends deliveries to webhooks, and webhookWake wakes up their dispatcher
+	webhookClient *http.Client
+	webhookWake   chan struct{}
 }
 
 func main() {
@@ -57,6 +81,38 @@ func main() {
 		3*time.Second,
 		"DB query timeout in seconds",
 	)
+	flag.DurationVar(
+		&cfg.trash.retention,
+		"trash-retention",
+		30*24*time.Hour,
+		"How long deleted movies stay in the trash before being purged (0 keeps them forever)",
+	)
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "Interval between trash purges")
+	flag.DurationVar(
+		&cfg.events.retention,
+		"movie-events-retention",
+		7*24*time.Hour,
+		"How long changes stay in the change log, and so how long clients can resume their event stream (0 keeps them forever)",
+	)
+	flag.DurationVar(&cfg.events.pruneInterval, "movie-events-prune-interval", time.Hour, "Interval between change log prunes")
+	flag.DurationVar(
+		&cfg.events.heartbeat,
+		"movie-events-heartbeat",
+		15*time.Second,
+		"Interval between the comments keeping idle event streams open",
+	)
+	flag.DurationVar(
+		&cfg.idempotency.ttl,
+		"idempotency-key-ttl",
+		24*time.Hour,
+		"How long responses to requests with an Idempotency-Key are replayed",
+	)
+	flag.DurationVar(
+		&cfg.idempotency.lockTimeout,
+		"idempotency-lock-timeout",
+		time.Minute,
+		"How long an Idempotency-Key stays locked once its request stopped refreshing it, e.g. in a crash",
+	)
 	flag.Parse()
 
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
@@ -69,11 +125,25 @@ func main() {
 	logger.Printf("database connection pool established")
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db, cfg.db.DBQueryTimeout),
+		config:   cfg,
+		logger:   logger,
+		models:   data.NewModels(db, cfg.db.DBQueryTimeout),
+		shutdown: make(chan struct{}),
+
+		webhookClient: newWebhookClient(),
+		webhookWake:   make(chan struct{}, 1),
+	}
+
+	if cfg.trash.retention > 0 {
+		go app.purgeTrash()
 	}
 
+	if cfg.events.retention > 0 {
+		go app.pruneMovieEvents()
+	}
+
+	go app.dispatchWebhooks()
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
 		Handler:      app.routes(),
@@ -82,9 +152,36 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Event streams never become idle on their own, so they're ended first
+	srv.RegisterOnShutdown(func() { close(app.shutdown) })
+
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		logger.Printf("Shutting down server, caught signal %s", s)
+
+		// Requests in flight get 5s to complete
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
 	logger.Printf("Starting %s server on %s", cfg.env, srv.Addr)
 	err = srv.ListenAndServe()
-	logger.Fatal(err)
+	if !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatal(err)
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	logger.Printf("Stopped server")
 }
 
 func openDB(cfg config) (*sql.DB, error) {
@@ -1,33 +1,128 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/jsonpatch"
 	"greenlight.flaviogalon.github.io/internal/validator"
 )
 
-var LIST_MOVIES_SUPPORTED_SORT []string = []string{
-	"id",
-	"title",
-	"year",
-	"runtime",
-	"-id",
-	"-title",
-	"-year",
-	"-runtime",
+var LIST_MOVIES_SUPPORTED_SORT []string = data.MovieSortSafeList
+
+// Fields of movies the list endpoints compare with <field>_gt, _gte, _lt and _lte
+var MOVIE_SUPPORTED_CONDITIONS = data.MovieConditionSafeList
+
+// Read the filters shared by the endpoints listing movies
+func (app *application) readMovieFilter(queryStringValues url.Values) data.MovieFilter {
+	return data.MovieFilter{
+		Title: app.readString(queryStringValues, "title", ""),
+		// genres_all is the explicit name of genres
+		Genres: slices.Concat(
+			app.readCSV(queryStringValues, "genres", []string{}),
+			app.readCSV(queryStringValues, "genres_all", []string{}),
+		),
+		GenresAny: app.readCSV(queryStringValues, "genres_any", []string{}),
+		Director:  app.readString(queryStringValues, "director", ""),
+		Actor:     app.readString(queryStringValues, "actor", ""),
+	}
+}
+
+// Read the comparisons of the endpoints listing movies, such as year_gte=2000 and
+// created_after=2024-01-01T00:00:00Z
+func (app *application) readMovieConditions(queryStringValues url.Values, v *validator.Validator) []data.Condition {
+	conditions := []data.Condition{}
+
+	for _, field := range []string{"year", "runtime"} {
+		for _, operator := range []string{"gt", "gte", "lt", "lte"} {
+			key := field + "_" + operator
+			if !queryStringValues.Has(key) {
+				continue
+			}
+			value := app.readInt(queryStringValues, key, 0, v)
+			conditions = append(conditions, data.Condition{Field: field, Operator: operator, Value: value})
+		}
+	}
+
+	for _, bound := range []struct{ key, operator string }{
+		{"created_after", "gte"},
+		{"created_before", "lt"},
+	} {
+		if !queryStringValues.Has(bound.key) {
+			continue
+		}
+		value := app.readTime(queryStringValues, bound.key, v)
+		conditions = append(conditions, data.Condition{Field: "created_at", Operator: bound.operator, Value: value})
+	}
+
+	return conditions
+}
+
+// Data that's expected from the client to create a movie
+type movieInput struct {
+	Title   string       `json:"title" validate:"required,max=500"`
+	Year    int32        `json:"year" validate:"required,min=1888,year_not_future"`
+	Runtime data.Runtime `json:"runtime" validate:"required,min=1"`
+	Genres  []string     `json:"genres" validate:"required,min=1,max=5,unique"`
+	// Optional identifier of the movie in an external source
+	ExternalID *string `json:"external_id" validate:"min=1,max=255"`
+}
+
+func (input movieInput) movie() *data.Movie {
+	return &data.Movie{
+		Title:      input.Title,
+		Year:       input.Year,
+		Runtime:    input.Runtime,
+		Genres:     input.Genres,
+		ExternalID: input.ExternalID,
+	}
+}
+
+// Complete representation of a movie replacing an existing one. Fields left out
+// are cleared, and if Version is set the movie must currently be at that version
+type movieReplacement struct {
+	movieInput
+	Version *int32 `json:"version" validate:"min=1"`
+}
+
+// Data that's expected from the client to update a movie, pointers and slices have
+// a 'nil' zero-value. Rules on pointer fields are only checked when the field is provided
+type moviePatch struct {
+	Title   *string       `json:"title" validate:"max=500"`
+	Year    *int32        `json:"year" validate:"min=1888,year_not_future"`
+	Runtime *data.Runtime `json:"runtime" validate:"min=1"`
+	Genres  []string      `json:"genres" validate:"max=5,unique"`
+}
+
+// Apply the fields provided on the request body to a movie
+func (patch moviePatch) apply(movie *data.Movie) {
+	if patch.Title != nil {
+		movie.Title = *patch.Title
+	}
+	if patch.Year != nil {
+		movie.Year = *patch.Year
+	}
+	if patch.Runtime != nil {
+		movie.Runtime = *patch.Runtime
+	}
+	if patch.Genres != nil {
+		movie.Genres = patch.Genres
+	}
 }
 
 // Create a new Movie
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
-	}
+	var input movieInput
 
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -35,30 +130,42 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
-	}
-
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if v.Struct(input); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	movie := input.movie()
+
+	err = app.validateMovie(v, movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.movieModel(r).Insert(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_id", "a movie with this external id already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -72,7 +179,16 @@ func (app *application) getMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	expand := app.readExpand(r.URL.Query(), v)
+	fields := app.readFields(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -83,7 +199,13 @@ func (app *application) getMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	expanded, err := app.expandMovies([]*data.Movie{movie}, expand, fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": expanded[0]}, nil)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 	}
@@ -97,6 +219,14 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	expectedVersion, err := app.readExpectedVersion(r, nil)
+	if err != nil {
+		v := validator.New()
+		v.AddError("version", "X-Expected-Version must be a positive integer")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
@@ -108,43 +238,213 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Data that's expected from the client, pointers and slices have a 'nil' zero-value
-	var inputData struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	// Optional optimistic concurrency check, the client sends the version it last saw
+	if expectedVersion != 0 && expectedVersion != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	// Besides the partial movie of application/json bodies, RFC 7396 merge patches
+	// and RFC 6902 JSON patches are applied to the movie's JSON representation
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case MERGE_PATCH_CONTENT_TYPE, JSON_PATCH_CONTENT_TYPE:
+		movie, err = app.readMoviePatchDocument(w, r, movie, mediaType)
+		if err != nil {
+			var bodyErr patchBodyError
+			var invalidErr invalidPatchError
+			switch {
+			case errors.As(err, &bodyErr):
+				app.badRequestResponse(w, r, bodyErr.err)
+			case errors.As(err, &invalidErr):
+				app.failedValidationResponse(w, r, invalidErr.errors)
+			case errors.Is(err, jsonpatch.ErrInvalidOperation):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.errorResponse(w, r, http.StatusConflict, err.Error())
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+	default:
+		var inputData moviePatch
+
+		err = app.readJSON(w, r, &inputData)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if v.Struct(inputData); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		inputData.apply(movie)
+	}
+
+	// Validate the resulting data
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.movieModel(r).Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_id", "a movie with this external id already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
+}
+
+// Replace a movie with the complete representation on the request body
+func (app *application) replaceMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input movieReplacement
 
-	err = app.readJSON(w, r, &inputData)
+	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	// Check if fields were provided on request body
-	if inputData.Title != nil {
-		movie.Title = *inputData.Title
+	v := validator.New()
+
+	expectedVersion, err := app.readExpectedVersion(r, input.Version)
+	v.Check(err == nil, "version", "X-Expected-Version must be a positive integer")
+
+	if v.Struct(input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	current, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if expectedVersion != 0 && expectedVersion != current.Version {
+		app.editConflictResponse(w, r)
+		return
 	}
-	if inputData.Year != nil {
-		movie.Year = *inputData.Year
+
+	movie := input.movie()
+	movie.ID = current.ID
+	movie.CreatedAt = current.CreatedAt
+	movie.Version = current.Version
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
-	if inputData.Runtime != nil {
-		movie.Runtime = *inputData.Runtime
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
-	if inputData.Genres != nil {
-		movie.Genres = inputData.Genres
+
+	err = app.movieModel(r).Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_id", "a movie with this external id already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Create or replace the movie with the external id of the URL. With a version
+// (on the body or as X-Expected-Version) the movie must exist and be at that version
+func (app *application) upsertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	externalID := r.PathValue("external_id")
+
+	var input movieReplacement
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
-	// Validate the resulting data
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	expectedVersion, err := app.readExpectedVersion(r, input.Version)
+	v.Check(err == nil, "version", "X-Expected-Version must be a positive integer")
+	v.Check(len(externalID) <= 255, "external_id", "must not be more than 255 bytes long")
+	v.Check(
+		input.ExternalID == nil || *input.ExternalID == externalID,
+		"external_id",
+		"must match the external id of the URL",
+	)
+
+	if v.Struct(input); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	movie := input.movie()
+	movie.ExternalID = &externalID
+	movie.Version = expectedVersion
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := app.movieModel(r).Upsert(movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -155,7 +455,14 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	status := http.StatusOK
+	headers := make(http.Header)
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	}
+
+	err = app.writeResponse(w, r, status, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -169,7 +476,32 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err = app.movieModel(r).Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie succesfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Take a deleted movie out of the trash
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.movieModel(r).Restore(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -180,7 +512,54 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie succesfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+var TRASH_MOVIES_SUPPORTED_SORT = append([]string{"deleted_at", "-deleted_at"}, data.MovieSortSafeList...)
+
+// List the movies in the trash, most recently deleted first by default
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	input.MovieFilter = app.readMovieFilter(queryStringValues)
+	input.Conditions = app.readMovieConditions(queryStringValues, v)
+	input.Trash = true
+	expand := app.readExpand(queryStringValues, v)
+	input.Fields = app.readFields(queryStringValues, v)
+	input.Filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	input.Filters.Sort = app.readString(queryStringValues, "sort", "-deleted_at")
+	input.SortSafeList = TRASH_MOVIES_SUPPORTED_SORT
+	input.ConditionSafeList = MOVIE_SUPPORTED_CONDITIONS
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	expanded, err := app.expandMovies(movies, expand, input.Fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": expanded, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -189,8 +568,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 // List movies with filters
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilter
 		data.Filters
 	}
 
@@ -198,26 +576,239 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	queryStringValues := r.URL.Query()
 
-	input.Title = app.readString(queryStringValues, "title", "")
-	input.Genres = app.readCSV(queryStringValues, "genres", []string{})
+	input.MovieFilter = app.readMovieFilter(queryStringValues)
+	input.Conditions = app.readMovieConditions(queryStringValues, v)
+	input.IncludeDeleted = app.readBool(queryStringValues, "include_deleted", false, v)
+	expand := app.readExpand(queryStringValues, v)
+	input.Fields = app.readFields(queryStringValues, v)
+	facets := app.readFacets(queryStringValues, v)
 	input.Filters.Page = app.readInt(queryStringValues, "page", 1, v)
 	input.Filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
 	input.Filters.Sort = app.readString(queryStringValues, "sort", "id")
 	input.SortSafeList = LIST_MOVIES_SUPPORTED_SORT
+	input.ConditionSafeList = MOVIE_SUPPORTED_CONDITIONS
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	// Deleted movies are only listed along with the others to admins
+	if user := app.contextGetUser(r); input.IncludeDeleted && !user.IsAdmin() {
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+		} else {
+			app.notPermittedResponse(w, r)
+		}
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilter, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies}, nil)
+	expanded, err := app.expandMovies(movies, expand, input.Fields)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	response := envelope{"movies": expanded, "metadata": metadata}
+
+	// Facets count every movie matching the filters, not only the ones of the page
+	if len(facets) > 0 {
+		response["facets"], err = app.models.Movies.Facets(input.MovieFilter, input.Filters, facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Number of movies inserted per transaction when importing
+const IMPORT_MOVIES_BATCH_SIZE = 500
+
+// Content types mapped to the import format they carry
+var IMPORT_MOVIES_CONTENT_TYPES = map[string]string{
+	"text/csv":                  data.ImportFormatCSV,
+	"application/x-ndjson":      data.ImportFormatNDJSON,
+	"application/jsonl":         data.ImportFormatNDJSON,
+	"text/tab-separated-values": data.ImportFormatIMDb,
+}
+
+// Import movies from a CSV, NDJSON or IMDb title.basics.tsv body, streamed row by row
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = IMPORT_MOVIES_CONTENT_TYPES[mediaType]
+	}
+
+	v := validator.New()
+	v.Check(
+		validator.PermittedValue(format, data.ImportFormatCSV, data.ImportFormatNDJSON, data.ImportFormatIMDb),
+		"format",
+		"must be one of csv, ndjson or imdb, or be implied by the Content-Type header",
+	)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Imports can be much larger and slower than regular requests
+	var maxBytes int64 = 1024 * 1024 * 1024
+	body, err := decodeRequestBody(w, r, maxBytes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	r.Body = body
+
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	report, err := app.movieModel(r).Import(r.Body, format, IMPORT_MOVIES_BATCH_SIZE)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidImportInput):
+			// Batches committed before the error are kept, so the report is still useful
+			env := envelope{"error": err.Error()}
+			if report != nil {
+				env["report"] = report
+			}
+			err = app.writeResponse(w, r, http.StatusBadRequest, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Formats movies can be exported in, with the content type they are served as
+var EXPORT_MOVIES_CONTENT_TYPES = map[string]string{
+	"ndjson": "application/x-ndjson",
+	"csv":    "text/csv",
+}
+
+// Stream every movie matching the list filters as NDJSON or CSV. Movies are read
+// in keyset pages by data.MovieModel.Export rather than from a single cursor: with
+// the one-connection pool a cursor open for the whole download would hold the only
+// connection, and even on a connection of its own its read lock would keep SQLite
+// writers from committing until the client is done. The price is that a movie
+// whose sort values change during an export may be left out or exported twice.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilter
+		Format string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	input.MovieFilter = app.readMovieFilter(queryStringValues)
+	input.Conditions = app.readMovieConditions(queryStringValues, v)
+	input.IncludeDeleted = app.readBool(queryStringValues, "include_deleted", false, v)
+	input.Format = app.readString(queryStringValues, "format", "")
+	input.Filters.Sort = app.readString(queryStringValues, "sort", "id")
+	input.SortSafeList = LIST_MOVIES_SUPPORTED_SORT
+	input.ConditionSafeList = MOVIE_SUPPORTED_CONDITIONS
+
+	// The format query parameter takes precedence over the Accept header
+	if input.Format == "" {
+		input.Format = "ndjson"
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			input.Format = "csv"
+		}
+	}
+
+	_, ok := EXPORT_MOVIES_CONTENT_TYPES[input.Format]
+	v.Check(ok, "format", "must be either ndjson or csv")
+	data.ValidateSort(v, input.Filters)
+	data.ValidateConditions(v, input.Filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Same as the listing, only admins can export deleted movies
+	if user := app.contextGetUser(r); input.IncludeDeleted && !user.IsAdmin() {
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+		} else {
+			app.notPermittedResponse(w, r)
+		}
+		return
+	}
+
+	// The export takes as long as the client needs to download it
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", EXPORT_MOVIES_CONTENT_TYPES[input.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, input.Format))
+	w.WriteHeader(http.StatusOK)
+
+	fw := newFlushWriter(w)
+
+	var writeMovie func(movie *data.Movie) error
+
+	switch input.Format {
+	case "csv":
+		csvWriter := csv.NewWriter(fw)
+		csvWriter.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+
+		writeMovie = func(movie *data.Movie) error {
+			csvWriter.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				strconv.Itoa(int(movie.Runtime)),
+				strings.Join(movie.Genres, ","),
+				strconv.Itoa(int(movie.Version)),
+			})
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+
+	default:
+		enc := json.NewEncoder(fw)
+		writeMovie = func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		}
+	}
+
+	err := app.models.Movies.Export(r.Context(), input.MovieFilter, input.Filters, func(movie *data.Movie) error {
+		err := writeMovie(movie)
+		if err != nil {
+			return err
+		}
+		return fw.rowWritten()
+	})
+	if err == nil {
+		err = fw.Flush()
+	}
+	if err != nil {
+		// The status line is gone already, so abort the response for the client
+		// to notice that the export is incomplete
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
 	}
 }
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+func TestUpdateMovieExpectedVersion(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	movie := insertTestMovie(t, app, "Moana")
+	path := fmt.Sprintf("/v1/movies/%d", movie.ID)
+	patch := map[string]any{"title": "Renamed"}
+
+	tests := []struct {
+		name    string
+		version string
+		status  int
+	}{
+		{name: "not a number", version: "abc", status: http.StatusUnprocessableEntity},
+		{name: "not positive", version: "0", status: http.StatusUnprocessableEntity},
+		{name: "stale", version: fmt.Sprint(movie.Version + 1), status: http.StatusConflict},
+		// Parsed rather than compared as a string
+		{name: "leading zero", version: fmt.Sprintf("0%d", movie.Version), status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := admin.Clone()
+			headers.Set("X-Expected-Version", tt.version)
+
+			res, body := doTestRequest(t, ts, http.MethodPatch, path, headers, patch)
+			if res.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d: %s", res.StatusCode, tt.status, body)
+			}
+		})
+	}
+}
+
+func TestMovieChangesRequireAdmin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	movie := insertTestMovie(t, app, "Moana")
+	path := fmt.Sprintf("/v1/movies/%d", movie.ID)
+	userToken := createTestUser(t, app, "user@example.com", data.RoleUser)
+
+	changes := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodPost, "/v1/movies", map[string]any{"title": "Arrival", "year": 2016, "runtime": "116 mins", "genres": []string{"drama"}}},
+		{http.MethodPost, "/v1/movies/import?format=ndjson", nil},
+		{http.MethodPost, "/v1/movies/batch", failingBatch},
+		{http.MethodPatch, path, map[string]any{"title": "Renamed"}},
+		{http.MethodPut, path, map[string]any{"title": "Renamed", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}},
+		{http.MethodPut, "/v1/movies/external/tt3521164", map[string]any{"title": "Renamed", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}},
+		{http.MethodDelete, path, nil},
+	}
+
+	callers := []struct {
+		name    string
+		headers http.Header
+		status  int
+	}{
+		{name: "anonymous", status: http.StatusUnauthorized},
+		{name: "user", headers: http.Header{"Authorization": {"Bearer " + userToken}}, status: http.StatusForbidden},
+	}
+
+	for _, caller := range callers {
+		for _, change := range changes {
+			t.Run(caller.name+" "+change.method, func(t *testing.T) {
+				res, body := doTestRequest(t, ts, change.method, change.path, caller.headers, change.body)
+				if res.StatusCode != caller.status {
+					t.Errorf("got status %d, want %d: %s", res.StatusCode, caller.status, body)
+				}
+			})
+		}
+	}
+
+	got, err := app.models.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != movie.Title || got.Version != movie.Version {
+		t.Errorf("got %q at version %d, want the movie unchanged", got.Title, got.Version)
+	}
+	if count := countTestMovies(t, app); count != 1 {
+		t.Errorf("got %d movies, want 1", count)
+	}
+}
+
+func TestTrashRequiresAdmin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	movie := insertTestMovie(t, app, "Moana")
+	if err := app.models.Movies.Delete(movie.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	userToken := createTestUser(t, app, "user@example.com", data.RoleUser)
+	adminToken := createTestUser(t, app, "admin@example.com", data.RoleAdmin)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "anonymous", status: http.StatusUnauthorized},
+		{name: "user", token: userToken, status: http.StatusForbidden},
+		{name: "admin", token: adminToken, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.token != "" {
+				headers.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies/trash", headers, nil)
+			if res.StatusCode != tt.status {
+				t.Errorf("listing the trash: got status %d, want %d: %s", res.StatusCode, tt.status, body)
+			}
+
+			res, body = doTestRequest(t, ts, http.MethodPost, fmt.Sprintf("/v1/movies/%d/restore", movie.ID), headers, nil)
+			if res.StatusCode != tt.status {
+				t.Errorf("restoring a movie: got status %d, want %d: %s", res.StatusCode, tt.status, body)
+			}
+		})
+	}
+}
+
+func TestExportMovies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	for _, title := range []string{"Moana", "Arrival", "Coco"} {
+		insertTestMovie(t, app, title)
+	}
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		contentType string
+		filename    string
+		// Lines of the body, including the CSV header
+		lines int
+	}{
+		{name: "ndjson by default", path: "/v1/movies/export", contentType: "application/x-ndjson", filename: "movies.ndjson", lines: 3},
+		{name: "csv by Accept", path: "/v1/movies/export", accept: "text/csv", contentType: "text/csv", filename: "movies.csv", lines: 4},
+		{name: "format over Accept", path: "/v1/movies/export?format=ndjson", accept: "text/csv", contentType: "application/x-ndjson", filename: "movies.ndjson", lines: 3},
+		{name: "filtered", path: "/v1/movies/export?format=csv&title=arrival", contentType: "text/csv", filename: "movies.csv", lines: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.accept != "" {
+				headers.Set("Accept", tt.accept)
+			}
+
+			res, body := doTestRequest(t, ts, http.MethodGet, tt.path, headers, nil)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d: %s", res.StatusCode, body)
+			}
+			if got := res.Header.Get("Content-Type"); got != tt.contentType {
+				t.Errorf("got Content-Type %q, want %q", got, tt.contentType)
+			}
+			if got, want := res.Header.Get("Content-Disposition"), fmt.Sprintf(`attachment; filename="%s"`, tt.filename); got != want {
+				t.Errorf("got Content-Disposition %q, want %q", got, want)
+			}
+			if got := strings.Count(string(body), "\n"); got != tt.lines {
+				t.Errorf("got %d lines, want %d:\n%s", got, tt.lines, body)
+			}
+		})
+	}
+
+	res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies/export?format=xml", nil, nil)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an unsupported format, want 422: %s", res.StatusCode, body)
+	}
+}
+
+func TestUpdateMoviePatchDocuments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	movie := insertTestMovie(t, app, "Moana")
+	path := fmt.Sprintf("/v1/movies/%d", movie.ID)
+
+	// Applied in order, each successful patch bumps the version
+	steps := []struct {
+		name        string
+		contentType string
+		patch       any
+		status      int
+		title       string
+		genres      []string
+	}{
+		{
+			// Unlike an absent field, null removes the genres
+			name:        "merge patch removing a required field",
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			patch:       map[string]any{"genres": nil},
+			status:      http.StatusUnprocessableEntity,
+			title:       "Moana",
+			genres:      []string{"animation"},
+		},
+		{
+			name:        "merge patch",
+			contentType: MERGE_PATCH_CONTENT_TYPE,
+			patch:       map[string]any{"title": "Renamed"},
+			status:      http.StatusOK,
+			title:       "Renamed",
+			genres:      []string{"animation"},
+		},
+		{
+			name:        "JSON patch testing a stale version",
+			contentType: JSON_PATCH_CONTENT_TYPE,
+			patch: []map[string]any{
+				{"op": "test", "path": "/version", "value": movie.Version},
+				{"op": "add", "path": "/genres/-", "value": "adventure"},
+			},
+			status: http.StatusConflict,
+			title:  "Renamed",
+			genres: []string{"animation"},
+		},
+		{
+			name:        "JSON patch adding a genre",
+			contentType: JSON_PATCH_CONTENT_TYPE,
+			patch: []map[string]any{
+				{"op": "test", "path": "/version", "value": movie.Version + 1},
+				{"op": "add", "path": "/genres/-", "value": "adventure"},
+			},
+			status: http.StatusOK,
+			title:  "Renamed",
+			genres: []string{"animation", "adventure"},
+		},
+		{
+			name:        "JSON patch removing a genre",
+			contentType: JSON_PATCH_CONTENT_TYPE,
+			patch:       []map[string]any{{"op": "remove", "path": "/genres/0"}},
+			status:      http.StatusOK,
+			title:       "Renamed",
+			genres:      []string{"adventure"},
+		},
+	}
+
+	for _, step := range steps {
+		headers := admin.Clone()
+		headers.Set("Content-Type", step.contentType)
+
+		res, body := doTestRequest(t, ts, http.MethodPatch, path, headers, step.patch)
+		if res.StatusCode != step.status {
+			t.Errorf("%s: got status %d, want %d: %s", step.name, res.StatusCode, step.status, body)
+		}
+
+		got, err := app.models.Movies.Get(movie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != step.title || !slices.Equal(got.Genres, step.genres) {
+			t.Errorf("%s: got %q with genres %v, want %q with %v", step.name, got.Title, got.Genres, step.title, step.genres)
+		}
+	}
+}
+
+func TestReplaceMovie(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	movie := insertTestMovie(t, app, "Moana")
+	path := fmt.Sprintf("/v1/movies/%d", movie.ID)
+
+	replacement := func(version int32) map[string]any {
+		body := map[string]any{"title": "Arrival", "year": 2016, "runtime": "116 mins", "genres": []string{"drama"}}
+		if version != 0 {
+			body["version"] = version
+		}
+		return body
+	}
+
+	stale := fmt.Sprint(movie.Version + 1)
+
+	// Applied in order, only the last one replaces the movie
+	tests := []struct {
+		name            string
+		path            string
+		expectedVersion string
+		body            map[string]any
+		status          int
+	}{
+		{name: "incomplete", path: path, body: map[string]any{"title": "Arrival", "year": 2016}, status: http.StatusUnprocessableEntity},
+		{name: "stale version in the body", path: path, body: replacement(movie.Version + 1), status: http.StatusConflict},
+		{name: "stale expected version", path: path, expectedVersion: stale, body: replacement(0), status: http.StatusConflict},
+		{name: "missing movie", path: "/v1/movies/404", body: replacement(0), status: http.StatusNotFound},
+		{name: "current version", path: path, body: replacement(movie.Version), status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := admin.Clone()
+			if tt.expectedVersion != "" {
+				headers.Set("X-Expected-Version", tt.expectedVersion)
+			}
+
+			res, body := doTestRequest(t, ts, http.MethodPut, tt.path, headers, tt.body)
+			if res.StatusCode != tt.status {
+				t.Errorf("got status %d, want %d: %s", res.StatusCode, tt.status, body)
+			}
+		})
+	}
+
+	got, err := app.models.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Arrival" || got.Runtime != 116 || !slices.Equal(got.Genres, []string{"drama"}) || got.Version != movie.Version+1 {
+		t.Errorf("got %+v, want the movie replaced once", got)
+	}
+}
+
+func TestUpsertMovie(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	path := "/v1/movies/external/tt3521164"
+	movie := map[string]any{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}}
+
+	// A version can only be expected from an existing movie
+	headers := admin.Clone()
+	headers.Set("X-Expected-Version", "1")
+	res, body := doTestRequest(t, ts, http.MethodPut, path, headers, movie)
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("expecting a version of a missing movie: got status %d, want 409: %s", res.StatusCode, body)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodPut, path, admin, movie)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("creating: got status %d, want 201: %s", res.StatusCode, body)
+	}
+	location := res.Header.Get("Location")
+
+	movie["title"] = "Moana (2016)"
+	res, body = doTestRequest(t, ts, http.MethodPut, path, admin, movie)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("replacing: got status %d, want 200: %s", res.StatusCode, body)
+	}
+
+	headers.Set("X-Expected-Version", "1")
+	res, body = doTestRequest(t, ts, http.MethodPut, path, headers, movie)
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("replacing a stale version: got status %d, want 409: %s", res.StatusCode, body)
+	}
+
+	movie["external_id"] = "tt0000000"
+	res, body = doTestRequest(t, ts, http.MethodPut, path, admin, movie)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("another external id in the body: got status %d, want 422: %s", res.StatusCode, body)
+	}
+
+	if got := countTestMovies(t, app); got != 1 {
+		t.Fatalf("got %d movies, want 1", got)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodGet, location, nil, nil)
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), `"title":"Moana (2016)"`) || !strings.Contains(string(body), `"version":2`) {
+		t.Errorf("got %d %s, want the movie replaced at version 2", res.StatusCode, body)
+	}
+}
+
+func TestExportIncludeDeleted(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+	userToken := createTestUser(t, app, "user@example.com", data.RoleUser)
+
+	insertTestMovie(t, app, "Moana")
+	deleted := insertTestMovie(t, app, "Arrival")
+	if err := app.models.Movies.Delete(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		headers http.Header
+		status  int
+		movies  int
+	}{
+		{name: "without deleted movies", path: "/v1/movies/export", status: http.StatusOK, movies: 1},
+		{name: "anonymous", path: "/v1/movies/export?include_deleted=true", status: http.StatusUnauthorized},
+		{
+			name:    "user",
+			path:    "/v1/movies/export?include_deleted=true",
+			headers: http.Header{"Authorization": {"Bearer " + userToken}},
+			status:  http.StatusForbidden,
+		},
+		{name: "admin", path: "/v1/movies/export?include_deleted=true", headers: admin, status: http.StatusOK, movies: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, ts, http.MethodGet, tt.path, tt.headers, nil)
+			if res.StatusCode != tt.status {
+				t.Fatalf("got status %d, want %d: %s", res.StatusCode, tt.status, body)
+			}
+			if tt.status == http.StatusOK && strings.Count(string(body), "\n") != tt.movies {
+				t.Errorf("got %s, want %d movies", body, tt.movies)
+			}
+		})
+	}
+}
+
+func TestListMoviesSortAndConditions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	for _, movie := range []*data.Movie{
+		{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+		{Title: "Arrival", Year: 2016, Runtime: 116, Genres: []string{"drama", "science-fiction"}},
+		{Title: "Inception", Year: 2010, Runtime: 148, Genres: []string{"action", "science-fiction"}},
+		{Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"action", "drama"}},
+	} {
+		if err := app.models.Movies.Insert(movie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Heat was added long ago
+	_, err := app.models.Movies.DB.Exec(`UPDATE movies SET created_at = '2000-01-01 00:00:00' WHERE title = 'Heat'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		titles []string
+	}{
+		{name: "descending then ascending", query: "sort=-year,title", titles: []string{"Arrival", "Moana", "Inception", "Heat"}},
+		{name: "descending twice", query: "sort=-year,-title", titles: []string{"Moana", "Arrival", "Inception", "Heat"}},
+		{name: "year from", query: "sort=id&year_gte=2010", titles: []string{"Moana", "Arrival", "Inception"}},
+		{name: "year after", query: "sort=id&year_gt=2010", titles: []string{"Moana", "Arrival"}},
+		{name: "year range", query: "sort=id&year_gte=2000&year_lte=2010", titles: []string{"Inception"}},
+		{name: "runtime under", query: "sort=id&runtime_lt=148", titles: []string{"Moana", "Arrival"}},
+		{name: "runtime up to", query: "sort=id&runtime_lte=148", titles: []string{"Moana", "Arrival", "Inception"}},
+		{name: "any genre", query: "sort=id&genres_any=animation,action", titles: []string{"Moana", "Inception", "Heat"}},
+		{name: "all genres", query: "sort=id&genres_all=action,drama", titles: []string{"Heat"}},
+		{name: "genres is all genres", query: "sort=id&genres=science-fiction", titles: []string{"Arrival", "Inception"}},
+		{name: "created after", query: "sort=id&created_after=2001-01-01T00:00:00Z", titles: []string{"Moana", "Arrival", "Inception"}},
+		{name: "created before", query: "sort=id&created_before=2001-01-01T00:00:00%2B02:00", titles: []string{"Heat"}},
+		{name: "combined", query: "sort=-runtime&genres_any=science-fiction,drama&year_lt=2016", titles: []string{"Heat", "Inception"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies?"+tt.query, nil, nil)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d: %s", res.StatusCode, body)
+			}
+
+			var response struct {
+				Movies []data.Movie `json:"movies"`
+			}
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatal(err)
+			}
+			titles := []string{}
+			for _, movie := range response.Movies {
+				titles = append(titles, movie.Title)
+			}
+			if !slices.Equal(titles, tt.titles) {
+				t.Errorf("got movies %q, want %q", titles, tt.titles)
+			}
+		})
+	}
+
+	invalid := []string{
+		"sort=-year,year",
+		"sort=year,title,runtime,id,rating,-version",
+		"sort=plot",
+		"year_gte=recent",
+		"created_after=2001-01-01",
+	}
+	for _, query := range invalid {
+		res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies?"+query, nil, nil)
+		if res.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d, want 422: %s", query, res.StatusCode, body)
+		}
+	}
+}
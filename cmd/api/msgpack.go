@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Media types of MessagePack bodies, the first one is sent in responses
+var MSGPACK_CONTENT_TYPES = []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+
+// Deepest nesting of arrays and maps accepted in MessagePack request bodies
+const MSGPACK_MAX_DEPTH = 100
+
+var errInvalidMsgpack = errors.New("body contains badly-formed MessagePack")
+
+// Write a value decoded by orderedValue as MessagePack
+func writeMsgpack(w io.Writer, value any) error {
+	var buf []byte
+
+	var encode func(value any) error
+	encode = func(value any) error {
+		switch value := value.(type) {
+		case nil:
+			buf = append(buf, 0xc0)
+		case bool:
+			if value {
+				buf = append(buf, 0xc3)
+			} else {
+				buf = append(buf, 0xc2)
+			}
+		case json.Number:
+			if n, err := value.Int64(); err == nil {
+				buf = appendMsgpackInt(buf, n)
+				break
+			}
+			f, err := value.Float64()
+			if err != nil {
+				return err
+			}
+			buf = binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f))
+		case string:
+			buf = appendMsgpackString(buf, value)
+		case []any:
+			buf = appendMsgpackLength(buf, len(value), 0x90, 16, 0xdc)
+			for _, item := range value {
+				if err := encode(item); err != nil {
+					return err
+				}
+			}
+		case orderedObject:
+			buf = appendMsgpackLength(buf, len(value), 0x80, 16, 0xde)
+			for _, field := range value {
+				buf = appendMsgpackString(buf, field.Key)
+				if err := encode(field.Value); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("can't encode %T as MessagePack", value)
+		}
+		return nil
+	}
+
+	if err := encode(value); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendMsgpackInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= math.MaxInt8:
+		return append(buf, byte(n))
+	case n < 0 && n >= -32:
+		return append(buf, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		return append(buf, 0xcc, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(n))
+	case n >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(n))
+	case n >= math.MinInt8:
+		return append(buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+	}
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+// Append the header of an array or a map of n elements: the fix type holding up to
+// fixMax of them, else the 16 or 32-bit type following the 16-bit one
+func appendMsgpackLength(buf []byte, n int, fixType byte, fixMax int, type16 byte) []byte {
+	switch {
+	case n < fixMax:
+		return append(buf, fixType|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, type16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, type16+1), uint32(n))
+	}
+}
+
+// Convert a MessagePack document to JSON, so it can be decoded like a JSON body.
+// Map keys must be strings, binary values become strings and extension types, e.g.
+// timestamps, aren't supported
+func msgpackToJSON(body []byte) ([]byte, error) {
+	d := msgpackDecoder{buf: body}
+
+	value, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.buf) {
+		return nil, errors.New("body must only contain a single MessagePack value")
+	}
+
+	return json.Marshal(value)
+}
+
+type msgpackDecoder struct {
+	buf []byte
+	pos int
+}
+
+// Return the next n bytes of the document
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.buf)-d.pos {
+		return nil, errInvalidMsgpack
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// Return the next big-endian unsigned integer of size bytes
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (any, error) {
+	if depth > MSGPACK_MAX_DEPTH {
+		return nil, errors.New("body contains too deeply nested MessagePack")
+	}
+
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := b[0]; {
+	case t <= 0x7f:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t&0xf0 == 0x80:
+		return d.decodeMap(int(t&0x0f), depth)
+	case t&0xf0 == 0x90:
+		return d.decodeArray(int(t&0x0f), depth)
+	case t&0xe0 == 0xa0:
+		return d.decodeString(int(t & 0x1f))
+	}
+
+	switch t := b[0]; t {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6, 0xd9, 0xda, 0xdb:
+		// Binary and string types with an 8, 16 or 32-bit length
+		size := map[byte]int{0xc4: 1, 0xc5: 2, 0xc6: 4, 0xd9: 1, 0xda: 2, 0xdb: 4}[t]
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(int(n))
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (t - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (t - 0xd0)
+		n, err := d.uint(size)
+		// Sign-extend the integer from its size to 64 bits
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, err
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n), depth)
+	default:
+		return nil, errors.New("body contains MessagePack extension types, which aren't supported")
+	}
+}
+
+func (d *msgpackDecoder) decodeString(n int) (any, error) {
+	b, err := d.next(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) decodeArray(n int, depth int) (any, error) {
+	// Every element takes at least a byte, which bounds what a bogus length allocates
+	if n > len(d.buf)-d.pos {
+		return nil, errInvalidMsgpack
+	}
+
+	array := make([]any, 0, n)
+	for range n {
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int, depth int) (any, error) {
+	if n > len(d.buf)-d.pos {
+		return nil, errInvalidMsgpack
+	}
+
+	object := make(map[string]any, n)
+	for range n {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, errors.New("body contains MessagePack map keys that aren't strings")
+		}
+
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		object[name] = value
+	}
+	return object, nil
+}
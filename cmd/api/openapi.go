@@ -0,0 +1,1515 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Documentation of a single route, keyed by its ServeMux pattern in apiOperations
+type apiOperation struct {
+	Summary     string
+	Query       []apiParameter
+	Headers     []apiParameter
+	RequestBody any // JSON schema of the request body, nil if there is none
+	// Media types accepted for the request body, defaults to JSON and MessagePack
+	RequestTypes []string
+	// Schemas of request bodies whose media type has a different shape than RequestBody
+	RequestSchemas map[string]any
+	Status         int // status code of a successful response
+	Response       any // JSON schema of a successful response
+	// Media types of a successful response, defaults to those of RESPONSE_ENCODERS
+	ResponseTypes []string
+	Errors        []int
+	// Accepts an Idempotency-Key header, see app.idempotent
+	Idempotent bool
+}
+
+type apiParameter struct {
+	Name        string
+	Description string
+	Schema      any
+}
+
+var pathParamRX = regexp.MustCompile(`\{([a-zA-Z_]+)\.*\}`)
+
+func schemaRef(name string) envelope {
+	return envelope{"$ref": "#/components/schemas/" + name}
+}
+
+// Schema of a response envelope holding a single key
+func envelopeSchema(key string, schema any) envelope {
+	return envelope{
+		"type":       "object",
+		"properties": envelope{key: schema},
+		"required":   []string{key},
+	}
+}
+
+func arraySchema(items any) envelope {
+	return envelope{"type": "array", "items": items}
+}
+
+// Filters shared by the endpoints listing movies
+var movieFilterParameters = []apiParameter{
+	{Name: "title", Description: "Filter by title", Schema: envelope{"type": "string"}},
+	{
+		Name:        "genres",
+		Description: "Comma-separated list of genres a movie must have, by slug, name or alias",
+		Schema:      envelope{"type": "string"},
+	},
+	{Name: "genres_all", Description: "Same as genres", Schema: envelope{"type": "string"}},
+	{
+		Name:        "genres_any",
+		Description: "Comma-separated list of genres a movie must have at least one of, by slug, name or alias",
+		Schema:      envelope{"type": "string"},
+	},
+	{Name: "director", Description: "Filter by part of the name of a director", Schema: envelope{"type": "string"}},
+	{Name: "actor", Description: "Filter by part of the name of an actor", Schema: envelope{"type": "string"}},
+	{Name: "created_after", Schema: envelope{"type": "string", "format": "date-time"}},
+	{Name: "created_before", Schema: envelope{"type": "string", "format": "date-time"}},
+}
+
+// Comparisons of the endpoints listing movies, e.g. year_gte
+func movieConditionParameters() []apiParameter {
+	descriptions := map[string]string{
+		"gt":  "greater than",
+		"gte": "greater than or equal to",
+		"lt":  "less than",
+		"lte": "less than or equal to",
+	}
+
+	parameters := []apiParameter{}
+	for _, field := range []string{"year", "runtime"} {
+		for _, operator := range []string{"gt", "gte", "lt", "lte"} {
+			parameters = append(parameters, apiParameter{
+				Name:        field + "_" + operator,
+				Description: fmt.Sprintf("Only movies with a %s %s the value", field, descriptions[operator]),
+				Schema:      envelope{"type": "integer"},
+			})
+		}
+	}
+
+	return parameters
+}
+
+// Parameter of a comma-separated sort, each value from values
+func sortParameter(values []string, defaultValue string) apiParameter {
+	fields := []string{}
+	for _, value := range values {
+		field := strings.TrimPrefix(value, "-")
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+
+	value := "-?(" + strings.Join(fields, "|") + ")"
+
+	return apiParameter{
+		Name: "sort",
+		Description: fmt.Sprintf(
+			"Comma-separated list of up to %d fields to sort by, prefixed with - for descending order. Any of: %s",
+			data.MaxSortKeys,
+			strings.Join(fields, ", "),
+		),
+		Schema: envelope{
+			"type":    "string",
+			"pattern": fmt.Sprintf("^%s(,%s){0,%d}$", value, value, data.MaxSortKeys-1),
+			"default": defaultValue,
+		},
+	}
+}
+
+var paginationParameters = []apiParameter{
+	{Name: "page", Schema: envelope{"type": "integer", "minimum": 1, "maximum": 9_999_999, "default": 1}},
+	{Name: "page_size", Schema: envelope{"type": "integer", "minimum": 1, "maximum": 100, "default": 20}},
+}
+
+var sortMoviesParameter = sortParameter(LIST_MOVIES_SUPPORTED_SORT, "id")
+
+var expandMoviesParameter = apiParameter{
+	Name:        "expand",
+	Description: "Comma-separated list of related data to embed in movies, one of: credits",
+	Schema:      envelope{"type": "string"},
+}
+
+var listVersionHeader = apiParameter{
+	Name:        "X-Expected-Version",
+	Description: "Fail with 409 unless the list is currently at this version",
+	Schema:      envelope{"type": "integer"},
+}
+
+var idempotencyKeyHeader = apiParameter{
+	Name: "Idempotency-Key",
+	Description: "Unique key of the request, e.g. a UUID. Retries with the same key and body replay " +
+		"the first response, with an Idempotent-Replayed header, instead of handling the request again. " +
+		"Requests with a key must be authenticated",
+	Schema: envelope{"type": "string", "maxLength": IDEMPOTENCY_KEY_MAX_LENGTH},
+}
+
+// Errors of the endpoints changing the movies of a list
+var listItemErrors = []int{
+	http.StatusBadRequest,
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusNotFound,
+	http.StatusConflict,
+	http.StatusUnprocessableEntity,
+}
+
+var fieldsMoviesParameter = apiParameter{
+	Name: "fields",
+	Description: "Comma-separated list of fields to limit movies to, the id and expansions are always included. " +
+		"Any of: " + strings.Join(MOVIE_SUPPORTED_FIELDS, ", "),
+	Schema: envelope{"type": "string"},
+}
+
+// Documentation for every route registered in routes()
+var apiOperations = map[string]apiOperation{
+	"GET /v1/audit": {
+		Summary: "Show the audit log of changes, admins only",
+		Query: []apiParameter{
+			{Name: "user_id", Description: "Changes made by a user", Schema: envelope{"type": "integer", "minimum": 1}},
+			{Name: "request_id", Description: "Changes made by a request", Schema: envelope{"type": "string"}},
+			{
+				Name:   "action",
+				Schema: envelope{"type": "string", "enum": []string{"create", "update", "delete", "restore", "purge"}},
+			},
+			{Name: "resource", Description: "Kind of record changed, e.g. movie", Schema: envelope{"type": "string"}},
+			{Name: "resource_id", Schema: envelope{"type": "integer", "minimum": 1}},
+			{Name: "created_after", Schema: envelope{"type": "string", "format": "date-time"}},
+			{Name: "created_before", Schema: envelope{"type": "string", "format": "date-time"}},
+			paginationParameters[0],
+			paginationParameters[1],
+			sortParameter(LIST_AUDIT_EVENTS_SUPPORTED_SORT, "-id"),
+		},
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"events":   arraySchema(schemaRef("AuditEvent")),
+				"metadata": schemaRef("Metadata"),
+			},
+			"required": []string{"events", "metadata"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity},
+	},
+	"GET /v1/webhooks": {
+		Summary: "Show the webhooks subscribed to changes to the catalog, admins only",
+		Query: slices.Concat(paginationParameters, []apiParameter{
+			sortParameter(LIST_WEBHOOKS_SUPPORTED_SORT, "id"),
+		}),
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"webhooks": arraySchema(schemaRef("Webhook")),
+				"metadata": schemaRef("Metadata"),
+			},
+			"required": []string{"webhooks", "metadata"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity},
+	},
+	"POST /v1/webhooks": {
+		Idempotent:  true,
+		Summary:     "Subscribe an URL to changes to the catalog, admins only",
+		RequestBody: schemaRef("WebhookInput"),
+		Status:      http.StatusCreated,
+		Response:    envelopeSchema("webhook", schemaRef("Webhook")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"GET /v1/webhooks/{id}": {
+		Summary:  "Show the details of a specific webhook, admins only",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("webhook", schemaRef("Webhook")),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	},
+	"PATCH /v1/webhooks/{id}": {
+		Summary:     "Change the URL, events, secret or state of a webhook, admins only",
+		RequestBody: schemaRef("WebhookPatch"),
+		Headers: []apiParameter{{
+			Name:        "X-Expected-Version",
+			Description: "Fail with 409 unless the webhook is currently at this version",
+			Schema:      envelope{"type": "integer"},
+		}},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("webhook", schemaRef("Webhook")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"DELETE /v1/webhooks/{id}": {
+		Summary:  "Delete a webhook along with its deliveries, admins only",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("message", envelope{"type": "string"}),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	},
+	"GET /v1/webhooks/{id}/deliveries": {
+		Summary: "Show the deliveries of a webhook, admins only",
+		Query: slices.Concat([]apiParameter{
+			{
+				Name:        "status",
+				Description: "Only the deliveries with this status, e.g. dead ones",
+				Schema:      envelope{"type": "string", "enum": data.WebhookDeliveryStatusSafeList},
+			},
+		}, paginationParameters, []apiParameter{
+			sortParameter(LIST_WEBHOOKS_SUPPORTED_SORT, "-id"),
+		}),
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"deliveries": arraySchema(schemaRef("WebhookDelivery")),
+				"metadata":   schemaRef("Metadata"),
+			},
+			"required": []string{"deliveries", "metadata"},
+		},
+		Errors: []int{
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"POST /v1/webhooks/{id}/deliveries/{delivery_id}/retry": {
+		Summary:  "Queue a delivery again with its attempts starting over, e.g. a dead one, admins only",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("delivery", schemaRef("WebhookDelivery")),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	},
+	"POST /v1/graphql": {
+		Summary:       "Query or change movies with GraphQL, see the README for the schema and its limits",
+		RequestBody:   schemaRef("GraphQLRequest"),
+		Status:        http.StatusOK,
+		Response:      schemaRef("GraphQLResponse"),
+		ResponseTypes: []string{"application/json"},
+		Errors:        []int{http.StatusBadRequest},
+	},
+	"GET /v1/genres": {
+		Summary:  "List the genres movies can have",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("genres", arraySchema(schemaRef("Genre"))),
+	},
+	"POST /v1/genres": {
+		Idempotent:  true,
+		Summary:     "Add a genre to the vocabulary, admins only",
+		RequestBody: schemaRef("GenreInput"),
+		Status:      http.StatusCreated,
+		Response:    envelopeSchema("genre", schemaRef("Genre")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"GET /v1/genres/{id}": {
+		Summary:  "Show a specific genre",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("genre", schemaRef("Genre")),
+		Errors:   []int{http.StatusNotFound},
+	},
+	"PATCH /v1/genres/{id}": {
+		Summary:     "Rename a genre or replace its aliases, admins only",
+		RequestBody: schemaRef("GenrePatch"),
+		Status:      http.StatusOK,
+		Response:    envelopeSchema("genre", schemaRef("Genre")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"DELETE /v1/genres/{id}": {
+		Summary:  "Remove a genre no movie has from the vocabulary, admins only",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("message", envelope{"type": "string"}),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+	},
+	"GET /v1/healthcheck": {
+		Summary: "Show application information",
+		Status:  http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"status": envelope{"type": "string"},
+				"system_info": envelope{
+					"type":                 "object",
+					"additionalProperties": envelope{"type": "string"},
+				},
+			},
+		},
+	},
+	"GET /v1/openapi.json": {
+		Summary:  "Show this OpenAPI document",
+		Status:   http.StatusOK,
+		Response: envelope{"type": "object"},
+	},
+	"GET /v1/movies": {
+		Summary: "Show the details of all movies",
+		Query: slices.Concat(movieFilterParameters, movieConditionParameters(), paginationParameters, []apiParameter{
+			sortMoviesParameter,
+			expandMoviesParameter,
+			fieldsMoviesParameter,
+			{
+				Name:        "facets",
+				Description: "Comma-separated list of facets to count the filtered movies by, any of: " + strings.Join(MOVIE_SUPPORTED_FACETS, ", "),
+				Schema:      envelope{"type": "string"},
+			},
+			{
+				Name:        "include_deleted",
+				Description: "Also list the movies in the trash, admins only",
+				Schema:      envelope{"type": "boolean", "default": false},
+			},
+		}),
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"movies":   arraySchema(schemaRef("Movie")),
+				"metadata": schemaRef("Metadata"),
+				"facets": envelope{
+					"type":                 "object",
+					"description":          "Only with facets=, the counts of each facet",
+					"additionalProperties": arraySchema(schemaRef("FacetCount")),
+				},
+			},
+			"required": []string{"movies", "metadata"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity},
+	},
+	"GET /v1/movies/stats": {
+		Summary:  "Show the number of movies by genre, decade and year and their runtime distribution",
+		Query:    slices.Concat(movieFilterParameters, movieConditionParameters()),
+		Status:   http.StatusOK,
+		Response: envelopeSchema("stats", schemaRef("MovieStats")),
+		Errors:   []int{http.StatusUnprocessableEntity},
+	},
+	"GET /v1/movies/events": {
+		Summary: "Stream the changes to movies as Server-Sent Events, " +
+			"whose data is the change and id its position in the change log",
+		Headers: []apiParameter{{
+			Name:        "Last-Event-ID",
+			Description: "Resume the stream after this event, else only changes made from now on are sent",
+			Schema:      envelope{"type": "integer", "minimum": 0},
+		}},
+		Status:        http.StatusOK,
+		Response:      schemaRef("MovieEvent"),
+		ResponseTypes: []string{"text/event-stream"},
+		Errors:        []int{http.StatusBadRequest, http.StatusGone},
+	},
+	"POST /v1/movies": {
+		Idempotent:  true,
+		Summary:     "Create a new movie, admins only",
+		RequestBody: schemaRef("MovieInput"),
+		Status:      http.StatusCreated,
+		Response:    envelopeSchema("movie", schemaRef("Movie")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"POST /v1/movies/import": {
+		Summary: "Import movies from a CSV, NDJSON or IMDb title.basics.tsv file, admins only",
+		Query: []apiParameter{{
+			Name:        "format",
+			Description: "Format of the body, defaults to the one implied by the Content-Type header",
+			Schema:      envelope{"type": "string", "enum": []string{"csv", "ndjson", "imdb"}},
+		}},
+		RequestBody:  envelope{"type": "string"},
+		RequestTypes: []string{"text/csv", "application/x-ndjson", "text/tab-separated-values"},
+		Status:       http.StatusOK,
+		Response:     envelopeSchema("report", schemaRef("ImportReport")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"GET /v1/movies/trash": {
+		Summary: "Show the movies in the trash, admins only",
+		Query: slices.Concat(movieFilterParameters, movieConditionParameters(), paginationParameters, []apiParameter{
+			sortParameter(TRASH_MOVIES_SUPPORTED_SORT, "-deleted_at"),
+			expandMoviesParameter,
+			fieldsMoviesParameter,
+		}),
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"movies":   arraySchema(schemaRef("Movie")),
+				"metadata": schemaRef("Metadata"),
+			},
+			"required": []string{"movies", "metadata"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity},
+	},
+	"GET /v1/movies/export": {
+		Summary: "Download every movie matching the filters as NDJSON or CSV",
+		Query: slices.Concat(movieFilterParameters, movieConditionParameters(), []apiParameter{
+			sortMoviesParameter,
+			{
+				Name:        "format",
+				Description: "Export format, defaults to the one accepted by the Accept header or ndjson",
+				Schema:      envelope{"type": "string", "enum": []string{"ndjson", "csv"}},
+			},
+			{
+				Name:        "include_deleted",
+				Description: "Also export the movies in the trash, admins only",
+				Schema:      envelope{"type": "boolean", "default": false},
+			},
+		}),
+		Status:        http.StatusOK,
+		Response:      schemaRef("Movie"),
+		ResponseTypes: []string{"application/x-ndjson", "text/csv"},
+		Errors:        []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity},
+	},
+	"POST /v1/movies/batch": {
+		Idempotent: true,
+		Summary:    "Create, patch and delete movies in a single request, admins only",
+		Query: []apiParameter{{
+			Name:        "atomic",
+			Description: "Run every operation in one transaction, rolled back if any of them fails",
+			Schema:      envelope{"type": "boolean", "default": false},
+		}},
+		RequestBody: envelopeSchema("operations", envelope{
+			"type":     "array",
+			"minItems": 1,
+			"maxItems": BATCH_MOVIES_MAX_OPERATIONS,
+			"items":    schemaRef("BatchOperation"),
+		}),
+		Status:   http.StatusOK,
+		Response: envelopeSchema("results", arraySchema(schemaRef("BatchResult"))),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"GET /v1/movies/{id}": {
+		Summary:  "Show the details of a specific movie",
+		Query:    []apiParameter{expandMoviesParameter, fieldsMoviesParameter},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("movie", schemaRef("Movie")),
+		Errors:   []int{http.StatusNotFound, http.StatusUnprocessableEntity},
+	},
+	"GET /v1/movies/{id}/credits": {
+		Summary:  "Show the directors, writers and actors of a specific movie",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("credits", arraySchema(schemaRef("Credit"))),
+		Errors:   []int{http.StatusNotFound},
+	},
+	"POST /v1/movies/{id}/credits": {
+		Idempotent:  true,
+		Summary:     "Credit a person in a specific movie, admins only",
+		RequestBody: schemaRef("CreditInput"),
+		Status:      http.StatusCreated,
+		Response:    envelopeSchema("credit", schemaRef("Credit")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"DELETE /v1/movies/{id}/credits/{credit_id}": {
+		Summary:  "Remove a credit from a specific movie, admins only",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("message", envelope{"type": "string"}),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	},
+	"GET /v1/movies/{id}/reviews": {
+		Summary: "Show the reviews of a specific movie",
+		Query: slices.Concat([]apiParameter{
+			{Name: "user_id", Description: "Only the review of a user", Schema: envelope{"type": "integer", "minimum": 1}},
+		}, paginationParameters, []apiParameter{
+			sortParameter(LIST_REVIEWS_SUPPORTED_SORT, "-id"),
+		}),
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"reviews":  arraySchema(schemaRef("Review")),
+				"metadata": schemaRef("Metadata"),
+			},
+			"required": []string{"reviews", "metadata"},
+		},
+		Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity},
+	},
+	"POST /v1/movies/{id}/reviews": {
+		Idempotent:  true,
+		Summary:     "Review a specific movie, once per user",
+		RequestBody: schemaRef("ReviewInput"),
+		Status:      http.StatusCreated,
+		Response:    envelopeSchema("review", schemaRef("Review")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusNotFound,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"PATCH /v1/movies/{id}/reviews": {
+		Summary:     "Update your review of a specific movie",
+		RequestBody: schemaRef("ReviewPatch"),
+		Headers: []apiParameter{{
+			Name:        "X-Expected-Version",
+			Description: "Fail with 409 unless the review is currently at this version",
+			Schema:      envelope{"type": "integer"},
+		}},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("review", schemaRef("Review")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusNotFound,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"DELETE /v1/movies/{id}/reviews": {
+		Summary:  "Delete your review of a specific movie",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("message", envelope{"type": "string"}),
+		Errors:   []int{http.StatusUnauthorized, http.StatusNotFound},
+	},
+	"GET /v1/lists": {
+		Summary: "Show your lists, or the public lists of another user",
+		Query: slices.Concat([]apiParameter{
+			{
+				Name:        "user_id",
+				Description: "Owner of the lists, defaults to you. Only public lists of other users are shown",
+				Schema:      envelope{"type": "integer", "minimum": 1},
+			},
+		}, paginationParameters, []apiParameter{
+			sortParameter(LIST_LISTS_SUPPORTED_SORT, "id"),
+		}),
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"lists":    arraySchema(schemaRef("List")),
+				"metadata": schemaRef("Metadata"),
+			},
+			"required": []string{"lists", "metadata"},
+		},
+		Errors: []int{http.StatusUnauthorized, http.StatusUnprocessableEntity},
+	},
+	"POST /v1/lists": {
+		Idempotent:  true,
+		Summary:     "Create an empty list",
+		RequestBody: schemaRef("ListInput"),
+		Status:      http.StatusCreated,
+		Response:    envelopeSchema("list", schemaRef("List")),
+		Errors:      []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnprocessableEntity},
+	},
+	"GET /v1/lists/{id}": {
+		Summary:  "Show the details of a specific list, private lists only to their owner",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("list", schemaRef("List")),
+		Errors:   []int{http.StatusNotFound},
+	},
+	"PATCH /v1/lists/{id}": {
+		Summary:     "Update one of your lists",
+		RequestBody: schemaRef("ListPatch"),
+		Headers: []apiParameter{{
+			Name:        "X-Expected-Version",
+			Description: "Fail with 409 unless the list is currently at this version",
+			Schema:      envelope{"type": "integer"},
+		}},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("list", schemaRef("List")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"DELETE /v1/lists/{id}": {
+		Summary:  "Delete one of your lists",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("message", envelope{"type": "string"}),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	},
+	"GET /v1/lists/{id}/items": {
+		Summary: "Show the movies of a specific list, leaving out the ones in the trash",
+		Query: slices.Concat(paginationParameters, []apiParameter{
+			sortParameter(LIST_LIST_ITEMS_SUPPORTED_SORT, "position"),
+		}),
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"items":    arraySchema(schemaRef("ListItem")),
+				"metadata": schemaRef("Metadata"),
+			},
+			"required": []string{"items", "metadata"},
+		},
+		Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity},
+	},
+	"POST /v1/lists/{id}/items": {
+		Idempotent:  true,
+		Summary:     "Add a movie to one of your lists",
+		RequestBody: schemaRef("ListItemInput"),
+		Headers:     []apiParameter{listVersionHeader},
+		Status:      http.StatusCreated,
+		Response:    envelopeSchema("list", schemaRef("List")),
+		Errors:      listItemErrors,
+	},
+	"PATCH /v1/lists/{id}/items/{movie_id}": {
+		Summary:     "Move a movie to another position in one of your lists",
+		RequestBody: schemaRef("ListItemPatch"),
+		Headers:     []apiParameter{listVersionHeader},
+		Status:      http.StatusOK,
+		Response:    envelopeSchema("list", schemaRef("List")),
+		Errors:      listItemErrors,
+	},
+	"DELETE /v1/lists/{id}/items/{movie_id}": {
+		Summary:  "Remove a movie from one of your lists",
+		Headers:  []apiParameter{listVersionHeader},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("list", schemaRef("List")),
+		Errors:   listItemErrors,
+	},
+	"GET /v1/people": {
+		Summary: "Show the details of all people",
+		Query: slices.Concat([]apiParameter{
+			{Name: "name", Description: "Filter by part of the name", Schema: envelope{"type": "string"}},
+		}, paginationParameters, []apiParameter{
+			sortParameter(LIST_PEOPLE_SUPPORTED_SORT, "id"),
+		}),
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"people":   arraySchema(schemaRef("Person")),
+				"metadata": schemaRef("Metadata"),
+			},
+			"required": []string{"people", "metadata"},
+		},
+		Errors: []int{http.StatusUnprocessableEntity},
+	},
+	"POST /v1/people": {
+		Idempotent:  true,
+		Summary:     "Create a new person, admins only",
+		RequestBody: schemaRef("PersonInput"),
+		Status:      http.StatusCreated,
+		Response:    envelopeSchema("person", schemaRef("Person")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"GET /v1/people/{id}": {
+		Summary:  "Show the details of a specific person",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("person", schemaRef("Person")),
+		Errors:   []int{http.StatusNotFound},
+	},
+	"PATCH /v1/people/{id}": {
+		Summary:     "Update the details of a specific person, admins only",
+		RequestBody: schemaRef("PersonPatch"),
+		Headers: []apiParameter{{
+			Name:        "X-Expected-Version",
+			Description: "Fail with 409 unless the person is currently at this version",
+			Schema:      envelope{"type": "integer"},
+		}},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("person", schemaRef("Person")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"DELETE /v1/people/{id}": {
+		Summary:  "Delete a specific person who isn't credited in any movie, admins only",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("message", envelope{"type": "string"}),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+	},
+	"PATCH /v1/movies/{id}": {
+		Summary:     "Update the details of a specific movie, admins only",
+		RequestBody: schemaRef("MoviePatch"),
+		RequestSchemas: map[string]any{
+			MERGE_PATCH_CONTENT_TYPE: schemaRef("MergePatch"),
+			JSON_PATCH_CONTENT_TYPE:  arraySchema(schemaRef("JSONPatchOperation")),
+		},
+		Headers: []apiParameter{{
+			Name:        "X-Expected-Version",
+			Description: "Fail with 409 unless the movie is currently at this version",
+			Schema:      envelope{"type": "integer"},
+		}},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("movie", schemaRef("Movie")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"PUT /v1/movies/{id}": {
+		Summary:     "Replace a specific movie, fields left out are cleared, admins only",
+		RequestBody: schemaRef("MovieReplacement"),
+		Headers: []apiParameter{{
+			Name:        "X-Expected-Version",
+			Description: "Fail with 409 unless the movie is currently at this version, ignored if the body has a version",
+			Schema:      envelope{"type": "integer"},
+		}},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("movie", schemaRef("Movie")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"PUT /v1/movies/external/{external_id}": {
+		Summary:     "Create or replace the movie with an external id, 201 when it was created, admins only",
+		RequestBody: schemaRef("MovieReplacement"),
+		Headers: []apiParameter{{
+			Name:        "X-Expected-Version",
+			Description: "Fail with 409 unless the movie exists and is at this version, ignored if the body has a version",
+			Schema:      envelope{"type": "integer"},
+		}},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("movie", schemaRef("Movie")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"POST /v1/movies/{id}/restore": {
+		Summary:  "Take a deleted movie out of the trash, admins only",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("movie", schemaRef("Movie")),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	},
+	"GET /v1/movies/{id}/revisions": {
+		Summary: "Show the revisions of a specific movie",
+		Query: []apiParameter{
+			paginationParameters[0],
+			paginationParameters[1],
+			sortParameter(LIST_REVISIONS_SUPPORTED_SORT, "-version"),
+		},
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"revisions": arraySchema(schemaRef("MovieRevision")),
+				"metadata":  schemaRef("Metadata"),
+			},
+			"required": []string{"revisions", "metadata"},
+		},
+		Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity},
+	},
+	"GET /v1/movies/{id}/revisions/diff": {
+		Summary: "Show the fields of a specific movie changed between two versions",
+		Query: []apiParameter{
+			{Name: "from", Description: "Version to compare from", Schema: envelope{"type": "integer", "minimum": 1}},
+			{
+				Name:        "to",
+				Description: "Version to compare to, defaults to the current one",
+				Schema:      envelope{"type": "integer", "minimum": 1},
+			},
+		},
+		Status: http.StatusOK,
+		Response: envelope{
+			"type": "object",
+			"properties": envelope{
+				"from":    envelope{"type": "integer"},
+				"to":      envelope{"type": "integer"},
+				"changes": arraySchema(schemaOf(reflect.TypeFor[data.MovieChange]())),
+			},
+			"required": []string{"from", "to", "changes"},
+		},
+		Errors: []int{http.StatusNotFound, http.StatusUnprocessableEntity},
+	},
+	"GET /v1/movies/{id}/revisions/{version}": {
+		Summary:  "Show a specific movie as it was at a version",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("revision", schemaRef("MovieRevision")),
+		Errors:   []int{http.StatusNotFound},
+	},
+	"POST /v1/movies/{id}/revisions/{version}/restore": {
+		Summary: "Bring a specific movie back to a version, as a new version, admins only",
+		Headers: []apiParameter{{
+			Name:        "X-Expected-Version",
+			Description: "Fail with 409 unless the movie is currently at this version",
+			Schema:      envelope{"type": "integer"},
+		}},
+		Status:   http.StatusOK,
+		Response: envelopeSchema("movie", schemaRef("Movie")),
+		Errors: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusNotFound,
+			http.StatusConflict,
+			http.StatusUnprocessableEntity,
+		},
+	},
+	"DELETE /v1/movies/{id}": {
+		Summary:  "Move a specific movie to the trash, admins only",
+		Status:   http.StatusOK,
+		Response: envelopeSchema("message", envelope{"type": "string"}),
+		Errors:   []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound},
+	},
+}
+
+// An error message, or validation errors keyed by field name
+var errorMessageSchema = envelope{
+	"oneOf": []any{
+		envelope{"type": "string"},
+		envelope{
+			"type":                 "object",
+			"description":          "Validation errors keyed by field name",
+			"additionalProperties": envelope{"type": "string"},
+		},
+	},
+}
+
+// Reusable schemas, referenced from operations as #/components/schemas/<name>
+func apiSchemas() envelope {
+	movie := schemaOf(reflect.TypeFor[data.Movie]())
+	// Responses limited with fields= may leave out anything but the id
+	movie["required"] = []string{"id"}
+
+	input := schemaOf(reflect.TypeFor[data.Movie]())
+	delete(input["properties"].(envelope), "id")
+	delete(input["properties"].(envelope), "deleted_at")
+	delete(input["properties"].(envelope), "rating_avg")
+	delete(input["properties"].(envelope), "rating_count")
+	delete(input["properties"].(envelope), "version")
+	input["required"] = []string{"title", "year", "runtime", "genres"}
+
+	replacement := schemaOf(reflect.TypeFor[data.Movie]())
+	delete(replacement["properties"].(envelope), "id")
+	delete(replacement["properties"].(envelope), "deleted_at")
+	delete(replacement["properties"].(envelope), "rating_avg")
+	delete(replacement["properties"].(envelope), "rating_count")
+	replacement["properties"].(envelope)["version"] = envelope{
+		"type":        "integer",
+		"minimum":     1,
+		"description": "Fail with 409 unless the movie is currently at this version",
+	}
+	replacement["required"] = []string{"title", "year", "runtime", "genres"}
+
+	patch := schemaOf(reflect.TypeFor[data.Movie]())
+	delete(patch["properties"].(envelope), "id")
+	delete(patch["properties"].(envelope), "external_id")
+	delete(patch["properties"].(envelope), "deleted_at")
+	delete(patch["properties"].(envelope), "rating_avg")
+	delete(patch["properties"].(envelope), "rating_count")
+	delete(patch["properties"].(envelope), "version")
+
+	genreInput := schemaOf(reflect.TypeFor[data.Genre]())
+	delete(genreInput["properties"].(envelope), "id")
+	delete(genreInput["properties"].(envelope), "movie_count")
+	genreInput["properties"].(envelope)["slug"].(envelope)["description"] = "Defaults to the name, normalized like the aliases"
+	genreInput["required"] = []string{"name"}
+
+	genrePatch := schemaOf(reflect.TypeFor[data.Genre]())
+	delete(genrePatch["properties"].(envelope), "id")
+	delete(genrePatch["properties"].(envelope), "slug")
+	delete(genrePatch["properties"].(envelope), "movie_count")
+
+	person := schemaOf(reflect.TypeFor[data.Person]())
+	person["required"] = []string{"id", "name", "version"}
+
+	personInput := schemaOf(reflect.TypeFor[data.Person]())
+	delete(personInput["properties"].(envelope), "id")
+	delete(personInput["properties"].(envelope), "version")
+	personInput["required"] = []string{"name"}
+
+	personPatch := schemaOf(reflect.TypeFor[data.Person]())
+	delete(personPatch["properties"].(envelope), "id")
+	personPatch["properties"].(envelope)["version"] = envelope{
+		"type":        "integer",
+		"minimum":     1,
+		"description": "Fail with 409 unless the person is currently at this version",
+	}
+
+	creditInput := schemaOf(reflect.TypeFor[data.Credit]())
+	delete(creditInput["properties"].(envelope), "id")
+	delete(creditInput["properties"].(envelope), "movie_id")
+	delete(creditInput["properties"].(envelope), "name")
+	delete(creditInput["properties"].(envelope), "position")
+
+	movie["properties"].(envelope)["credits"] = envelope{
+		"type":        "array",
+		"items":       schemaRef("Credit"),
+		"description": "Only with expand=credits",
+	}
+
+	reviewInput := envelope{
+		"type": "object",
+		"properties": envelope{
+			"score": envelope{"type": "integer", "minimum": 1, "maximum": 10},
+			"text":  envelope{"type": "string", "maxLength": 5000},
+		},
+		"required": []string{"score"},
+	}
+
+	reviewPatch := envelope{
+		"type": "object",
+		"properties": envelope{
+			"score": envelope{"type": "integer", "minimum": 1, "maximum": 10},
+			"text":  envelope{"type": "string", "maxLength": 5000, "description": "An empty text removes it"},
+			"version": envelope{
+				"type":        "integer",
+				"minimum":     1,
+				"description": "Fail with 409 unless the review is currently at this version",
+			},
+		},
+	}
+
+	listInput := schemaOf(reflect.TypeFor[data.List]())
+	for _, name := range []string{"id", "user_id", "item_count", "created_at", "updated_at", "version"} {
+		delete(listInput["properties"].(envelope), name)
+	}
+	listInput["required"] = []string{"name"}
+
+	listPatch := schemaOf(reflect.TypeFor[data.List]())
+	for _, name := range []string{"id", "user_id", "item_count", "created_at", "updated_at"} {
+		delete(listPatch["properties"].(envelope), name)
+	}
+	listPatch["properties"].(envelope)["description"].(envelope)["description"] = "An empty description removes it"
+	listPatch["properties"].(envelope)["version"] = envelope{
+		"type":        "integer",
+		"minimum":     1,
+		"description": "Fail with 409 unless the list is currently at this version",
+	}
+
+	listItem := schemaOf(reflect.TypeFor[data.ListItem]())
+	listItem["properties"].(envelope)["movie"] = schemaRef("Movie")
+
+	listItemInput := envelope{
+		"type": "object",
+		"properties": envelope{
+			"movie_id": envelope{"type": "integer", "minimum": 1},
+			"position": envelope{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Defaults to the end of the list, the movies from there on move down",
+			},
+		},
+		"required": []string{"movie_id"},
+	}
+
+	listItemPatch := envelope{
+		"type": "object",
+		"properties": envelope{
+			"position": envelope{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Positions past the end move the movie to the end",
+			},
+		},
+		"required": []string{"position"},
+	}
+
+	facetCount := schemaOf(reflect.TypeFor[data.FacetCount]())
+	facetCount["properties"].(envelope)["value"] = envelope{
+		"type":        []string{"string", "integer"},
+		"description": "Genre slug, year or first year of the decade",
+	}
+
+	movieStats := schemaOf(reflect.TypeFor[data.MovieStats]())
+	for _, name := range []string{"genres", "decades", "years"} {
+		movieStats["properties"].(envelope)[name] = arraySchema(schemaRef("FacetCount"))
+	}
+
+	movieEvent := schemaOf(reflect.TypeFor[data.MovieEvent]())
+	movieEvent["properties"].(envelope)["type"] = envelope{
+		"type": "string",
+		"enum": []string{
+			data.MovieEventCreated,
+			data.MovieEventUpdated,
+			data.MovieEventDeleted,
+			data.MovieEventRestored,
+			data.MovieEventPurged,
+		},
+	}
+
+	webhook := schemaOf(reflect.TypeFor[data.Webhook]())
+	webhook["properties"].(envelope)["events"] = envelope{
+		"type":        "array",
+		"items":       envelope{"type": "string", "enum": data.WebhookEventSafeList},
+		"uniqueItems": true,
+		"description": "Event types sent, all of them if empty",
+	}
+
+	webhookInput := schemaOf(reflect.TypeFor[data.Webhook]())
+	for _, name := range []string{"id", "created_at", "updated_at", "version"} {
+		delete(webhookInput["properties"].(envelope), name)
+	}
+	webhookInput["properties"].(envelope)["events"] = webhook["properties"].(envelope)["events"]
+	webhookInput["properties"].(envelope)["secret"] = envelope{
+		"type":        "string",
+		"minLength":   16,
+		"maxLength":   200,
+		"description": "Key of the signatures of the deliveries, never shown again",
+	}
+	webhookInput["properties"].(envelope)["active"] = envelope{
+		"type":        "boolean",
+		"description": "Defaults to true, inactive webhooks aren't sent events",
+	}
+	webhookInput["required"] = []string{"url", "secret"}
+
+	webhookPatch := schemaOf(reflect.TypeFor[data.Webhook]())
+	for _, name := range []string{"id", "created_at", "updated_at"} {
+		delete(webhookPatch["properties"].(envelope), name)
+	}
+	webhookPatch["properties"].(envelope)["events"] = webhook["properties"].(envelope)["events"]
+	webhookPatch["properties"].(envelope)["secret"] = webhookInput["properties"].(envelope)["secret"]
+	webhookPatch["properties"].(envelope)["version"] = envelope{
+		"type":        "integer",
+		"minimum":     1,
+		"description": "Fail with 409 unless the webhook is currently at this version",
+	}
+
+	webhookDelivery := schemaOf(reflect.TypeFor[data.WebhookDelivery]())
+	webhookDelivery["properties"].(envelope)["event"] = schemaRef("MovieEvent")
+	webhookDelivery["properties"].(envelope)["status"] = envelope{
+		"type": "string",
+		"enum": data.WebhookDeliveryStatusSafeList,
+	}
+
+	graphQLError := envelope{
+		"type": "object",
+		"properties": envelope{
+			"message": envelope{"type": "string"},
+			"locations": arraySchema(envelope{
+				"type": "object",
+				"properties": envelope{
+					"line":   envelope{"type": "integer"},
+					"column": envelope{"type": "integer"},
+				},
+			}),
+			"path": envelope{
+				"type":        "array",
+				"items":       envelope{"oneOf": []envelope{{"type": "string"}, {"type": "integer"}}},
+				"description": "Keys and indices leading to the field that failed",
+			},
+			"extensions": envelope{
+				"type":        "object",
+				"description": "The code of the error, e.g. NOT_FOUND, and the errors of FAILED_VALIDATION ones",
+			},
+		},
+		"required": []string{"message"},
+	}
+
+	graphQLRequest := envelope{
+		"type": "object",
+		"properties": envelope{
+			"query":         envelope{"type": "string"},
+			"operationName": envelope{"type": "string", "description": "Operation to execute among those of the query"},
+			"variables":     envelope{"type": "object"},
+			"extensions":    envelope{"type": "object", "description": "Ignored"},
+		},
+		"required": []string{"query"},
+	}
+
+	graphQLResponse := envelope{
+		"type": "object",
+		"properties": envelope{
+			"data":   envelope{"type": []string{"object", "null"}},
+			"errors": arraySchema(schemaRef("GraphQLError")),
+		},
+	}
+
+	return envelope{
+		"GraphQLRequest":   graphQLRequest,
+		"GraphQLResponse":  graphQLResponse,
+		"GraphQLError":     graphQLError,
+		"Webhook":          webhook,
+		"WebhookInput":     webhookInput,
+		"WebhookPatch":     webhookPatch,
+		"WebhookDelivery":  webhookDelivery,
+		"MovieEvent":       movieEvent,
+		"FacetCount":       facetCount,
+		"MovieStats":       movieStats,
+		"List":             schemaOf(reflect.TypeFor[data.List]()),
+		"ListInput":        listInput,
+		"ListPatch":        listPatch,
+		"ListItem":         listItem,
+		"ListItemInput":    listItemInput,
+		"ListItemPatch":    listItemPatch,
+		"Review":           schemaOf(reflect.TypeFor[data.Review]()),
+		"ReviewInput":      reviewInput,
+		"ReviewPatch":      reviewPatch,
+		"Credit":           schemaOf(reflect.TypeFor[data.Credit]()),
+		"CreditInput":      creditInput,
+		"Person":           person,
+		"PersonInput":      personInput,
+		"PersonPatch":      personPatch,
+		"Genre":            schemaOf(reflect.TypeFor[data.Genre]()),
+		"GenreInput":       genreInput,
+		"GenrePatch":       genrePatch,
+		"Movie":            movie,
+		"MovieInput":       input,
+		"MoviePatch":       patch,
+		"MovieReplacement": replacement,
+		"Metadata":         schemaOf(reflect.TypeFor[data.Metadata]()),
+		"AuditEvent":       schemaOf(reflect.TypeFor[data.AuditEvent]()),
+		"MovieRevision":    schemaOf(reflect.TypeFor[data.MovieRevision]()),
+		"ImportReport":     schemaOf(reflect.TypeFor[data.ImportReport]()),
+		"MergePatch": envelope{
+			"type":        "object",
+			"description": "RFC 7396 merge patch of the movie's JSON representation, a null removes a field",
+		},
+		"JSONPatchOperation": envelope{
+			"type":        "object",
+			"description": "RFC 6902 operation on the movie's JSON representation, a test of /version makes the update conditional",
+			"properties": envelope{
+				"op":    envelope{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  envelope{"type": "string"},
+				"from":  envelope{"type": "string", "description": "Required for move and copy"},
+				"value": envelope{"description": "Required for add, replace and test"},
+			},
+			"required": []string{"op", "path"},
+		},
+		"BatchOperation": envelope{
+			"type": "object",
+			"properties": envelope{
+				"op":      envelope{"type": "string", "enum": BATCH_MOVIES_SUPPORTED_OPS},
+				"id":      envelope{"type": "integer", "description": "Required for patch and delete"},
+				"version": envelope{"type": "integer", "description": "Expected version for patch and delete"},
+				"movie": envelope{
+					"description": "Required for create and patch",
+					"oneOf":       []any{schemaRef("MovieInput"), schemaRef("MoviePatch")},
+				},
+			},
+			"required": []string{"op"},
+		},
+		"BatchResult": envelope{
+			"type": "object",
+			"properties": envelope{
+				"index":  envelope{"type": "integer"},
+				"op":     envelope{"type": "string"},
+				"status": envelope{"type": "integer"},
+				"movie":  schemaRef("Movie"),
+				"error":  errorMessageSchema,
+			},
+		},
+		"Runtime": envelope{
+			"type":        "string",
+			"format":      "runtime",
+			"pattern":     `^[0-9]+ (mins|minutes)$`,
+			"description": `Runtime in minutes. Sent as "<n> mins", returned as "<n> minutes"`,
+			"examples":    []string{"102 mins"},
+		},
+		"Error": envelope{
+			"type": "object",
+			"properties": envelope{
+				"error": errorMessageSchema,
+			},
+			"required": []string{"error"},
+		},
+	}
+}
+
+// Build a JSON schema for a struct from its json and validate tags
+func schemaOf(t reflect.Type) envelope {
+	switch t {
+	case reflect.TypeFor[data.Runtime]():
+		return schemaRef("Runtime")
+	case reflect.TypeFor[time.Time]():
+		return envelope{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[json.RawMessage]():
+		return envelope{"description": "Any JSON value"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return envelope{"type": "string"}
+	case reflect.Bool:
+		return envelope{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return envelope{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return envelope{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return arraySchema(schemaOf(t.Elem()))
+	case reflect.Map:
+		return envelope{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := envelope{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			schema := schemaOf(field.Type)
+			if _, isRef := schema["$ref"]; !isRef {
+				applyValidateTag(schema, field.Tag.Get("validate"))
+			}
+			properties[name] = schema
+		}
+		return envelope{"type": "object", "properties": properties}
+	}
+
+	return envelope{}
+}
+
+// Translate the rules of a `validate` tag into JSON schema keywords
+func applyValidateTag(schema envelope, tag string) {
+	if tag == "" {
+		return
+	}
+
+	for _, part := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(part, "=")
+		n, _ := strconv.Atoi(param)
+
+		switch schema["type"] {
+		case "string":
+			switch name {
+			case "min":
+				schema["minLength"] = n
+			case "max":
+				schema["maxLength"] = n
+			case "email":
+				schema["format"] = "email"
+			case "http_url":
+				schema["format"] = "uri"
+			case "oneof":
+				schema["enum"] = strings.Fields(param)
+			}
+		case "integer", "number":
+			switch name {
+			case "min":
+				schema["minimum"] = n
+			case "max":
+				schema["maximum"] = n
+			case "year_not_future":
+				schema["description"] = "Must not be in the future"
+			}
+		case "array":
+			switch name {
+			case "min":
+				schema["minItems"] = n
+			case "max":
+				schema["maxItems"] = n
+			case "unique":
+				schema["uniqueItems"] = true
+			}
+		}
+	}
+}
+
+// Build the OpenAPI document from the patterns registered on the router. It fails
+// if a route is missing documentation or documentation exists for an unknown route.
+func buildOpenAPISpec(patterns []string) (envelope, error) {
+	paths := envelope{}
+
+	for _, pattern := range patterns {
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			// Catch-all routes without a method are not part of the API
+			continue
+		}
+
+		operation, ok := apiOperations[pattern]
+		if !ok {
+			return nil, fmt.Errorf("openapi: route %q is not documented", pattern)
+		}
+
+		pathItem, ok := paths[path].(envelope)
+		if !ok {
+			pathItem = envelope{}
+			paths[path] = pathItem
+		}
+		pathItem[strings.ToLower(method)] = operation.spec(path)
+	}
+
+	for pattern := range apiOperations {
+		if !slices.Contains(patterns, pattern) {
+			return nil, fmt.Errorf("openapi: documented route %q is not registered", pattern)
+		}
+	}
+
+	return envelope{
+		"openapi": "3.1.0",
+		"info": envelope{
+			"title": "Greenlight",
+			"description": "JSON API for retrieving and managing information about movies. " +
+				"Responses are compressed according to Accept-Encoding (" + strings.Join(COMPRESSION_ENCODINGS, ", ") + "), " +
+				"and request bodies may be sent with any of these as their Content-Encoding. " +
+				"Responses are written in the format of the Accept header: JSON, XML, CSV (lists and single records) " +
+				"or MessagePack, or refused with a 406. Responses to changes an acceptable format can't represent fall back to JSON. " +
+				"Request bodies may be JSON or MessagePack",
+			"version": version,
+		},
+		"paths": paths,
+		"components": envelope{
+			"schemas": apiSchemas(),
+			"securitySchemes": envelope{
+				"bearerAuth": envelope{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// Authentication is optional, anonymous callers are allowed
+		"security": []any{envelope{}, envelope{"bearerAuth": []string{}}},
+	}, nil
+}
+
+func (op apiOperation) spec(path string) envelope {
+	parameters := []any{}
+
+	for _, match := range pathParamRX.FindAllStringSubmatch(path, -1) {
+		// Record ids and versions are integers, other parameters (e.g. external ids) are strings
+		schema := envelope{"type": "string", "maxLength": 255}
+		if slices.Contains([]string{"id", "credit_id", "movie_id", "version", "delivery_id"}, match[1]) {
+			schema = envelope{"type": "integer", "minimum": 1}
+		}
+
+		parameters = append(parameters, envelope{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+	}
+
+	for _, param := range op.Query {
+		p := envelope{"name": param.Name, "in": "query", "schema": param.Schema}
+		if param.Description != "" {
+			p["description"] = param.Description
+		}
+		parameters = append(parameters, p)
+	}
+
+	headers, errorStatuses := op.Headers, slices.Clone(op.Errors)
+	if op.Idempotent {
+		headers = append(slices.Clone(headers), idempotencyKeyHeader)
+		errorStatuses = append(errorStatuses, http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+
+	for _, param := range headers {
+		parameters = append(parameters, envelope{
+			"name":        param.Name,
+			"in":          "header",
+			"description": param.Description,
+			"schema":      param.Schema,
+		})
+	}
+
+	responseTypes := op.ResponseTypes
+	if responseTypes == nil {
+		for _, encoder := range RESPONSE_ENCODERS {
+			responseTypes = append(responseTypes, encoder.MediaTypes[0])
+		}
+	}
+
+	responseContent := envelope{}
+	for _, responseType := range responseTypes {
+		responseContent[responseType] = envelope{"schema": op.Response}
+	}
+
+	responses := envelope{
+		strconv.Itoa(op.Status): envelope{
+			"description": http.StatusText(op.Status),
+			"content":     responseContent,
+		},
+	}
+
+	// Errors can't be written as CSV, which falls back to JSON
+	errorResponse := envelope{}
+	for _, encoder := range RESPONSE_ENCODERS {
+		if encoder.MediaTypes[0] != "text/csv" {
+			errorResponse[encoder.MediaTypes[0]] = envelope{"schema": schemaRef("Error")}
+		}
+	}
+	for _, status := range append(errorStatuses, http.StatusInternalServerError) {
+		responses[strconv.Itoa(status)] = envelope{
+			"description": http.StatusText(status),
+			"content":     errorResponse,
+		}
+	}
+
+	spec := envelope{
+		"summary":    op.Summary,
+		"parameters": parameters,
+		"responses":  responses,
+	}
+
+	if op.RequestBody != nil {
+		requestTypes := op.RequestTypes
+		if requestTypes == nil {
+			requestTypes = []string{"application/json", MSGPACK_CONTENT_TYPES[0]}
+		}
+
+		content := envelope{}
+		for _, requestType := range requestTypes {
+			content[requestType] = envelope{"schema": op.RequestBody}
+		}
+		for requestType, schema := range op.RequestSchemas {
+			content[requestType] = envelope{"schema": schema}
+		}
+
+		spec["requestBody"] = envelope{"required": true, "content": content}
+	}
+
+	return spec
+}
+
+// Serve the OpenAPI document generated at startup, always as JSON
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, app.openAPISpec, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
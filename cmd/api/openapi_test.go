@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	app := &application{}
+	app.routes()
+
+	router := http.NewServeMux()
+	var registered []string
+	for _, pattern := range app.registerRoutes(router) {
+		// Catch-all routes without a method are not part of the API
+		if strings.Contains(pattern, " ") {
+			registered = append(registered, pattern)
+		}
+	}
+
+	paths, ok := app.openAPISpec["paths"].(envelope)
+	if !ok {
+		t.Fatal("the OpenAPI document has no paths")
+	}
+
+	var documented []string
+	for path, item := range paths {
+		for method := range item.(envelope) {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	slices.Sort(registered)
+	slices.Sort(documented)
+	if !slices.Equal(registered, documented) {
+		t.Fatalf("routes and OpenAPI document diverge\nregistered: %v\ndocumented: %v", registered, documented)
+	}
+
+	// Every documented operation is served by the route of the same pattern
+	pathParam := regexp.MustCompile(`\{[a-z_]+\}`)
+	for _, operation := range documented {
+		method, path, _ := strings.Cut(operation, " ")
+		r := httptest.NewRequest(method, pathParam.ReplaceAllString(path, "1"), nil)
+
+		if _, pattern := router.Handler(r); pattern != operation {
+			t.Errorf("%s %s is routed to %q", method, path, pattern)
+		}
+	}
+}
+
+func TestOpenAPIDescribesEncodings(t *testing.T) {
+	app := &application{}
+	app.routes()
+
+	description := app.openAPISpec["info"].(envelope)["description"].(string)
+	for _, encoding := range COMPRESSION_ENCODINGS {
+		if !strings.Contains(description, encoding) {
+			t.Errorf("the OpenAPI description doesn't mention the %s encoding", encoding)
+		}
+	}
+}
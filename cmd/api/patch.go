@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/jsonpatch"
+)
+
+// Content types selecting how updateMovieHandler interprets the request body
+const (
+	MERGE_PATCH_CONTENT_TYPE = "application/merge-patch+json" // RFC 7396
+	JSON_PATCH_CONTENT_TYPE  = "application/json-patch+json"  // RFC 6902
+)
+
+// The patched document doesn't describe a valid movie
+type invalidPatchError struct {
+	errors map[string]string
+}
+
+func (e invalidPatchError) Error() string {
+	return fmt.Sprint(e.errors)
+}
+
+// Read a merge patch or JSON patch from the request body and apply it to the JSON
+// representation of movie, returning the patched movie. Errors reading the body are
+// returned as is, patches that can't be applied as jsonpatch errors
+func (app *application) readMoviePatchDocument(w http.ResponseWriter, r *http.Request, movie *data.Movie, mediaType string) (*data.Movie, error) {
+	document, err := movieDocument(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mediaType {
+	case MERGE_PATCH_CONTENT_TYPE:
+		var patch any
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return nil, patchBodyError{err}
+		}
+		if _, ok := patch.(map[string]any); !ok {
+			return nil, patchBodyError{errors.New("body must contain a JSON object")}
+		}
+		document = jsonpatch.MergePatch(document, patch)
+
+	case JSON_PATCH_CONTENT_TYPE:
+		var operations []jsonpatch.Operation
+		err = app.readJSON(w, r, &operations)
+		if err != nil {
+			return nil, patchBodyError{err}
+		}
+		document, err = jsonpatch.Apply(document, operations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return patchedMovie(movie, document)
+}
+
+// The request body couldn't be read as a patch document
+type patchBodyError struct {
+	err error
+}
+
+func (e patchBodyError) Error() string {
+	return e.err.Error()
+}
+
+// Return the JSON representation of a movie, as a document patches can be applied to
+func movieDocument(movie *data.Movie) (any, error) {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	var document any
+	err = json.Unmarshal(js, &document)
+	return document, err
+}
+
+// Decode a patched document back into a movie. The id can't be changed, and a
+// different version than the current one is reported as an edit conflict
+func patchedMovie(movie *data.Movie, document any) (*data.Movie, error) {
+	object, ok := document.(map[string]any)
+	if !ok {
+		return nil, invalidPatchError{map[string]string{"movie": "must be a JSON object"}}
+	}
+
+	js, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+
+	var result data.Movie
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		return nil, invalidPatchError{map[string]string{"movie": jsonDecodeError(err).Error()}}
+	}
+
+	// Removing the id or version means leaving them untouched
+	if _, ok := object["id"]; !ok {
+		result.ID = movie.ID
+	}
+	if _, ok := object["version"]; !ok {
+		result.Version = movie.Version
+	}
+
+	if result.ID != movie.ID {
+		return nil, invalidPatchError{map[string]string{"id": "must not be changed"}}
+	}
+	if result.Version != movie.Version {
+		return nil, data.ErrEditConflict
+	}
+
+	result.CreatedAt = movie.CreatedAt
+	// Ratings come from reviews, they can't be patched
+	result.RatingAvg = movie.RatingAvg
+	result.RatingCount = movie.RatingCount
+
+	return &result, nil
+}
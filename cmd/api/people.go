@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var LIST_PEOPLE_SUPPORTED_SORT = data.PersonSortSafeList
+
+// Data that's expected from the client to create a person
+type personInput struct {
+	Name        string            `json:"name"`
+	BirthYear   *int32            `json:"birth_year"`
+	ExternalIDs map[string]string `json:"external_ids"`
+}
+
+// Data that's expected from the client to update a person. Fields left out are
+// unchanged, if Version is set the person must currently be at that version
+type personPatch struct {
+	Name        *string           `json:"name"`
+	BirthYear   *int32            `json:"birth_year"`
+	ExternalIDs map[string]string `json:"external_ids"`
+	Version     *int32            `json:"version"`
+}
+
+// Return the person model recording the actor of the request as the author of changes
+func (app *application) personModel(r *http.Request) data.PersonModel {
+	return app.models.People.WithActor(app.actor(r))
+}
+
+// Fetch the person of the request, writing a not found response if there's none
+func (app *application) readPersonFromRequestParams(w http.ResponseWriter, r *http.Request) (*data.Person, bool) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	person, err := app.models.People.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return person, true
+}
+
+// List people, filtered by name
+func (app *application) listPeopleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.PersonFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	input.Name = app.readString(queryStringValues, "name", "")
+	input.Filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	input.Filters.Sort = app.readString(queryStringValues, "sort", "id")
+	input.SortSafeList = LIST_PEOPLE_SUPPORTED_SORT
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	people, metadata, err := app.models.People.GetAll(input.PersonFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"people": people, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Create a new person
+func (app *application) createPersonHandler(w http.ResponseWriter, r *http.Request) {
+	var input personInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	person := &data.Person{
+		Name:        input.Name,
+		BirthYear:   input.BirthYear,
+		ExternalIDs: input.ExternalIDs,
+	}
+
+	v := validator.New()
+
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.personModel(r).Insert(person)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/people/%d", person.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"person": person}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show the details of a specific person
+func (app *application) getPersonHandler(w http.ResponseWriter, r *http.Request) {
+	person, ok := app.readPersonFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Update the details of a specific person
+func (app *application) updatePersonHandler(w http.ResponseWriter, r *http.Request) {
+	person, ok := app.readPersonFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var input personPatch
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	expectedVersion, err := app.readExpectedVersion(r, input.Version)
+	v.Check(err == nil, "version", "X-Expected-Version must be a positive integer")
+
+	if expectedVersion != 0 && expectedVersion != person.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Name != nil {
+		person.Name = *input.Name
+	}
+	if input.BirthYear != nil {
+		person.BirthYear = input.BirthYear
+	}
+	if input.ExternalIDs != nil {
+		person.ExternalIDs = input.ExternalIDs
+	}
+
+	if data.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.personModel(r).Update(person)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"person": person}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete a specific person, as long as they aren't credited in any movie
+func (app *application) deletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.personModel(r).Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrPersonCredited):
+			app.errorResponse(w, r, http.StatusConflict, "the person can't be deleted while credited in movies, including the ones in the trash")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "person successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
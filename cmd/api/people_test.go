@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Create a person through the API, returning its id
+func createTestPerson(t *testing.T, ts *httptest.Server, headers http.Header, name string) int64 {
+	t.Helper()
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/people", headers, map[string]any{"name": name})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+
+	var response struct {
+		Person data.Person `json:"person"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	return response.Person.ID
+}
+
+func TestPeople(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	id := createTestPerson(t, ts, admin, "Christopher Nolan")
+	createTestPerson(t, ts, admin, "Denis Villeneuve")
+	path := fmt.Sprintf("/v1/people/%d", id)
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/people", admin, map[string]any{"name": "", "birth_year": 1700})
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an invalid person, want 422: %s", res.StatusCode, body)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodGet, "/v1/people?name=NOLAN", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var list struct {
+		People []data.Person `json:"people"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.People) != 1 || list.People[0].ID != id {
+		t.Errorf("got people %+v, want the one matching the name", list.People)
+	}
+
+	headers := admin.Clone()
+	headers.Set("X-Expected-Version", "2")
+	res, body = doTestRequest(t, ts, http.MethodPatch, path, headers, map[string]any{"birth_year": 1970})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("got status %d updating a stale version, want 409: %s", res.StatusCode, body)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodPatch, path, admin, map[string]any{
+		"birth_year": 1970, "external_ids": map[string]string{"imdb": "nm0634240"},
+	})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodGet, path, nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var response struct {
+		Person data.Person `json:"person"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+	person := response.Person
+	if person.Name != "Christopher Nolan" || person.BirthYear == nil || *person.BirthYear != 1970 ||
+		person.ExternalIDs["imdb"] != "nm0634240" || person.Version != 2 {
+		t.Errorf("got %+v, want the updated person", person)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodDelete, path, admin, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	res, body = doTestRequest(t, ts, http.MethodGet, path, nil, nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d for a deleted person, want 404: %s", res.StatusCode, body)
+	}
+}
+
+func TestMovieCredits(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	inception := insertTestMovie(t, app, "Inception")
+	arrival := insertTestMovie(t, app, "Arrival")
+	nolan := createTestPerson(t, ts, admin, "Christopher Nolan")
+	dicaprio := createTestPerson(t, ts, admin, "Leonardo DiCaprio")
+	page := createTestPerson(t, ts, admin, "Elliot Page")
+
+	credit := func(movieID, personID int64, role string, character any) (*http.Response, []byte) {
+		t.Helper()
+
+		body := map[string]any{"person_id": personID, "role": role}
+		if character != nil {
+			body["character"] = character
+		}
+		return doTestRequest(t, ts, http.MethodPost, fmt.Sprintf("/v1/movies/%d/credits", movieID), admin, body)
+	}
+
+	// Added out of order, listed directors first then actors as added
+	for _, c := range []struct {
+		person    int64
+		role      string
+		character any
+	}{
+		{dicaprio, "actor", "Cobb"},
+		{page, "actor", "Ariadne"},
+		{nolan, "director", nil},
+		{nolan, "writer", nil},
+	} {
+		if res, body := credit(inception.ID, c.person, c.role, c.character); res.StatusCode != http.StatusCreated {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+	}
+
+	invalid := []struct {
+		name      string
+		person    int64
+		role      string
+		character any
+	}{
+		{name: "duplicate", person: nolan, role: "director"},
+		{name: "unknown person", person: 404, role: "director"},
+		{name: "unknown role", person: nolan, role: "producer"},
+		{name: "character of a director", person: nolan, role: "director", character: "Cobb"},
+	}
+	for _, tt := range invalid {
+		if res, body := credit(inception.ID, tt.person, tt.role, tt.character); res.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d, want 422: %s", tt.name, res.StatusCode, body)
+		}
+	}
+
+	list := func(t *testing.T) []data.Credit {
+		t.Helper()
+
+		res, body := doTestRequest(t, ts, http.MethodGet, fmt.Sprintf("/v1/movies/%d/credits", inception.ID), nil, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+		var response struct {
+			Credits []data.Credit `json:"credits"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Credits
+	}
+
+	credits := list(t)
+	var got []string
+	for _, c := range credits {
+		got = append(got, c.Role+" "+c.Name)
+	}
+	want := []string{"director Christopher Nolan", "writer Christopher Nolan", "actor Leonardo DiCaprio", "actor Elliot Page"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got credits %q, want %q", got, want)
+	}
+
+	// Movies are filtered by part of the name of their directors and actors
+	filters := []struct {
+		query string
+		ids   []int64
+	}{
+		{query: "director=nolan", ids: []int64{inception.ID}},
+		{query: "actor=page", ids: []int64{inception.ID}},
+		{query: "director=dicaprio", ids: []int64{}},
+		{query: "", ids: []int64{inception.ID, arrival.ID}},
+	}
+	for _, tt := range filters {
+		res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies?sort=id&"+tt.query, nil, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", tt.query, res.StatusCode, body)
+		}
+		var response struct {
+			Movies []data.Movie `json:"movies"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		ids := []int64{}
+		for _, movie := range response.Movies {
+			ids = append(ids, movie.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%q: got movies %v, want %v", tt.query, ids, tt.ids)
+		}
+	}
+
+	// People can't be deleted while credited
+	res, body := doTestRequest(t, ts, http.MethodDelete, fmt.Sprintf("/v1/people/%d", nolan), admin, nil)
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("got status %d deleting a credited person, want 409: %s", res.StatusCode, body)
+	}
+
+	path := fmt.Sprintf("/v1/movies/%d/credits/%d", inception.ID, credits[0].ID)
+	res, body = doTestRequest(t, ts, http.MethodDelete, fmt.Sprintf("/v1/movies/%d/credits/%d", arrival.ID, credits[0].ID), admin, nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d deleting the credit of another movie, want 404: %s", res.StatusCode, body)
+	}
+	res, body = doTestRequest(t, ts, http.MethodDelete, path, admin, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	if credits := list(t); len(credits) != 3 || credits[0].Role != "writer" {
+		t.Errorf("got credits %+v, want the director removed", credits)
+	}
+}
+
+func TestPeopleChangesRequireAdmin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+	user := http.Header{"Authorization": {"Bearer " + createTestUser(t, app, "user@example.com", data.RoleUser)}}
+
+	movie := insertTestMovie(t, app, "Inception")
+	person := createTestPerson(t, ts, admin, "Christopher Nolan")
+
+	changes := []struct {
+		method string
+		path   string
+		body   any
+	}{
+		{http.MethodPost, "/v1/people", map[string]any{"name": "Denis Villeneuve"}},
+		{http.MethodPatch, fmt.Sprintf("/v1/people/%d", person), map[string]any{"name": "Renamed"}},
+		{http.MethodDelete, fmt.Sprintf("/v1/people/%d", person), nil},
+		{http.MethodPost, fmt.Sprintf("/v1/movies/%d/credits", movie.ID), map[string]any{"person_id": person, "role": "director"}},
+		{http.MethodDelete, fmt.Sprintf("/v1/movies/%d/credits/1", movie.ID), nil},
+	}
+
+	for _, change := range changes {
+		res, body := doTestRequest(t, ts, change.method, change.path, nil, change.body)
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("anonymous %s %s: got status %d, want 401: %s", change.method, change.path, res.StatusCode, body)
+		}
+
+		res, body = doTestRequest(t, ts, change.method, change.path, user, change.body)
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("user %s %s: got status %d, want 403: %s", change.method, change.path, res.StatusCode, body)
+		}
+	}
+
+	res, body := doTestRequest(t, ts, http.MethodGet, "/v1/people", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var response struct {
+		People []data.Person `json:"people"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.People) != 1 || response.People[0].Name != "Christopher Nolan" {
+		t.Errorf("got people %+v, want them unchanged", response.People)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Permanently delete the movies that outlived the trash retention period, every
+// purge interval until the process exits
+func (app *application) purgeTrash() {
+	ticker := time.NewTicker(app.config.trash.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		app.purgeTrashOnce()
+		<-ticker.C
+	}
+}
+
+func (app *application) purgeTrashOnce() {
+	// A panic in the background must not take the server down
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Printf("trash purge panicked: %s", fmt.Sprint(err))
+		}
+	}()
+
+	purged, err := app.models.Movies.Purge(app.config.trash.retention)
+	if err != nil {
+		app.logger.Printf("trash purge failed: %s", err)
+		return
+	}
+
+	if purged > 0 {
+		app.logger.Printf("purged %d movies from the trash", purged)
+	}
+}
+
+// Delete the changes that outlived the change log retention period, every prune
+// interval until the process exits
+func (app *application) pruneMovieEvents() {
+	ticker := time.NewTicker(app.config.events.pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		app.pruneMovieEventsOnce()
+		<-ticker.C
+	}
+}
+
+func (app *application) pruneMovieEventsOnce() {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Printf("change log prune panicked: %s", fmt.Sprint(err))
+		}
+	}()
+
+	pruned, err := app.models.Movies.PruneEvents(app.config.events.retention)
+	if err != nil {
+		app.logger.Printf("change log prune failed: %s", err)
+		return
+	}
+
+	if pruned > 0 {
+		app.logger.Printf("pruned %d events from the change log", pruned)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var LIST_REVIEWS_SUPPORTED_SORT = data.ReviewSortSafeList
+
+// Data that's expected from the client to review a movie
+type reviewInput struct {
+	Score int32   `json:"score"`
+	Text  *string `json:"text"`
+}
+
+// Data that's expected from the client to update their review. Fields left out are
+// unchanged, an empty text removes it. If Version is set the review must currently
+// be at that version
+type reviewPatch struct {
+	Score   *int32  `json:"score"`
+	Text    *string `json:"text"`
+	Version *int32  `json:"version"`
+}
+
+// An empty text is the same as no text at all
+func reviewText(text *string) *string {
+	if text != nil && *text == "" {
+		return nil
+	}
+	return text
+}
+
+// Return the review model recording the actor of the request as the author of changes
+func (app *application) reviewModel(r *http.Request) data.ReviewModel {
+	return app.models.Reviews.WithActor(app.actor(r))
+}
+
+// List the reviews of a movie, most recent first by default
+func (app *application) listMovieReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.ReviewFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	input.MovieID = movie.ID
+	input.UserID = int64(app.readInt(queryStringValues, "user_id", 0, v))
+	input.Filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	input.Filters.Sort = app.readString(queryStringValues, "sort", "-id")
+	input.SortSafeList = LIST_REVIEWS_SUPPORTED_SORT
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAll(input.ReviewFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Review a movie as the authenticated user
+func (app *application) createMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var input reviewInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	review := &data.Review{
+		MovieID:  movie.ID,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Score:    input.Score,
+		Text:     reviewText(input.Text),
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.reviewModel(r).Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("movie", "you already reviewed this movie, update your review instead")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/reviews?user_id=%d", movie.ID, user.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"review": review}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Update the authenticated user's review of a movie
+func (app *application) updateMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	review, err := app.models.Reviews.GetForUser(movie.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input reviewPatch
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	expectedVersion, err := app.readExpectedVersion(r, input.Version)
+	v.Check(err == nil, "version", "X-Expected-Version must be a positive integer")
+
+	if expectedVersion != 0 && expectedVersion != review.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.Score != nil {
+		review.Score = *input.Score
+	}
+	if input.Text != nil {
+		review.Text = reviewText(input.Text)
+	}
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.reviewModel(r).Update(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete the authenticated user's review of a movie
+func (app *application) deleteMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.reviewModel(r).Delete(movie.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Fetch a movie straight from the database of app
+func getTestMovie(t *testing.T, app *application, id int64) *data.Movie {
+	t.Helper()
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return movie
+}
+
+func TestMovieReviews(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	alice := http.Header{"Authorization": {"Bearer " + createTestUser(t, app, "alice@example.com", data.RoleUser)}}
+	bob := http.Header{"Authorization": {"Bearer " + createTestUser(t, app, "bob@example.com", data.RoleUser)}}
+	carol := http.Header{"Authorization": {"Bearer " + createTestUser(t, app, "carol@example.com", data.RoleUser)}}
+
+	moana := insertTestMovie(t, app, "Moana")
+	arrival := insertTestMovie(t, app, "Arrival")
+	insertTestMovie(t, app, "Inception")
+	path := fmt.Sprintf("/v1/movies/%d/reviews", moana.ID)
+
+	res, body := doTestRequest(t, ts, http.MethodPost, path, nil, map[string]any{"score": 9})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d for an anonymous review, want 401: %s", res.StatusCode, body)
+	}
+
+	for _, review := range []struct {
+		headers http.Header
+		path    string
+		score   int
+	}{
+		{alice, path, 9},
+		{bob, path, 6},
+		{alice, fmt.Sprintf("/v1/movies/%d/reviews", arrival.ID), 10},
+	} {
+		res, body := doTestRequest(t, ts, http.MethodPost, review.path, review.headers, map[string]any{"score": review.score, "text": "Loved it"})
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+	}
+
+	invalid := []struct {
+		name    string
+		headers http.Header
+		body    map[string]any
+	}{
+		{name: "second review", headers: alice, body: map[string]any{"score": 5}},
+		{name: "score too high", headers: carol, body: map[string]any{"score": 11}},
+		{name: "no score", headers: carol, body: map[string]any{"text": "Loved it"}},
+	}
+	for _, tt := range invalid {
+		res, body := doTestRequest(t, ts, http.MethodPost, path, tt.headers, tt.body)
+		if res.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d, want 422: %s", tt.name, res.StatusCode, body)
+		}
+	}
+
+	checkRating := func(t *testing.T, avg *float64, count int32) {
+		t.Helper()
+
+		movie := getTestMovie(t, app, moana.ID)
+		if !reflect.DeepEqual(movie.RatingAvg, avg) || movie.RatingCount != count {
+			t.Errorf("got rating %v of %d reviews, want %v of %d", movie.RatingAvg, movie.RatingCount, avg, count)
+		}
+		// Ratings are kept apart from the versioned fields
+		if movie.Version != 1 {
+			t.Errorf("got version %d, want reviews to leave the movie unchanged", movie.Version)
+		}
+	}
+	ptr := func(f float64) *float64 { return &f }
+
+	checkRating(t, ptr(7.5), 2)
+
+	res, body = doTestRequest(t, ts, http.MethodGet, path+"?sort=-score", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var list struct {
+		Reviews []data.Review `json:"reviews"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Reviews) != 2 || list.Reviews[0].UserName != "alice@example.com" || list.Reviews[1].Score != 6 {
+		t.Errorf("got reviews %+v, want both by descending score", list.Reviews)
+	}
+
+	// Updates act on the caller's review
+	headers := alice.Clone()
+	headers.Set("X-Expected-Version", "2")
+	res, body = doTestRequest(t, ts, http.MethodPatch, path, headers, map[string]any{"score": 3})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("got status %d updating a stale review, want 409: %s", res.StatusCode, body)
+	}
+	res, body = doTestRequest(t, ts, http.MethodPatch, path, alice, map[string]any{"score": 3, "text": ""})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var updated struct {
+		Review data.Review `json:"review"`
+	}
+	if err := json.Unmarshal(body, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Review.Score != 3 || updated.Review.Text != nil || updated.Review.Version != 2 {
+		t.Errorf("got review %+v, want the score changed and the text removed", updated.Review)
+	}
+
+	checkRating(t, ptr(4.5), 2)
+
+	// The best rated first, unrated movies last
+	res, body = doTestRequest(t, ts, http.MethodGet, "/v1/movies?sort=-rating", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var movies struct {
+		Movies []data.Movie `json:"movies"`
+	}
+	if err := json.Unmarshal(body, &movies); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, movie := range movies.Movies {
+		titles = append(titles, movie.Title)
+	}
+	if want := []string{"Arrival", "Moana", "Inception"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("got movies %q, want %q", titles, want)
+	}
+
+	for _, headers := range []http.Header{alice, bob} {
+		res, body := doTestRequest(t, ts, http.MethodDelete, path, headers, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+	}
+	res, body = doTestRequest(t, ts, http.MethodPatch, path, bob, map[string]any{"score": 3})
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d updating a deleted review, want 404: %s", res.StatusCode, body)
+	}
+
+	checkRating(t, nil, 0)
+}
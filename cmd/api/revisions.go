@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var LIST_REVISIONS_SUPPORTED_SORT = data.RevisionSortSafeList
+
+// Read a version from a HTTP request's parameters
+func (app *application) readVersionFromRequestParams(r *http.Request) (int32, error) {
+	version, err := strconv.ParseInt(r.PathValue("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
+// Fetch the movie of the request, writing a not found response if there's none.
+// Revisions of movies in the trash can't be seen until they're restored
+func (app *application) readMovieFromRequestParams(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
+
+// List the revisions of a movie, most recent first by default
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	filters.Sort = app.readString(queryStringValues, "sort", "-version")
+	filters.SortSafeList = LIST_REVISIONS_SUPPORTED_SORT
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Movies.GetRevisions(movie.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show a movie as it was at a specific version
+func (app *application) getMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := app.readVersionFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(movie.ID, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show the fields changed between two versions of a movie, to the current one by default
+func (app *application) diffMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	from := app.readInt(queryStringValues, "from", 0, v)
+	to := app.readInt(queryStringValues, "to", int(movie.Version), v)
+
+	v.Check(from > 0, "from", "must be provided")
+	v.Check(from <= int(movie.Version), "from", "must not be greater than the current version")
+	v.Check(to > 0, "to", "must be greater than zero")
+	v.Check(to <= int(movie.Version), "to", "must not be greater than the current version")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions := make([]*data.MovieRevision, 0, 2)
+	for _, version := range []int{from, to} {
+		revision, err := app.models.Movies.GetRevision(movie.ID, int32(version))
+		if err != nil {
+			switch {
+			// Versions written before revisions were recorded are gone
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		revisions = append(revisions, revision)
+	}
+
+	changes, err := data.DiffRevisions(revisions[0], revisions[1])
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"from": from, "to": to, "changes": changes}
+
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Bring a movie back to how it was at a previous version. This is a regular update,
+// so it creates a new version rather than rewriting history
+func (app *application) restoreMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := app.readVersionFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	expectedVersion, err := app.readExpectedVersion(r, nil)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if expectedVersion != 0 && expectedVersion != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(movie.ID, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movie.Title = revision.Movie.Title
+	movie.Year = revision.Movie.Year
+	movie.Runtime = revision.Movie.Runtime
+	movie.Genres = revision.Movie.Genres
+	movie.ExternalID = revision.Movie.ExternalID
+
+	// Rules may have changed since the revision was written
+	v := validator.New()
+
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.movieModel(r).Update(movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateExternalID):
+			v.AddError("external_id", "a movie with this external id already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+func TestMovieRevisions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	admin := newTestAdminHeaders(t, app)
+
+	res, body := doTestRequest(t, ts, http.MethodPost, "/v1/movies", admin, map[string]any{
+		"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"},
+	})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	path := res.Header.Get("Location")
+
+	// Three versions, written by the admin
+	for _, patch := range []map[string]any{{"title": "Moana 2"}, {"runtime": "100 mins", "genres": []string{"animation", "musical"}}} {
+		res, body := doTestRequest(t, ts, http.MethodPatch, path, admin, patch)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+	}
+
+	t.Run("list", func(t *testing.T) {
+		res, body := doTestRequest(t, ts, http.MethodGet, path+"/revisions", nil, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+
+		var response struct {
+			Revisions []data.MovieRevision `json:"revisions"`
+			Metadata  data.Metadata        `json:"metadata"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+
+		var versions []int32
+		for _, revision := range response.Revisions {
+			versions = append(versions, revision.Version)
+			if revision.UserID == nil {
+				t.Errorf("got no user for version %d", revision.Version)
+			}
+		}
+		if want := []int32{3, 2, 1}; !reflect.DeepEqual(versions, want) {
+			t.Errorf("got versions %v, want %v", versions, want)
+		}
+		if response.Metadata.TotalRecords != 3 {
+			t.Errorf("got %d revisions in total, want 3", response.Metadata.TotalRecords)
+		}
+	})
+
+	t.Run("version", func(t *testing.T) {
+		res, body := doTestRequest(t, ts, http.MethodGet, path+"/revisions/2", nil, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+
+		var response struct {
+			Revision data.MovieRevision `json:"revision"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		movie := response.Revision.Movie
+		if response.Revision.Version != 2 || movie.Title != "Moana 2" || movie.Runtime != 107 || movie.Version != 2 {
+			t.Errorf("got revision %d of %+v, want the snapshot of version 2", response.Revision.Version, movie)
+		}
+
+		res, body = doTestRequest(t, ts, http.MethodGet, path+"/revisions/4", nil, nil)
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("got status %d for a future version, want 404: %s", res.StatusCode, body)
+		}
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		tests := []struct {
+			query   string
+			changes []data.MovieChange
+		}{
+			{
+				query:   "from=1&to=2",
+				changes: []data.MovieChange{{Field: "title", From: "Moana", To: "Moana 2"}},
+			},
+			{
+				// To the current version by default
+				query: "from=1",
+				changes: []data.MovieChange{
+					{Field: "genres", From: []any{"animation"}, To: []any{"animation", "musical"}},
+					{Field: "runtime", From: "107 minutes", To: "100 minutes"},
+					{Field: "title", From: "Moana", To: "Moana 2"},
+				},
+			},
+			{query: "from=3", changes: []data.MovieChange{}},
+		}
+
+		for _, tt := range tests {
+			res, body := doTestRequest(t, ts, http.MethodGet, path+"/revisions/diff?"+tt.query, nil, nil)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("%s: got status %d: %s", tt.query, res.StatusCode, body)
+			}
+
+			var response struct {
+				Changes []data.MovieChange `json:"changes"`
+			}
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(response.Changes, tt.changes) {
+				t.Errorf("%s: got changes %+v, want %+v", tt.query, response.Changes, tt.changes)
+			}
+		}
+
+		for _, query := range []string{"", "from=4", "from=1&to=0"} {
+			res, body := doTestRequest(t, ts, http.MethodGet, path+"/revisions/diff?"+query, nil, nil)
+			if res.StatusCode != http.StatusUnprocessableEntity {
+				t.Errorf("%q: got status %d, want 422: %s", query, res.StatusCode, body)
+			}
+		}
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		res, body := doTestRequest(t, ts, http.MethodPost, path+"/revisions/1/restore", nil, nil)
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("got status %d for an anonymous restore, want 401: %s", res.StatusCode, body)
+		}
+
+		headers := admin.Clone()
+		headers.Set("X-Expected-Version", "2")
+		res, body = doTestRequest(t, ts, http.MethodPost, path+"/revisions/1/restore", headers, nil)
+		if res.StatusCode != http.StatusConflict {
+			t.Errorf("got status %d restoring from a stale version, want 409: %s", res.StatusCode, body)
+		}
+
+		res, body = doTestRequest(t, ts, http.MethodPost, path+"/revisions/1/restore", admin, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.StatusCode, body)
+		}
+
+		var response struct {
+			Movie data.Movie `json:"movie"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatal(err)
+		}
+		movie := response.Movie
+
+		// The old snapshot as a new version
+		if movie.Version != 4 || movie.Title != "Moana" || movie.Runtime != 107 || !reflect.DeepEqual(movie.Genres, []string{"animation"}) {
+			t.Errorf("got %+v, want version 1 restored as version 4", movie)
+		}
+
+		revision, err := app.models.Movies.GetRevision(movie.ID, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revision.Movie.Title != "Moana" {
+			t.Errorf("got revision 4 titled %q, want the restored title", revision.Movie.Title)
+		}
+		if _, err := app.models.Movies.GetRevision(movie.ID, 3); err != nil {
+			t.Errorf("got %v for version 3, want history kept", err)
+		}
+	})
+
+	res, body = doTestRequest(t, ts, http.MethodGet, fmt.Sprintf("/v1/movies/%d/revisions", 404), nil, nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d for the revisions of a missing movie, want 404: %s", res.StatusCode, body)
+	}
+}
@@ -4,15 +4,94 @@ import "net/http"
 
 func (app *application) routes() http.Handler {
 	router := http.NewServeMux()
+	patterns := app.registerRoutes(router)
 
-	router.HandleFunc("GET /v1/healthcheck", app.healthcheckHandler)
-	router.HandleFunc("GET /v1/movies", app.listMoviesHandler)
-	router.HandleFunc("POST /v1/movies", app.createMovieHandler)
-	router.HandleFunc("GET /v1/movies/{id}", app.getMovieHandler)
-	router.HandleFunc("PATCH /v1/movies/{id}", app.updateMovieHandler)
-	router.HandleFunc("DELETE /v1/movies/{id}", app.deleteMovieHandler)
+	// Like ServeMux does for conflicting patterns, refuse to start with routes
+	// and documentation out of sync
+	spec, err := buildOpenAPISpec(patterns)
+	if err != nil {
+		panic(err)
+	}
+	app.openAPISpec = spec
+
+	schema, err := app.buildGraphQLSchema()
+	if err != nil {
+		panic(err)
+	}
+	app.graphQLSchema = schema
+
+	return app.compressResponses(app.requestID(app.negotiateResponses(app.authenticate(router))))
+}
+
+// Register the handlers of the API on router. Patterns are returned so the
+// OpenAPI document can be checked against them
+func (app *application) registerRoutes(router *http.ServeMux) []string {
+	var patterns []string
+	handle := func(pattern string, handler http.HandlerFunc) {
+		patterns = append(patterns, pattern)
+		router.HandleFunc(pattern, handler)
+	}
+
+	handle("GET /v1/healthcheck", app.healthcheckHandler)
+	handle("GET /v1/openapi.json", app.openAPIHandler)
+	handle("GET /v1/movies", app.listMoviesHandler)
+	handle("POST /v1/movies", app.requireAdmin(app.idempotent(app.createMovieHandler)))
+	handle("POST /v1/movies/import", app.requireAdmin(app.importMoviesHandler))
+	handle("GET /v1/movies/export", app.exportMoviesHandler)
+	handle("POST /v1/movies/batch", app.requireAdmin(app.idempotent(app.batchMoviesHandler)))
+	handle("GET /v1/movies/trash", app.requireAdmin(app.listTrashHandler))
+	handle("GET /v1/movies/stats", app.movieStatsHandler)
+	handle("GET /v1/movies/events", app.movieEventsHandler)
+	handle("GET /v1/movies/{id}", app.getMovieHandler)
+	handle("PATCH /v1/movies/{id}", app.requireAdmin(app.updateMovieHandler))
+	handle("PUT /v1/movies/{id}", app.requireAdmin(app.replaceMovieHandler))
+	handle("PUT /v1/movies/external/{external_id}", app.requireAdmin(app.upsertMovieHandler))
+	handle("DELETE /v1/movies/{id}", app.requireAdmin(app.deleteMovieHandler))
+	handle("POST /v1/movies/{id}/restore", app.requireAdmin(app.restoreMovieHandler))
+	handle("GET /v1/movies/{id}/revisions", app.listMovieRevisionsHandler)
+	handle("GET /v1/movies/{id}/revisions/diff", app.diffMovieRevisionsHandler)
+	handle("GET /v1/movies/{id}/revisions/{version}", app.getMovieRevisionHandler)
+	handle("POST /v1/movies/{id}/revisions/{version}/restore", app.requireAdmin(app.restoreMovieRevisionHandler))
+	handle("GET /v1/movies/{id}/credits", app.listMovieCreditsHandler)
+	handle("POST /v1/movies/{id}/credits", app.requireAdmin(app.idempotent(app.createMovieCreditHandler)))
+	handle("DELETE /v1/movies/{id}/credits/{credit_id}", app.requireAdmin(app.deleteMovieCreditHandler))
+	handle("GET /v1/movies/{id}/reviews", app.listMovieReviewsHandler)
+	handle("POST /v1/movies/{id}/reviews", app.requireAuthenticatedUser(app.idempotent(app.createMovieReviewHandler)))
+	handle("PATCH /v1/movies/{id}/reviews", app.requireAuthenticatedUser(app.updateMovieReviewHandler))
+	handle("DELETE /v1/movies/{id}/reviews", app.requireAuthenticatedUser(app.deleteMovieReviewHandler))
+	handle("GET /v1/people", app.listPeopleHandler)
+	handle("POST /v1/people", app.requireAdmin(app.idempotent(app.createPersonHandler)))
+	handle("GET /v1/people/{id}", app.getPersonHandler)
+	handle("PATCH /v1/people/{id}", app.requireAdmin(app.updatePersonHandler))
+	handle("DELETE /v1/people/{id}", app.requireAdmin(app.deletePersonHandler))
+	handle("GET /v1/genres", app.listGenresHandler)
+	handle("POST /v1/genres", app.requireAdmin(app.idempotent(app.createGenreHandler)))
+	handle("GET /v1/genres/{id}", app.getGenreHandler)
+	handle("PATCH /v1/genres/{id}", app.requireAdmin(app.updateGenreHandler))
+	handle("DELETE /v1/genres/{id}", app.requireAdmin(app.deleteGenreHandler))
+	handle("GET /v1/lists", app.listListsHandler)
+	handle("POST /v1/lists", app.requireAuthenticatedUser(app.idempotent(app.createListHandler)))
+	handle("GET /v1/lists/{id}", app.getListHandler)
+	handle("PATCH /v1/lists/{id}", app.requireAuthenticatedUser(app.updateListHandler))
+	handle("DELETE /v1/lists/{id}", app.requireAuthenticatedUser(app.deleteListHandler))
+	handle("GET /v1/lists/{id}/items", app.listListItemsHandler)
+	handle("POST /v1/lists/{id}/items", app.requireAuthenticatedUser(app.idempotent(app.createListItemHandler)))
+	handle("PATCH /v1/lists/{id}/items/{movie_id}", app.requireAuthenticatedUser(app.updateListItemHandler))
+	handle("DELETE /v1/lists/{id}/items/{movie_id}", app.requireAuthenticatedUser(app.deleteListItemHandler))
+	handle("GET /v1/audit", app.requireAdmin(app.listAuditEventsHandler))
+	handle("GET /v1/webhooks", app.requireAdmin(app.listWebhooksHandler))
+	handle("POST /v1/webhooks", app.requireAdmin(app.idempotent(app.createWebhookHandler)))
+	handle("GET /v1/webhooks/{id}", app.requireAdmin(app.getWebhookHandler))
+	handle("PATCH /v1/webhooks/{id}", app.requireAdmin(app.updateWebhookHandler))
+	handle("DELETE /v1/webhooks/{id}", app.requireAdmin(app.deleteWebhookHandler))
+	handle("GET /v1/webhooks/{id}/deliveries", app.requireAdmin(app.listWebhookDeliveriesHandler))
+	handle(
+		"POST /v1/webhooks/{id}/deliveries/{delivery_id}/retry",
+		app.requireAdmin(app.retryWebhookDeliveryHandler),
+	)
+	handle("POST /v1/graphql", app.graphQLHandler)
 	// Match all other requests to a generic not found response
-	router.HandleFunc("/", app.notFoundResponse)
+	handle("/", app.notFoundResponse)
 
-	return router
+	return patterns
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Facets the movie counts of the list endpoint can be grouped by with facets=
+var MOVIE_SUPPORTED_FACETS = data.MovieFacetSafeList
+
+// Read the comma-separated facets parameter, checking every value is supported
+func (app *application) readFacets(queryStringValues url.Values, v *validator.Validator) []string {
+	facets := app.readCSV(queryStringValues, "facets", []string{})
+
+	for _, value := range facets {
+		v.Check(validator.PermittedValue(value, MOVIE_SUPPORTED_FACETS...), "facets", "invalid facets value")
+	}
+
+	return facets
+}
+
+// Show aggregates of the movies matching the filters of the list endpoint
+func (app *application) movieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	input.MovieFilter = app.readMovieFilter(queryStringValues)
+	input.Conditions = app.readMovieConditions(queryStringValues, v)
+	input.ConditionSafeList = MOVIE_SUPPORTED_CONDITIONS
+
+	if data.ValidateConditions(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stats, err := app.models.Movies.Stats(input.MovieFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Insert movies of several decades, genres and runtimes, one of them in the trash
+func insertTestStatsMovies(t *testing.T, app *application) {
+	t.Helper()
+
+	for _, movie := range []*data.Movie{
+		{Title: "Heat", Year: 1995, Runtime: 170, Genres: []string{"action", "drama"}},
+		{Title: "Inception", Year: 2010, Runtime: 148, Genres: []string{"action", "science-fiction"}},
+		{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}},
+		{Title: "Arrival", Year: 2016, Runtime: 116, Genres: []string{"drama", "science-fiction"}},
+		{Title: "Parasite", Year: 2019, Runtime: 132, Genres: []string{"drama"}},
+		{Title: "Deleted", Year: 2019, Runtime: 90, Genres: []string{"comedy"}},
+	} {
+		if err := app.models.Movies.Insert(movie); err != nil {
+			t.Fatal(err)
+		}
+		if movie.Title == "Deleted" {
+			if err := app.models.Movies.Delete(movie.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestMovieStats(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	insertTestStatsMovies(t, app)
+
+	tests := []struct {
+		name    string
+		query   string
+		total   int
+		genres  string
+		decades string
+		years   string
+		runtime *data.RuntimeStats
+	}{
+		{
+			name:    "every movie",
+			query:   "",
+			total:   5,
+			genres:  "[{drama 3} {action 2} {science-fiction 2} {animation 1}]",
+			decades: "[{1990 1} {2010 4}]",
+			years:   "[{1995 1} {2010 1} {2016 2} {2019 1}]",
+			runtime: &data.RuntimeStats{
+				Min: 107, Max: 170, Average: 134.6,
+				Percentiles: map[string]int32{"p25": 116, "p50": 132, "p75": 148, "p90": 170, "p95": 170, "p99": 170},
+			},
+		},
+		{
+			name:    "filtered",
+			query:   "genres=drama&year_gte=2000",
+			total:   2,
+			genres:  "[{drama 2} {science-fiction 1}]",
+			decades: "[{2010 2}]",
+			years:   "[{2016 1} {2019 1}]",
+			runtime: &data.RuntimeStats{
+				Min: 116, Max: 132, Average: 124,
+				Percentiles: map[string]int32{"p25": 116, "p50": 116, "p75": 132, "p90": 132, "p95": 132, "p99": 132},
+			},
+		},
+		{name: "no movie", query: "title=missing", genres: "[]", decades: "[]", years: "[]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies/stats?"+tt.query, nil, nil)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d: %s", res.StatusCode, body)
+			}
+
+			var response struct {
+				Stats data.MovieStats `json:"stats"`
+			}
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatal(err)
+			}
+			stats := response.Stats
+
+			if stats.Total != tt.total {
+				t.Errorf("got a total of %d, want %d", stats.Total, tt.total)
+			}
+			if got := fmt.Sprint(stats.Genres); got != tt.genres {
+				t.Errorf("got genres %s, want %s", got, tt.genres)
+			}
+			if got := fmt.Sprint(stats.Decades); got != tt.decades {
+				t.Errorf("got decades %s, want %s", got, tt.decades)
+			}
+			if got := fmt.Sprint(stats.Years); got != tt.years {
+				t.Errorf("got years %s, want %s", got, tt.years)
+			}
+			if !reflect.DeepEqual(stats.Runtime, tt.runtime) {
+				t.Errorf("got runtime %+v, want %+v", stats.Runtime, tt.runtime)
+			}
+		})
+	}
+
+	res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies/stats?year_gte=recent", nil, nil)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an invalid filter, want 422: %s", res.StatusCode, body)
+	}
+}
+
+func TestListMoviesFacets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+	insertTestStatsMovies(t, app)
+
+	// Facets count every matching movie, not only the ones of the page
+	res, body := doTestRequest(t, ts, http.MethodGet, "/v1/movies?facets=genres,decade&genres_any=action,animation&page_size=1", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+
+	var response struct {
+		Movies []data.Movie                 `json:"movies"`
+		Facets map[string][]data.FacetCount `json:"facets"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Movies) != 1 {
+		t.Errorf("got %d movies, want a page of 1", len(response.Movies))
+	}
+	if len(response.Facets) != 2 {
+		t.Errorf("got facets %v, want genres and decade only", response.Facets)
+	}
+	if got, want := fmt.Sprint(response.Facets["genres"]), "[{action 2} {animation 1} {drama 1} {science-fiction 1}]"; got != want {
+		t.Errorf("got genres %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(response.Facets["decade"]), "[{1990 1} {2010 2}]"; got != want {
+		t.Errorf("got decades %s, want %s", got, want)
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodGet, "/v1/movies", nil, nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d: %s", res.StatusCode, body)
+	}
+	var plain map[string]json.RawMessage
+	if err := json.Unmarshal(body, &plain); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plain["facets"]; ok {
+		t.Error("got facets without facets=")
+	}
+
+	res, body = doTestRequest(t, ts, http.MethodGet, "/v1/movies?facets=genres,runtime", nil, nil)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an unsupported facet, want 422: %s", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+// Create an application backed by a new SQLite database with every migration
+// applied, closed at the end of the test
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.db.dsn = filepath.Join(t.TempDir(), "greenlight.db")
+	cfg.db.maxOpenConns = 1
+	cfg.db.maxIdleConns = 1
+	cfg.db.maxIdleTime = "15m"
+	cfg.db.DBQueryTimeout = 3 * time.Second
+	cfg.events.heartbeat = 15 * time.Second
+	cfg.idempotency.ttl = time.Hour
+	cfg.idempotency.lockTimeout = time.Minute
+
+	db, err := openDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	scripts, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Versions are zero-padded, so migrations sort in the order they apply in
+	slices.Sort(scripts)
+
+	for _, script := range scripts {
+		sql, err := os.ReadFile(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(sql)); err != nil {
+			t.Fatalf("%s: %s", filepath.Base(script), err)
+		}
+	}
+
+	shutdown := make(chan struct{})
+	t.Cleanup(func() { close(shutdown) })
+
+	return &application{
+		config:   cfg,
+		logger:   log.New(io.Discard, "", 0),
+		models:   data.NewModels(db, cfg.db.DBQueryTimeout),
+		shutdown: shutdown,
+
+		webhookClient: newWebhookClient(),
+		webhookWake:   make(chan struct{}, 1),
+	}
+}
+
+// Serve the routes of app over HTTP until the end of the test
+func newTestServer(t *testing.T, app *application) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(app.routes())
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// Create a user with the given role, returning its API token
+func createTestUser(t *testing.T, app *application, email, role string) string {
+	t.Helper()
+
+	token, err := app.models.Users.Insert(&data.User{Name: email, Email: email, Role: role})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// Create an admin, returning the headers authenticating its requests
+func newTestAdminHeaders(t *testing.T, app *application) http.Header {
+	t.Helper()
+
+	token := createTestUser(t, app, "admin@example.com", data.RoleAdmin)
+
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// Insert a movie straight into the database of app
+func insertTestMovie(t *testing.T, app *application, title string) *data.Movie {
+	t.Helper()
+
+	movie := &data.Movie{Title: title, Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	if err := app.models.Movies.Insert(movie); err != nil {
+		t.Fatal(err)
+	}
+
+	return movie
+}
+
+// Count the movies of app outside the trash
+func countTestMovies(t *testing.T, app *application) int {
+	t.Helper()
+
+	var count int
+	if err := app.models.Movies.DB.QueryRow("SELECT count(*) FROM movies WHERE deleted_at IS NULL").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+// Send a request to a test server, with a JSON body if body isn't nil, and
+// return the response with its body read
+func doTestRequest(
+	t *testing.T,
+	ts *httptest.Server,
+	method, path string,
+	headers http.Header,
+	body any,
+) (*http.Response, []byte) {
+	t.Helper()
+
+	var payload io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res, resBody
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var LIST_WEBHOOKS_SUPPORTED_SORT = data.WebhookSortSafeList
+
+// Data that's expected from the client to create a webhook
+type webhookInput struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+// Data that's expected from the client to update a webhook. Fields left out are
+// unchanged. If Version is set the webhook must currently be at that version
+type webhookPatch struct {
+	URL     *string   `json:"url"`
+	Events  *[]string `json:"events"`
+	Secret  *string   `json:"secret"`
+	Active  *bool     `json:"active"`
+	Version *int32    `json:"version"`
+}
+
+// Return the webhook model recording the actor of the request as the author of changes
+func (app *application) webhookModel(r *http.Request) data.WebhookModel {
+	return app.models.Webhooks.WithActor(app.actor(r))
+}
+
+// Fetch the webhook of the request, writing the error response if there's none
+func (app *application) readWebhookFromRequestParams(w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	id, err := app.readIdFromRequestParams(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	webhook, err := app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+// Show the details of all webhooks
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	filters.Sort = app.readString(queryStringValues, "sort", "id")
+	filters.SortSafeList = LIST_WEBHOOKS_SUPPORTED_SORT
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	webhooks, metadata, err := app.models.Webhooks.GetAll(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhooks": webhooks, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Subscribe an URL to changes to the catalog
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input webhookInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		Secret: input.Secret,
+		Active: input.Active == nil || *input.Active,
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.webhookModel(r).Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"webhook": webhook}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show the details of a specific webhook
+func (app *application) getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readWebhookFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Change a webhook's URL, events, secret or whether it's active
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readWebhookFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var input webhookPatch
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	expectedVersion, err := app.readExpectedVersion(r, input.Version)
+	v.Check(err == nil, "version", "X-Expected-Version must be a positive integer")
+
+	if expectedVersion != 0 && expectedVersion != webhook.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	if input.URL != nil {
+		webhook.URL = *input.URL
+	}
+	if input.Events != nil {
+		webhook.Events = *input.Events
+	}
+	if input.Secret != nil {
+		webhook.Secret = *input.Secret
+	}
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.webhookModel(r).Update(webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete a webhook along with its deliveries
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readWebhookFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.webhookModel(r).Delete(webhook.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show the deliveries of a webhook, e.g. the dead ones
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readWebhookFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	queryStringValues := r.URL.Query()
+
+	input.Status = app.readString(queryStringValues, "status", "")
+	input.Filters.Page = app.readInt(queryStringValues, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryStringValues, "page_size", 20, v)
+	input.Filters.Sort = app.readString(queryStringValues, "sort", "-id")
+	input.SortSafeList = LIST_WEBHOOKS_SUPPORTED_SORT
+
+	if input.Status != "" {
+		v.Check(
+			validator.PermittedValue(input.Status, data.WebhookDeliveryStatusSafeList...),
+			"status",
+			"must be one of pending, delivered or dead",
+		)
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(webhook.ID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Queue a delivery of a webhook again, e.g. a dead one once the receiver is fixed
+func (app *application) retryWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.readWebhookFromRequestParams(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(r.PathValue("delivery_id"), 10, 64)
+	if err != nil || deliveryID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	delivery, err := app.models.Webhooks.Redeliver(webhook.ID, deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.wakeWebhookDispatcher()
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"delivery": delivery}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
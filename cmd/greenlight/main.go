@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/pkg/client"
+)
+
+const usage = `Usage: greenlight [flags] <command> [arguments]
+
+Commands:
+  movies list|get|create|update|delete|restore  Manage movies
+  migrate up|down|version                        Apply or roll back database migrations
+  users list|create|rotate-token|delete          Manage API users (database only)
+
+Movies are managed through the API at -api, or directly on the database when
+-db-dsn is set.
+
+Flags:
+`
+
+type config struct {
+	apiURL         string
+	token          string
+	dsn            string
+	output         string
+	dbQueryTimeout time.Duration
+}
+
+type cli struct {
+	config config
+	out    *printer
+	db     *sql.DB
+	models data.Models
+	api    *client.Client
+}
+
+func main() {
+	var cfg config
+
+	flags := flag.NewFlagSet("greenlight", flag.ExitOnError)
+	flags.StringVar(&cfg.apiURL, "api", envOr("GREENLIGHT_API_URL", "http://localhost:4000"), "API base URL")
+	flags.StringVar(&cfg.token, "token", os.Getenv("GREENLIGHT_TOKEN"), "API token")
+	flags.StringVar(&cfg.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "SQLite DSN, bypasses the API when set")
+	flags.StringVar(&cfg.output, "output", "table", "Output format (table|json|csv)")
+	flags.DurationVar(&cfg.dbQueryTimeout, "db-query-timeout", 3*time.Second, "DB query timeout")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	out, err := newPrinter(os.Stdout, cfg.output)
+	if err != nil {
+		fatal(err)
+	}
+
+	app := &cli{config: cfg, out: out}
+	defer app.close()
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+
+	switch command {
+	case "movies":
+		err = app.moviesCommand(args)
+	case "migrate":
+		err = app.migrateCommand(args)
+	case "users":
+		err = app.usersCommand(args)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+
+	if err != nil {
+		app.close()
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+func envOr(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Whether commands should go straight to the database instead of the API
+func (app *cli) direct() bool {
+	return app.config.dsn != ""
+}
+
+// Open the database connection, for commands that can only run against it
+func (app *cli) openDB() error {
+	if app.db != nil {
+		return nil
+	}
+	if app.config.dsn == "" {
+		return errors.New("this command requires -db-dsn or GREENLIGHT_DB_DSN")
+	}
+
+	db, err := sql.Open("sqlite3", app.config.dsn)
+	if err != nil {
+		return err
+	}
+	// Same as the API, sqlite3 doesn't support concurrent writers
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	app.db = db
+	app.models = data.NewModels(db, app.config.dbQueryTimeout)
+
+	return nil
+}
+
+func (app *cli) client() *client.Client {
+	if app.api == nil {
+		app.api = client.New(app.config.apiURL)
+		app.api.Token = app.config.token
+	}
+	return app.api
+}
+
+func (app *cli) close() {
+	if app.db != nil {
+		app.db.Close()
+		app.db = nil
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Migration files are named like golang-migrate expects them, and the applied
+// version is kept in the same schema_migrations table, so both tools can be mixed
+var migrationFileRX = regexp.MustCompile(`^([0-9]+)_[^.]+\.(up|down)\.sql$`)
+
+type migration struct {
+	version uint64
+	up      string
+	down    string
+}
+
+func (app *cli) migrateCommand(args []string) error {
+	var path string
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.StringVar(&path, "path", "./migrations", "Directory containing the migration files")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: greenlight migrate [-path dir] up [N] | down [N] | version")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("missing migrate command")
+	}
+
+	err := app.openDB()
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations(path)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	err = ensureMigrationsTable(ctx, app.db)
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := currentMigration(ctx, app.db)
+	if err != nil {
+		return err
+	}
+
+	if flags.Arg(0) == "version" {
+		if dirty {
+			fmt.Printf("%d (dirty)\n", current)
+		} else {
+			fmt.Println(current)
+		}
+		return nil
+	}
+
+	if dirty {
+		return fmt.Errorf("database is at dirty version %d, fix it manually before migrating", current)
+	}
+
+	// Number of steps, all of them by default
+	steps := len(migrations)
+	if flags.NArg() > 1 {
+		steps, err = strconv.Atoi(flags.Arg(1))
+		if err != nil || steps < 1 {
+			return fmt.Errorf("invalid number of steps %q", flags.Arg(1))
+		}
+	}
+
+	switch flags.Arg(0) {
+	case "up":
+		for _, m := range migrations {
+			if steps == 0 {
+				break
+			}
+			if m.version <= current {
+				continue
+			}
+
+			err = applyMigration(ctx, app.db, m.version, m.up, m.version)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d/u applied\n", m.version)
+			steps--
+		}
+
+	case "down":
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if steps == 0 {
+				break
+			}
+			if m.version > current {
+				continue
+			}
+
+			// The previous migration becomes the current version, none if it was the first one
+			var previous uint64
+			if i > 0 {
+				previous = migrations[i-1].version
+			}
+
+			err = applyMigration(ctx, app.db, m.version, m.down, previous)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d/d applied\n", m.version)
+			steps--
+		}
+
+	default:
+		return fmt.Errorf("unknown migrate command %q", flags.Arg(0))
+	}
+
+	return nil
+}
+
+// Read the migration files from a directory, sorted by version
+func loadMigrations(path string) ([]*migration, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint64]*migration{}
+
+	for _, entry := range entries {
+		matches := migrationFileRX.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := os.ReadFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+
+		if matches[2] == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]*migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
+
+func ensureMigrationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (version uint64, dirty bool);
+        CREATE UNIQUE INDEX IF NOT EXISTS version_unique ON schema_migrations (version);`)
+	return err
+}
+
+func currentMigration(ctx context.Context, db *sql.DB) (uint64, bool, error) {
+	var version uint64
+	var dirty bool
+
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).
+		Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+// Run a migration in a transaction and record the resulting version. The version
+// is marked dirty beforehand, so a failure halfway is visible to the next run
+func applyMigration(ctx context.Context, db *sql.DB, version uint64, script string, result uint64) error {
+	err := setMigrationVersion(ctx, db, version, true)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return fmt.Errorf("migration %d failed: %w", version, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if result == 0 {
+		_, err = db.ExecContext(ctx, `DELETE FROM schema_migrations`)
+		return err
+	}
+
+	return setMigrationVersion(ctx, db, result, false)
+}
+
+func setMigrationVersion(ctx context.Context, db *sql.DB, version uint64, dirty bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`,
+		version,
+		dirty,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
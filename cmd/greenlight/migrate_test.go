@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	app, _ := newTestCLI(t, "table")
+	ctx := context.Background()
+
+	migrations, err := loadMigrations(testMigrationsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) < 2 {
+		t.Fatalf("got %d migrations, want at least 2", len(migrations))
+	}
+	last := migrations[len(migrations)-1].version
+	previous := migrations[len(migrations)-2].version
+
+	version := func() uint64 {
+		t.Helper()
+
+		current, dirty, err := currentMigration(ctx, app.db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dirty {
+			t.Fatalf("got dirty version %d", current)
+		}
+		return current
+	}
+	hasMovies := func() bool {
+		t.Helper()
+
+		var count int
+		err := app.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'movies'").Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count == 1
+	}
+
+	steps := []struct {
+		args    []string
+		version uint64
+		movies  bool
+	}{
+		{args: []string{"up", "1"}, version: migrations[0].version, movies: true},
+		{args: []string{"up"}, version: last, movies: true},
+		// Already up to date
+		{args: []string{"up"}, version: last, movies: true},
+		{args: []string{"down", "1"}, version: previous, movies: true},
+		{args: []string{"down"}, version: 0, movies: false},
+		{args: []string{"up"}, version: last, movies: true},
+	}
+
+	for _, step := range steps {
+		args := append([]string{"-path", testMigrationsPath}, step.args...)
+		if err := app.migrateCommand(args); err != nil {
+			t.Fatalf("migrate %v: %s", step.args, err)
+		}
+
+		if got := version(); got != step.version {
+			t.Errorf("migrate %v: got version %d, want %d", step.args, got, step.version)
+		}
+		if got := hasMovies(); got != step.movies {
+			t.Errorf("migrate %v: got movies table %t, want %t", step.args, got, step.movies)
+		}
+	}
+}
+
+func TestMigrateInvalidSteps(t *testing.T) {
+	app, _ := newTestCLI(t, "table")
+
+	for _, args := range [][]string{{"up", "0"}, {"down", "x"}, {"sideways"}} {
+		if err := app.migrateCommand(append([]string{"-path", testMigrationsPath}, args...)); err == nil {
+			t.Errorf("migrate %v: got no error", args)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+	"greenlight.flaviogalon.github.io/pkg/client"
+)
+
+// Number of movies inserted per transaction when importing straight to the database
+const importBatchSize = 500
+
+type listParams struct {
+	title    string
+	genres   []string
+	sort     string
+	page     int
+	pageSize int
+}
+
+// Partial update of a movie, nil fields are left untouched
+type movieUpdate struct {
+	title   *string
+	year    *int32
+	runtime *data.Runtime
+	genres  []string
+}
+
+// Where movies are read from and written to, either the API or the database
+type movieStore interface {
+	list(params listParams) ([]*data.Movie, data.Metadata, error)
+	get(id int64) (*data.Movie, error)
+	create(movie *data.Movie) error
+	update(id int64, expectedVersion int32, update movieUpdate) (*data.Movie, error)
+	delete(id int64) error
+	restore(id int64) (*data.Movie, error)
+	importMovies(r io.Reader, format string) (*data.ImportReport, error)
+}
+
+func (app *cli) movieStore() (movieStore, error) {
+	if app.direct() {
+		if err := app.openDB(); err != nil {
+			return nil, err
+		}
+		return dbMovieStore{models: app.models}, nil
+	}
+	return apiMovieStore{client: app.client()}, nil
+}
+
+func (app *cli) moviesCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: greenlight movies list|get|create|update|delete|restore|import")
+	}
+
+	store, err := app.movieStore()
+	if err != nil {
+		return err
+	}
+
+	subcommand, args := args[0], args[1:]
+
+	switch subcommand {
+	case "list":
+		return app.moviesList(store, args)
+	case "get":
+		return app.moviesGet(store, args)
+	case "create":
+		return app.moviesCreate(store, args)
+	case "update":
+		return app.moviesUpdate(store, args)
+	case "delete":
+		return app.moviesDelete(store, args)
+	case "restore":
+		return app.moviesRestore(store, args)
+	case "import":
+		return app.moviesImport(store, args)
+	}
+
+	return fmt.Errorf("unknown movies command %q", subcommand)
+}
+
+func (app *cli) moviesList(store movieStore, args []string) error {
+	var params listParams
+	var genres string
+	var all bool
+
+	flags := flag.NewFlagSet("movies list", flag.ExitOnError)
+	flags.StringVar(&params.title, "title", "", "Filter by title")
+	flags.StringVar(&genres, "genres", "", "Comma-separated genres a movie must have")
+	flags.StringVar(&params.sort, "sort", "id", "Sort key, prefix with - for descending order")
+	flags.IntVar(&params.page, "page", 1, "Page number")
+	flags.IntVar(&params.pageSize, "page-size", 20, "Number of movies per page")
+	flags.BoolVar(&all, "all", false, "Fetch every page")
+	flags.Parse(args)
+
+	if genres != "" {
+		params.genres = strings.Split(genres, ",")
+	}
+
+	var movies []*data.Movie
+	for {
+		page, metadata, err := store.list(params)
+		if err != nil {
+			return err
+		}
+
+		movies = append(movies, page...)
+
+		if !all || params.page >= metadata.LastPage {
+			break
+		}
+		params.page++
+	}
+
+	return app.out.movies(movies)
+}
+
+func (app *cli) moviesGet(store movieStore, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	movie, err := store.get(id)
+	if err != nil {
+		return err
+	}
+
+	return app.out.movie(movie)
+}
+
+func (app *cli) moviesCreate(store movieStore, args []string) error {
+	var movie data.Movie
+	var year, runtime int
+	var genres string
+
+	flags := flag.NewFlagSet("movies create", flag.ExitOnError)
+	flags.StringVar(&movie.Title, "title", "", "Title")
+	flags.IntVar(&year, "year", 0, "Release year")
+	flags.IntVar(&runtime, "runtime", 0, "Runtime in minutes")
+	flags.StringVar(&genres, "genres", "", "Comma-separated genres")
+	flags.Parse(args)
+
+	movie.Year = int32(year)
+	movie.Runtime = data.Runtime(runtime)
+	if genres != "" {
+		movie.Genres = strings.Split(genres, ",")
+	}
+
+	err := store.create(&movie)
+	if err != nil {
+		return err
+	}
+
+	return app.out.movie(&movie)
+}
+
+func (app *cli) moviesUpdate(store movieStore, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	var title, genres string
+	var year, runtime, version int
+
+	flags := flag.NewFlagSet("movies update", flag.ExitOnError)
+	flags.StringVar(&title, "title", "", "Title")
+	flags.IntVar(&year, "year", 0, "Release year")
+	flags.IntVar(&runtime, "runtime", 0, "Runtime in minutes")
+	flags.StringVar(&genres, "genres", "", "Comma-separated genres")
+	flags.IntVar(&version, "version", 0, "Fail unless the movie is at this version")
+	flags.Parse(args[1:])
+
+	// Only send the fields that were set on the command line
+	var update movieUpdate
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "title":
+			update.title = &title
+		case "year":
+			y := int32(year)
+			update.year = &y
+		case "runtime":
+			r := data.Runtime(runtime)
+			update.runtime = &r
+		case "genres":
+			update.genres = strings.Split(genres, ",")
+		}
+	})
+
+	movie, err := store.update(id, int32(version), update)
+	if err != nil {
+		return err
+	}
+
+	return app.out.movie(movie)
+}
+
+func (app *cli) moviesDelete(store movieStore, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	return store.delete(id)
+}
+
+func (app *cli) moviesRestore(store movieStore, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	movie, err := store.restore(id)
+	if err != nil {
+		return err
+	}
+
+	return app.out.movie(movie)
+}
+
+func (app *cli) moviesImport(store movieStore, args []string) error {
+	var format string
+
+	flags := flag.NewFlagSet("movies import", flag.ExitOnError)
+	flags.StringVar(&format, "format", "", "Input format (csv|ndjson|imdb), implied by the file extension if not set")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return errors.New("usage: greenlight movies import [-format csv|ndjson|imdb] <file|->")
+	}
+	path := flags.Arg(0)
+
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = data.ImportFormatCSV
+		case ".ndjson", ".jsonl":
+			format = data.ImportFormatNDJSON
+		case ".tsv":
+			format = data.ImportFormatIMDb
+		default:
+			return fmt.Errorf("can't tell the format of %q, use -format", path)
+		}
+	}
+
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	report, err := store.importMovies(input, format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "created: %d, skipped: %d, invalid: %d\n", report.Created, report.Skipped, report.Invalid)
+
+	return app.out.importReport(report)
+}
+
+func parseID(args []string) (int64, error) {
+	if len(args) == 0 {
+		return 0, errors.New("missing ID argument")
+	}
+
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid ID %q", args[0])
+	}
+
+	return id, nil
+}
+
+// Errors of a failed validation, in the same shape the API returns them
+type validationError map[string]string
+
+func (e validationError) Error() string {
+	fields := make([]string, 0, len(e))
+	for field, message := range e {
+		fields = append(fields, field+" "+message)
+	}
+	sort.Strings(fields)
+	return "validation failed: " + strings.Join(fields, "; ")
+}
+
+// Movie store working directly on the database through internal/data
+type dbMovieStore struct {
+	models data.Models
+}
+
+func (s dbMovieStore) list(params listParams) ([]*data.Movie, data.Metadata, error) {
+	filters := data.Filters{
+		Page:         params.page,
+		PageSize:     params.pageSize,
+		Sort:         params.sort,
+		SortSafeList: data.MovieSortSafeList,
+	}
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, data.Metadata{}, validationError(v.Errors)
+	}
+
+	return s.models.Movies.GetAll(data.MovieFilter{Title: params.title, Genres: params.genres}, filters)
+}
+
+func (s dbMovieStore) get(id int64) (*data.Movie, error) {
+	return s.models.Movies.Get(id)
+}
+
+func (s dbMovieStore) create(movie *data.Movie) error {
+	genres, err := s.models.Genres.Vocabulary()
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		return validationError(v.Errors)
+	}
+
+	return s.models.Movies.Insert(movie)
+}
+
+func (s dbMovieStore) update(id int64, expectedVersion int32, update movieUpdate) (*data.Movie, error) {
+	movie, err := s.models.Movies.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if expectedVersion != 0 && movie.Version != expectedVersion {
+		return nil, data.ErrEditConflict
+	}
+
+	if update.title != nil {
+		movie.Title = *update.title
+	}
+	if update.year != nil {
+		movie.Year = *update.year
+	}
+	if update.runtime != nil {
+		movie.Runtime = *update.runtime
+	}
+	if update.genres != nil {
+		movie.Genres = update.genres
+	}
+
+	genres, err := s.models.Genres.Vocabulary()
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		return nil, validationError(v.Errors)
+	}
+
+	err = s.models.Movies.Update(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return movie, nil
+}
+
+func (s dbMovieStore) delete(id int64) error {
+	return s.models.Movies.Delete(id)
+}
+
+func (s dbMovieStore) restore(id int64) (*data.Movie, error) {
+	return s.models.Movies.Restore(id)
+}
+
+func (s dbMovieStore) importMovies(r io.Reader, format string) (*data.ImportReport, error) {
+	return s.models.Movies.Import(r, format, importBatchSize)
+}
+
+// Movie store talking to a running API through pkg/client
+type apiMovieStore struct {
+	client *client.Client
+}
+
+func fromClientMovie(m *client.Movie) *data.Movie {
+	movie := &data.Movie{
+		ID:          m.ID,
+		Title:       m.Title,
+		Year:        m.Year,
+		Runtime:     data.Runtime(m.Runtime),
+		Genres:      m.Genres,
+		RatingAvg:   m.RatingAvg,
+		RatingCount: m.RatingCount,
+		Version:     m.Version,
+	}
+	if m.ExternalID != "" {
+		movie.ExternalID = &m.ExternalID
+	}
+	return movie
+}
+
+func (s apiMovieStore) list(params listParams) ([]*data.Movie, data.Metadata, error) {
+	pages := s.client.ListMovies(context.Background(), client.ListMoviesParams{
+		Title:    params.title,
+		Genres:   params.genres,
+		Sort:     params.sort,
+		PageSize: params.pageSize,
+		Page:     params.page,
+	})
+
+	movies := []*data.Movie{}
+	if pages.Next() {
+		for _, movie := range pages.Movies() {
+			movies = append(movies, fromClientMovie(movie))
+		}
+	}
+	if err := pages.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	metadata := pages.Metadata()
+
+	return movies, data.Metadata(metadata), nil
+}
+
+func (s apiMovieStore) get(id int64) (*data.Movie, error) {
+	movie, err := s.client.GetMovie(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromClientMovie(movie), nil
+}
+
+func (s apiMovieStore) create(movie *data.Movie) error {
+	created, err := s.client.CreateMovie(context.Background(), client.Movie{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: client.Runtime(movie.Runtime),
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+
+	*movie = *fromClientMovie(created)
+	return nil
+}
+
+func (s apiMovieStore) update(id int64, expectedVersion int32, update movieUpdate) (*data.Movie, error) {
+	clientUpdate := client.MovieUpdate{
+		Title:  update.title,
+		Year:   update.year,
+		Genres: update.genres,
+	}
+	if update.runtime != nil {
+		runtime := client.Runtime(*update.runtime)
+		clientUpdate.Runtime = &runtime
+	}
+
+	movie, err := s.client.UpdateMovie(context.Background(), id, expectedVersion, clientUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromClientMovie(movie), nil
+}
+
+func (s apiMovieStore) delete(id int64) error {
+	return s.client.DeleteMovie(context.Background(), id)
+}
+
+func (s apiMovieStore) restore(id int64) (*data.Movie, error) {
+	movie, err := s.client.RestoreMovie(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromClientMovie(movie), nil
+}
+
+func (s apiMovieStore) importMovies(r io.Reader, format string) (*data.ImportReport, error) {
+	// Imports can take longer than the client's default timeout
+	c := *s.client
+	c.HTTPClient = &http.Client{}
+
+	report, err := c.ImportMovies(context.Background(), r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	imported := &data.ImportReport{
+		Created: report.Created,
+		Skipped: report.Skipped,
+		Invalid: report.Invalid,
+		Rows:    make([]data.ImportRow, 0, len(report.Rows)),
+	}
+	for _, row := range report.Rows {
+		imported.Rows = append(imported.Rows, data.ImportRow(row))
+	}
+
+	return imported, nil
+}
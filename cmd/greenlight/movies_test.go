@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/pkg/client"
+)
+
+// Run a movies subcommand, returning what it printed as JSON
+func runMoviesCommand(t *testing.T, app *cli, buf *bytes.Buffer, args ...string) ([]byte, error) {
+	t.Helper()
+
+	buf.Reset()
+	err := app.moviesCommand(args)
+
+	return buf.Bytes(), err
+}
+
+func decodeTestMovie(t *testing.T, js []byte) *data.Movie {
+	t.Helper()
+
+	var movie data.Movie
+	if err := json.Unmarshal(js, &movie); err != nil {
+		t.Fatalf("%s: %s", err, js)
+	}
+
+	return &movie
+}
+
+func TestDBMovies(t *testing.T) {
+	app, buf := newMigratedTestCLI(t, "json")
+
+	out, err := runMoviesCommand(t, app, buf, "create", "-title", "Moana", "-year", "2016", "-runtime", "107", "-genres", "animation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moana := decodeTestMovie(t, out)
+	if moana.ID == 0 || moana.Title != "Moana" || moana.Runtime != 107 || moana.Version != 1 {
+		t.Fatalf("got created movie %+v", moana)
+	}
+
+	_, err = runMoviesCommand(t, app, buf, "create", "-title", "", "-year", "2016", "-runtime", "107", "-genres", "animation")
+	var validationErr validationError
+	if !errors.As(err, &validationErr) || validationErr["title"] == "" {
+		t.Errorf("creating a movie without a title: got %v, want a validation error for title", err)
+	}
+
+	out, err = runMoviesCommand(t, app, buf, "create", "-title", "Arrival", "-year", "2017", "-runtime", "116", "-genres", "drama")
+	if err != nil {
+		t.Fatal(err)
+	}
+	arrival := decodeTestMovie(t, out)
+
+	_, err = runMoviesCommand(t, app, buf, "update", "1", "-title", "Renamed", "-version", "2")
+	if !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("updating a stale version: got %v, want ErrEditConflict", err)
+	}
+
+	// Only the flags set are changed
+	out, err = runMoviesCommand(t, app, buf, "update", "1", "-runtime", "110", "-version", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := decodeTestMovie(t, out)
+	if updated.Title != "Moana" || updated.Runtime != 110 || updated.Version != 2 {
+		t.Errorf("got updated movie %+v", updated)
+	}
+
+	out, err = runMoviesCommand(t, app, buf, "get", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeTestMovie(t, out); !reflect.DeepEqual(got, updated) {
+		t.Errorf("got movie %+v, want %+v", got, updated)
+	}
+
+	out, err = runMoviesCommand(t, app, buf, "list", "-sort", "-year", "-page-size", "1", "-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var movies []*data.Movie
+	if err := json.Unmarshal(out, &movies); err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 2 || movies[0].ID != arrival.ID || movies[1].ID != moana.ID {
+		t.Errorf("got movies %+v, want every page sorted by descending year", movies)
+	}
+
+	if _, err := runMoviesCommand(t, app, buf, "delete", "1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = runMoviesCommand(t, app, buf, "get", "1")
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("getting a deleted movie: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+// A request as the fake API received it
+type apiRequest struct {
+	method          string
+	url             string
+	authorization   string
+	expectedVersion string
+	body            map[string]any
+}
+
+// Serve the movie endpoints the CLI uses with canned responses, recording the requests
+func newFakeAPI(t *testing.T) (*httptest.Server, func() []apiRequest) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []apiRequest
+
+	movie := func(version int) map[string]any {
+		return map[string]any{"id": 1, "title": "Moana", "year": 2016, "runtime": "107 minutes", "genres": []string{"animation"}, "version": version}
+	}
+	write := func(w http.ResponseWriter, status int, body any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/movies", func(w http.ResponseWriter, r *http.Request) {
+		page := movie(1)
+		if r.URL.Query().Get("page") == "2" {
+			page = map[string]any{"id": 2, "title": "Arrival", "year": 2016, "runtime": "116 minutes", "genres": []string{"drama"}, "version": 1}
+		}
+		metadata := map[string]any{"current_page": 1, "page_size": 1, "first_page": 1, "last_page": 2, "total_records": 2}
+		write(w, http.StatusOK, map[string]any{"movies": []any{page}, "metadata": metadata})
+	})
+	mux.HandleFunc("POST /v1/movies", func(w http.ResponseWriter, r *http.Request) {
+		write(w, http.StatusCreated, map[string]any{"movie": movie(1)})
+	})
+	mux.HandleFunc("GET /v1/movies/1", func(w http.ResponseWriter, r *http.Request) {
+		write(w, http.StatusOK, map[string]any{"movie": movie(1)})
+	})
+	mux.HandleFunc("PATCH /v1/movies/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Expected-Version") != "1" {
+			write(w, http.StatusConflict, map[string]any{"error": "unable to update the record due to an edit conflict, please try again"})
+			return
+		}
+		write(w, http.StatusOK, map[string]any{"movie": movie(2)})
+	})
+	mux.HandleFunc("DELETE /v1/movies/1", func(w http.ResponseWriter, r *http.Request) {
+		write(w, http.StatusOK, map[string]any{"message": "movie successfully deleted"})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		write(w, http.StatusNotFound, map[string]any{"error": "the requested resource could not be found"})
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := apiRequest{
+			method:          r.Method,
+			url:             r.URL.String(),
+			authorization:   r.Header.Get("Authorization"),
+			expectedVersion: r.Header.Get("X-Expected-Version"),
+		}
+		if body, _ := io.ReadAll(r.Body); len(body) > 0 {
+			json.Unmarshal(body, &req.body)
+		}
+
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, func() []apiRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestAPIMovies(t *testing.T) {
+	ts, requests := newFakeAPI(t)
+
+	app, buf := newTestCLI(t, "json")
+	// Movies go through the API unless a DSN is set
+	app.config.dsn = ""
+	app.config.apiURL = ts.URL
+	app.config.token = "secret-token"
+
+	out, err := runMoviesCommand(t, app, buf, "create", "-title", "Moana", "-year", "2016", "-runtime", "107", "-genres", "animation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie := decodeTestMovie(t, out); movie.ID != 1 || movie.Runtime != 107 {
+		t.Errorf("got created movie %+v", movie)
+	}
+
+	_, err = runMoviesCommand(t, app, buf, "update", "1", "-title", "Renamed", "-version", "2")
+	if !errors.Is(err, client.ErrEditConflict) {
+		t.Errorf("updating a stale version: got %v, want ErrEditConflict", err)
+	}
+
+	out, err = runMoviesCommand(t, app, buf, "update", "1", "-title", "Renamed", "-version", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie := decodeTestMovie(t, out); movie.Version != 2 {
+		t.Errorf("got updated movie %+v", movie)
+	}
+
+	_, err = runMoviesCommand(t, app, buf, "get", "2")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("getting a missing movie: got %v, want ErrNotFound", err)
+	}
+
+	out, err = runMoviesCommand(t, app, buf, "list", "-sort", "-year", "-page-size", "1", "-all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var movies []*data.Movie
+	if err := json.Unmarshal(out, &movies); err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 2 || movies[0].ID != 1 || movies[1].ID != 2 {
+		t.Errorf("got movies %+v, want the movies of both pages", movies)
+	}
+
+	if _, err := runMoviesCommand(t, app, buf, "delete", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	received := requests()
+	want := []struct {
+		method string
+		url    string
+	}{
+		{http.MethodPost, "/v1/movies"},
+		{http.MethodPatch, "/v1/movies/1"},
+		{http.MethodPatch, "/v1/movies/1"},
+		{http.MethodGet, "/v1/movies/2"},
+		{http.MethodGet, "/v1/movies?page=1&page_size=1&sort=-year"},
+		{http.MethodGet, "/v1/movies?page=2&page_size=1&sort=-year"},
+		{http.MethodDelete, "/v1/movies/1"},
+	}
+	if len(received) != len(want) {
+		t.Fatalf("got %d requests, want %d: %+v", len(received), len(want), received)
+	}
+	for i, req := range received {
+		if req.method != want[i].method || req.url != want[i].url {
+			t.Errorf("request %d: got %s %s, want %s %s", i, req.method, req.url, want[i].method, want[i].url)
+		}
+		if req.authorization != "Bearer secret-token" {
+			t.Errorf("request %d: got Authorization %q, want the token", i, req.authorization)
+		}
+	}
+
+	// Updates only carry the fields set on the command line
+	if patch := received[2].body; len(patch) != 1 || patch["title"] != "Renamed" {
+		t.Errorf("got update body %v, want only the title", patch)
+	}
+	if received[2].expectedVersion != "1" {
+		t.Errorf("got X-Expected-Version %q, want 1", received[2].expectedVersion)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case "table", "json", "csv":
+		return &printer{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unsupported output format %q", format)
+}
+
+// Print value as indented JSON, or its rows as a table or CSV
+func (p *printer) print(value any, headers []string, rows [][]string) error {
+	switch p.format {
+	case "json":
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+
+	case "csv":
+		w := csv.NewWriter(p.w)
+		w.Write(headers)
+		w.WriteAll(rows)
+		return w.Error()
+
+	default:
+		w := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(headers, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	}
+}
+
+var movieHeaders = []string{"id", "title", "year", "runtime", "genres", "version"}
+
+func movieRow(movie *data.Movie) []string {
+	return []string{
+		fmt.Sprint(movie.ID),
+		movie.Title,
+		fmt.Sprint(movie.Year),
+		fmt.Sprint(int32(movie.Runtime)),
+		strings.Join(movie.Genres, ","),
+		fmt.Sprint(movie.Version),
+	}
+}
+
+func (p *printer) movies(movies []*data.Movie) error {
+	rows := make([][]string, 0, len(movies))
+	for _, movie := range movies {
+		rows = append(rows, movieRow(movie))
+	}
+	return p.print(movies, movieHeaders, rows)
+}
+
+func (p *printer) movie(movie *data.Movie) error {
+	return p.print(movie, movieHeaders, [][]string{movieRow(movie)})
+}
+
+var userHeaders = []string{"id", "name", "email", "role", "created_at"}
+
+func userRow(user *data.User) []string {
+	return []string{
+		fmt.Sprint(user.ID),
+		user.Name,
+		user.Email,
+		user.Role,
+		user.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+func (p *printer) users(users []*data.User) error {
+	rows := make([][]string, 0, len(users))
+	for _, user := range users {
+		rows = append(rows, userRow(user))
+	}
+	return p.print(users, userHeaders, rows)
+}
+
+var importHeaders = []string{"line", "status", "id", "details"}
+
+func (p *printer) importReport(report *data.ImportReport) error {
+	rows := make([][]string, 0, len(report.Rows))
+	for _, row := range report.Rows {
+		details := row.Reason
+		if len(row.Errors) > 0 {
+			details = validationError(row.Errors).Error()
+		}
+
+		id := ""
+		if row.ID != 0 {
+			id = fmt.Sprint(row.ID)
+		}
+
+		rows = append(rows, []string{fmt.Sprint(row.Line), row.Status, id, details})
+	}
+	return p.print(report, importHeaders, rows)
+}
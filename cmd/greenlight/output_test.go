@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+)
+
+var testMovies = []*data.Movie{
+	{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}, Version: 1},
+	{ID: 2, Title: "Arrival, the movie", Year: 2016, Runtime: 116, Genres: []string{"drama"}, Version: 3},
+}
+
+func TestPrintMovies(t *testing.T) {
+	tests := []struct {
+		format string
+		check  func(t *testing.T, output string)
+	}{
+		{
+			format: "table",
+			check: func(t *testing.T, output string) {
+				lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+				if len(lines) != 3 {
+					t.Fatalf("got %d lines, want a header and 2 rows:\n%s", len(lines), output)
+				}
+				if fields := strings.Fields(lines[0]); !reflect.DeepEqual(fields, []string{"ID", "TITLE", "YEAR", "RUNTIME", "GENRES", "VERSION"}) {
+					t.Errorf("got header %q", lines[0])
+				}
+				// Columns are aligned
+				if strings.Index(lines[1], "2016") != strings.Index(lines[2], "2016") {
+					t.Errorf("got misaligned rows:\n%s", output)
+				}
+				if fields := strings.Fields(lines[1]); !reflect.DeepEqual(fields, []string{"1", "Moana", "2016", "107", "animation,adventure", "1"}) {
+					t.Errorf("got row %q", lines[1])
+				}
+			},
+		},
+		{
+			format: "csv",
+			check: func(t *testing.T, output string) {
+				records, err := csv.NewReader(strings.NewReader(output)).ReadAll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := [][]string{
+					{"id", "title", "year", "runtime", "genres", "version"},
+					{"1", "Moana", "2016", "107", "animation,adventure", "1"},
+					{"2", "Arrival, the movie", "2016", "116", "drama", "3"},
+				}
+				if !reflect.DeepEqual(records, want) {
+					t.Errorf("got records %q, want %q", records, want)
+				}
+			},
+		},
+		{
+			format: "json",
+			check: func(t *testing.T, output string) {
+				var movies []*data.Movie
+				if err := json.Unmarshal([]byte(output), &movies); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(movies, testMovies) {
+					t.Errorf("got movies %+v, want %+v", movies, testMovies)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			p, err := newPrinter(&buf, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := p.movies(testMovies); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, buf.String())
+		})
+	}
+}
+
+func TestNewPrinterUnsupportedFormat(t *testing.T) {
+	if _, err := newPrinter(&bytes.Buffer{}, "yaml"); err == nil {
+		t.Error("got no error for an unsupported format")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testMigrationsPath = "../../migrations"
+
+// Create a CLI working on a new SQLite database, without any migration applied,
+// and the buffer its output is printed to
+func newTestCLI(t *testing.T, format string) (*cli, *bytes.Buffer) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	out, err := newPrinter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &cli{
+		config: config{
+			dsn:            filepath.Join(t.TempDir(), "greenlight.db"),
+			output:         format,
+			dbQueryTimeout: 3 * time.Second,
+		},
+		out: out,
+	}
+	t.Cleanup(app.close)
+
+	return app, &buf
+}
+
+// Create a CLI working on a new database with every migration applied
+func newMigratedTestCLI(t *testing.T, format string) (*cli, *bytes.Buffer) {
+	t.Helper()
+
+	app, buf := newTestCLI(t, format)
+	if err := app.migrateCommand([]string{"-path", testMigrationsPath, "up"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return app, buf
+}
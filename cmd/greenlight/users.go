@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"greenlight.flaviogalon.github.io/internal/data"
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Users are managed on the database only, the API has no endpoints for them
+func (app *cli) usersCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: greenlight users list|create|rotate-token|delete")
+	}
+
+	err := app.openDB()
+	if err != nil {
+		return err
+	}
+
+	subcommand, args := args[0], args[1:]
+
+	switch subcommand {
+	case "list":
+		users, err := app.models.Users.GetAll()
+		if err != nil {
+			return err
+		}
+		return app.out.users(users)
+
+	case "create":
+		user := &data.User{}
+
+		flags := flag.NewFlagSet("users create", flag.ExitOnError)
+		flags.StringVar(&user.Name, "name", "", "Name")
+		flags.StringVar(&user.Email, "email", "", "Email address")
+		flags.StringVar(&user.Role, "role", data.RoleUser, "Role (user|admin)")
+		flags.Parse(args)
+
+		v := validator.New()
+		if data.ValidateUser(v, user); !v.Valid() {
+			return validationError(v.Errors)
+		}
+
+		token, err := app.models.Users.Insert(user)
+		if err != nil {
+			return err
+		}
+
+		err = app.out.users([]*data.User{user})
+		if err != nil {
+			return err
+		}
+		printToken(token)
+		return nil
+
+	case "rotate-token":
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+
+		token, err := app.models.Users.RotateToken(id)
+		if err != nil {
+			return err
+		}
+		printToken(token)
+		return nil
+
+	case "delete":
+		id, err := parseID(args)
+		if err != nil {
+			return err
+		}
+		return app.models.Users.Delete(id)
+	}
+
+	return fmt.Errorf("unknown users command %q", subcommand)
+}
+
+// The token is printed to stderr so the regular output stays parseable
+func printToken(token string) {
+	fmt.Fprintf(os.Stderr, "API token (shown only once): %s\n", token)
+}
@@ -2,4 +2,4 @@ module greenlight.flaviogalon.github.io
 
 go 1.22.2
 
-require github.com/mattn/go-sqlite3 v1.14.22 // indirect
+require github.com/mattn/go-sqlite3 v1.14.22
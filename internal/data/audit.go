@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// Kinds of records changes are recorded for
+const (
+	AuditResourceMovie = "movie"
+)
+
+// Values accepted by the sort filter when listing audit events
+var AuditSortSafeList = []string{"id", "-id"}
+
+// A change to a record. Before and After hold its JSON representation, and are
+// left out when the record didn't exist before or doesn't exist anymore
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UserID     *int64          `json:"user_id,omitempty"` // nil for direct database changes
+	RequestID  string          `json:"request_id,omitempty"`
+	RemoteIP   string          `json:"remote_ip,omitempty"`
+	Action     string          `json:"action"`
+	Resource   string          `json:"resource"`
+	ResourceID int64           `json:"resource_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+}
+
+// Record a change made by actor to a resource, as part of the transaction q making
+// it. before and after are marshalled to JSON, nil values are stored as NULL
+func insertAuditEvent(
+	ctx context.Context,
+	q queryer,
+	actor Actor,
+	action, resource string,
+	resourceID int64,
+	before, after any,
+) error {
+	snapshots := make([]any, 2)
+	for i, value := range []any{before, after} {
+		if value == nil {
+			continue
+		}
+		js, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		snapshots[i] = string(js)
+	}
+
+	var userID *int64
+	if actor.UserID != 0 {
+		userID = &actor.UserID
+	}
+
+	query := `
+        INSERT INTO audit_events (user_id, request_id, remote_ip, action, resource, resource_id, before, after)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	args := []any{userID, actor.RequestID, actor.RemoteIP, action, resource, resourceID, snapshots[0], snapshots[1]}
+
+	_, err := q.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Which audit events GetAll returns, zero values match every event
+type AuditFilter struct {
+	UserID        int64
+	RequestID     string
+	Action        string
+	Resource      string
+	ResourceID    int64
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+func ValidateAuditFilter(v *validator.Validator, f AuditFilter) {
+	v.Check(f.UserID >= 0, "user_id", "must be greater than zero")
+	v.Check(f.ResourceID >= 0, "resource_id", "must be greater than zero")
+	v.Check(
+		f.Action == "" || validator.PermittedValue(f.Action, AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditPurge),
+		"action",
+		"must be one of create, update, delete, restore or purge",
+	)
+	v.Check(
+		f.CreatedAfter.IsZero() || f.CreatedBefore.IsZero() || f.CreatedAfter.Before(f.CreatedBefore),
+		"created_after",
+		"must be before created_before",
+	)
+}
+
+// Return the conditions of the WHERE clause selecting the filtered events, with the
+// arguments bound to their $N placeholders
+func (f AuditFilter) where() (string, []any) {
+	conditions := []string{"1 = 1"}
+	args := []any{}
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.UserID != 0 {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.RequestID != "" {
+		add("request_id = $%d", f.RequestID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.Resource != "" {
+		add("resource = $%d", f.Resource)
+	}
+	if f.ResourceID != 0 {
+		add("resource_id = $%d", f.ResourceID)
+	}
+	// created_at is stored as "YYYY-MM-DD HH:MM:SS" in UTC
+	if !f.CreatedAfter.IsZero() {
+		add("created_at >= $%d", f.CreatedAfter.UTC().Format(time.DateTime))
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at < $%d", f.CreatedBefore.UTC().Format(time.DateTime))
+	}
+
+	return strings.Join(conditions, "\n        AND "), args
+}
+
+type AuditModel struct {
+	DB *sql.DB
+	ModelsConfig
+}
+
+// Fetch a page of the audit events matching filter
+func (m AuditModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEvent, Metadata, error) {
+	where, args := filter.where()
+
+	// The sort column comes from a safelist, so it's fine to interpolate it
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), id, created_at, user_id, request_id, remote_ip, action, resource,
+            resource_id, before, after
+        FROM audit_events
+        WHERE %s
+        ORDER BY %s
+        LIMIT $%d OFFSET $%d`, where, filters.orderBy(""), len(args)+1, len(args)+2)
+
+	args = append(args, filters.limit(), filters.offset())
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var before, after sql.NullString
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.UserID,
+			&event.RequestID,
+			&event.RemoteIP,
+			&event.Action,
+			&event.Resource,
+			&event.ResourceID,
+			&before,
+			&after,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if before.Valid {
+			event.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			event.After = json.RawMessage(after.String)
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
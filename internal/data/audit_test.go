@@ -0,0 +1,111 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+var errTestRollback = errors.New("rolled back by the test")
+
+func getTestAuditEvents(t *testing.T, models Models, filter AuditFilter) []*AuditEvent {
+	t.Helper()
+
+	events, _, err := models.Audit.GetAll(filter, Filters{Page: 1, PageSize: 100, Sort: "id", SortSafeList: AuditSortSafeList})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return events
+}
+
+func TestAuditMovieChanges(t *testing.T) {
+	models := newTestModels(t)
+	movies := models.Movies.WithActor(Actor{RequestID: "request", RemoteIP: "192.0.2.1"})
+
+	movie := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	if err := movies.Insert(movie); err != nil {
+		t.Fatal(err)
+	}
+	movie.Title = "Moana 2"
+	if err := movies.Update(movie); err != nil {
+		t.Fatal(err)
+	}
+	if err := movies.Delete(movie.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	events := getTestAuditEvents(t, models, AuditFilter{})
+
+	want := []struct {
+		action string
+		before string
+		after  string
+	}{
+		{action: AuditCreate, after: "Moana"},
+		{action: AuditUpdate, before: "Moana", after: "Moana 2"},
+		{action: AuditDelete, before: "Moana 2", after: "Moana 2"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+
+	title := func(snapshot json.RawMessage) string {
+		t.Helper()
+
+		if snapshot == nil {
+			return ""
+		}
+		var movie Movie
+		if err := json.Unmarshal(snapshot, &movie); err != nil {
+			t.Fatal(err)
+		}
+		return movie.Title
+	}
+
+	for i, event := range events {
+		if event.Action != want[i].action || event.Resource != AuditResourceMovie || event.ResourceID != movie.ID {
+			t.Errorf("event %d: got %s of %s %d, want %s of movie %d", i, event.Action, event.Resource, event.ResourceID, want[i].action, movie.ID)
+		}
+		if got := title(event.Before); got != want[i].before {
+			t.Errorf("event %d: got %q before, want %q", i, got, want[i].before)
+		}
+		if got := title(event.After); got != want[i].after {
+			t.Errorf("event %d: got %q after, want %q", i, got, want[i].after)
+		}
+		if event.UserID != nil || event.RequestID != "request" || event.RemoteIP != "192.0.2.1" {
+			t.Errorf("event %d: got user %v, request %q and IP %q, want the actor", i, event.UserID, event.RequestID, event.RemoteIP)
+		}
+	}
+}
+
+func TestAuditRolledBack(t *testing.T) {
+	models := newTestModels(t)
+
+	movie := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	if err := models.Movies.Insert(movie); err != nil {
+		t.Fatal(err)
+	}
+
+	err := models.Movies.InTx(func(tx MovieTx) error {
+		if err := tx.Insert(&Movie{Title: "Arrival", Year: 2016, Runtime: 116, Genres: []string{"drama"}}); err != nil {
+			return err
+		}
+
+		movie.Title = "Moana 2"
+		if err := tx.Update(movie); err != nil {
+			return err
+		}
+
+		return errTestRollback
+	})
+	if !errors.Is(err, errTestRollback) {
+		t.Fatalf("got %v, want the error of the transaction", err)
+	}
+
+	// Only the insert before the transaction is left
+	events := getTestAuditEvents(t, models, AuditFilter{})
+	if len(events) != 1 || events[0].Action != AuditCreate || events[0].ResourceID != movie.ID {
+		t.Errorf("got %d events, want the create of movie %d only", len(events), movie.ID)
+	}
+}
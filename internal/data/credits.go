@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+	ErrUnknownPerson   = errors.New("unknown person")
+)
+
+const AuditResourceCredit = "credit"
+
+const (
+	CreditDirector = "director"
+	CreditActor    = "actor"
+	CreditWriter   = "writer"
+)
+
+// A person's part in a movie
+type Credit struct {
+	ID       int64  `json:"id"`
+	MovieID  int64  `json:"movie_id"`
+	PersonID int64  `json:"person_id" validate:"required,min=1"`
+	Name     string `json:"name"` // of the person, for display
+	Role     string `json:"role" validate:"required,oneof=director actor writer"`
+	// Character played, only for actors
+	Character *string `json:"character,omitempty" validate:"min=1,max=500"`
+	Position  int     `json:"position"` // billing order within the role
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Struct(credit)
+
+	v.Check(credit.Character == nil || credit.Role == CreditActor, "character", "must only be provided for actors")
+}
+
+// Columns read by scanCredit, in order. Queries must join people
+const creditColumns = `
+        movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+        movie_credits.role, movie_credits.character, movie_credits.position`
+
+// Directors first, then writers and actors, each in billing order
+const creditOrder = `
+        CASE movie_credits.role WHEN 'director' THEN 0 WHEN 'writer' THEN 1 ELSE 2 END,
+        movie_credits.position, movie_credits.id`
+
+func scanCredit(row rowScanner) (*Credit, error) {
+	var credit Credit
+
+	err := row.Scan(
+		&credit.ID,
+		&credit.MovieID,
+		&credit.PersonID,
+		&credit.Name,
+		&credit.Role,
+		&credit.Character,
+		&credit.Position,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credit, nil
+}
+
+type CreditModel struct {
+	DB *sql.DB
+	ModelsConfig
+	actor Actor
+}
+
+// Return a model recording actor as the author of the changes it makes
+func (m CreditModel) WithActor(actor Actor) CreditModel {
+	m.actor = actor
+	return m
+}
+
+// Fetch the credits of a movie
+func (m CreditModel) GetForMovie(movieID int64) ([]*Credit, error) {
+	credits, err := m.GetForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+
+	if credits[movieID] == nil {
+		return []*Credit{}, nil
+	}
+
+	return credits[movieID], nil
+}
+
+// Fetch the credits of several movies at once, keyed by movie id. Movies without
+// credits are left out of the map
+func (m CreditModel) GetForMovies(movieIDs []int64) (map[int64][]*Credit, error) {
+	ids, err := json.Marshal(movieIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        SELECT ` + creditColumns + `
+        FROM movie_credits
+        JOIN people ON people.id = movie_credits.person_id
+        WHERE movie_credits.movie_id IN (SELECT value FROM json_each($1))
+        ORDER BY ` + creditOrder
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	credits := map[int64][]*Credit{}
+	for rows.Next() {
+		credit, err := scanCredit(rows)
+		if err != nil {
+			return nil, err
+		}
+		credits[credit.MovieID] = append(credits[credit.MovieID], credit)
+	}
+
+	return credits, rows.Err()
+}
+
+// Credit a person in a movie, after the people already credited in the same role.
+// It fails with ErrUnknownPerson if the person doesn't exist
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `
+        INSERT INTO movie_credits (movie_id, person_id, role, character, position)
+        VALUES ($1, $2, $3, $4, (
+            SELECT IFNULL(MAX(position) + 1, 0) FROM movie_credits WHERE movie_id = $1 AND role = $3
+        ))
+        RETURNING id, position`
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		person, err := getPerson(ctx, tx, credit.PersonID)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return ErrUnknownPerson
+			}
+			return err
+		}
+
+		credit.Name = person.Name
+
+		args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.Position)
+		if err != nil {
+			switch {
+			case strings.Contains(err.Error(), "UNIQUE constraint failed"):
+				return ErrDuplicateCredit
+			default:
+				return err
+			}
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditCreate, AuditResourceCredit, credit.ID, nil, credit)
+	})
+}
+
+// Remove a credit from a movie
+func (m CreditModel) Delete(movieID, id int64) error {
+	query := `
+        SELECT ` + creditColumns + `
+        FROM movie_credits
+        JOIN people ON people.id = movie_credits.person_id
+        WHERE movie_credits.id = $1 AND movie_credits.movie_id = $2`
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := scanCredit(tx.QueryRowContext(ctx, query, id, movieID))
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditDelete, AuditResourceCredit, id, before, nil)
+	})
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Kinds of changes to movies recorded in the change log
+const (
+	MovieEventCreated  = "created"
+	MovieEventUpdated  = "updated"
+	MovieEventDeleted  = "deleted" // moved to the trash
+	MovieEventRestored = "restored"
+	MovieEventPurged   = "purged"
+)
+
+// A change to a movie, identified by the version it left the movie at
+type MovieEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Type      string    `json:"type"`
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+}
+
+// Record a change to a movie in the change log, and queue its delivery to webhooks,
+// as part of the transaction q making it
+func insertMovieEvent(ctx context.Context, q queryer, eventType string, movie *Movie) error {
+	query := `
+        INSERT INTO movie_events (type, movie_id, version)
+        VALUES ($1, $2, $3)
+        RETURNING id`
+
+	var id int64
+	err := q.QueryRowContext(ctx, query, eventType, movie.ID, movie.Version).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	return insertWebhookDeliveries(ctx, q, id, "movie."+eventType)
+}
+
+// Fetch up to limit events of the change log following the one with the id after,
+// oldest first
+func (m MovieModel) Events(after int64, limit int) ([]*MovieEvent, error) {
+	query := `
+        SELECT id, created_at, type, movie_id, version
+        FROM movie_events
+        WHERE id > $1
+        ORDER BY id
+        LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*MovieEvent{}
+	for rows.Next() {
+		var event MovieEvent
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Type, &event.MovieID, &event.Version)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
+
+// Return the id of the latest event of the change log, 0 if it's empty
+func (m MovieModel) LatestEventID() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, `SELECT IFNULL(MAX(id), 0) FROM movie_events`).Scan(&id)
+	return id, err
+}
+
+// Return the id of the oldest event still in the change log, 0 if it's empty
+func (m MovieModel) OldestEventID() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, `SELECT IFNULL(MIN(id), 0) FROM movie_events`).Scan(&id)
+	return id, err
+}
+
+// Delete the events of the change log older than retention, returning how many
+// were deleted. The latest event is always kept, so the position of the log is
+// known even once every change in it is old, and so are the events still pending
+// delivery to a webhook
+func (m MovieModel) PruneEvents(retention time.Duration) (int64, error) {
+	query := `
+        DELETE FROM movie_events
+        WHERE created_at < datetime('now', $1)
+        AND id < (SELECT MAX(id) FROM movie_events)
+        AND id NOT IN (SELECT event_id FROM webhook_deliveries WHERE status = 'pending')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, sqliteInterval(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Return a channel closed once the next transaction changing movies through the
+// models is committed. Changes made by other processes, e.g. the CLI, aren't
+// notified, so waiters should also check the change log every now and then
+func (m MovieModel) Changed() <-chan struct{} {
+	return m.changes.wait()
+}
+
+// Broadcasts to the goroutines waiting for it that movies changed
+type changeNotifier struct {
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{changed: make(chan struct{})}
+}
+
+func (n *changeNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.changed
+}
+
+func (n *changeNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
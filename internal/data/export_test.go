@@ -0,0 +1,61 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExportPages(t *testing.T) {
+	models := newTestModels(t)
+
+	// More than two pages, with ties on the sort keys
+	var input strings.Builder
+	for i := range 2*exportPageSize + 10 {
+		fmt.Fprintf(&input, `{"title":"Movie %d","year":%d,"runtime":"%d mins","genres":["drama"]}`+"\n", i/3, 1990+i%30, 90+i%40)
+	}
+
+	report, err := models.Movies.Import(strings.NewReader(input.String()), ImportFormatNDJSON, exportPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 2*exportPageSize+10 {
+		t.Fatalf("imported %d movies", report.Created)
+	}
+
+	// Some movies are rated, the others have a NULL rating
+	_, err = models.Movies.DB.Exec(`UPDATE movies SET rating_avg = id % 5 + 0.5 WHERE id % 3 = 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sort := range []string{"id", "-year,title", "title,-runtime", "-rating,year", "rating"} {
+		t.Run(sort, func(t *testing.T) {
+			filters := Filters{Sort: sort, SortSafeList: MovieSortSafeList, Page: 1, PageSize: 10_000}
+
+			want, _, err := models.Movies.GetAll(MovieFilter{}, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int64
+			err = models.Movies.Export(context.Background(), MovieFilter{}, filters, func(movie *Movie) error {
+				got = append(got, movie.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(want) {
+				t.Fatalf("exported %d movies, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i].ID {
+					t.Fatalf("movie %d: got id %d, want %d", i, got[i], want[i].ID)
+				}
+			}
+		})
+	}
+}
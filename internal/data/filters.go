@@ -1,12 +1,47 @@
 package data
 
-import "greenlight.flaviogalon.github.io/internal/validator"
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
 
 type Filters struct {
-	Page         int
-	PageSize     int
+	Page     int
+	PageSize int
+	// Comma-separated sort values from SortSafeList, e.g. -year,title. Records are
+	// sorted by the first one, then the next ones to break ties
 	Sort         string
 	SortSafeList []string
+	// Columns of the sort values and condition fields that aren't named after one,
+	// e.g. rating_avg for rating
+	SortColumns map[string]string
+	// Comparisons records must all satisfy, on the fields of ConditionSafeList
+	Conditions        []Condition
+	ConditionSafeList []string
+}
+
+// Most values a sort can have
+const MaxSortKeys = 5
+
+// A comparison of a field of the records to a value, e.g. year_gte=2000 is
+// {Field: "year", Operator: "gte", Value: 2000}
+type Condition struct {
+	Field    string
+	Operator string // a key of ConditionOperators
+	Value    any
+}
+
+// SQL operators of the operators conditions accept
+var ConditionOperators = map[string]string{
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -15,5 +50,198 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	ValidateSort(v, f)
+	ValidateConditions(v, f)
+}
+
+// Validate the conditions of f, for listings that aren't paginated
+func ValidateConditions(v *validator.Validator, f Filters) {
+	for _, condition := range f.Conditions {
+		_, ok := ConditionOperators[condition.Operator]
+		v.Check(
+			ok && validator.PermittedValue(condition.Field, f.ConditionSafeList...),
+			condition.Field+"_"+condition.Operator,
+			"unsupported filter",
+		)
+	}
+}
+
+// Validate the sort of f, for listings that aren't paginated
+func ValidateSort(v *validator.Validator, f Filters) {
+	keys := f.sortKeys()
+
+	v.Check(len(keys) <= MaxSortKeys, "sort", fmt.Sprintf("must have a maximum of %d values", MaxSortKeys))
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		v.Check(validator.PermittedValue(key, f.SortSafeList...), "sort", "invalid sort value")
+
+		field := strings.TrimPrefix(key, "-")
+		v.Check(!seen[field], "sort", "must not repeat a value")
+		seen[field] = true
+	}
+}
+
+func (f Filters) sortKeys() []string {
+	return strings.Split(f.Sort, ",")
+}
+
+// Return the column a sort value or condition field is stored in
+func (f Filters) column(field string) string {
+	if column, ok := f.SortColumns[field]; ok {
+		return column
+	}
+	return field
+}
+
+// Return the terms of the ORDER BY clause, e.g. "year DESC, title ASC", qualifying
+// columns with table if it isn't empty. It panics if a sort value isn't a safe one
+func (f Filters) orderBy(table string) string {
+	if table != "" {
+		table += "."
+	}
+
+	keys := f.sortKeys()
+	terms := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		if !slices.Contains(f.SortSafeList, key) {
+			panic("unsafe sort parameter: " + key)
+		}
+
+		direction := "ASC"
+		if strings.HasPrefix(key, "-") {
+			direction = "DESC"
+		}
+
+		terms = append(terms, table+f.column(strings.TrimPrefix(key, "-"))+" "+direction)
+	}
+
+	return strings.Join(terms, ", ")
+}
+
+// Return the conditions as terms of a WHERE clause, with their values bound to
+// placeholders numbered from after the given arguments. It panics if a condition
+// isn't a safe one
+func (f Filters) where(args []any) ([]string, []any) {
+	terms := make([]string, 0, len(f.Conditions))
+
+	for _, condition := range f.Conditions {
+		operator, ok := ConditionOperators[condition.Operator]
+		if !ok || !slices.Contains(f.ConditionSafeList, condition.Field) {
+			panic("unsafe filter: " + condition.Field + "_" + condition.Operator)
+		}
+
+		value := condition.Value
+		// Timestamps are stored as "YYYY-MM-DD HH:MM:SS" in UTC
+		if t, ok := value.(time.Time); ok {
+			value = t.UTC().Format(time.DateTime)
+		}
+
+		args = append(args, value)
+		terms = append(terms, fmt.Sprintf("%s %s $%d", f.column(condition.Field), operator, len(args)))
+	}
+
+	return terms, args
+}
+
+// Return the columns of the sort keys followed by id, which breaks ties
+func (f Filters) keysetColumns() []string {
+	keys := f.sortKeys()
+	columns := make([]string, 0, len(keys)+1)
+
+	for _, key := range keys {
+		columns = append(columns, f.column(strings.TrimPrefix(key, "-")))
+	}
+
+	return append(columns, "id")
+}
+
+// Return a WHERE term matching the records sorted after the one with the given
+// values of keysetColumns, in the order of orderBy followed by id ASC, so pages
+// can be read without an offset. Values are bound to placeholders numbered from
+// after the given arguments. NULLs sort first in ascending order, as in SQLite
+func (f Filters) after(values []any, args []any) (string, []any) {
+	columns := f.keysetColumns()
+	descending := make([]bool, len(columns))
+	for i, key := range f.sortKeys() {
+		descending[i] = strings.HasPrefix(key, "-")
+	}
+
+	// Each value is bound once, the first time it's used, so placeholders appear
+	// in the order of the arguments
+	placeholders := make([]string, len(values))
+	placeholder := func(i int) string {
+		if placeholders[i] == "" {
+			args = append(args, values[i])
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		return placeholders[i]
+	}
+
+	terms := make([]string, 0, len(columns))
+
+	for i, column := range columns {
+		var equal []string
+		for j := range i {
+			if values[j] == nil {
+				equal = append(equal, columns[j]+" IS NULL")
+			} else {
+				equal = append(equal, columns[j]+" = "+placeholder(j))
+			}
+		}
+
+		var next string
+		switch {
+		case values[i] == nil && descending[i]:
+			// NULLs come last, nothing sorts after them on this column
+			continue
+		case values[i] == nil:
+			next = column + " IS NOT NULL"
+		case descending[i]:
+			next = fmt.Sprintf("(%s < %s OR %s IS NULL)", column, placeholder(i), column)
+		default:
+			next = column + " > " + placeholder(i)
+		}
+
+		terms = append(terms, "("+strings.Join(append(equal, next), " AND ")+")")
+	}
+
+	if len(terms) == 0 {
+		return "FALSE", args
+	}
+
+	return "(" + strings.Join(terms, "\n            OR ") + ")", args
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Pagination information returned along with a page of records
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// Calculate the pagination metadata given the total number of records
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
 }
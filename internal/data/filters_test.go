@@ -0,0 +1,162 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFiltersOrderBy(t *testing.T) {
+	filters := Filters{
+		SortSafeList: []string{"title", "year", "rating", "-title", "-year", "-rating"},
+		SortColumns:  map[string]string{"rating": "rating_avg"},
+	}
+
+	tests := []struct {
+		sort  string
+		table string
+		want  string
+	}{
+		{sort: "title", want: "title ASC"},
+		{sort: "-year", want: "year DESC"},
+		{sort: "-year,title", want: "year DESC, title ASC"},
+		{sort: "rating,-title", table: "m", want: "m.rating_avg ASC, m.title DESC"},
+	}
+
+	for _, tt := range tests {
+		filters.Sort = tt.sort
+		if got := filters.orderBy(tt.table); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic for an unsafe sort")
+		}
+	}()
+	filters.Sort = "title,id; DROP TABLE movies"
+	filters.orderBy("")
+}
+
+func TestFiltersWhere(t *testing.T) {
+	filters := Filters{
+		SortColumns:       map[string]string{"rating": "rating_avg"},
+		ConditionSafeList: []string{"year", "rating", "created_at"},
+		Conditions: []Condition{
+			{Field: "year", Operator: "gte", Value: 2000},
+			{Field: "rating", Operator: "lt", Value: 7.5},
+			{Field: "created_at", Operator: "gt", Value: time.Date(2024, 1, 2, 4, 4, 5, 0, time.FixedZone("", 3600))},
+		},
+	}
+
+	// Placeholders are numbered after the arguments given
+	terms, args := filters.where([]any{"Moana"})
+
+	wantTerms := []string{"year >= $2", "rating_avg < $3", "created_at > $4"}
+	if !reflect.DeepEqual(terms, wantTerms) {
+		t.Errorf("got terms %q, want %q", terms, wantTerms)
+	}
+	wantArgs := []any{"Moana", 2000, 7.5, "2024-01-02 03:04:05"}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("got args %v, want %v", args, wantArgs)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic for an unsafe condition")
+		}
+	}()
+	filters.Conditions = []Condition{{Field: "title", Operator: "gt", Value: "M"}}
+	filters.where(nil)
+}
+
+// Every record sorted after each one is found from its keyset values, whatever
+// the direction of the sort and the NULLs of its columns
+func TestFiltersAfter(t *testing.T) {
+	db := openTestDB(t)
+
+	_, err := db.Exec(`
+        CREATE TABLE records (id INTEGER PRIMARY KEY, year INTEGER, rating REAL, title TEXT NOT NULL);
+        INSERT INTO records (year, rating, title) VALUES
+            (2016, 7.5, 'Moana'),
+            (2016, NULL, 'Arrival'),
+            (NULL, 8.0, 'Heat'),
+            (2010, 8.0, 'Inception'),
+            (NULL, NULL, 'Alien'),
+            (2016, 7.5, 'Moana'),
+            (2010, NULL, 'Inception')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filters := Filters{
+		SortSafeList: []string{"year", "rating", "title", "-year", "-rating", "-title"},
+	}
+
+	sorts := []string{
+		"year",
+		"-year",
+		"rating",
+		"-rating",
+		"year,rating",
+		"year,-rating",
+		"-year,rating",
+		"-year,-rating",
+		"-rating,title",
+		"rating,-year,title",
+		"-title,-year,-rating",
+	}
+
+	for _, sort := range sorts {
+		t.Run(sort, func(t *testing.T) {
+			filters.Sort = sort
+			columns := strings.Join(filters.keysetColumns(), ", ")
+
+			query := func(where string, args []any) ([]int64, [][]any) {
+				t.Helper()
+
+				rows, err := db.Query(fmt.Sprintf(`
+                    SELECT %s FROM records WHERE id > $1 AND %s ORDER BY %s, id ASC`,
+					columns, where, filters.orderBy("")), args...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer rows.Close()
+
+				var ids []int64
+				var keys [][]any
+				for rows.Next() {
+					values := make([]any, len(filters.keysetColumns()))
+					dest := make([]any, len(values))
+					for i := range values {
+						dest[i] = &values[i]
+					}
+					if err := rows.Scan(dest...); err != nil {
+						t.Fatal(err)
+					}
+					ids = append(ids, values[len(values)-1].(int64))
+					keys = append(keys, values)
+				}
+				if err := rows.Err(); err != nil {
+					t.Fatal(err)
+				}
+				return ids, keys
+			}
+
+			ids, keys := query("TRUE", []any{0})
+
+			for i, values := range keys {
+				after, args := filters.after(values, []any{0})
+
+				got, _ := query(after, args)
+				if want := ids[i+1:]; !slices.Equal(got, want) {
+					t.Errorf("after %v: got records %v, want %v", values, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+const AuditResourceGenre = "genre"
+
+type Genre struct {
+	ID      int64    `json:"id"`
+	Slug    string   `json:"slug" validate:"required,max=50"`
+	Name    string   `json:"name" validate:"required,max=100"`
+	Aliases []string `json:"aliases" validate:"max=20,unique"`
+	// Number of movies with the genre, movies in the trash aside
+	MovieCount int `json:"movie_count"`
+}
+
+// Normalize a genre into a slug: lowercase letters and digits separated by dashes
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+
+	return b.String()
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Struct(genre)
+
+	v.Check(Slugify(genre.Slug) == genre.Slug, "slug", "must only contain lowercase letters, digits and dashes")
+	for _, alias := range genre.Aliases {
+		v.Check(Slugify(alias) == alias, "aliases", "must only contain lowercase letters, digits and dashes")
+		v.Check(alias != genre.Slug, "aliases", "must not contain the slug")
+	}
+}
+
+// Canonical slugs of the genres, keyed by their slugs and aliases
+type GenreVocabulary map[string]string
+
+// Return the canonical slug of a genre given by name, slug or alias
+func (g GenreVocabulary) Canonical(genre string) (string, bool) {
+	slug, ok := g[Slugify(genre)]
+	return slug, ok
+}
+
+// Map the genres of a movie to their canonical slugs, dropping genres that turn out
+// to be the same one. Unknown genres are reported on the validator
+func (g GenreVocabulary) canonicalize(v *validator.Validator, movie *Movie) {
+	genres := make([]string, 0, len(movie.Genres))
+	seen := map[string]bool{}
+
+	for _, genre := range movie.Genres {
+		slug, ok := g.Canonical(genre)
+		if !ok {
+			v.AddError("genres", fmt.Sprintf("unknown genre %q", genre))
+			continue
+		}
+		if !seen[slug] {
+			seen[slug] = true
+			genres = append(genres, slug)
+		}
+	}
+
+	movie.Genres = genres
+}
+
+type GenreModel struct {
+	DB *sql.DB
+	ModelsConfig
+	actor Actor
+}
+
+// Return a model recording actor as the author of the changes it makes
+func (m GenreModel) WithActor(actor Actor) GenreModel {
+	m.actor = actor
+	return m
+}
+
+// Load the vocabulary movie genres are validated against
+func (m GenreModel) Vocabulary() (GenreVocabulary, error) {
+	query := `
+        SELECT slug, slug FROM genres
+        UNION ALL
+        SELECT genre_aliases.alias, genres.slug
+        FROM genre_aliases
+        JOIN genres ON genres.id = genre_aliases.genre_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	vocabulary := GenreVocabulary{}
+	for rows.Next() {
+		var key, slug string
+		if err := rows.Scan(&key, &slug); err != nil {
+			return nil, err
+		}
+		vocabulary[key] = slug
+	}
+
+	return vocabulary, rows.Err()
+}
+
+const genreColumns = `
+        genres.id, genres.slug, genres.name,
+        (SELECT json_group_array(alias) FROM genre_aliases WHERE genre_id = genres.id),
+        (SELECT COUNT(*) FROM movie_genres
+            JOIN movies ON movies.id = movie_genres.movie_id
+            WHERE movie_genres.genre_id = genres.id AND movies.deleted_at IS NULL)`
+
+func scanGenre(row rowScanner) (*Genre, error) {
+	var genre Genre
+	var aliasesJSON string
+
+	err := row.Scan(&genre.ID, &genre.Slug, &genre.Name, &aliasesJSON, &genre.MovieCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(aliasesJSON), &genre.Aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	return &genre, nil
+}
+
+// Fetch every genre, sorted by slug
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `SELECT ` + genreColumns + ` FROM genres ORDER BY slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		genre, err := scanGenre(rows)
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, genre)
+	}
+
+	return genres, rows.Err()
+}
+
+// Fetch a specific genre
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	return getGenre(ctx, m.DB, id)
+}
+
+func getGenre(ctx context.Context, q queryer, id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + genreColumns + ` FROM genres WHERE id = $1`
+
+	genre, err := scanGenre(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return genre, nil
+}
+
+// Fail with ErrDuplicateGenre if the slug or aliases of a genre are used by another one
+func checkGenreNames(ctx context.Context, q queryer, genre *Genre) error {
+	names, err := json.Marshal(append([]string{genre.Slug}, genre.Aliases...))
+	if err != nil {
+		return err
+	}
+
+	query := `
+        SELECT EXISTS (SELECT 1 FROM genres WHERE slug IN (SELECT value FROM json_each($1)) AND id != $2)
+            OR EXISTS (SELECT 1 FROM genre_aliases WHERE alias IN (SELECT value FROM json_each($1)) AND genre_id != $2)`
+
+	var exists bool
+	err = q.QueryRowContext(ctx, query, names, genre.ID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrDuplicateGenre
+	}
+
+	return nil
+}
+
+// Replace the aliases of a genre
+func setGenreAliases(ctx context.Context, q queryer, genre *Genre) error {
+	_, err := q.ExecContext(ctx, `DELETE FROM genre_aliases WHERE genre_id = $1`, genre.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range genre.Aliases {
+		_, err = q.ExecContext(ctx, `INSERT INTO genre_aliases (alias, genre_id) VALUES ($1, $2)`, alias, genre.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Add a genre to the vocabulary
+func (m GenreModel) Insert(genre *Genre) error {
+	if genre.Aliases == nil {
+		genre.Aliases = []string{}
+	}
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		err := checkGenreNames(ctx, tx, genre)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `INSERT INTO genres (slug, name) VALUES ($1, $2) RETURNING id`, genre.Slug, genre.Name).
+			Scan(&genre.ID)
+		if err != nil {
+			return err
+		}
+
+		err = setGenreAliases(ctx, tx, genre)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditCreate, AuditResourceGenre, genre.ID, nil, genre)
+	})
+}
+
+// Update the name and aliases of a genre. Its slug is what movies refer to, so it
+// can't be changed
+func (m GenreModel) Update(genre *Genre) error {
+	if genre.Aliases == nil {
+		genre.Aliases = []string{}
+	}
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getGenre(ctx, tx, genre.ID)
+		if err != nil {
+			return err
+		}
+
+		genre.Slug = before.Slug
+		genre.MovieCount = before.MovieCount
+
+		err = checkGenreNames(ctx, tx, genre)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE genres SET name = $1 WHERE id = $2`, genre.Name, genre.ID)
+		if err != nil {
+			return err
+		}
+
+		err = setGenreAliases(ctx, tx, genre)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditUpdate, AuditResourceGenre, genre.ID, before, genre)
+	})
+}
+
+// Remove a genre from the vocabulary. It fails with ErrGenreInUse while movies,
+// including the ones in the trash, have it
+func (m GenreModel) Delete(id int64) error {
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getGenre(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		var inUse bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_genres WHERE genre_id = $1)`, id).
+			Scan(&inUse)
+		if err != nil {
+			return err
+		}
+
+		if inUse {
+			return ErrGenreInUse
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM genre_aliases WHERE genre_id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditDelete, AuditResourceGenre, id, before, nil)
+	})
+}
+
+// Replace the rows of movie_genres of a movie with its genres, which must be
+// canonical slugs as left by ValidateMovie
+func setMovieGenres(ctx context.Context, q queryer, movie *Movie) error {
+	_, err := q.ExecContext(ctx, `DELETE FROM movie_genres WHERE movie_id = $1`, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO movie_genres (movie_id, genre_id, position)
+        SELECT $1, id, $2 FROM genres WHERE slug = $3`
+
+	for position, slug := range movie.Genres {
+		result, err := q.ExecContext(ctx, query, movie.ID, position, slug)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if inserted == 0 {
+			return fmt.Errorf("unknown genre %q, movies must be validated with ValidateMovie", slug)
+		}
+	}
+
+	return nil
+}
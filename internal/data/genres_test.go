@@ -0,0 +1,142 @@
+package data
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Drama":              "drama",
+		"Science Fiction":    "science-fiction",
+		"  Film   Noir  ":    "film-noir",
+		"Action & Adventure": "action-adventure",
+		"Sci-Fi":             "sci-fi",
+		"--reality_tv--":     "reality-tv",
+		"R&B":                "r-b",
+		"Rock ’n’ Roll":      "rock-n-roll",
+		"Comédie Française":  "comédie-française",
+		"ÉPOQUE":             "époque",
+		"1980s":              "1980s",
+		"!!!":                "",
+	}
+
+	for input, want := range tests {
+		if got := Slugify(input); got != want {
+			t.Errorf("Slugify(%q): got %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestValidateMovieCanonicalGenres(t *testing.T) {
+	models := newTestModels(t)
+
+	genres, err := models.Genres.Vocabulary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movie := &Movie{
+		Title:   "Arrival",
+		Year:    2016,
+		Runtime: 116,
+		// An alias, the slug it maps to in another form, and a plain genre
+		Genres: []string{"Sci-Fi", "Science Fiction", "DRAMA"},
+	}
+
+	v := validator.New()
+	if ValidateMovie(v, movie, genres); !v.Valid() {
+		t.Fatalf("got errors %v", v.Errors)
+	}
+	if want := []string{"science-fiction", "drama"}; !reflect.DeepEqual(movie.Genres, want) {
+		t.Errorf("got genres %v, want %v", movie.Genres, want)
+	}
+
+	movie.Genres = []string{"drama", "no such genre"}
+
+	v = validator.New()
+	if ValidateMovie(v, movie, genres); !strings.Contains(v.Errors["genres"], "no such genre") {
+		t.Errorf("got errors %v, want the unknown genre reported", v.Errors)
+	}
+}
+
+// Free-text genres of movies created before the vocabulary existed get the slugs
+// Slugify would give them
+func TestGenresMigration(t *testing.T) {
+	db := openTestDB(t)
+
+	scripts := testMigrations(t)
+	split := 0
+	for split < len(scripts) && filepath.Base(scripts[split]) < "000007" {
+		split++
+	}
+	applyTestMigrations(t, db, scripts[:split])
+
+	tests := []struct {
+		genres []string
+		want   []string
+	}{
+		{[]string{"Action & Adventure", "action"}, []string{"action-adventure", "action"}},
+		{[]string{"Sci-Fi", "  Film   Noir "}, []string{"science-fiction", "film-noir"}},
+		{[]string{"Rock ’n’ Roll", "ÉPOQUE"}, []string{"rock-n-roll", "époque"}},
+		// Genres without a slug are dropped
+		{[]string{"Comédie Française", "!!!"}, []string{"comédie-française"}},
+		{[]string{"R&B", "r-b"}, []string{"r-b"}},
+	}
+	for _, tt := range tests {
+		js, err := json.Marshal(tt.genres)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec("INSERT INTO movies (title, year, runtime, genres) VALUES ('Movie', 2000, 90, $1)", string(js))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	applyTestMigrations(t, db, scripts[split:])
+
+	vocabulary, err := NewModels(db, 3*time.Second).Genres.Vocabulary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT genres FROM movies ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	for i := 0; rows.Next(); i++ {
+		var js string
+		if err := rows.Scan(&js); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		if err := json.Unmarshal([]byte(js), &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, tests[i].want) {
+			t.Errorf("movie %d: got genres %v, want %v", i+1, got, tests[i].want)
+		}
+
+		// The API finds the same genres in the vocabulary
+		for _, genre := range tests[i].genres {
+			if slug, ok := vocabulary.Canonical(genre); Slugify(genre) != "" && (!ok || !slices.Contains(got, slug)) {
+				t.Errorf("movie %d: genre %q maps to %q, which the movie doesn't have", i+1, genre, slug)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
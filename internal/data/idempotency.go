@@ -0,0 +1,139 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyInFlight = errors.New("idempotency key in flight")
+	ErrIdempotencyKeyReused   = errors.New("idempotency key reused for another request")
+)
+
+// Response recorded for an idempotency key
+type IdempotentResponse struct {
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+	ModelsConfig
+}
+
+// Claim the idempotency key of a user for the request identified by fingerprint.
+// It returns the response recorded for the key if the request was handled already,
+// else nil and the caller must handle it, then Complete or Release the key. The
+// key is held for lockTimeout, and considered abandoned after that unless Extend
+// is called, e.g. because the server stopped while handling the request
+func (m IdempotencyModel) Begin(userID int64, key, fingerprint string, lockTimeout time.Duration) (*IdempotentResponse, error) {
+	var response *IdempotentResponse
+
+	err := inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < datetime('now')`)
+		if err != nil {
+			return err
+		}
+
+		query := `
+            INSERT INTO idempotency_keys (user_id, idempotency_key, fingerprint, expires_at)
+            VALUES ($1, $2, $3, datetime('now', $4))
+            ON CONFLICT DO NOTHING`
+
+		result, err := tx.ExecContext(ctx, query, userID, key, fingerprint, sqliteInterval(lockTimeout))
+		if err != nil {
+			return err
+		}
+
+		claimed, err := result.RowsAffected()
+		if err != nil || claimed == 1 {
+			return err
+		}
+
+		query = `
+            SELECT fingerprint, status, header, body
+            FROM idempotency_keys
+            WHERE user_id = $1 AND idempotency_key = $2`
+
+		var storedFingerprint string
+		var status sql.NullInt64
+		var header sql.NullString
+		var body []byte
+
+		err = tx.QueryRowContext(ctx, query, userID, key).Scan(&storedFingerprint, &status, &header, &body)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case storedFingerprint != fingerprint:
+			return ErrIdempotencyKeyReused
+		case !status.Valid:
+			return ErrIdempotencyKeyInFlight
+		}
+
+		response = &IdempotentResponse{Status: int(status.Int64), Body: body}
+		return json.Unmarshal([]byte(header.String), &response.Header)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Hold the idempotency key of a request still being handled for another lockTimeout.
+// Keys whose response was recorded are left alone
+func (m IdempotencyModel) Extend(userID int64, key string, lockTimeout time.Duration) error {
+	query := `
+        UPDATE idempotency_keys
+        SET expires_at = datetime('now', $1)
+        WHERE user_id = $2 AND idempotency_key = $3 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, sqliteInterval(lockTimeout), userID, key)
+	return err
+}
+
+// Record the response to the request holding an idempotency key, replayed for the
+// retries of the request until ttl elapsed
+func (m IdempotencyModel) Complete(userID int64, key string, response *IdempotentResponse, ttl time.Duration) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE idempotency_keys
+        SET status = $1, header = $2, body = $3, expires_at = datetime('now', $4)
+        WHERE user_id = $5 AND idempotency_key = $6`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, response.Status, header, response.Body, sqliteInterval(ttl), userID, key)
+	return err
+}
+
+// Free an idempotency key whose request failed, so it can be retried
+func (m IdempotencyModel) Release(userID int64, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// Format a duration as an SQLite datetime modifier, e.g. "+60 seconds"
+func sqliteInterval(d time.Duration) string {
+	return fmt.Sprintf("%+d seconds", int64(d.Seconds()))
+}
@@ -0,0 +1,490 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+// Supported import formats
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+	ImportFormatIMDb   = "imdb" // title.basics.tsv from https://datasets.imdbws.com
+)
+
+var (
+	ErrUnsupportedImportFormat = errors.New("unsupported import format")
+	// Wraps errors reading the input, as opposed to database errors
+	ErrInvalidImportInput = errors.New("invalid import input")
+)
+
+// Status of a single row of an import
+const (
+	ImportCreated = "created"
+	ImportSkipped = "skipped"
+	ImportInvalid = "invalid"
+)
+
+type ImportRow struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Reason string            `json:"reason,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type ImportReport struct {
+	Created int         `json:"created"`
+	Skipped int         `json:"skipped"`
+	Invalid int         `json:"invalid"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+func (r *ImportReport) add(row ImportRow) {
+	switch row.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportSkipped:
+		r.Skipped++
+	case ImportInvalid:
+		r.Invalid++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// Rows are reported as soon as they are rejected but only once their batch is
+// committed when valid, so they have to be put back in input order
+func (r *ImportReport) sortRows() {
+	sort.SliceStable(r.Rows, func(i, j int) bool { return r.Rows[i].Line < r.Rows[j].Line })
+}
+
+// A parsed row, either a movie, a reason to skip it or a parsing error
+type importRecord struct {
+	line   int
+	movie  *Movie
+	skip   string
+	errors map[string]string
+}
+
+// Reads movies one row at a time, returns io.EOF when there are no more rows
+type importReader interface {
+	next() (importRecord, error)
+}
+
+func newImportReader(r io.Reader, format string) (importReader, error) {
+	switch format {
+	case ImportFormatCSV:
+		return newCSVImportReader(r)
+	case ImportFormatNDJSON:
+		return &ndjsonImportReader{scanner: newLineScanner(r)}, nil
+	case ImportFormatIMDb:
+		return newIMDbImportReader(r)
+	}
+
+	return nil, ErrUnsupportedImportFormat
+}
+
+// Parse movies from r, validate each with ValidateMovie and insert the valid ones
+// in transactions of batchSize rows. Movies with the same title and year as an
+// existing one are skipped. Errors reading the input abort the import, but rows
+// of batches already committed stay in the database.
+func (m MovieModel) Import(r io.Reader, format string, batchSize int) (*ImportReport, error) {
+	reader, err := newImportReader(r, format)
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedImportFormat) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImportInput, err)
+	}
+
+	// Loaded up front, the batches hold the only connection while they're written
+	genres, err := GenreModel{DB: m.DB, ModelsConfig: m.ModelsConfig}.Vocabulary()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{Rows: []ImportRow{}}
+	defer report.sortRows()
+
+	batch := make([]importRecord, 0, batchSize)
+
+	for {
+		record, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("%w: %w", ErrInvalidImportInput, err)
+		}
+
+		switch {
+		case record.errors != nil:
+			report.add(ImportRow{Line: record.line, Status: ImportInvalid, Errors: record.errors})
+			continue
+		case record.skip != "":
+			report.add(ImportRow{Line: record.line, Status: ImportSkipped, Reason: record.skip})
+			continue
+		}
+
+		v := validator.New()
+		if ValidateMovie(v, record.movie, genres); !v.Valid() {
+			report.add(ImportRow{Line: record.line, Status: ImportInvalid, Errors: v.Errors})
+			continue
+		}
+
+		batch = append(batch, record)
+		if len(batch) == batchSize {
+			err = m.insertImportBatch(batch, report)
+			if err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	err = m.insertImportBatch(batch, report)
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// Insert a batch of validated movies in a single transaction. Each row writes its
+// revision, audit, event and genre rows too, so rather than sharing a timeout
+// across the whole batch each row gets DBQueryTimeout
+func (m MovieModel) insertImportBatch(batch []importRecord, report *ImportReport) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// No timeout of its own, every statement runs under the timeout of its row
+	tx, err := m.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows := make([]ImportRow, 0, len(batch))
+
+	for _, record := range batch {
+		row, err := m.insertImportRecord(tx, record)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", record.line, err)
+		}
+		rows = append(rows, row)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.changes.notify()
+
+	for _, row := range rows {
+		report.add(row)
+	}
+
+	return nil
+}
+
+// Insert a movie of an import batch, unless one with the same title and year exists
+func (m MovieModel) insertImportRecord(tx *sql.Tx, record importRecord) (ImportRow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	movie := record.movie
+
+	var exists bool
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM movies WHERE title = $1 AND year = $2 AND deleted_at IS NULL)`,
+		movie.Title,
+		movie.Year,
+	).Scan(&exists)
+	if err != nil {
+		return ImportRow{}, err
+	}
+
+	if exists {
+		return ImportRow{
+			Line:   record.line,
+			Status: ImportSkipped,
+			Reason: "a movie with the same title and year already exists",
+		}, nil
+	}
+
+	err = insertMovie(ctx, tx, m.actor, movie)
+	if err != nil {
+		return ImportRow{}, err
+	}
+
+	return ImportRow{Line: record.line, Status: ImportCreated, ID: movie.ID}, nil
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+// Split a list of genres separated by commas or pipes
+func splitGenres(s string) []string {
+	genres := []string{}
+	for _, genre := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '|' }) {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			genres = append(genres, genre)
+		}
+	}
+	return genres
+}
+
+// Parse a runtime given either as a number of minutes or as "<n> mins"
+func parseRuntime(s string) (Runtime, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimSuffix(s, " minutes"), " mins")
+
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidRunTimeFormat
+	}
+
+	return Runtime(i), nil
+}
+
+// CSV with a header row naming the title, year, runtime and genres columns
+type csvImportReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVImportReader(r io.Reader) (*csvImportReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("csv: missing header row")
+		}
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv: missing %q column in header row", name)
+		}
+	}
+
+	return &csvImportReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvImportReader) next() (importRecord, error) {
+	fields, err := c.reader.Read()
+	if err != nil {
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return importRecord{
+				line:   parseError.Line,
+				errors: map[string]string{"row": parseError.Err.Error()},
+			}, nil
+		}
+		return importRecord{}, err
+	}
+
+	line, _ := c.reader.FieldPos(0)
+	record := importRecord{line: line, movie: &Movie{}}
+	errs := map[string]string{}
+
+	field := func(name string) string {
+		if i := c.columns[name]; i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+
+	record.movie.Title = field("title")
+
+	if year := field("year"); year != "" {
+		y, err := strconv.ParseInt(year, 10, 32)
+		if err != nil {
+			errs["year"] = "must be an integer value"
+		}
+		record.movie.Year = int32(y)
+	}
+
+	if runtime := field("runtime"); runtime != "" {
+		record.movie.Runtime, err = parseRuntime(runtime)
+		if err != nil {
+			errs["runtime"] = err.Error()
+		}
+	}
+
+	if genres := field("genres"); genres != "" {
+		record.movie.Genres = splitGenres(genres)
+	}
+
+	if len(errs) > 0 {
+		record.errors = errs
+	}
+
+	return record, nil
+}
+
+// One JSON object per line, in the same shape accepted by POST /v1/movies
+type ndjsonImportReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonImportReader) next() (importRecord, error) {
+	for n.scanner.Scan() {
+		n.line++
+
+		text := strings.TrimSpace(n.scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var input struct {
+			Title   string   `json:"title"`
+			Year    int32    `json:"year"`
+			Runtime Runtime  `json:"runtime"`
+			Genres  []string `json:"genres"`
+		}
+
+		dec := json.NewDecoder(strings.NewReader(text))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err != nil {
+			return importRecord{
+				line:   n.line,
+				errors: map[string]string{"row": err.Error()},
+			}, nil
+		}
+
+		return importRecord{
+			line: n.line,
+			movie: &Movie{
+				Title:   input.Title,
+				Year:    input.Year,
+				Runtime: input.Runtime,
+				Genres:  input.Genres,
+			},
+		}, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return importRecord{}, err
+	}
+
+	return importRecord{}, io.EOF
+}
+
+// IMDb title.basics.tsv dump: tab separated, no quoting and \N for null values
+type imdbImportReader struct {
+	scanner *bufio.Scanner
+	columns map[string]int
+	line    int
+}
+
+const imdbNull = `\N`
+
+func newIMDbImportReader(r io.Reader) (*imdbImportReader, error) {
+	scanner := newLineScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("imdb: missing header row")
+	}
+
+	columns := map[string]int{}
+	for i, name := range strings.Split(scanner.Text(), "\t") {
+		columns[name] = i
+	}
+
+	for _, name := range []string{"titleType", "primaryTitle", "startYear", "runtimeMinutes", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("imdb: missing %q column in header row", name)
+		}
+	}
+
+	return &imdbImportReader{scanner: scanner, columns: columns, line: 1}, nil
+}
+
+func (d *imdbImportReader) next() (importRecord, error) {
+	for d.scanner.Scan() {
+		d.line++
+
+		if d.scanner.Text() == "" {
+			continue
+		}
+
+		fields := strings.Split(d.scanner.Text(), "\t")
+		field := func(name string) string {
+			if i := d.columns[name]; i < len(fields) && fields[i] != imdbNull {
+				return fields[i]
+			}
+			return ""
+		}
+
+		if titleType := field("titleType"); titleType != "movie" {
+			return importRecord{line: d.line, skip: fmt.Sprintf("title type %q is not a movie", titleType)}, nil
+		}
+
+		record := importRecord{line: d.line, movie: &Movie{Title: field("primaryTitle")}}
+		errs := map[string]string{}
+
+		if year := field("startYear"); year != "" {
+			y, err := strconv.ParseInt(year, 10, 32)
+			if err != nil {
+				errs["year"] = "must be an integer value"
+			}
+			record.movie.Year = int32(y)
+		}
+
+		if runtime := field("runtimeMinutes"); runtime != "" {
+			r, err := strconv.ParseInt(runtime, 10, 32)
+			if err != nil {
+				errs["runtime"] = "must be an integer value"
+			}
+			record.movie.Runtime = Runtime(r)
+		}
+
+		if genres := field("genres"); genres != "" {
+			record.movie.Genres = splitGenres(genres)
+		}
+
+		if len(errs) > 0 {
+			record.errors = errs
+		}
+
+		return record, nil
+	}
+
+	if err := d.scanner.Err(); err != nil {
+		return importRecord{}, err
+	}
+
+	return importRecord{}, io.EOF
+}
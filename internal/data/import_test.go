@@ -0,0 +1,160 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Status of each row of a report, in order
+func rowStatuses(report *ImportReport) []string {
+	statuses := make([]string, 0, len(report.Rows))
+	for _, row := range report.Rows {
+		statuses = append(statuses, row.Status)
+	}
+	return statuses
+}
+
+func TestImportCSV(t *testing.T) {
+	models := newTestModels(t)
+
+	existing := &Movie{Title: "Alien", Year: 1979, Runtime: 117, Genres: []string{"horror"}}
+	if err := models.Movies.Insert(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Join([]string{
+		"title,year,runtime,genres",
+		"Moana,2016,107 mins,Animation|Adventure",
+		"Moana,2016,107,animation",
+		"Alien,1979,117,horror",
+		"Broken,abc,90,drama",
+		"Unknown,2000,90,no-such-genre",
+		"Arrival,2016,116,Sci-Fi",
+		`"Quoted, title",2001,90,drama`,
+	}, "\n")
+
+	// Small batches, so duplicates are found across and within batches
+	report, err := models.Movies.Import(strings.NewReader(input), ImportFormatCSV, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatuses := []string{
+		ImportCreated, ImportSkipped, ImportSkipped, ImportInvalid, ImportInvalid, ImportCreated, ImportCreated,
+	}
+	if got := rowStatuses(report); !reflect.DeepEqual(got, wantStatuses) {
+		t.Fatalf("got statuses %v, want %v", got, wantStatuses)
+	}
+	for i, row := range report.Rows {
+		if row.Line != i+2 {
+			t.Errorf("row %d: got line %d, want %d", i, row.Line, i+2)
+		}
+	}
+	if report.Created != 3 || report.Skipped != 2 || report.Invalid != 2 {
+		t.Errorf("got %d created, %d skipped and %d invalid, want 3, 2 and 2", report.Created, report.Skipped, report.Invalid)
+	}
+
+	if got := report.Rows[3].Errors["year"]; got != "must be an integer value" {
+		t.Errorf("got year error %q", got)
+	}
+	if got := report.Rows[4].Errors["genres"]; got == "" {
+		t.Error("got no error for an unknown genre")
+	}
+
+	// Genres are stored as their canonical slugs
+	moana, err := models.Movies.Get(report.Rows[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"animation", "adventure"}; !reflect.DeepEqual(moana.Genres, want) {
+		t.Errorf("got genres %v, want %v", moana.Genres, want)
+	}
+
+	arrival, err := models.Movies.Get(report.Rows[5].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"science-fiction"}; !reflect.DeepEqual(arrival.Genres, want) {
+		t.Errorf("got genres %v, want %v", arrival.Genres, want)
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	models := newTestModels(t)
+
+	input := strings.Join([]string{
+		`{"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation"]}`,
+		``,
+		`{"title":"Extra","year":2016,"runtime":"107 mins","genres":["animation"],"rating":5}`,
+		`{"title":"","year":2016,"runtime":"107 mins","genres":["animation"]}`,
+	}, "\n")
+
+	report, err := models.Movies.Import(strings.NewReader(input), ImportFormatNDJSON, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatuses := []string{ImportCreated, ImportInvalid, ImportInvalid}
+	if got := rowStatuses(report); !reflect.DeepEqual(got, wantStatuses) {
+		t.Fatalf("got statuses %v, want %v", got, wantStatuses)
+	}
+
+	// Blank lines count, so lines match the input
+	if report.Rows[1].Line != 3 || report.Rows[1].Errors["row"] == "" {
+		t.Errorf("got %+v, want a row error on line 3", report.Rows[1])
+	}
+	if report.Rows[2].Errors["title"] != "must be provided" {
+		t.Errorf("got %+v, want a title error", report.Rows[2])
+	}
+}
+
+func TestImportIMDb(t *testing.T) {
+	models := newTestModels(t)
+
+	input := strings.Join([]string{
+		"tconst\ttitleType\tprimaryTitle\toriginalTitle\tisAdult\tstartYear\tendYear\truntimeMinutes\tgenres",
+		"tt0000001\tmovie\tMoana\tMoana\t0\t2016\t\\N\t107\tAnimation,Adventure",
+		"tt0000002\ttvSeries\tSeries\tSeries\t0\t2016\t\\N\t30\tDrama",
+		"tt0000003\tmovie\tNo Runtime\tNo Runtime\t0\t2016\t\\N\t\\N\tDrama",
+	}, "\n")
+
+	report, err := models.Movies.Import(strings.NewReader(input), ImportFormatIMDb, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatuses := []string{ImportCreated, ImportSkipped, ImportInvalid}
+	if got := rowStatuses(report); !reflect.DeepEqual(got, wantStatuses) {
+		t.Fatalf("got statuses %v, want %v", got, wantStatuses)
+	}
+	if report.Rows[2].Errors["runtime"] != "must be provided" {
+		t.Errorf("got %+v, want a runtime error", report.Rows[2])
+	}
+}
+
+func TestImportInvalidInput(t *testing.T) {
+	models := newTestModels(t)
+
+	_, err := models.Movies.Import(strings.NewReader("title,year\nMoana,2016\n"), ImportFormatCSV, 10)
+	if !errors.Is(err, ErrInvalidImportInput) {
+		t.Errorf("missing columns: got %v, want ErrInvalidImportInput", err)
+	}
+
+	_, err = models.Movies.Import(strings.NewReader(""), "xml", 10)
+	if !errors.Is(err, ErrUnsupportedImportFormat) {
+		t.Errorf("unknown format: got %v, want ErrUnsupportedImportFormat", err)
+	}
+
+	// Rows of batches committed before the error are kept and reported
+	input := `{"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation"]}` + "\n" +
+		strings.Repeat("x", 2*1024*1024)
+	report, err := models.Movies.Import(strings.NewReader(input), ImportFormatNDJSON, 1)
+	if !errors.Is(err, ErrInvalidImportInput) {
+		t.Fatalf("line too long: got %v, want ErrInvalidImportInput", err)
+	}
+	if report == nil || report.Created != 1 {
+		t.Errorf("got report %+v, want the first movie created", report)
+	}
+}
@@ -0,0 +1,497 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var (
+	ErrDuplicateListItem = errors.New("duplicate list item")
+	ErrListFull          = errors.New("list full")
+	ErrUnknownMovie      = errors.New("unknown movie")
+)
+
+const AuditResourceList = "list"
+
+// Most movies a list can hold, including the ones in the trash
+const MaxListItems = 1000
+
+// Values accepted by the sort filter when listing lists
+var ListSortSafeList = []string{"id", "name", "updated_at", "-id", "-name", "-updated_at"}
+
+// Values accepted by the sort filter when listing the movies of a list
+var ListItemSortSafeList = []string{"position", "added_at", "-position", "-added_at"}
+
+// An ordered collection of movies curated by a user, e.g. a watchlist. Only its
+// owner can see it unless it's public
+type List struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Name        string    `json:"name" validate:"required,max=100"`
+	Description *string   `json:"description,omitempty" validate:"min=1,max=1000"`
+	Public      bool      `json:"public"`
+	ItemCount   int       `json:"item_count"` // movies in the trash aren't counted
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int32     `json:"version"`
+}
+
+// A movie in a list. Position starts at 0 and may skip the places of movies in
+// the trash, which are hidden until they're restored or purged
+type ListItem struct {
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+func ValidateList(v *validator.Validator, list *List) {
+	v.Struct(list)
+}
+
+// Which lists GetAll returns
+type ListFilter struct {
+	UserID     int64
+	PublicOnly bool
+}
+
+// A list as recorded in the audit log, with the ids of its movies in order
+type listSnapshot struct {
+	*List
+	MovieIDs []int64 `json:"movie_ids"`
+}
+
+// Columns read by scanList, in order
+const listColumns = `
+        lists.id, lists.user_id, lists.name, lists.description, lists.public,
+        (
+            SELECT COUNT(*) FROM list_items
+            JOIN movies ON movies.id = list_items.movie_id
+            WHERE list_items.list_id = lists.id AND movies.deleted_at IS NULL
+        ),
+        lists.created_at, lists.updated_at, lists.version`
+
+func scanList(row rowScanner, leading ...any) (*List, error) {
+	var list List
+
+	dest := append(leading,
+		&list.ID,
+		&list.UserID,
+		&list.Name,
+		&list.Description,
+		&list.Public,
+		&list.ItemCount,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+		&list.Version,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+type ListModel struct {
+	DB *sql.DB
+	ModelsConfig
+	actor Actor
+}
+
+// Return a model recording actor as the author of the changes it makes
+func (m ListModel) WithActor(actor Actor) ListModel {
+	m.actor = actor
+	return m
+}
+
+// Create an empty list
+func (m ListModel) Insert(list *List) error {
+	query := `
+        INSERT INTO lists (user_id, name, description, public)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at, updated_at, version`
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		args := []any{list.UserID, list.Name, list.Description, list.Public}
+
+		err := tx.QueryRowContext(ctx, query, args...).
+			Scan(&list.ID, &list.CreatedAt, &list.UpdatedAt, &list.Version)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditCreate, AuditResourceList, list.ID, nil,
+			listSnapshot{List: list, MovieIDs: []int64{}})
+	})
+}
+
+// Fetch a list, whoever owns it
+func (m ListModel) Get(id int64) (*List, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	return getList(ctx, m.DB, id)
+}
+
+func getList(ctx context.Context, q queryer, id int64) (*List, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT ` + listColumns + `
+        FROM lists
+        WHERE lists.id = $1`
+
+	list, err := scanList(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// Fetch a list along with the ids of all its movies, in order
+func getListSnapshot(ctx context.Context, q queryer, id int64) (listSnapshot, error) {
+	list, err := getList(ctx, q, id)
+	if err != nil {
+		return listSnapshot{}, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+        SELECT movie_id FROM list_items WHERE list_id = $1 ORDER BY position`, id)
+	if err != nil {
+		return listSnapshot{}, err
+	}
+
+	defer rows.Close()
+
+	snapshot := listSnapshot{List: list, MovieIDs: []int64{}}
+	for rows.Next() {
+		var movieID int64
+		if err := rows.Scan(&movieID); err != nil {
+			return listSnapshot{}, err
+		}
+		snapshot.MovieIDs = append(snapshot.MovieIDs, movieID)
+	}
+
+	return snapshot, rows.Err()
+}
+
+// Bump the version of a list as part of a change to it, failing with
+// ErrEditConflict if it isn't at the version it was read at anymore
+func touchList(ctx context.Context, q queryer, list *List) error {
+	query := `
+        UPDATE lists
+        SET updated_at = current_timestamp, version = version + 1
+        WHERE id = $1 AND version = $2
+        RETURNING updated_at, version`
+
+	err := q.QueryRowContext(ctx, query, list.ID, list.Version).Scan(&list.UpdatedAt, &list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Change a list's name, description and visibility, as long as it's still at the
+// version it was read at
+func (m ListModel) Update(list *List) error {
+	query := `
+        UPDATE lists
+        SET name = $1, description = $2, public = $3
+        WHERE id = $4`
+
+	return m.change(list, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, list.Name, list.Description, list.Public, list.ID)
+		return err
+	})
+}
+
+// Delete a list along with its items
+func (m ListModel) Delete(id int64) error {
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getListSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM lists WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditDelete, AuditResourceList, id, before, nil)
+	})
+}
+
+// Add a movie to a list at position, moving the following items down. A nil
+// position, or one past the end, adds the movie at the end. It fails with
+// ErrUnknownMovie if the movie doesn't exist or is in the trash
+func (m ListModel) AddItem(list *List, movieID int64, position *int) error {
+	return m.change(list, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := getMovie(ctx, tx, movieID)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return ErrUnknownMovie
+			}
+			return err
+		}
+
+		count, err := countListItems(ctx, tx, list.ID)
+		if err != nil {
+			return err
+		}
+
+		if count >= MaxListItems {
+			return ErrListFull
+		}
+
+		at := count
+		if position != nil && *position < count {
+			at = *position
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO list_items (list_id, movie_id, position) VALUES ($1, $2, $3)`,
+			list.ID, movieID, count)
+		if err != nil {
+			switch {
+			case strings.Contains(err.Error(), "UNIQUE constraint failed"):
+				return ErrDuplicateListItem
+			default:
+				return err
+			}
+		}
+
+		return moveListItem(ctx, tx, list.ID, movieID, count, at)
+	})
+}
+
+// Remove a movie from a list, moving the following items up
+func (m ListModel) RemoveItem(list *List, movieID int64) error {
+	return m.change(list, func(ctx context.Context, tx *sql.Tx) error {
+		from, err := getListItemPosition(ctx, tx, list.ID, movieID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            DELETE FROM list_items WHERE list_id = $1 AND movie_id = $2`, list.ID, movieID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+            UPDATE list_items SET position = position - 1 WHERE list_id = $1 AND position > $2`,
+			list.ID, from)
+		return err
+	})
+}
+
+// Move a movie of a list to position, shifting the items in between. A position
+// past the end moves it to the end
+func (m ListModel) MoveItem(list *List, movieID int64, position int) error {
+	return m.change(list, func(ctx context.Context, tx *sql.Tx) error {
+		from, err := getListItemPosition(ctx, tx, list.ID, movieID)
+		if err != nil {
+			return err
+		}
+
+		count, err := countListItems(ctx, tx, list.ID)
+		if err != nil {
+			return err
+		}
+
+		return moveListItem(ctx, tx, list.ID, movieID, from, min(position, count-1))
+	})
+}
+
+// Run fn to change a list in a transaction that also bumps its version and
+// records the change in the audit log. list is updated to its new state
+func (m ListModel) change(list *List, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getListSnapshot(ctx, tx, list.ID)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return ErrEditConflict
+			}
+			return err
+		}
+
+		err = touchList(ctx, tx, list)
+		if err != nil {
+			return err
+		}
+
+		err = fn(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		after, err := getListSnapshot(ctx, tx, list.ID)
+		if err != nil {
+			return err
+		}
+
+		*list = *after.List
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditUpdate, AuditResourceList, list.ID, before, after)
+	})
+}
+
+func countListItems(ctx context.Context, q queryer, listID int64) (int, error) {
+	var count int
+	err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM list_items WHERE list_id = $1`, listID).Scan(&count)
+	return count, err
+}
+
+// Return the position of a movie in a list, or ErrRecordNotFound if it isn't in it
+func getListItemPosition(ctx context.Context, q queryer, listID, movieID int64) (int, error) {
+	query := `SELECT position FROM list_items WHERE list_id = $1 AND movie_id = $2`
+
+	var position int
+	err := q.QueryRowContext(ctx, query, listID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return position, nil
+}
+
+// Move the item of a list at position from to position to, shifting the items in
+// between by one place towards from
+func moveListItem(ctx context.Context, q queryer, listID, movieID int64, from, to int) error {
+	shift := `
+        UPDATE list_items SET position = position - 1
+        WHERE list_id = $1 AND position > $2 AND position <= $3`
+	args := []any{listID, from, to}
+
+	if to < from {
+		shift = `
+        UPDATE list_items SET position = position + 1
+        WHERE list_id = $1 AND position >= $2 AND position < $3`
+		args = []any{listID, to, from}
+	}
+
+	_, err := q.ExecContext(ctx, shift, args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.ExecContext(ctx, `
+        UPDATE list_items SET position = $1 WHERE list_id = $2 AND movie_id = $3`, to, listID, movieID)
+	return err
+}
+
+// Fetch a page of the lists matching filter
+func (m ListModel) GetAll(filter ListFilter, filters Filters) ([]*List, Metadata, error) {
+	// The sort column comes from a safelist, so it's fine to interpolate it.
+	// The id is a secondary sort key to keep pages stable
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), %s
+        FROM lists
+        WHERE lists.user_id = $1 AND (lists.public OR NOT $2)
+        ORDER BY %s, lists.id ASC
+        LIMIT $3 OFFSET $4`,
+		listColumns, filters.orderBy("lists"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filter.UserID, filter.PublicOnly, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	lists := []*List{}
+
+	for rows.Next() {
+		list, err := scanList(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		lists = append(lists, list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return lists, metadata, nil
+}
+
+// Fetch a page of the movies of a list, leaving out the ones in the trash
+func (m ListModel) GetItems(listID int64, filters Filters) ([]*ListItem, Metadata, error) {
+	// The sort column comes from a safelist, so it's fine to interpolate it.
+	// The position is a secondary sort key to keep pages stable
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), list_items.position, list_items.added_at, %s
+        FROM list_items
+        JOIN movies ON movies.id = list_items.movie_id
+        WHERE list_items.list_id = $1 AND movies.deleted_at IS NULL
+        ORDER BY %s, list_items.position ASC
+        LIMIT $2 OFFSET $3`,
+		movieColumns, filters.orderBy("list_items"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*ListItem{}
+
+	for rows.Next() {
+		var item ListItem
+
+		item.Movie, err = scanMovie(rows, &totalRecords, &item.Position, &item.AddedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return items, metadata, nil
+}
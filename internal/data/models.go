@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -12,7 +13,24 @@ var (
 )
 
 type Models struct {
-	Movies MovieModel
+	Movies  MovieModel
+	Users   UserModel
+	Audit   AuditModel
+	Genres  GenreModel
+	People  PersonModel
+	Credits CreditModel
+	Reviews ReviewModel
+	Lists   ListModel
+	// Responses of requests sent with an Idempotency-Key header
+	Idempotency IdempotencyModel
+	Webhooks    WebhookModel
+}
+
+// Who is making a change, recorded along with it
+type Actor struct {
+	UserID    int64 // 0 for anonymous users and direct database access
+	RequestID string
+	RemoteIP  string
 }
 
 type ModelsConfig struct {
@@ -22,6 +40,41 @@ type ModelsConfig struct {
 func NewModels(db *sql.DB, timeout time.Duration) Models {
 	modelsConfig := ModelsConfig{DBQueryTimeout: timeout}
 	return Models{
-		Movies: MovieModel{DB: db, ModelsConfig: modelsConfig},
+		Movies:      MovieModel{DB: db, ModelsConfig: modelsConfig, changes: newChangeNotifier()},
+		Users:       UserModel{DB: db, ModelsConfig: modelsConfig},
+		Audit:       AuditModel{DB: db, ModelsConfig: modelsConfig},
+		Genres:      GenreModel{DB: db, ModelsConfig: modelsConfig},
+		People:      PersonModel{DB: db, ModelsConfig: modelsConfig},
+		Credits:     CreditModel{DB: db, ModelsConfig: modelsConfig},
+		Reviews:     ReviewModel{DB: db, ModelsConfig: modelsConfig},
+		Lists:       ListModel{DB: db, ModelsConfig: modelsConfig},
+		Idempotency: IdempotencyModel{DB: db, ModelsConfig: modelsConfig},
+		Webhooks:    WebhookModel{DB: db, ModelsConfig: modelsConfig},
 	}
 }
+
+// Methods shared by *sql.DB and *sql.Tx, so queries can run in or out of a transaction
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Run fn within a transaction, committed if fn returns nil
+func inTx(db *sql.DB, config ModelsConfig, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DBQueryTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
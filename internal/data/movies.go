@@ -5,59 +5,201 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"greenlight.flaviogalon.github.io/internal/validator"
 )
 
+// Values accepted by the sort filter when listing movies
+var MovieSortSafeList = []string{
+	"id",
+	"title",
+	"year",
+	"runtime",
+	"rating",
+	"-id",
+	"-title",
+	"-year",
+	"-runtime",
+	"-rating",
+}
+
+// Columns of the movie sort values not named after one
+var movieSortColumns = map[string]string{"rating": "rating_avg"}
+
+// Fields of movies that can be compared in filters, e.g. year_gte=2000
+var MovieConditionSafeList = []string{"year", "runtime", "created_at"}
+
 type Movie struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"-"` // never going to be serialized
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`    // serialized only if != 0
-	Runtime   Runtime   `json:"runtime,omitempty"` // serialized only if != 0
-	Genres    []string  `json:"genres,omitempty"`  // serialized only if != []
-	Version   int32     `json:"version"`
-}
-
-func ValidateMovie(v *validator.Validator, movie *Movie) {
-	// Title must not be empty
-	v.Check(movie.Title != "", "title", "must be provided")
-	// Title most be at most 500 bytes long
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
-
-	// Year must be provided
-	v.Check(movie.Year != 0, "year", "must be provided")
-	// Year must be greater than 1888
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	// Year must not be in the future
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "most not be in the future")
-
-	// Runtime must be provided
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	// Runtime must be positive
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
-
-	// Genres must be provided
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	// Genres must contain at least 1 element
-	v.Check(len(movie.Genres) > 0, "genres", "must container at least 1 genre")
-	// Genres must containt at most 5 elemenets
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	// Genres must contain unique elements
-	v.Check(validator.Unique(movie.Genres), "genres", "most not contain duplicate values")
+	Title     string    `json:"title" validate:"required,max=500"`
+	Year      int32     `json:"year,omitempty" validate:"required,min=1888,year_not_future"` // serialized only if != 0
+	Runtime   Runtime   `json:"runtime,omitempty" validate:"required,min=1"`                 // serialized only if != 0
+	Genres    []string  `json:"genres,omitempty" validate:"required,min=1,max=5,unique"`     // serialized only if != []
+	// Identifier of the movie in an external source, unique among movies
+	ExternalID *string `json:"external_id,omitempty" validate:"min=1,max=255"`
+	// Average score of the movie's reviews and their number, kept up to date by
+	// triggers on the reviews table. RatingAvg is nil until the movie is reviewed
+	RatingAvg   *float64 `json:"rating_avg,omitempty"`
+	RatingCount int32    `json:"rating_count"`
+	// When the movie was moved to the trash, nil unless it was deleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Version   int32      `json:"version"`
+}
+
+var ErrDuplicateExternalID = errors.New("duplicate external id")
+
+// Fields of a movie that can be selected on their own, named after both their
+// column and their JSON key
+var MovieFieldSafeList = []string{
+	"id",
+	"title",
+	"year",
+	"runtime",
+	"genres",
+	"external_id",
+	"rating_avg",
+	"rating_count",
+	"deleted_at",
+	"version",
+}
+
+// Columns of a complete movie, in the order of movieColumns
+var movieColumnList = []string{
+	"id", "created_at", "title", "year", "runtime", "genres", "external_id",
+	"rating_avg", "rating_count", "deleted_at", "version",
+}
+
+// Columns read by scanMovie, in order
+var movieColumns = strings.Join(movieColumnList, ", ")
+
+// Return the columns to select for the given fields of a movie, all of them when
+// there are none. The id is always selected
+func movieFieldColumns(fields []string) []string {
+	if len(fields) == 0 {
+		return movieColumnList
+	}
+
+	columns := []string{"id"}
+	for _, field := range fields {
+		if !slices.Contains(columns, field) {
+			columns = append(columns, field)
+		}
+	}
+
+	return columns
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// Scan a row selecting movieColumns, preceded by the leading columns if any
+func scanMovie(row rowScanner, leading ...any) (*Movie, error) {
+	return scanMovieColumns(row, movieColumnList, leading...)
+}
+
+// Scan a row selecting the given columns of the movies table, preceded by the
+// leading columns if any. Fields of the movie that weren't selected are left zero
+func scanMovieColumns(row rowScanner, columns []string, leading ...any) (*Movie, error) {
+	var movie Movie
+	var genresJSONString *string
+
+	dest := leading
+	for _, column := range columns {
+		switch column {
+		case "id":
+			dest = append(dest, &movie.ID)
+		case "created_at":
+			dest = append(dest, &movie.CreatedAt)
+		case "title":
+			dest = append(dest, &movie.Title)
+		case "year":
+			dest = append(dest, &movie.Year)
+		case "runtime":
+			dest = append(dest, &movie.Runtime)
+		case "genres":
+			dest = append(dest, &genresJSONString)
+		case "external_id":
+			dest = append(dest, &movie.ExternalID)
+		case "rating_avg":
+			dest = append(dest, &movie.RatingAvg)
+		case "rating_count":
+			dest = append(dest, &movie.RatingCount)
+		case "deleted_at":
+			dest = append(dest, &movie.DeletedAt)
+		case "version":
+			dest = append(dest, &movie.Version)
+		default:
+			panic("unknown movie column: " + column)
+		}
+	}
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	if genresJSONString != nil {
+		// JSON parsing error
+		err = json.Unmarshal([]byte(*genresJSONString), &movie.Genres)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
+
+// Translate constraint violations of the movies table into model errors
+func movieWriteError(err error) error {
+	switch {
+	case strings.Contains(err.Error(), "UNIQUE constraint failed: movies.external_id"):
+		return ErrDuplicateExternalID
+	default:
+		return err
+	}
+}
+
+// Validate a movie against the rules declared on its struct tags, which mirror
+// the CHECK constraints of the movies table, and its genres against the vocabulary.
+// Genres given by name or alias are replaced by their canonical slugs
+func ValidateMovie(v *validator.Validator, movie *Movie, genres GenreVocabulary) {
+	v.Struct(movie)
+	genres.canonicalize(v, movie)
 }
 
 type MovieModel struct {
 	DB *sql.DB
 	ModelsConfig
+	actor Actor
+	// Notified once changes to movies are committed
+	changes *changeNotifier
 }
 
-// Insert a new record in the movies table
+// Return a model recording actor as the author of the changes it makes
+func (m MovieModel) WithActor(actor Actor) MovieModel {
+	m.actor = actor
+	return m
+}
+
+// Insert a new record in the movies table, along with its first revision
 func (m MovieModel) Insert(movie *Movie) error {
+	return m.InTx(func(tx MovieTx) error {
+		return tx.Insert(movie)
+	})
+}
+
+// Insert a new record in the movies table, its first revision and audit event. q
+// should be a transaction for all of them to be written atomically
+func insertMovie(ctx context.Context, q queryer, actor Actor, movie *Movie) error {
 	query := `
-        INSERT INTO movies (title, year, runtime, genres)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO movies (title, year, runtime, genres, external_id)
+        VALUES ($1, $2, $3, $4, $5)
         RETURNING id, created_at, version`
 
 	jsonGenres, err := json.Marshal(movie.Genres)
@@ -65,41 +207,63 @@ func (m MovieModel) Insert(movie *Movie) error {
 		return err
 	}
 
-	args := []any{movie.Title, movie.Year, movie.Runtime, jsonGenres}
+	args := []any{movie.Title, movie.Year, movie.Runtime, jsonGenres, movie.ExternalID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
-	defer cancel()
-
-	return m.DB.QueryRowContext(ctx, query, args...).
+	err = q.QueryRowContext(ctx, query, args...).
 		Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return movieWriteError(err)
+	}
+
+	err = setMovieGenres(ctx, q, movie)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(ctx, q, actor, movie)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEvent(ctx, q, actor, AuditCreate, AuditResourceMovie, movie.ID, nil, movie)
+	if err != nil {
+		return err
+	}
+
+	return insertMovieEvent(ctx, q, MovieEventCreated, movie)
 }
 
 // Fetch a specific record from the movies table
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.GetFields(id, nil)
+}
+
+// Fetch a specific record from the movies table, reading only the given fields
+// from MovieFieldSafeList along with the id. Empty fields read all of them
+func (m MovieModel) GetFields(id int64, fields []string) (*Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	return getMovieFields(ctx, m.DB, id, fields)
+}
+
+func getMovie(ctx context.Context, q queryer, id int64) (*Movie, error) {
+	return getMovieFields(ctx, q, id, nil)
+}
+
+func getMovieFields(ctx context.Context, q queryer, id int64, fields []string) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	var movie Movie
-	var genresJSONString string
-
-	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
-	defer cancel()
+	columns := movieFieldColumns(fields)
 
 	query := `
-        SELECT id, created_at, title, year, runtime, genres, version
+        SELECT ` + strings.Join(columns, ", ") + `
         FROM movies
-        WHERE id = $1`
-
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		&genresJSONString,
-		&movie.Version,
-	)
+        WHERE id = $1 AND deleted_at IS NULL`
+
+	movie, err := scanMovieColumns(q.QueryRowContext(ctx, query, id), columns)
 	// DB query erros
 	if err != nil {
 		switch {
@@ -110,22 +274,62 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
-	// JSON parsing error
-	err = json.Unmarshal([]byte(genresJSONString), &movie.Genres)
+	return movie, nil
+}
+
+// Fetch the movie with the given external id, even if it's in the trash
+func (m MovieModel) GetByExternalID(externalID string) (*Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	return getMovieByExternalID(ctx, m.DB, externalID)
+}
+
+func getMovieByExternalID(ctx context.Context, q queryer, externalID string) (*Movie, error) {
+	query := `
+        SELECT ` + movieColumns + `
+        FROM movies
+        WHERE external_id = $1`
+
+	movie, err := scanMovie(q.QueryRowContext(ctx, query, externalID))
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	return &movie, nil
+	return movie, nil
 }
 
-// Update a specific record in the movies table
+// Update a specific record in the movies table, recording its new revision
 func (m MovieModel) Update(movie *Movie) error {
+	return m.InTx(func(tx MovieTx) error {
+		return tx.Update(movie)
+	})
+}
+
+// Update a movie if it's still at movie.Version, bumping its version and recording
+// the new revision and audit event. q should be a transaction for all of them to be
+// written atomically
+func updateMovie(ctx context.Context, q queryer, actor Actor, movie *Movie) error {
+	before, err := getMovie(ctx, q, movie.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
 	query := `
         UPDATE movies
-        SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-        WHERE id = $5 AND version = $6
-        RETURNING version`
+        SET title = $1, year = $2, runtime = $3, genres = $4, external_id = $5, version = version + 1
+        WHERE id = $6 AND version = $7 AND deleted_at IS NULL
+        RETURNING version, rating_avg, rating_count`
 
 	jsonGenres, err := json.Marshal(movie.Genres)
 	if err != nil {
@@ -137,102 +341,540 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Year,
 		movie.Runtime,
 		jsonGenres,
+		movie.ExternalID,
 		movie.ID,
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
-	defer cancel()
-
-	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = q.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.RatingAvg, &movie.RatingCount)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
-			return err
+			return movieWriteError(err)
 		}
 	}
 
-	return nil
+	err = setMovieGenres(ctx, q, movie)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(ctx, q, actor, movie)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEvent(ctx, q, actor, AuditUpdate, AuditResourceMovie, movie.ID, before, movie)
+	if err != nil {
+		return err
+	}
+
+	return insertMovieEvent(ctx, q, MovieEventUpdated, movie)
 }
 
-// Delete a specific record from the movies table
+// Move a movie to the trash, from where it can be restored until it's purged
 func (m MovieModel) Delete(id int64) error {
+	return m.InTx(func(tx MovieTx) error {
+		return tx.Delete(id)
+	})
+}
+
+func deleteMovie(ctx context.Context, q queryer, actor Actor, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
-        DELETE FROM movies
-        WHERE id = $1`
+        UPDATE movies
+        SET deleted_at = current_timestamp
+        WHERE id = $1 AND deleted_at IS NULL
+        RETURNING ` + movieColumns
 
+	after, err := scanMovie(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	before := *after
+	before.DeletedAt = nil
+
+	err = insertAuditEvent(ctx, q, actor, AuditDelete, AuditResourceMovie, id, &before, after)
+	if err != nil {
+		return err
+	}
+
+	return insertMovieEvent(ctx, q, MovieEventDeleted, after)
+}
+
+// Take a movie out of the trash, failing with ErrRecordNotFound if it isn't there
+func (m MovieModel) Restore(id int64) (movie *Movie, err error) {
+	err = m.InTx(func(tx MovieTx) error {
+		movie, err = restoreMovie(tx.ctx, tx.tx, tx.actor, id)
+		return err
+	})
+
+	return movie, err
+}
+
+func restoreMovie(ctx context.Context, q queryer, actor Actor, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT ` + movieColumns + `
+        FROM movies
+        WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	before, err := scanMovie(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+        UPDATE movies
+        SET deleted_at = NULL
+        WHERE id = $1
+        RETURNING ` + movieColumns
+
+	movie, err := scanMovie(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertAuditEvent(ctx, q, actor, AuditRestore, AuditResourceMovie, id, before, movie)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertMovieEvent(ctx, q, MovieEventRestored, movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return movie, nil
+}
+
+// Permanently delete the movies that have been in the trash for longer than
+// retention, recording an audit event for each, and return how many were purged
+func (m MovieModel) Purge(retention time.Duration) (purged int64, err error) {
+	err = m.InTx(func(tx MovieTx) error {
+		query := `
+            SELECT ` + movieColumns + `
+            FROM movies
+            WHERE deleted_at < datetime('now', $1)`
+
+		rows, err := tx.tx.QueryContext(tx.ctx, query, sqliteInterval(-retention))
+		if err != nil {
+			return err
+		}
+
+		// Read every purged movie first, rather than changing the table under the cursor
+		movies := []*Movie{}
+		for rows.Next() {
+			movie, err := scanMovie(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			movies = append(movies, movie)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, movie := range movies {
+			_, err = tx.tx.ExecContext(tx.ctx, `DELETE FROM movies WHERE id = $1`, movie.ID)
+			if err != nil {
+				return err
+			}
+
+			err = insertAuditEvent(tx.ctx, tx.tx, tx.actor, AuditPurge, AuditResourceMovie, movie.ID, movie, nil)
+			if err != nil {
+				return err
+			}
+
+			err = insertMovieEvent(tx.ctx, tx.tx, MovieEventPurged, movie)
+			if err != nil {
+				return err
+			}
+		}
+
+		purged = int64(len(movies))
+		return nil
+	})
+
+	return purged, err
+}
+
+// Movie operations running within a transaction started by MovieModel.InTx. It has
+// the same methods as MovieModel, so code can work with either of them
+type MovieTx struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	actor Actor
+}
+
+func (t MovieTx) Insert(movie *Movie) error {
+	return insertMovie(t.ctx, t.tx, t.actor, movie)
+}
+
+func (t MovieTx) Get(id int64) (*Movie, error) {
+	return getMovie(t.ctx, t.tx, id)
+}
+
+func (t MovieTx) GetByExternalID(externalID string) (*Movie, error) {
+	return getMovieByExternalID(t.ctx, t.tx, externalID)
+}
+
+func (t MovieTx) Update(movie *Movie) error {
+	return updateMovie(t.ctx, t.tx, t.actor, movie)
+}
+
+func (t MovieTx) Delete(id int64) error {
+	return deleteMovie(t.ctx, t.tx, t.actor, id)
+}
+
+// Run fn within a transaction, which is committed if fn returns nil and rolled
+// back otherwise. The whole transaction shares a single query timeout
+func (m MovieModel) InTx(fn func(tx MovieTx) error) error {
 	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	err = fn(MovieTx{ctx: ctx, tx: tx, actor: m.actor})
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = tx.Commit()
+	if err != nil {
+		return err
 	}
 
+	m.changes.notify()
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, error) {
-	query := `
-        SELECT id, created_at, title, year, runtime, genres, version
+// Create a movie with movie.ExternalID, or replace the movie which already has it (taking
+// it out of the trash if needed), in a single transaction. A non zero movie.Version must match the version of the existing
+// movie, otherwise or if there's none it fails with ErrEditConflict. Reports whether
+// the movie was created
+func (m MovieModel) Upsert(movie *Movie) (created bool, err error) {
+	if movie.ExternalID == nil {
+		return false, errors.New("upsert requires an external id")
+	}
+
+	err = m.InTx(func(tx MovieTx) error {
+		existing, err := tx.GetByExternalID(*movie.ExternalID)
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			if movie.Version != 0 {
+				return ErrEditConflict
+			}
+			created = true
+			return tx.Insert(movie)
+		case err != nil:
+			return err
+		}
+
+		if movie.Version != 0 && movie.Version != existing.Version {
+			return ErrEditConflict
+		}
+
+		// The source still has the movie, so it comes back from the trash
+		if existing.DeletedAt != nil {
+			if _, err := restoreMovie(tx.ctx, tx.tx, tx.actor, existing.ID); err != nil {
+				return err
+			}
+			movie.DeletedAt = nil
+		}
+
+		movie.ID = existing.ID
+		movie.CreatedAt = existing.CreatedAt
+		movie.Version = existing.Version
+
+		return tx.Update(movie)
+	})
+
+	return created, err
+}
+
+// Which movies GetAll and Export return, and which of their fields
+type MovieFilter struct {
+	Title  string   // part of the title, case insensitive
+	Genres []string // genres the movie must all have, by name, slug or alias
+	// Genres the movie must have at least one of, by name, slug or alias
+	GenresAny []string
+	// Part of the name of a director or actor of the movie, case insensitive
+	Director string
+	Actor    string
+	// Movies in the trash are left out unless IncludeDeleted is set, Trash returns only them
+	IncludeDeleted bool
+	Trash          bool
+	// Fields read from MovieFieldSafeList, along with the id. Empty for all of them
+	Fields []string
+}
+
+// Return the conditions of the WHERE clause selecting the filtered movies, along
+// with the conditions of filters, with the arguments bound to their $N placeholders
+func (f MovieFilter) where(filters Filters) (string, []any, error) {
+	slugs := func(genres []string) ([]byte, error) {
+		wanted := make([]string, 0, len(genres))
+		for _, genre := range genres {
+			wanted = append(wanted, Slugify(genre))
+		}
+		return json.Marshal(wanted)
+	}
+
+	allGenres, err := slugs(f.Genres)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Whether the movie has the genre wanted.value, by slug or alias
+	const hasGenre = `EXISTS (
+                SELECT 1 FROM movie_genres
+                JOIN genres ON genres.id = movie_genres.genre_id
+                LEFT JOIN genre_aliases ON genre_aliases.genre_id = genres.id
+                WHERE movie_genres.movie_id = movies.id
+                AND (genres.slug = wanted.value OR genre_aliases.alias = wanted.value)
+            )`
+
+	conditions := []string{
+		`(LOWER(title) LIKE '%' || LOWER($1) || '%' OR $1 = '')`,
+		`NOT EXISTS (
+            SELECT 1 FROM json_each($2) AS wanted
+            WHERE NOT ` + hasGenre + `
+        )`,
+	}
+
+	args := []any{f.Title, allGenres}
+
+	if len(f.GenresAny) > 0 {
+		anyGenres, err := slugs(f.GenresAny)
+		if err != nil {
+			return "", nil, err
+		}
+
+		args = append(args, anyGenres)
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+            SELECT 1 FROM json_each($%d) AS wanted
+            WHERE %s
+        )`, len(args), hasGenre))
+	}
+
+	for _, credited := range []struct{ role, name string }{
+		{CreditDirector, f.Director},
+		{CreditActor, f.Actor},
+	} {
+		if credited.name == "" {
+			continue
+		}
+
+		args = append(args, credited.role, credited.name)
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+            SELECT 1 FROM movie_credits
+            JOIN people ON people.id = movie_credits.person_id
+            WHERE movie_credits.movie_id = movies.id
+            AND movie_credits.role = $%d
+            AND LOWER(people.name) LIKE '%%' || LOWER($%d) || '%%'
+        )`, len(args)-1, len(args)))
+	}
+
+	comparisons, args := filters.where(args)
+	conditions = append(conditions, comparisons...)
+
+	switch {
+	case f.Trash:
+		conditions = append(conditions, "deleted_at IS NOT NULL")
+	case !f.IncludeDeleted:
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
+	return strings.Join(conditions, "\n        AND "), args, nil
+}
+
+// Fetch a page of the movies matching filter
+func (m MovieModel) GetAll(filter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	filters.SortColumns = movieSortColumns
+	filters.ConditionSafeList = MovieConditionSafeList
+
+	where, args, err := filter.where(filters)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	columns := movieFieldColumns(filter.Fields)
+
+	// The sort column comes from a safelist, so it's fine to interpolate it, and
+	// so are the fields. The id is a secondary sort key to keep pages stable
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), %s
         FROM movies
-        ORDER BY id`
+        WHERE %s
+        ORDER BY %s, id ASC
+        LIMIT $%d OFFSET $%d`,
+		strings.Join(columns, ", "), where, filters.orderBy(""), len(args)+1, len(args)+2)
 
 	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	args = append(args, filters.limit(), filters.offset())
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
 
+	totalRecords := 0
 	movies := []*Movie{}
-	var genresJSONString string
 
 	for rows.Next() {
-		var movie Movie
-
-		err := rows.Scan(
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			&genresJSONString,
-			&movie.Version,
-		)
+		movie, err := scanMovieColumns(rows, columns, &totalRecords)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Number of movies an export reads at a time
+const exportPageSize = 500
+
+// Call fn for every movie matching the filter and sort order of GetAll, reading
+// them in pages of exportPageSize movies instead of loading them all in memory.
+// Pagination filters are ignored. Pages are read after one another by their sort
+// values, each within the usual query timeout, and fn is only called once a page
+// is read: the connection isn't held while the caller streams movies to a
+// possibly slow client. Movies changed during the export may be left out or
+// exported twice if their sort values change.
+func (m MovieModel) Export(
+	ctx context.Context,
+	filter MovieFilter,
+	filters Filters,
+	fn func(movie *Movie) error,
+) error {
+	filters.SortColumns = movieSortColumns
+	filters.ConditionSafeList = MovieConditionSafeList
+
+	where, args, err := filter.where(filters)
+	if err != nil {
+		return err
+	}
+
+	columns := movieFieldColumns(filter.Fields)
+	keysetColumns := filters.keysetColumns()
+
+	// Values of the keyset columns of the last movie exported, nil before the first page
+	var last []any
+
+	for {
+		pageWhere, pageArgs := where, args
+		if last != nil {
+			var after string
+			after, pageArgs = filters.after(last, slices.Clip(args))
+			pageWhere += "\n        AND " + after
 		}
 
-		err = json.Unmarshal([]byte(genresJSONString), &movie.Genres)
+		query := fmt.Sprintf(`
+        SELECT %s, %s
+        FROM movies
+        WHERE %s
+        ORDER BY %s, id ASC
+        LIMIT %d`,
+			strings.Join(keysetColumns, ", "),
+			strings.Join(columns, ", "),
+			pageWhere,
+			filters.orderBy(""),
+			exportPageSize,
+		)
+
+		movies, keys, err := m.exportPage(ctx, query, pageArgs, columns, len(keysetColumns))
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		movies = append(movies, &movie)
+		for _, movie := range movies {
+			err = fn(movie)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(movies) < exportPageSize {
+			return nil
+		}
+		last = keys
+	}
+}
+
+// Read a page of an export, returning its movies and the values of the keyset
+// columns of the last one
+func (m MovieModel) exportPage(
+	ctx context.Context,
+	query string,
+	args []any,
+	columns []string,
+	keyCount int,
+) ([]*Movie, []any, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer rows.Close()
+
+	movies := make([]*Movie, 0, exportPageSize)
+	keys := make([]any, keyCount)
+	dest := make([]any, keyCount)
+	for i := range keys {
+		dest[i] = &keys[i]
+	}
+
+	for rows.Next() {
+		movie, err := scanMovieColumns(rows, columns, dest...)
+		if err != nil {
+			return nil, nil, err
+		}
+		movies = append(movies, movie)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return movies, nil
+	return movies, keys, nil
 }
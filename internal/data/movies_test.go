@@ -0,0 +1,80 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	models := newTestModels(t)
+
+	var movies []*Movie
+	for _, title := range []string{"Moana", "Arrival", "Coco"} {
+		movie := &Movie{Title: title, Year: 2016, Runtime: 107, Genres: []string{"drama"}}
+		if err := models.Movies.Insert(movie); err != nil {
+			t.Fatal(err)
+		}
+		movies = append(movies, movie)
+	}
+	kept, restored, purged := movies[0], movies[1], movies[2]
+
+	for _, movie := range []*Movie{restored, purged} {
+		if err := models.Movies.Delete(movie.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := models.Movies.Get(restored.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for a deleted movie, want ErrRecordNotFound", err)
+	}
+	if err := models.Movies.Delete(restored.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v deleting a deleted movie, want ErrRecordNotFound", err)
+	}
+
+	filters := Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: MovieSortSafeList}
+	listed, _, err := models.Movies.GetAll(MovieFilter{}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != kept.ID {
+		t.Errorf("got %d movies listed, want only the one outside the trash", len(listed))
+	}
+
+	listed, _, err = models.Movies.GetAll(MovieFilter{IncludeDeleted: true}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 {
+		t.Errorf("got %d movies listed with the deleted ones, want 3", len(listed))
+	}
+
+	// Only the movie deleted before the retention period is purged
+	_, err = models.Movies.DB.Exec(`UPDATE movies SET deleted_at = datetime('now', '-2 days') WHERE id = $1`, purged.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := models.Movies.Purge(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d movies purged, want 1", count)
+	}
+
+	if _, err := models.Movies.Restore(purged.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v restoring a purged movie, want ErrRecordNotFound", err)
+	}
+
+	movie, err := models.Movies.Restore(restored.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.DeletedAt != nil {
+		t.Errorf("got deleted_at %v on a restored movie", movie.DeletedAt)
+	}
+	if _, err := models.Movies.Get(restored.ID); err != nil {
+		t.Errorf("got %v for a restored movie", err)
+	}
+}
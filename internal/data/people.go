@@ -0,0 +1,254 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var ErrPersonCredited = errors.New("person credited")
+
+const AuditResourcePerson = "person"
+
+// Values accepted by the sort filter when listing people
+var PersonSortSafeList = []string{"id", "name", "birth_year", "-id", "-name", "-birth_year"}
+
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name" validate:"required,max=500"`
+	BirthYear *int32    `json:"birth_year,omitempty" validate:"min=1800,year_not_future"`
+	// Identifiers of the person in external sources, keyed by source, e.g. {"imdb": "nm0000138"}
+	ExternalIDs map[string]string `json:"external_ids,omitempty" validate:"max=10"`
+	Version     int32             `json:"version"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Struct(person)
+
+	for source, id := range person.ExternalIDs {
+		v.Check(source != "" && len(source) <= 50, "external_ids", "sources must be between 1 and 50 bytes long")
+		v.Check(id != "" && len(id) <= 255, "external_ids", "ids must be between 1 and 255 bytes long")
+	}
+}
+
+// Which people GetAll returns
+type PersonFilter struct {
+	Name string // part of the name, case insensitive
+}
+
+// Columns read by scanPerson, in order
+const personColumns = `id, created_at, name, birth_year, external_ids, version`
+
+func scanPerson(row rowScanner, leading ...any) (*Person, error) {
+	var person Person
+	var externalIDsJSON string
+
+	dest := append(leading,
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&externalIDsJSON,
+		&person.Version,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(externalIDsJSON), &person.ExternalIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &person, nil
+}
+
+type PersonModel struct {
+	DB *sql.DB
+	ModelsConfig
+	actor Actor
+}
+
+// Return a model recording actor as the author of the changes it makes
+func (m PersonModel) WithActor(actor Actor) PersonModel {
+	m.actor = actor
+	return m
+}
+
+// Insert a new record in the people table
+func (m PersonModel) Insert(person *Person) error {
+	if person.ExternalIDs == nil {
+		person.ExternalIDs = map[string]string{}
+	}
+
+	externalIDs, err := json.Marshal(person.ExternalIDs)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO people (name, birth_year, external_ids)
+        VALUES ($1, $2, json($3))
+        RETURNING id, created_at, version`
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, person.Name, person.BirthYear, externalIDs).
+			Scan(&person.ID, &person.CreatedAt, &person.Version)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditCreate, AuditResourcePerson, person.ID, nil, person)
+	})
+}
+
+// Fetch a specific record from the people table
+func (m PersonModel) Get(id int64) (*Person, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	return getPerson(ctx, m.DB, id)
+}
+
+func getPerson(ctx context.Context, q queryer, id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + personColumns + ` FROM people WHERE id = $1`
+
+	person, err := scanPerson(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return person, nil
+}
+
+// Update a specific record from the people table, as long as it's still at the
+// version it was read at
+func (m PersonModel) Update(person *Person) error {
+	if person.ExternalIDs == nil {
+		person.ExternalIDs = map[string]string{}
+	}
+
+	externalIDs, err := json.Marshal(person.ExternalIDs)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE people
+        SET name = $1, birth_year = $2, external_ids = json($3), version = version + 1
+        WHERE id = $4 AND version = $5
+        RETURNING version`
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getPerson(ctx, tx, person.ID)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return ErrEditConflict
+			}
+			return err
+		}
+
+		args := []any{person.Name, person.BirthYear, externalIDs, person.ID, person.Version}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditUpdate, AuditResourcePerson, person.ID, before, person)
+	})
+}
+
+// Delete a specific record from the people table. It fails with ErrPersonCredited
+// while the person is credited in movies, including the ones in the trash
+func (m PersonModel) Delete(id int64) error {
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getPerson(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		var credited bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_credits WHERE person_id = $1)`, id).
+			Scan(&credited)
+		if err != nil {
+			return err
+		}
+
+		if credited {
+			return ErrPersonCredited
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM people WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditDelete, AuditResourcePerson, id, before, nil)
+	})
+}
+
+// Fetch a page of the people matching filter
+func (m PersonModel) GetAll(filter PersonFilter, filters Filters) ([]*Person, Metadata, error) {
+	// The sort column comes from a safelist, so it's fine to interpolate it.
+	// The id is a secondary sort key to keep pages stable
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), %s
+        FROM people
+        WHERE (LOWER(name) LIKE '%%' || LOWER($1) || '%%' OR $1 = '')
+        ORDER BY %s, id ASC
+        LIMIT $2 OFFSET $3`,
+		personColumns, filters.orderBy(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filter.Name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		person, err := scanPerson(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
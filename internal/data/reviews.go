@@ -0,0 +1,232 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+const AuditResourceReview = "review"
+
+// Values accepted by the sort filter when listing reviews
+var ReviewSortSafeList = []string{"id", "score", "updated_at", "-id", "-score", "-updated_at"}
+
+// A user's score of a movie. Each user can review a movie once
+type Review struct {
+	ID        int64     `json:"id"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Score     int32     `json:"score" validate:"required,min=1,max=10"`
+	Text      *string   `json:"text,omitempty" validate:"min=1,max=5000"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Struct(review)
+}
+
+// Which reviews GetAll returns
+type ReviewFilter struct {
+	MovieID int64
+	UserID  int64 // 0 for every user
+}
+
+// Columns read by scanReview, in order. Queries must left join users, whose
+// reviews are deleted along with them
+const reviewColumns = `
+        reviews.id, reviews.movie_id, reviews.user_id, IFNULL(users.name, ''), reviews.score,
+        reviews.text, reviews.created_at, reviews.updated_at, reviews.version`
+
+func scanReview(row rowScanner, leading ...any) (*Review, error) {
+	var review Review
+
+	dest := append(leading,
+		&review.ID,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&review.Score,
+		&review.Text,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.Version,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+type ReviewModel struct {
+	DB *sql.DB
+	ModelsConfig
+	actor Actor
+}
+
+// Return a model recording actor as the author of the changes it makes
+func (m ReviewModel) WithActor(actor Actor) ReviewModel {
+	m.actor = actor
+	return m
+}
+
+// Add the review of a user to a movie. It fails with ErrDuplicateReview if the
+// user already reviewed it
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+        INSERT INTO reviews (movie_id, user_id, score, text)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at, updated_at, version`
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		args := []any{review.MovieID, review.UserID, review.Score, review.Text}
+
+		err := tx.QueryRowContext(ctx, query, args...).
+			Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case strings.Contains(err.Error(), "UNIQUE constraint failed: reviews.movie_id, reviews.user_id"):
+				return ErrDuplicateReview
+			default:
+				return err
+			}
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditCreate, AuditResourceReview, review.ID, nil, review)
+	})
+}
+
+// Fetch the review of a user of a movie
+func (m ReviewModel) GetForUser(movieID, userID int64) (*Review, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	return getReviewForUser(ctx, m.DB, movieID, userID)
+}
+
+func getReviewForUser(ctx context.Context, q queryer, movieID, userID int64) (*Review, error) {
+	query := `
+        SELECT ` + reviewColumns + `
+        FROM reviews
+        LEFT JOIN users ON users.id = reviews.user_id
+        WHERE reviews.movie_id = $1 AND reviews.user_id = $2`
+
+	review, err := scanReview(q.QueryRowContext(ctx, query, movieID, userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return review, nil
+}
+
+// Update the score and text of a review, as long as it's still at the version it
+// was read at
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+        UPDATE reviews
+        SET score = $1, text = $2, updated_at = current_timestamp, version = version + 1
+        WHERE id = $3 AND version = $4
+        RETURNING updated_at, version`
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getReviewForUser(ctx, tx, review.MovieID, review.UserID)
+		if err != nil {
+			if errors.Is(err, ErrRecordNotFound) {
+				return ErrEditConflict
+			}
+			return err
+		}
+
+		args := []any{review.Score, review.Text, review.ID, review.Version}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditUpdate, AuditResourceReview, review.ID, before, review)
+	})
+}
+
+// Delete the review of a user of a movie
+func (m ReviewModel) Delete(movieID, userID int64) error {
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getReviewForUser(ctx, tx, movieID, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1`, before.ID)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditDelete, AuditResourceReview, before.ID, before, nil)
+	})
+}
+
+// Fetch a page of the reviews matching filter
+func (m ReviewModel) GetAll(filter ReviewFilter, filters Filters) ([]*Review, Metadata, error) {
+	// The sort column comes from a safelist, so it's fine to interpolate it.
+	// The id is a secondary sort key to keep pages stable
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), %s
+        FROM reviews
+        LEFT JOIN users ON users.id = reviews.user_id
+        WHERE reviews.movie_id = $1 AND (reviews.user_id = $2 OR $2 = 0)
+        ORDER BY %s, reviews.id ASC
+        LIMIT $3 OFFSET $4`,
+		reviewColumns, filters.orderBy("reviews"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filter.MovieID, filter.UserID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		review, err := scanReview(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
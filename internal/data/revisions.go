@@ -0,0 +1,186 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Values accepted by the sort filter when listing revisions
+var RevisionSortSafeList = []string{"version", "-version"}
+
+// A movie as it was at one of its versions
+type MovieRevision struct {
+	Version   int32     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    *int64    `json:"user_id,omitempty"` // nil for direct database changes
+	Movie     *Movie    `json:"movie"`
+}
+
+// A field with different values in two revisions, nil when it's not set
+type MovieChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// Record the current state of a movie as the revision of its version
+func insertRevision(ctx context.Context, q queryer, actor Actor, movie *Movie) error {
+	snapshot := *movie
+	snapshot.DeletedAt = nil
+
+	js, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	var userID *int64
+	if actor.UserID != 0 {
+		userID = &actor.UserID
+	}
+
+	query := `
+        INSERT INTO movie_revisions (movie_id, version, user_id, movie)
+        VALUES ($1, $2, $3, $4)`
+
+	_, err = q.ExecContext(ctx, query, movie.ID, movie.Version, userID, js)
+	return err
+}
+
+func scanRevision(row rowScanner, leading ...any) (*MovieRevision, error) {
+	var revision MovieRevision
+	var movieJSON string
+
+	dest := append(leading, &revision.Version, &revision.CreatedAt, &revision.UserID, &movieJSON)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(movieJSON), &revision.Movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// Fetch a page of the revisions of a movie
+func (m MovieModel) GetRevisions(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	// The sort column comes from a safelist, so it's fine to interpolate it
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), version, created_at, user_id, movie
+        FROM movie_revisions
+        WHERE movie_id = $1
+        ORDER BY %s
+        LIMIT $2 OFFSET $3`, filters.orderBy(""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		revision, err := scanRevision(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// Fetch the revision of a movie at a specific version
+func (m MovieModel) GetRevision(movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+        SELECT version, created_at, user_id, movie
+        FROM movie_revisions
+        WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	revision, err := scanRevision(m.DB.QueryRowContext(ctx, query, movieID, version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return revision, nil
+}
+
+// Return the fields of the movie that changed between two revisions, sorted by
+// name. The id and version are left out, as they always differ or never do, and
+// so are the ratings, which change with reviews rather than with the movie
+func DiffRevisions(from, to *MovieRevision) ([]MovieChange, error) {
+	fromFields, err := movieFields(from.Movie)
+	if err != nil {
+		return nil, err
+	}
+
+	toFields, err := movieFields(to.Movie)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for name := range fromFields {
+		names[name] = true
+	}
+	for name := range toFields {
+		names[name] = true
+	}
+	delete(names, "id")
+	delete(names, "version")
+	delete(names, "rating_avg")
+	delete(names, "rating_count")
+
+	changes := []MovieChange{}
+	for name := range names {
+		if !reflect.DeepEqual(fromFields[name], toFields[name]) {
+			changes = append(changes, MovieChange{Field: name, From: fromFields[name], To: toFields[name]})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes, nil
+}
+
+// Return the fields of the JSON representation of a movie
+func movieFields(movie *Movie) (map[string]any, error) {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	err = json.Unmarshal(js, &fields)
+	return fields, err
+}
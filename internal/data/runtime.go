@@ -32,8 +32,8 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 	// 2. Split the string to isolate the number
 	parts := strings.Split(unquotedJSONValue, " ")
 
-	// 3. Check parts of the string
-	if len(parts) != 2 || parts[1] != "mins" {
+	// 3. Check parts of the string, "<runtime> minutes" as returned by MarshalJSON is accepted too
+	if len(parts) != 2 || (parts[1] != "mins" && parts[1] != "minutes") {
 		return ErrInvalidRunTimeFormat
 	}
 
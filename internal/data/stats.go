@@ -0,0 +1,225 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Facets movie counts can be grouped by
+var MovieFacetSafeList = []string{"genres", "year", "decade"}
+
+// Percentiles of the runtimes reported by Stats
+var RuntimePercentiles = []int{25, 50, 75, 90, 95, 99}
+
+// How a facet groups movies: the value counted, the tables it's read from besides
+// movies and the order of the counts
+var movieFacets = map[string]struct{ value, from, order string }{
+	// movies.genres holds the slugs of the movie_genres rows as a JSON array
+	"genres": {"genre.value", ", json_each(movies.genres) AS genre", "COUNT(*) DESC, 1 ASC"},
+	"year":   {"year", "", "1 ASC"},
+	"decade": {"year / 10 * 10", "", "1 ASC"},
+}
+
+// Number of movies having a value, e.g. a genre or a year
+type FacetCount struct {
+	Value any `json:"value"`
+	Count int `json:"count"`
+}
+
+// Runtime distribution of movies, in minutes
+type RuntimeStats struct {
+	Min     int32   `json:"min"`
+	Max     int32   `json:"max"`
+	Average float64 `json:"average"`
+	// Runtimes at RuntimePercentiles, keyed p<percentile>, e.g. p50 for the median
+	Percentiles map[string]int32 `json:"percentiles"`
+}
+
+// Aggregates of the movies matching a filter
+type MovieStats struct {
+	Total   int           `json:"total"`
+	Genres  []FacetCount  `json:"genres"`
+	Decades []FacetCount  `json:"decades"`
+	Years   []FacetCount  `json:"years"`
+	Runtime *RuntimeStats `json:"runtime,omitempty"` // nil without movies
+}
+
+// Count the movies matching filter and the conditions of filters by each of the
+// facets, from MovieFacetSafeList. Pagination and sort are ignored
+func (m MovieModel) Facets(filter MovieFilter, filters Filters, facets []string) (map[string][]FacetCount, error) {
+	counts := make(map[string][]FacetCount, len(facets))
+
+	err := inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		for _, facet := range facets {
+			facetCounts, err := countMovieFacet(ctx, tx, filter, filters, facet)
+			if err != nil {
+				return err
+			}
+			counts[facet] = facetCounts
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// Aggregate the movies matching filter and the conditions of filters
+func (m MovieModel) Stats(filter MovieFilter, filters Filters) (*MovieStats, error) {
+	var stats MovieStats
+
+	err := inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+
+		stats.Runtime, stats.Total, err = movieRuntimeStats(ctx, tx, filter, filters)
+		if err != nil {
+			return err
+		}
+
+		for facet, dest := range map[string]*[]FacetCount{
+			"genres": &stats.Genres,
+			"decade": &stats.Decades,
+			"year":   &stats.Years,
+		} {
+			*dest, err = countMovieFacet(ctx, tx, filter, filters, facet)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Count the movies matching the filters by the values of a facet. It panics if the
+// facet isn't a safe one
+func countMovieFacet(ctx context.Context, q queryer, filter MovieFilter, filters Filters, facet string) ([]FacetCount, error) {
+	grouping, ok := movieFacets[facet]
+	if !ok {
+		panic("unsafe facet: " + facet)
+	}
+
+	filters.SortColumns = movieSortColumns
+	filters.ConditionSafeList = MovieConditionSafeList
+
+	where, args, err := filter.where(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// The facet comes from a safelist, so it's fine to interpolate it
+	query := fmt.Sprintf(`
+        SELECT %s, COUNT(*)
+        FROM movies%s
+        WHERE %s
+        GROUP BY 1
+        ORDER BY %s`, grouping.value, grouping.from, where, grouping.order)
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	counts := []FacetCount{}
+	for rows.Next() {
+		var count FacetCount
+		if err := rows.Scan(&count.Value, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+
+	return counts, rows.Err()
+}
+
+// Return the runtime distribution of the movies matching the filters, nil if there
+// are none, along with their number
+func movieRuntimeStats(ctx context.Context, q queryer, filter MovieFilter, filters Filters) (*RuntimeStats, int, error) {
+	filters.SortColumns = movieSortColumns
+	filters.ConditionSafeList = MovieConditionSafeList
+
+	where, args, err := filter.where(filters)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+        SELECT COUNT(*), IFNULL(MIN(runtime), 0), IFNULL(MAX(runtime), 0), IFNULL(AVG(runtime), 0)
+        FROM movies
+        WHERE %s`, where)
+
+	var total int
+	var stats RuntimeStats
+
+	err = q.QueryRowContext(ctx, query, args...).Scan(&total, &stats.Min, &stats.Max, &stats.Average)
+	if err != nil || total == 0 {
+		return nil, total, err
+	}
+
+	stats.Average = math.Round(stats.Average*10) / 10
+
+	// Nearest-rank percentiles: the runtime of the movie at rank ceil(p% of the
+	// movies) once sorted by runtime
+	ranks := make(map[int][]string, len(RuntimePercentiles))
+	rankList := make([]int, 0, len(RuntimePercentiles))
+	for _, percentile := range RuntimePercentiles {
+		rank := max(1, int(math.Ceil(float64(percentile)*float64(total)/100)))
+		if _, ok := ranks[rank]; !ok {
+			rankList = append(rankList, rank)
+		}
+		ranks[rank] = append(ranks[rank], "p"+strconv.Itoa(percentile))
+	}
+
+	jsonRanks, err := json.Marshal(rankList)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, jsonRanks)
+
+	query = fmt.Sprintf(`
+        SELECT rank, runtime FROM (
+            SELECT runtime, ROW_NUMBER() OVER (ORDER BY runtime) AS rank
+            FROM movies
+            WHERE %s
+        )
+        WHERE rank IN (SELECT value FROM json_each($%d))`, where, len(args))
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	stats.Percentiles = make(map[string]int32, len(RuntimePercentiles))
+	for rows.Next() {
+		var rank int
+		var runtime int32
+		if err := rows.Scan(&rank, &runtime); err != nil {
+			return nil, 0, err
+		}
+		for _, name := range ranks[rank] {
+			stats.Percentiles[name] = runtime
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return &stats, total, nil
+}
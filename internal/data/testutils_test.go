@@ -0,0 +1,69 @@
+package data
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Open a new SQLite database with every migration applied, closed at the end of
+// the test. Like the API server, it has a single connection
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db := openTestDB(t)
+	applyTestMigrations(t, db, testMigrations(t))
+
+	return db
+}
+
+// Open a new empty SQLite database, closed at the end of the test
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "greenlight.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// Paths of the up migrations, in the order they apply in
+func testMigrations(t *testing.T) []string {
+	t.Helper()
+
+	scripts, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(scripts)
+
+	return scripts
+}
+
+func applyTestMigrations(t *testing.T, db *sql.DB, scripts []string) {
+	t.Helper()
+
+	for _, script := range scripts {
+		sql, err := os.ReadFile(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(sql)); err != nil {
+			t.Fatalf("%s: %s", filepath.Base(script), err)
+		}
+	}
+}
+
+func newTestModels(t *testing.T) Models {
+	t.Helper()
+	return NewModels(newTestDB(t), 3*time.Second)
+}
@@ -0,0 +1,237 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+var ErrDuplicateEmail = errors.New("duplicate email")
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Identity of API callers not presenting a token
+var AnonymousUser = &User{}
+
+type User struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name" validate:"required,max=500"`
+	Email     string    `json:"email" validate:"required,email"`
+	Role      string    `json:"role" validate:"required,oneof=user admin"`
+	Version   int32     `json:"-"`
+}
+
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+func ValidateUser(v *validator.Validator, user *User) {
+	v.Struct(user)
+}
+
+type UserModel struct {
+	DB *sql.DB
+	ModelsConfig
+}
+
+// Generate a random API token, returning the plaintext and the hash to be stored
+func generateToken() (string, []byte, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	return plaintext, hash[:], nil
+}
+
+// Insert a new record in the users table, returning the plaintext API token of the
+// user. Only the hash of the token is stored, so it can't be recovered later.
+func (m UserModel) Insert(user *User) (string, error) {
+	query := `
+        INSERT INTO users (name, email, role, token_hash)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at, version`
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	args := []any{user.Name, user.Email, user.Role, tokenHash}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "UNIQUE constraint failed: users.email"):
+			return "", ErrDuplicateEmail
+		default:
+			return "", err
+		}
+	}
+
+	return token, nil
+}
+
+// Fetch the user owning an API token
+func (m UserModel) GetForToken(token string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(token))
+
+	query := `
+        SELECT id, created_at, name, email, role, version
+        FROM users
+        WHERE token_hash = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:]).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Role,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Fetch every record from the users table
+func (m UserModel) GetAll() ([]*User, error) {
+	query := `
+        SELECT id, created_at, name, email, role, version
+        FROM users
+        ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Role,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Replace the API token of a user, returning the new plaintext token
+func (m UserModel) RotateToken(id int64) (string, error) {
+	query := `
+        UPDATE users
+        SET token_hash = $1, version = version + 1
+        WHERE id = $2`
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash, id)
+	if err != nil {
+		return "", err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if rowsAffected == 0 {
+		return "", ErrRecordNotFound
+	}
+
+	return token, nil
+}
+
+// Delete a specific record from the users table
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM users
+        WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
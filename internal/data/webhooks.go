@@ -0,0 +1,508 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"greenlight.flaviogalon.github.io/internal/validator"
+)
+
+const AuditResourceWebhook = "webhook"
+
+// Event types webhooks can subscribe to: the movie events of the change log
+var WebhookEventSafeList = []string{
+	"movie." + MovieEventCreated,
+	"movie." + MovieEventUpdated,
+	"movie." + MovieEventDeleted,
+	"movie." + MovieEventRestored,
+	"movie." + MovieEventPurged,
+}
+
+// Statuses of webhook deliveries
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead" // failed too many times
+)
+
+var WebhookDeliveryStatusSafeList = []string{WebhookDeliveryPending, WebhookDeliveryDelivered, WebhookDeliveryDead}
+
+// Values accepted by the sort filter when listing webhooks and their deliveries
+var WebhookSortSafeList = []string{"id", "-id"}
+
+// A subscription of an URL to changes to the catalog, which it's sent as signed
+// POST requests
+type Webhook struct {
+	ID     int64    `json:"id"`
+	URL    string   `json:"url" validate:"required,max=2000,http_url"`
+	Events []string `json:"events" validate:"unique"` // event types sent, all of them if empty
+	// Key of the signatures of the deliveries, never sent back to clients
+	Secret    string    `json:"-" validate:"min=16,max=200"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+}
+
+// An event sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID        int64       `json:"id"`
+	WebhookID int64       `json:"webhook_id"`
+	Event     *MovieEvent `json:"event"`
+	Status    string      `json:"status"`
+	Attempts  int         `json:"attempts"`
+	// Set while the delivery is pending
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	// HTTP status and error of the last failed attempt
+	LastStatus  *int       `json:"last_status,omitempty"`
+	LastError   *string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// A pending delivery whose attempt is due, along with where it's sent
+type DueWebhookDelivery struct {
+	*WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// Type of the event a delivery sends, e.g. movie.updated
+func (d *WebhookDelivery) EventType() string {
+	return "movie." + d.Event.Type
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Struct(webhook)
+	v.Check(webhook.Secret != "", "secret", "must be provided")
+
+	for _, event := range webhook.Events {
+		if !validator.PermittedValue(event, WebhookEventSafeList...) {
+			v.AddError("events", "must only contain: "+strings.Join(WebhookEventSafeList, ", "))
+			break
+		}
+	}
+}
+
+// Columns read by scanWebhook, in order
+const webhookColumns = `id, url, events, secret, active, created_at, updated_at, version`
+
+func scanWebhook(row rowScanner, leading ...any) (*Webhook, error) {
+	var webhook Webhook
+	var events string
+
+	dest := append(leading,
+		&webhook.ID,
+		&webhook.URL,
+		&events,
+		&webhook.Secret,
+		&webhook.Active,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+		&webhook.Version,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(events), &webhook.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+	ModelsConfig
+	actor Actor
+}
+
+// Return a model recording actor as the author of the changes it makes
+func (m WebhookModel) WithActor(actor Actor) WebhookModel {
+	m.actor = actor
+	return m
+}
+
+// Create a webhook, which is sent the changes made from now on
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+        INSERT INTO webhooks (url, events, secret, active)
+        VALUES ($1, $2, $3, $4)
+        RETURNING id, created_at, updated_at, version`
+
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	jsonEvents, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		args := []any{webhook.URL, jsonEvents, webhook.Secret, webhook.Active}
+
+		err := tx.QueryRowContext(ctx, query, args...).
+			Scan(&webhook.ID, &webhook.CreatedAt, &webhook.UpdatedAt, &webhook.Version)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditCreate, AuditResourceWebhook, webhook.ID, nil, webhook)
+	})
+}
+
+// Fetch a specific webhook
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	return getWebhook(ctx, m.DB, id)
+}
+
+func getWebhook(ctx context.Context, q queryer, id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT ` + webhookColumns + `
+        FROM webhooks
+        WHERE id = $1`
+
+	webhook, err := scanWebhook(q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return webhook, nil
+}
+
+// Fetch a page of the webhooks
+func (m WebhookModel) GetAll(filters Filters) ([]*Webhook, Metadata, error) {
+	// The sort column comes from a safelist, so it's fine to interpolate it
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), %s
+        FROM webhooks
+        ORDER BY %s
+        LIMIT $1 OFFSET $2`,
+		webhookColumns, filters.orderBy("webhooks"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		webhook, err := scanWebhook(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return webhooks, metadata, nil
+}
+
+// Update a webhook if it's still at webhook.Version, bumping its version
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+        UPDATE webhooks
+        SET url = $1, events = $2, secret = $3, active = $4,
+            updated_at = current_timestamp, version = version + 1
+        WHERE id = $5 AND version = $6
+        RETURNING updated_at, version`
+
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	jsonEvents, err := json.Marshal(webhook.Events)
+	if err != nil {
+		return err
+	}
+
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getWebhook(ctx, tx, webhook.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRecordNotFound):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		args := []any{webhook.URL, jsonEvents, webhook.Secret, webhook.Active, webhook.ID, webhook.Version}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&webhook.UpdatedAt, &webhook.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditUpdate, AuditResourceWebhook, webhook.ID, before, webhook)
+	})
+}
+
+// Delete a webhook along with its deliveries
+func (m WebhookModel) Delete(id int64) error {
+	return inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getWebhook(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		return insertAuditEvent(ctx, tx, m.actor, AuditDelete, AuditResourceWebhook, id, before, nil)
+	})
+}
+
+// Queue the delivery of an event of the change log to the active webhooks
+// subscribed to its type, as part of the transaction q recording it
+func insertWebhookDeliveries(ctx context.Context, q queryer, eventID int64, eventType string) error {
+	query := `
+        INSERT INTO webhook_deliveries (webhook_id, event_id, next_attempt_at)
+        SELECT id, $1, current_timestamp
+        FROM webhooks
+        WHERE active AND (
+            json_array_length(events) = 0
+            OR EXISTS (SELECT 1 FROM json_each(webhooks.events) WHERE value = $2)
+        )`
+
+	_, err := q.ExecContext(ctx, query, eventID, eventType)
+	return err
+}
+
+// Columns read by scanWebhookDelivery, in order
+const webhookDeliveryColumns = `
+        webhook_deliveries.id, webhook_deliveries.webhook_id, webhook_deliveries.status,
+        webhook_deliveries.attempts, webhook_deliveries.next_attempt_at,
+        webhook_deliveries.last_status, webhook_deliveries.last_error,
+        webhook_deliveries.created_at, webhook_deliveries.delivered_at,
+        movie_events.id, movie_events.created_at, movie_events.type,
+        movie_events.movie_id, movie_events.version`
+
+func scanWebhookDelivery(row rowScanner, leading ...any) (*WebhookDelivery, error) {
+	delivery := WebhookDelivery{Event: &MovieEvent{}}
+
+	dest := append(leading,
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastStatus,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+		&delivery.Event.ID,
+		&delivery.Event.CreatedAt,
+		&delivery.Event.Type,
+		&delivery.Event.MovieID,
+		&delivery.Event.Version,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// Fetch a page of the deliveries of a webhook, only the ones with status unless
+// it's empty
+func (m WebhookModel) GetDeliveries(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	// The sort column comes from a safelist, so it's fine to interpolate it
+	query := fmt.Sprintf(`
+        SELECT COUNT(*) OVER(), %s
+        FROM webhook_deliveries
+        JOIN movie_events ON movie_events.id = webhook_deliveries.event_id
+        WHERE webhook_deliveries.webhook_id = $1 AND (webhook_deliveries.status = $2 OR $2 = '')
+        ORDER BY %s
+        LIMIT $3 OFFSET $4`,
+		webhookDeliveryColumns, filters.orderBy("webhook_deliveries"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// Fetch up to limit pending deliveries due to be attempted, to active webhooks,
+// the longest overdue first
+func (m WebhookModel) GetDueDeliveries(limit int) ([]*DueWebhookDelivery, error) {
+	query := `
+        SELECT webhooks.url, webhooks.secret, ` + webhookDeliveryColumns + `
+        FROM webhook_deliveries
+        JOIN movie_events ON movie_events.id = webhook_deliveries.event_id
+        JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+        WHERE webhook_deliveries.status = 'pending'
+            AND webhook_deliveries.next_attempt_at <= datetime('now')
+            AND webhooks.active
+        ORDER BY webhook_deliveries.next_attempt_at, webhook_deliveries.id
+        LIMIT $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := []*DueWebhookDelivery{}
+	for rows.Next() {
+		var due DueWebhookDelivery
+
+		due.WebhookDelivery, err = scanWebhookDelivery(rows, &due.URL, &due.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &due)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// Record a successful attempt of a delivery
+func (m WebhookModel) MarkDelivered(id int64) error {
+	query := `
+        UPDATE webhook_deliveries
+        SET status = 'delivered', attempts = attempts + 1, next_attempt_at = NULL,
+            delivered_at = current_timestamp
+        WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Record a failed attempt of a delivery, along with the HTTP status of the response
+// if there was one. The delivery is attempted again after retryAfter, unless it's
+// dead
+func (m WebhookModel) MarkFailed(id int64, lastStatus *int, lastError string, retryAfter time.Duration, dead bool) error {
+	query := `
+        UPDATE webhook_deliveries
+        SET status = $1, attempts = attempts + 1, last_status = $2, last_error = $3,
+            next_attempt_at = CASE WHEN $1 = 'pending' THEN datetime('now', $4) END
+        WHERE id = $5`
+
+	status := WebhookDeliveryPending
+	if dead {
+		status = WebhookDeliveryDead
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.ModelsConfig.DBQueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, lastStatus, lastError, sqliteInterval(retryAfter), id)
+	return err
+}
+
+// Queue a delivery of a webhook again, e.g. a dead one once the receiver is fixed.
+// Its attempts start over
+func (m WebhookModel) Redeliver(webhookID, id int64) (*WebhookDelivery, error) {
+	var delivery *WebhookDelivery
+
+	err := inTx(m.DB, m.ModelsConfig, func(ctx context.Context, tx *sql.Tx) error {
+		query := `
+            UPDATE webhook_deliveries
+            SET status = 'pending', attempts = 0, next_attempt_at = current_timestamp
+            WHERE webhook_id = $1 AND id = $2`
+
+		result, err := tx.ExecContext(ctx, query, webhookID, id)
+		if err != nil {
+			return err
+		}
+
+		if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+			if err == nil {
+				err = ErrRecordNotFound
+			}
+			return err
+		}
+
+		query = `
+            SELECT ` + webhookDeliveryColumns + `
+            FROM webhook_deliveries
+            JOIN movie_events ON movie_events.id = webhook_deliveries.event_id
+            WHERE webhook_deliveries.id = $1`
+
+		delivery, err = scanWebhookDelivery(tx.QueryRowContext(ctx, query, id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
@@ -0,0 +1,735 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// A request to execute one of the operations of a document, as sent over HTTP
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+	// Extensions, e.g. persisted queries, aren't supported and are ignored
+	Extensions map[string]any `json:"extensions"`
+}
+
+// Limits of the operations executed, checked before executing them. Zero values
+// mean no limit
+type Limits struct {
+	// Deepest nesting of fields, the root fields being at depth 1
+	MaxDepth int
+	// Highest total cost of the fields selected, see Field.Complexity
+	MaxComplexity int
+}
+
+// The result of an operation. Errors are the ones of fields that couldn't be
+// resolved, whose value is null
+type Result struct {
+	Data   any
+	Errors []*Error
+}
+
+// Execute the operation of a request, given the value of the root type's
+// source, e.g. what's needed to authorize it. Requests that can't be executed,
+// e.g. because of a syntax error, a validation error or a limit being exceeded,
+// fail with Errors
+func (s *Schema) Execute(req Request, root any, limits Limits) (*Result, error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, Errors{{Message: "the request must have a query"}}
+	}
+
+	doc, err := parse(req.Query)
+	if err != nil {
+		return nil, Errors{err.(*Error)}
+	}
+
+	if errs := s.validate(doc); len(errs) > 0 {
+		return nil, errs
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return nil, Errors{err.(*Error)}
+	}
+
+	rootType := s.Query
+	if op.operation == "mutation" {
+		rootType = s.Mutation
+	}
+
+	e := &executor{schema: s, fragments: map[string]*fragmentDefinition{}, limits: limits}
+	for _, fragment := range doc.fragments {
+		e.fragments[fragment.name] = fragment
+	}
+
+	if errs := e.coerceVariables(op, req.Variables); len(errs) > 0 {
+		return nil, errs
+	}
+
+	if _, err := e.analyze(rootType, op.selectionSet, 1); err != nil {
+		return nil, Errors{err}
+	}
+
+	// The data is null when a non-null root field is
+	result := &Result{}
+	if data, ok := e.executeSelectionSet(rootType, root, op.selectionSet, nil); ok {
+		result.Data = data
+	}
+	result.Errors = e.errors
+
+	return result, nil
+}
+
+// Return the operation of the document the request names, the only one if it
+// names none
+func selectOperation(doc *document, name string) (*operationDefinition, error) {
+	if name == "" {
+		if len(doc.operations) != 1 {
+			return nil, &Error{Message: "the document has several operations, operationName must name one"}
+		}
+		return doc.operations[0], nil
+	}
+
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+
+	return nil, &Error{Message: fmt.Sprintf("unknown operation named %q", name)}
+}
+
+// Executes an operation of a validated document
+type executor struct {
+	schema    *Schema
+	fragments map[string]*fragmentDefinition
+	variables map[string]any
+	limits    Limits
+	errors    []*Error
+}
+
+// Record the error of a field, which is null in the result
+func (e *executor) addError(err error, loc Location, path []any) {
+	fieldErr := &Error{Message: err.Error()}
+
+	var resolverErr *Error
+	if errors.As(err, &resolverErr) {
+		fieldErr.Message = resolverErr.Message
+		fieldErr.Extensions = resolverErr.Extensions
+	}
+
+	fieldErr.Locations = []Location{loc}
+	fieldErr.Path = path
+
+	e.errors = append(e.errors, fieldErr)
+}
+
+// Coerce the variables of a request to the types of the operation's variables
+func (e *executor) coerceVariables(op *operationDefinition, values map[string]any) Errors {
+	var errs Errors
+	e.variables = map[string]any{}
+
+	for _, definition := range op.variables {
+		t := e.schema.typeOf(definition.typ)
+
+		value, ok := values[definition.name]
+		if !ok {
+			switch {
+			case definition.defaultValue != nil:
+				coerced, err := e.coerceLiteral(definition.defaultValue, t)
+				if err != nil {
+					errs = append(errs, variableError(definition, err))
+					continue
+				}
+				e.variables[definition.name] = coerced
+			case definition.typ.nonNull:
+				errs = append(errs, variableError(definition, fmt.Errorf("a value of type %s is required", t)))
+			}
+			continue
+		}
+
+		coerced, err := coerceInputValue(value, t)
+		if err != nil {
+			errs = append(errs, variableError(definition, err))
+			continue
+		}
+		e.variables[definition.name] = coerced
+	}
+
+	return errs
+}
+
+func variableError(definition *variableDefinition, err error) *Error {
+	return &Error{
+		Message:   fmt.Sprintf("variable $%s got an invalid value: %s", definition.name, err),
+		Locations: []Location{definition.loc},
+	}
+}
+
+// Coerce a value of a variable, decoded from JSON, to the type t
+func coerceInputValue(value any, t Type) (any, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if value == nil {
+			return nil, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		t = nonNull.OfType
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		items, ok := value.([]any)
+		if !ok {
+			item, err := coerceInputValue(value, t.OfType)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+
+		coerced := make([]any, len(items))
+		for i, item := range items {
+			var err error
+			if coerced[i], err = coerceInputValue(item, t.OfType); err != nil {
+				return nil, fmt.Errorf("at index %d: %w", i, err)
+			}
+		}
+		return coerced, nil
+
+	case *InputObject:
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected an object of type %s, found %s", t.Name, describe(value))
+		}
+
+		for name := range fields {
+			if t.field(name) == nil {
+				return nil, fmt.Errorf("field %q is not defined by type %s", name, t.Name)
+			}
+		}
+
+		coerced := map[string]any{}
+		for _, field := range t.Fields {
+			fieldValue, ok := fields[field.Name]
+			if !ok {
+				if field.Default != nil {
+					coerced[field.Name] = field.Default
+				} else if _, required := field.Type.(*NonNull); required {
+					return nil, fmt.Errorf("field %s.%s of required type %s was not provided", t.Name, field.Name, field.Type)
+				}
+				continue
+			}
+
+			var err error
+			if coerced[field.Name], err = coerceInputValue(fieldValue, field.Type); err != nil {
+				return nil, fmt.Errorf("at field %q: %w", field.Name, err)
+			}
+		}
+		return coerced, nil
+
+	case *Scalar:
+		switch value.(type) {
+		case string, bool, float64:
+			return t.Parse(value)
+		}
+		return nil, fmt.Errorf("%s can't represent %s", t.Name, describe(value))
+	}
+
+	return nil, fmt.Errorf("%s isn't an input type", t)
+}
+
+// Coerce a value of the document to the type t, substituting variables
+func (e *executor) coerceLiteral(val *value, t Type) (any, error) {
+	if val.kind == valueVariable {
+		coerced := e.variables[val.raw]
+		if _, ok := t.(*NonNull); ok && coerced == nil {
+			return nil, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		return coerced, nil
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if val.kind == valueNull {
+			return nil, fmt.Errorf("expected a value of type %s, found null", t)
+		}
+		t = nonNull.OfType
+	}
+
+	if val.kind == valueNull {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if val.kind != valueList {
+			item, err := e.coerceLiteral(val, t.OfType)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+
+		coerced := make([]any, len(val.list))
+		for i, item := range val.list {
+			var err error
+			if coerced[i], err = e.coerceLiteral(item, t.OfType); err != nil {
+				return nil, err
+			}
+		}
+		return coerced, nil
+
+	case *InputObject:
+		if val.kind != valueObject {
+			return nil, fmt.Errorf("expected an object of type %s, found %s", t.Name, val)
+		}
+
+		coerced := map[string]any{}
+		for _, field := range t.Fields {
+			node := findArgumentNode(val.fields, field.Name)
+			if node == nil || !e.isProvided(node.value) {
+				if field.Default != nil {
+					coerced[field.Name] = field.Default
+				} else if _, required := field.Type.(*NonNull); required {
+					return nil, fmt.Errorf("field %s.%s of required type %s was not provided", t.Name, field.Name, field.Type)
+				}
+				continue
+			}
+
+			var err error
+			if coerced[field.Name], err = e.coerceLiteral(node.value, field.Type); err != nil {
+				return nil, err
+			}
+		}
+		return coerced, nil
+
+	case *Scalar:
+		literal, err := literalValue(val)
+		if err != nil {
+			return nil, err
+		}
+		return t.Parse(literal)
+	}
+
+	return nil, fmt.Errorf("%s isn't an input type", t)
+}
+
+// Whether a value is given, which isn't the case of variables without a value
+func (e *executor) isProvided(val *value) bool {
+	if val.kind != valueVariable {
+		return true
+	}
+	_, ok := e.variables[val.raw]
+	return ok
+}
+
+func findArgumentNode(arguments []*argument, name string) *argument {
+	for _, arg := range arguments {
+		if arg.name == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+// Coerce the arguments given to a field or directive
+func (e *executor) coerceArguments(definitions []*Argument, arguments []*argument) (map[string]any, error) {
+	coerced := map[string]any{}
+
+	for _, definition := range definitions {
+		node := findArgumentNode(arguments, definition.Name)
+		if node == nil || !e.isProvided(node.value) {
+			if definition.Default != nil {
+				coerced[definition.Name] = definition.Default
+			} else if _, required := definition.Type.(*NonNull); required {
+				return nil, fmt.Errorf("argument %q of type %s is required", definition.Name, definition.Type)
+			}
+			continue
+		}
+
+		value, err := e.coerceLiteral(node.value, definition.Type)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %w", definition.Name, err)
+		}
+		coerced[definition.Name] = value
+	}
+
+	return coerced, nil
+}
+
+// Fields of a selection set sharing a response key, which are merged
+type fieldGroup struct {
+	key    string
+	fields []*fieldSelection
+}
+
+// Group the fields selected on an object by response key, in order, applying
+// fragments and the @skip and @include directives
+func (e *executor) collectFields(object *Object, selections []selection) []*fieldGroup {
+	var groups []*fieldGroup
+	index := map[string]*fieldGroup{}
+	visited := map[string]bool{}
+
+	var collect func(selections []selection)
+	collect = func(selections []selection) {
+		for _, s := range selections {
+			switch s := s.(type) {
+			case *fieldSelection:
+				if !e.included(s.directives) {
+					continue
+				}
+
+				key := s.responseKey()
+				group, ok := index[key]
+				if !ok {
+					group = &fieldGroup{key: key}
+					index[key] = group
+					groups = append(groups, group)
+				}
+				group.fields = append(group.fields, s)
+
+			case *fragmentSpread:
+				if visited[s.name] || !e.included(s.directives) {
+					continue
+				}
+				visited[s.name] = true
+
+				fragment := e.fragments[s.name]
+				if fragment.typeCondition == object.Name {
+					collect(fragment.selectionSet)
+				}
+
+			case *inlineFragment:
+				if !e.included(s.directives) {
+					continue
+				}
+				if s.typeCondition == "" || s.typeCondition == object.Name {
+					collect(s.selectionSet)
+				}
+			}
+		}
+	}
+
+	collect(selections)
+	return groups
+}
+
+// Whether the @skip and @include directives of a selection keep it
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		args, err := e.coerceArguments(directiveArgs, d.arguments)
+		if err != nil {
+			continue
+		}
+
+		condition, _ := args["if"].(bool)
+		if (d.name == "skip" && condition) || (d.name == "include" && !condition) {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the selections of the fields of a group, merged
+func mergeSelectionSets(fields []*fieldSelection) []selection {
+	if len(fields) == 1 {
+		return fields[0].selectionSet
+	}
+
+	var selections []selection
+	for _, field := range fields {
+		selections = append(selections, field.selectionSet...)
+	}
+	return selections
+}
+
+// Return the cost of the fields selected on an object, failing as soon as the
+// depth or complexity limit is exceeded, or fields of a group can't be merged
+func (e *executor) analyze(object *Object, selections []selection, depth int) (int, *Error) {
+	groups := e.collectFields(object, selections)
+
+	if e.limits.MaxDepth > 0 && depth > e.limits.MaxDepth && len(groups) > 0 {
+		return 0, &Error{
+			Message:   fmt.Sprintf("the query is nested deeper than the maximum of %d levels", e.limits.MaxDepth),
+			Locations: []Location{groups[0].fields[0].loc},
+		}
+	}
+
+	total := 0
+	for _, group := range groups {
+		field := group.fields[0]
+
+		for _, other := range group.fields[1:] {
+			if other.name != field.name || !sameArguments(other.arguments, field.arguments) {
+				return 0, &Error{
+					Message: fmt.Sprintf(
+						"fields %q conflict because they are different fields or have different arguments, "+
+							"use different aliases on the fields to fetch both",
+						group.key,
+					),
+					Locations: []Location{field.loc, other.loc},
+				}
+			}
+		}
+
+		if field.name == "__typename" {
+			total++
+			continue
+		}
+
+		definition := object.field(field.name)
+
+		args, err := e.coerceArguments(definition.Args, field.arguments)
+		if err != nil {
+			return 0, &Error{Message: err.Error(), Locations: []Location{field.loc}}
+		}
+
+		childComplexity := 0
+		if sub, ok := namedType(definition.Type).(*Object); ok {
+			var childErr *Error
+			childComplexity, childErr = e.analyze(sub, mergeSelectionSets(group.fields), depth+1)
+			if childErr != nil {
+				return 0, childErr
+			}
+		}
+
+		complexity := 1 + childComplexity
+		if definition.Complexity != nil {
+			complexity = definition.Complexity(args, childComplexity)
+		}
+
+		total += complexity
+		if e.limits.MaxComplexity > 0 && total > e.limits.MaxComplexity {
+			return 0, &Error{
+				Message:   fmt.Sprintf("the query is more complex than the maximum of %d", e.limits.MaxComplexity),
+				Locations: []Location{field.loc},
+			}
+		}
+	}
+
+	return total, nil
+}
+
+// Whether two lists of arguments are the same, regardless of their order
+func sameArguments(a, b []*argument) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, arg := range a {
+		other := findArgumentNode(b, arg.name)
+		if other == nil || !sameValue(arg.value, other.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameValue(a, b *value) bool {
+	if a.kind != b.kind || a.raw != b.raw || len(a.list) != len(b.list) {
+		return false
+	}
+
+	for i := range a.list {
+		if !sameValue(a.list[i], b.list[i]) {
+			return false
+		}
+	}
+	return sameArguments(a.fields, b.fields)
+}
+
+// Resolve the fields selected on an object. It returns false if a non-null field
+// is null, which makes the object null
+func (e *executor) executeSelectionSet(object *Object, source any, selections []selection, path []any) (any, bool) {
+	groups := e.collectFields(object, selections)
+	result := make(resultObject, 0, len(groups))
+
+	for _, group := range groups {
+		field := group.fields[0]
+
+		if field.name == "__typename" {
+			result = append(result, resultField{group.key, object.Name})
+			continue
+		}
+
+		value, ok := e.executeField(object.field(field.name), source, group.fields, appendPath(path, group.key))
+		if !ok {
+			return nil, false
+		}
+		result = append(result, resultField{group.key, value})
+	}
+
+	return result, true
+}
+
+func (e *executor) executeField(definition *Field, source any, fields []*fieldSelection, path []any) (any, bool) {
+	args, err := e.coerceArguments(definition.Args, fields[0].arguments)
+
+	var value any
+	if err == nil {
+		if definition.Resolve != nil {
+			value, err = definition.Resolve(source, args)
+		} else {
+			value, err = defaultResolve(source, definition.Name)
+		}
+	}
+
+	if err != nil {
+		e.addError(err, fields[0].loc, path)
+		_, nonNull := definition.Type.(*NonNull)
+		return nil, !nonNull
+	}
+
+	return e.completeValue(definition.Type, fields, value, path)
+}
+
+// Convert the value of a field to the result, according to its type. It returns
+// false if the value is null but its type is non-null, for the null to propagate
+// to the closest nullable field
+func (e *executor) completeValue(t Type, fields []*fieldSelection, value any, path []any) (any, bool) {
+	nonNull, ok := t.(*NonNull)
+	if !ok {
+		result, ok := e.completeNullable(t, fields, value, path)
+		if !ok {
+			return nil, true
+		}
+		return result, true
+	}
+
+	result, ok := e.completeNullable(nonNull.OfType, fields, value, path)
+	if !ok {
+		return nil, false
+	}
+	if result == nil {
+		e.addError(fmt.Errorf("cannot return null for non-nullable type %s", t), fields[0].loc, path)
+		return nil, false
+	}
+	return result, true
+}
+
+func (e *executor) completeNullable(t Type, fields []*fieldSelection, value any, path []any) (any, bool) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, true
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.IsNil()) {
+		return nil, true
+	}
+
+	switch t := t.(type) {
+	case *Scalar:
+		result, err := t.Serialize(v.Interface())
+		if err != nil {
+			e.addError(err, fields[0].loc, path)
+			return nil, false
+		}
+		return result, true
+
+	case *Object:
+		return e.executeSelectionSet(t, value, mergeSelectionSets(fields), path)
+
+	case *List:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			e.addError(fmt.Errorf("expected a list for type %s", t), fields[0].loc, path)
+			return nil, false
+		}
+
+		items := make([]any, v.Len())
+		for i := range items {
+			item, ok := e.completeValue(t.OfType, fields, v.Index(i).Interface(), appendPath(path, i))
+			if !ok {
+				return nil, false
+			}
+			items[i] = item
+		}
+		return items, true
+	}
+
+	e.addError(fmt.Errorf("%s isn't an output type", t), fields[0].loc, path)
+	return nil, false
+}
+
+// Read a field from a map by key, or from a struct by the name in its `json` tag
+func defaultResolve(source any, name string) (any, error) {
+	v := reflect.ValueOf(source)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			value := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if !value.IsValid() {
+				return nil, nil
+			}
+			return value.Interface(), nil
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if field.IsExported() && (tag == name || (tag == "" && field.Name == name)) {
+				return v.Field(i).Interface(), nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no resolver for field %q", name)
+}
+
+// Return a copy of path followed by a key or index, as paths are shared by fields
+func appendPath(path []any, element any) []any {
+	return append(slices.Clip(path), element)
+}
+
+// An object of the result, whose fields keep the order they were selected in
+type resultObject []resultField
+
+type resultField struct {
+	key   string
+	value any
+}
+
+func (o resultObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,160 @@
+package graphql
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A schema of nodes nested without end, counting the fields it resolves
+func newNodeSchema(t *testing.T, resolved *int) *Schema {
+	t.Helper()
+
+	resolveNode := func(source any, args map[string]any) (any, error) {
+		*resolved++
+		return map[string]any{"name": "node"}, nil
+	}
+
+	node := &Object{Name: "Node", Fields: []*Field{{Name: "name", Type: String}}}
+	node.Fields = append(node.Fields,
+		&Field{Name: "child", Type: node, Resolve: resolveNode},
+		&Field{
+			Name: "children",
+			Type: ListOf(node),
+			Args: []*Argument{{Name: "first", Type: Int, Default: 10}},
+			Resolve: func(source any, args map[string]any) (any, error) {
+				*resolved++
+				children := make([]any, args["first"].(int))
+				for i := range children {
+					children[i] = map[string]any{"name": "node"}
+				}
+				return children, nil
+			},
+			// Each child costs what's selected on it
+			Complexity: func(args map[string]any, childComplexity int) int {
+				return args["first"].(int) * childComplexity
+			},
+		},
+	)
+
+	query := &Object{Name: "Query", Fields: []*Field{{Name: "node", Type: node, Resolve: resolveNode}}}
+
+	schema, err := NewSchema(query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestExecuteLimits(t *testing.T) {
+	limits := Limits{MaxDepth: 3, MaxComplexity: 10}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		limits    Limits
+		// Part of the error message, empty if the query runs
+		err string
+	}{
+		{
+			name:  "at the maximum depth",
+			query: `{ node { child { name } } }`,
+		},
+		{
+			name:  "too deep",
+			query: `{ node { child { child { name } } } }`,
+			err:   "nested deeper than the maximum of 3 levels",
+		},
+		{
+			name:  "too deep through fragments",
+			query: `{ node { ...Child } } fragment Child on Node { child { child { name } } }`,
+			err:   "nested deeper than the maximum of 3 levels",
+		},
+		{
+			name:  "too deep through inline fragments",
+			query: `{ node { ... on Node { child { child { name } } } } }`,
+			err:   "nested deeper than the maximum of 3 levels",
+		},
+		{
+			name:   "no depth limit",
+			query:  `{ node { child { child { child { child { name } } } } } }`,
+			limits: Limits{MaxComplexity: 10},
+		},
+		{
+			// 1 for node, 3 times 1 for the names of the children
+			name:  "within the complexity limit",
+			query: `{ node { children(first: 3) { name } } }`,
+		},
+		{
+			name:  "too complex",
+			query: `{ node { children(first: 20) { name } } }`,
+			err:   "more complex than the maximum of 10",
+		},
+		{
+			name:  "too complex through variables",
+			query: `query ($first: Int) { node { children(first: $first) { name } } }`,
+			// As decoded from the JSON of a request
+			variables: map[string]any{"first": float64(20)},
+			err:       "more complex than the maximum of 10",
+		},
+		{
+			// 1 for node and 10 for the children of the default argument
+			name:  "too complex through a default argument",
+			query: `{ node { children { name } } }`,
+			err:   "more complex than the maximum of 10",
+		},
+		{
+			name:  "too complex through aliases",
+			query: `{ a: node { name } b: node { name } c: node { name } d: node { name } e: node { name } f: node { name } }`,
+			err:   "more complex than the maximum of 10",
+		},
+		{
+			// The same field selected twice only counts once
+			name:  "merged fields",
+			query: `{ node { children(first: 5) { name } children(first: 5) { name } } }`,
+		},
+		{
+			name:   "no complexity limit",
+			query:  `{ node { children(first: 20) { name } } }`,
+			limits: Limits{MaxDepth: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resolved int
+			schema := newNodeSchema(t, &resolved)
+
+			l := limits
+			if tt.limits != (Limits{}) {
+				l = tt.limits
+			}
+
+			result, err := schema.Execute(Request{Query: tt.query, Variables: tt.variables}, nil, l)
+
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				if len(result.Errors) > 0 || result.Data == nil {
+					t.Errorf("got result %+v, want data without errors", result)
+				}
+				return
+			}
+
+			var errs Errors
+			if !errors.As(err, &errs) || len(errs) != 1 || !strings.Contains(errs[0].Message, tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if len(errs[0].Locations) == 0 {
+				t.Error("got an error without a location")
+			}
+
+			// Limits are checked before anything is resolved
+			if resolved != 0 {
+				t.Errorf("got %d fields resolved, want none", resolved)
+			}
+		})
+	}
+}
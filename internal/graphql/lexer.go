@@ -0,0 +1,368 @@
+package graphql
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+// A lexical token of a document
+type token struct {
+	kind tokenKind
+	// The punctuator, name or number as written, or the value of a string
+	value string
+	loc   Location
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "<EOF>"
+	case tokenString:
+		return strconv.Quote(t.value)
+	default:
+		return t.value
+	}
+}
+
+// Splits a document into tokens, skipping whitespace, commas and comments
+type lexer struct {
+	src       string
+	pos       int
+	line      int
+	lineStart int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src, line: 1}
+}
+
+// Location of the given byte offset, on the current line
+func (l *lexer) location(pos int) Location {
+	return Location{Line: l.line, Column: utf8.RuneCountInString(l.src[l.lineStart:pos]) + 1}
+}
+
+func (l *lexer) newLine(pos int) {
+	l.line++
+	l.lineStart = pos
+}
+
+// Read the next token
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+
+	start := l.pos
+	loc := l.location(start)
+
+	if start >= len(l.src) {
+		return token{kind: tokenEOF, loc: loc}, nil
+	}
+
+	c := l.src[start]
+
+	switch {
+	case strings.IndexByte("!$&()[]{}:=@|", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunctuator, value: string(c), loc: loc}, nil
+
+	case c == '.':
+		if strings.HasPrefix(l.src[start:], "...") {
+			l.pos += 3
+			return token{kind: tokenPunctuator, value: "...", loc: loc}, nil
+		}
+		return token{}, syntaxError(loc, "unexpected %q", ".")
+
+	case isNameStart(c):
+		l.pos++
+		for l.pos < len(l.src) && isNameContinue(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], loc: loc}, nil
+
+	case c == '-' || isDigit(c):
+		return l.readNumber(loc)
+
+	case c == '"':
+		if strings.HasPrefix(l.src[start:], `"""`) {
+			return l.readBlockString(loc)
+		}
+		return l.readString(loc)
+	}
+
+	r, _ := utf8.DecodeRuneInString(l.src[start:])
+	return token{}, syntaxError(loc, "unexpected character %q", r)
+}
+
+// Skip whitespace, line terminators, commas, byte order marks and comments
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; c {
+		case ' ', '\t', ',':
+			l.pos++
+		case '\n':
+			l.pos++
+			l.newLine(l.pos)
+		case '\r':
+			l.pos++
+			if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+				l.pos++
+			}
+			l.newLine(l.pos)
+		case '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			if strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+				l.pos += len("\uFEFF")
+				continue
+			}
+			return
+		}
+	}
+}
+
+// Read an IntValue or FloatValue, e.g. -12, 1.5 or 6.02e23
+func (l *lexer) readNumber(loc Location) (token, error) {
+	start := l.pos
+	kind := tokenInt
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+
+	digits := func() error {
+		if l.pos >= len(l.src) || !isDigit(l.src[l.pos]) {
+			return syntaxError(l.location(l.pos), "invalid number, expected a digit")
+		}
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return nil
+	}
+
+	// Leading zeros aren't allowed
+	if l.pos < len(l.src) && l.src[l.pos] == '0' {
+		l.pos++
+		if l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			return token{}, syntaxError(l.location(l.pos), "invalid number, unexpected digit after 0")
+		}
+	} else if err := digits(); err != nil {
+		return token{}, err
+	}
+
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if err := digits(); err != nil {
+			return token{}, err
+		}
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if err := digits(); err != nil {
+			return token{}, err
+		}
+	}
+
+	// Numbers must be separated from the names and numbers following them
+	if l.pos < len(l.src) && (l.src[l.pos] == '.' || isNameStart(l.src[l.pos])) {
+		return token{}, syntaxError(l.location(l.pos), "invalid number, unexpected %q", l.src[l.pos])
+	}
+
+	return token{kind: kind, value: l.src[start:l.pos], loc: loc}, nil
+}
+
+// Read a StringValue between double quotes, decoding its escape sequences
+func (l *lexer) readString(loc Location) (token, error) {
+	l.pos++
+
+	var value strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokenString, value: value.String(), loc: loc}, nil
+
+		case c == '\n' || c == '\r':
+			return token{}, syntaxError(l.location(l.pos), "unterminated string")
+
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, syntaxError(l.location(l.pos), "unterminated string")
+			}
+
+			escape := l.src[l.pos+1]
+			if decoded, ok := simpleEscapes[escape]; ok {
+				value.WriteByte(decoded)
+				l.pos += 2
+				continue
+			}
+			if escape != 'u' {
+				return token{}, syntaxError(l.location(l.pos), "invalid escape sequence \\%c", escape)
+			}
+
+			r, size, ok := readUnicodeEscape(l.src[l.pos:])
+			if !ok {
+				return token{}, syntaxError(l.location(l.pos), "invalid unicode escape sequence")
+			}
+			value.WriteRune(r)
+			l.pos += size
+
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			if r < ' ' && r != '\t' {
+				return token{}, syntaxError(l.location(l.pos), "invalid character %q in string", r)
+			}
+			value.WriteRune(r)
+			l.pos += size
+		}
+	}
+
+	return token{}, syntaxError(l.location(l.pos), "unterminated string")
+}
+
+var simpleEscapes = map[byte]byte{
+	'"':  '"',
+	'\\': '\\',
+	'/':  '/',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+}
+
+// Decode a \uXXXX escape sequence, or a surrogate pair of them, at the start of s
+func readUnicodeEscape(s string) (rune, int, bool) {
+	hex := func(s string) (rune, bool) {
+		if len(s) < 6 || s[0] != '\\' || s[1] != 'u' {
+			return 0, false
+		}
+		n, err := strconv.ParseUint(s[2:6], 16, 32)
+		return rune(n), err == nil
+	}
+
+	r, ok := hex(s)
+	if !ok {
+		return 0, 0, false
+	}
+
+	switch {
+	case r >= 0xD800 && r <= 0xDBFF:
+		low, ok := hex(s[6:])
+		if !ok || low < 0xDC00 || low > 0xDFFF {
+			return 0, 0, false
+		}
+		return (r-0xD800)<<10 + (low - 0xDC00) + 0x10000, 12, true
+	case r >= 0xDC00 && r <= 0xDFFF:
+		return 0, 0, false
+	}
+
+	return r, 6, true
+}
+
+// Read a block string between triple quotes, which only escapes triple quotes and
+// is dedented like a heredoc
+func (l *lexer) readBlockString(loc Location) (token, error) {
+	l.pos += 3
+
+	var raw strings.Builder
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			l.pos += 3
+			return token{kind: tokenString, value: blockStringValue(raw.String()), loc: loc}, nil
+
+		case strings.HasPrefix(l.src[l.pos:], `\"""`):
+			raw.WriteString(`"""`)
+			l.pos += 4
+
+		case l.src[l.pos] == '\n':
+			raw.WriteByte('\n')
+			l.pos++
+			l.newLine(l.pos)
+
+		case l.src[l.pos] == '\r':
+			raw.WriteByte('\n')
+			l.pos++
+			if l.pos < len(l.src) && l.src[l.pos] == '\n' {
+				l.pos++
+			}
+			l.newLine(l.pos)
+
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			if r < ' ' && r != '\t' {
+				return token{}, syntaxError(l.location(l.pos), "invalid character %q in string", r)
+			}
+			raw.WriteRune(r)
+			l.pos += size
+		}
+	}
+
+	return token{}, syntaxError(l.location(l.pos), "unterminated string")
+}
+
+// Remove the indentation common to the lines of a block string but the first, and
+// its leading and trailing blank lines
+func blockStringValue(raw string) string {
+	lines := strings.Split(raw, "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			lines[i] = lines[i][min(indent, len(lines[i])):]
+		}
+	}
+
+	isBlank := func(line string) bool { return strings.TrimLeft(line, " \t") == "" }
+	for len(lines) > 0 && isBlank(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && isBlank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
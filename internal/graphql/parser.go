@@ -0,0 +1,639 @@
+package graphql
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Deepest nesting of selection sets, values and types a document may have, so
+// that parsing hostile documents can't exhaust the stack
+const maxNesting = 100
+
+// An executable document: operations and the fragments they use
+type document struct {
+	operations []*operationDefinition
+	fragments  []*fragmentDefinition
+}
+
+type operationDefinition struct {
+	operation    string // query, mutation or subscription
+	name         string // empty for anonymous operations
+	variables    []*variableDefinition
+	directives   []*directive
+	selectionSet []selection
+	loc          Location
+}
+
+type variableDefinition struct {
+	name         string
+	typ          *typeRef
+	defaultValue *value // nil without a default
+	loc          Location
+}
+
+// A type as written in variable definitions, e.g. [String!]!
+type typeRef struct {
+	name    string   // named types
+	elem    *typeRef // list types
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+type fragmentDefinition struct {
+	name          string
+	typeCondition string
+	directives    []*directive
+	selectionSet  []selection
+	loc           Location
+}
+
+// A *fieldSelection, *fragmentSpread or *inlineFragment
+type selection interface {
+	location() Location
+}
+
+type fieldSelection struct {
+	alias        string // empty without an alias
+	name         string
+	arguments    []*argument
+	directives   []*directive
+	selectionSet []selection
+	loc          Location
+}
+
+// Name of the field in the response
+func (f *fieldSelection) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	loc        Location
+}
+
+type inlineFragment struct {
+	typeCondition string // empty without one
+	directives    []*directive
+	selectionSet  []selection
+	loc           Location
+}
+
+func (f *fieldSelection) location() Location { return f.loc }
+func (f *fragmentSpread) location() Location { return f.loc }
+func (f *inlineFragment) location() Location { return f.loc }
+
+type argument struct {
+	name  string
+	value *value
+	loc   Location
+}
+
+type directive struct {
+	name      string
+	arguments []*argument
+	loc       Location
+}
+
+type valueKind int
+
+const (
+	valueVariable valueKind = iota
+	valueInt
+	valueFloat
+	valueString
+	valueBoolean
+	valueNull
+	valueEnum
+	valueList
+	valueObject
+)
+
+// A value written in a document
+type value struct {
+	kind   valueKind
+	raw    string // variable name, number, string, true/false or enum value
+	list   []*value
+	fields []*argument // fields of objects
+	loc    Location
+}
+
+// Parses documents into their syntax tree
+type parser struct {
+	lexer   *lexer
+	token   token
+	nesting int
+}
+
+// Parse an executable document. Type system definitions aren't supported
+func parse(src string) (*document, error) {
+	p := &parser{lexer: newLexer(src)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &document{}
+
+	if p.token.kind == tokenEOF {
+		return nil, syntaxError(p.token.loc, "the document has no operations")
+	}
+
+	for p.token.kind != tokenEOF {
+		switch {
+		case p.peek("{"):
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+
+		case p.token.kind == tokenName && slices.Contains([]string{"query", "mutation", "subscription"}, p.token.value):
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+
+		case p.token.kind == tokenName && p.token.value == "fragment":
+			fragment, err := p.parseFragmentDefinition()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments = append(doc.fragments, fragment)
+
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	token, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = token
+	return nil
+}
+
+// Whether the current token is the given punctuator
+func (p *parser) peek(punctuator string) bool {
+	return p.token.kind == tokenPunctuator && p.token.value == punctuator
+}
+
+// Consume the current token if it's the given punctuator
+func (p *parser) skip(punctuator string) (bool, error) {
+	if !p.peek(punctuator) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+// Consume the given punctuator, failing if it's not the current token
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return syntaxError(p.token.loc, "expected %q, found %s", punctuator, p.token)
+	}
+	return p.advance()
+}
+
+// Consume a name, failing if the current token isn't one
+func (p *parser) expectName() (string, error) {
+	if p.token.kind != tokenName {
+		return "", syntaxError(p.token.loc, "expected a name, found %s", p.token)
+	}
+	name := p.token.value
+	return name, p.advance()
+}
+
+// Consume the given keyword, failing if it's not the current token
+func (p *parser) expectKeyword(keyword string) error {
+	if p.token.kind != tokenName || p.token.value != keyword {
+		return syntaxError(p.token.loc, "expected %q, found %s", keyword, p.token)
+	}
+	return p.advance()
+}
+
+func (p *parser) unexpected() error {
+	return syntaxError(p.token.loc, "unexpected %s", p.token)
+}
+
+// Track the nesting of the construct being parsed, failing when it's too deep
+func (p *parser) nest() error {
+	p.nesting++
+	if p.nesting > maxNesting {
+		return syntaxError(p.token.loc, "the document is nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) parseOperation() (*operationDefinition, error) {
+	op := &operationDefinition{operation: "query", loc: p.token.loc}
+
+	// The query shorthand has no operation type, name or variables
+	if !p.peek("{") {
+		op.operation = p.token.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.token.kind == tokenName {
+			op.name = p.token.value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		if op.variables, err = p.parseVariableDefinitions(); err != nil {
+			return nil, err
+		}
+		if op.directives, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	op.selectionSet, err = p.parseSelectionSet()
+	return op, err
+}
+
+func (p *parser) parseVariableDefinitions() ([]*variableDefinition, error) {
+	if ok, err := p.skip("("); !ok || err != nil {
+		return nil, err
+	}
+
+	var definitions []*variableDefinition
+	for {
+		definition := &variableDefinition{loc: p.token.loc}
+
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if definition.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		if definition.typ, err = p.parseTypeRef(); err != nil {
+			return nil, err
+		}
+
+		if ok, err := p.skip("="); err != nil {
+			return nil, err
+		} else if ok {
+			if definition.defaultValue, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+		}
+
+		// Directives on variables aren't used, but they're valid syntax
+		if _, err = p.parseDirectives(); err != nil {
+			return nil, err
+		}
+
+		definitions = append(definitions, definition)
+
+		if ok, err := p.skip(")"); ok || err != nil {
+			return definitions, err
+		}
+	}
+}
+
+func (p *parser) parseTypeRef() (*typeRef, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { p.nesting-- }()
+
+	t := &typeRef{}
+
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		if t.elem, err = p.parseTypeRef(); err != nil {
+			return nil, err
+		}
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else {
+		if t.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	t.nonNull, err = p.skip("!")
+	return t, err
+}
+
+func (p *parser) parseFragmentDefinition() (*fragmentDefinition, error) {
+	fragment := &fragmentDefinition{loc: p.token.loc}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if fragment.name, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if fragment.name == "on" {
+		return nil, syntaxError(fragment.loc, "a fragment can't be named \"on\"")
+	}
+	if err = p.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	if fragment.typeCondition, err = p.expectName(); err != nil {
+		return nil, err
+	}
+	if fragment.directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	fragment.selectionSet, err = p.parseSelectionSet()
+	return fragment, err
+}
+
+func (p *parser) parseSelectionSet() ([]selection, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { p.nesting-- }()
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var selections []selection
+	for {
+		var s selection
+		var err error
+
+		if p.peek("...") {
+			s, err = p.parseFragment()
+		} else {
+			s, err = p.parseField()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		selections = append(selections, s)
+
+		if ok, err := p.skip("}"); ok || err != nil {
+			return selections, err
+		}
+	}
+}
+
+func (p *parser) parseField() (*fieldSelection, error) {
+	field := &fieldSelection{loc: p.token.loc}
+
+	var err error
+	if field.name, err = p.expectName(); err != nil {
+		return nil, err
+	}
+
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		field.alias = field.name
+		if field.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+
+	if field.arguments, err = p.parseArguments(false); err != nil {
+		return nil, err
+	}
+	if field.directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	if p.peek("{") {
+		field.selectionSet, err = p.parseSelectionSet()
+	}
+	return field, err
+}
+
+// Parse a fragment spread or an inline fragment
+func (p *parser) parseFragment() (selection, error) {
+	loc := p.token.loc
+
+	if err := p.expect("..."); err != nil {
+		return nil, err
+	}
+
+	if p.token.kind == tokenName && p.token.value != "on" {
+		spread := &fragmentSpread{name: p.token.value, loc: loc}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		var err error
+		spread.directives, err = p.parseDirectives()
+		return spread, err
+	}
+
+	fragment := &inlineFragment{loc: loc}
+
+	if p.token.kind == tokenName {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if fragment.typeCondition, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if fragment.directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	fragment.selectionSet, err = p.parseSelectionSet()
+	return fragment, err
+}
+
+func (p *parser) parseArguments(constant bool) ([]*argument, error) {
+	if ok, err := p.skip("("); !ok || err != nil {
+		return nil, err
+	}
+
+	var arguments []*argument
+	for {
+		arg := &argument{loc: p.token.loc}
+
+		var err error
+		if arg.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		if err = p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.value, err = p.parseValue(constant); err != nil {
+			return nil, err
+		}
+
+		arguments = append(arguments, arg)
+
+		if ok, err := p.skip(")"); ok || err != nil {
+			return arguments, err
+		}
+	}
+}
+
+func (p *parser) parseDirectives() ([]*directive, error) {
+	var directives []*directive
+
+	for p.peek("@") {
+		d := &directive{loc: p.token.loc}
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if d.name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+		if d.arguments, err = p.parseArguments(false); err != nil {
+			return nil, err
+		}
+
+		directives = append(directives, d)
+	}
+
+	return directives, nil
+}
+
+// Parse a value, which can't contain variables if it's constant, e.g. a default
+func (p *parser) parseValue(constant bool) (*value, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { p.nesting-- }()
+
+	v := &value{loc: p.token.loc, raw: p.token.value}
+
+	switch p.token.kind {
+	case tokenInt:
+		v.kind = valueInt
+	case tokenFloat:
+		v.kind = valueFloat
+	case tokenString:
+		v.kind = valueString
+	case tokenName:
+		switch p.token.value {
+		case "true", "false":
+			v.kind = valueBoolean
+		case "null":
+			v.kind = valueNull
+		default:
+			v.kind = valueEnum
+		}
+
+	case tokenPunctuator:
+		switch p.token.value {
+		case "$":
+			if constant {
+				return nil, syntaxError(v.loc, "unexpected variable in a constant value")
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+
+			var err error
+			v.kind = valueVariable
+			v.raw, err = p.expectName()
+			return v, err
+
+		case "[":
+			v.kind = valueList
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			for {
+				if ok, err := p.skip("]"); ok || err != nil {
+					return v, err
+				}
+
+				item, err := p.parseValue(constant)
+				if err != nil {
+					return nil, err
+				}
+				v.list = append(v.list, item)
+			}
+
+		case "{":
+			v.kind = valueObject
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			for {
+				if ok, err := p.skip("}"); ok || err != nil {
+					return v, err
+				}
+
+				field := &argument{loc: p.token.loc}
+
+				var err error
+				if field.name, err = p.expectName(); err != nil {
+					return nil, err
+				}
+				if err = p.expect(":"); err != nil {
+					return nil, err
+				}
+				if field.value, err = p.parseValue(constant); err != nil {
+					return nil, err
+				}
+				v.fields = append(v.fields, field)
+			}
+		}
+
+		return nil, p.unexpected()
+
+	default:
+		return nil, p.unexpected()
+	}
+
+	return v, p.advance()
+}
+
+// Describe a value for error messages
+func (v *value) String() string {
+	switch v.kind {
+	case valueVariable:
+		return "$" + v.raw
+	case valueString:
+		return fmt.Sprintf("%q", v.raw)
+	case valueList:
+		return "a list"
+	case valueObject:
+		return "an object"
+	default:
+		return v.raw
+	}
+}
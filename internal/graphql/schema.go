@@ -0,0 +1,449 @@
+// Package graphql executes GraphQL queries and mutations, as described by the
+// October 2021 specification, against a schema of objects whose fields are
+// resolved by Go functions. Interfaces, unions, enums, subscriptions and
+// introspection aren't supported.
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A type of the schema: a *Scalar, *Object, *InputObject, *List or *NonNull
+type Type interface {
+	String() string
+}
+
+// A leaf type, e.g. Int
+type Scalar struct {
+	Name        string
+	Description string
+	// Convert a value returned by a resolver to its JSON representation
+	Serialize func(v any) (any, error)
+	// Convert an input value to the value resolvers receive. Literals and variables
+	// are given as an int64 (Int literals), float64, string or bool
+	Parse func(v any) (any, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+// A type with fields, which resolve to other objects or scalars
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+func (o *Object) String() string { return o.Name }
+
+// Return the field with the given name, nil if there's none
+func (o *Object) field(name string) *Field {
+	for _, field := range o.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// A field of an object
+type Field struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*Argument
+	// Return the value of the field of source, the value of the object the field is
+	// selected on, given the coerced arguments. Arguments that aren't given and have
+	// no default are left out of args. Fields without a resolver are read from maps
+	// by key and from structs by the name in their `json` tag
+	Resolve func(source any, args map[string]any) (any, error)
+	// Return the cost of the field given its arguments and the cost of the fields
+	// selected on it, by default 1 plus the latter
+	Complexity func(args map[string]any, childComplexity int) int
+}
+
+// An argument of a field, or a field of an input object
+type Argument struct {
+	Name        string
+	Description string
+	Type        Type
+	// Value given when the argument is left out, already coerced, nil for none
+	Default any
+}
+
+// An object given as an argument
+type InputObject struct {
+	Name        string
+	Description string
+	Fields      []*Argument
+}
+
+func (o *InputObject) String() string { return o.Name }
+
+func (o *InputObject) field(name string) *Argument {
+	for _, field := range o.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// A list of values of a type
+type List struct {
+	OfType Type
+}
+
+func (l *List) String() string { return "[" + l.OfType.String() + "]" }
+
+// A type whose values can't be null
+type NonNull struct {
+	OfType Type
+}
+
+func (n *NonNull) String() string { return n.OfType.String() + "!" }
+
+// Shorthand for a list of values of t
+func ListOf(t Type) *List {
+	return &List{OfType: t}
+}
+
+// Shorthand for the non-null version of t
+func NonNullOf(t Type) *NonNull {
+	return &NonNull{OfType: t}
+}
+
+// Return the type t wraps in lists and non-nulls
+func namedType(t Type) Type {
+	for {
+		switch wrapper := t.(type) {
+		case *List:
+			t = wrapper.OfType
+		case *NonNull:
+			t = wrapper.OfType
+		default:
+			return t
+		}
+	}
+}
+
+// A schema, from its root operation types
+type Schema struct {
+	Query    *Object
+	Mutation *Object // nil without mutations
+	// Named types reachable from the root types
+	types map[string]Type
+}
+
+// Build a schema with the given root types, mutation may be nil. It fails if
+// two types share a name or an input type is used as an output type or the
+// other way around
+func NewSchema(query, mutation *Object) (*Schema, error) {
+	s := &Schema{Query: query, Mutation: mutation, types: map[string]Type{}}
+
+	for _, scalar := range []*Scalar{Int, Float, String, Boolean, ID} {
+		s.types[scalar.Name] = scalar
+	}
+
+	var addType func(t Type, input bool) error
+	addType = func(t Type, input bool) error {
+		t = namedType(t)
+
+		name := t.String()
+		if existing, ok := s.types[name]; ok {
+			if existing != t {
+				return fmt.Errorf("graphql: two types are named %s", name)
+			}
+			return nil
+		}
+
+		switch t := t.(type) {
+		case *Scalar:
+			s.types[name] = t
+
+		case *Object:
+			if input {
+				return fmt.Errorf("graphql: object %s can't be used as an input type", name)
+			}
+			s.types[name] = t
+
+			for _, field := range t.Fields {
+				if strings.HasPrefix(field.Name, "__") {
+					return fmt.Errorf("graphql: field %s.%s can't start with __", name, field.Name)
+				}
+				if err := addType(field.Type, false); err != nil {
+					return err
+				}
+				for _, arg := range field.Args {
+					if err := addType(arg.Type, true); err != nil {
+						return err
+					}
+				}
+			}
+
+		case *InputObject:
+			if !input {
+				return fmt.Errorf("graphql: input object %s can't be used as an output type", name)
+			}
+			s.types[name] = t
+
+			for _, field := range t.Fields {
+				if err := addType(field.Type, true); err != nil {
+					return err
+				}
+			}
+
+		default:
+			return fmt.Errorf("graphql: unsupported type %s", name)
+		}
+
+		return nil
+	}
+
+	if query == nil {
+		return nil, errors.New("graphql: a schema must have a query type")
+	}
+
+	for _, root := range []*Object{query, mutation} {
+		if root == nil {
+			continue
+		}
+		if err := addType(root, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Return the type of the schema a type reference of a document names, nil if
+// there's no such type
+func (s *Schema) typeOf(ref *typeRef) Type {
+	var t Type
+	if ref.elem != nil {
+		elem := s.typeOf(ref.elem)
+		if elem == nil {
+			return nil
+		}
+		t = ListOf(elem)
+	} else {
+		named, ok := s.types[ref.name]
+		if !ok {
+			return nil
+		}
+		t = named
+	}
+
+	if ref.nonNull {
+		t = NonNullOf(t)
+	}
+	return t
+}
+
+// Whether t can be the type of an argument or variable
+func isInputType(t Type) bool {
+	switch namedType(t).(type) {
+	case *Scalar, *InputObject:
+		return true
+	}
+	return false
+}
+
+// Whether t can have fields selected on it
+func isCompositeType(t Type) bool {
+	_, ok := namedType(t).(*Object)
+	return ok
+}
+
+// A location in a document, from 1:1
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// An error of a request, or of a field of its result, as reported to clients.
+// Resolvers may return one (or wrap one) to set extensions, e.g. an error code
+type Error struct {
+	Message    string         `json:"message"`
+	Locations  []Location     `json:"locations,omitempty"`
+	Path       []any          `json:"path,omitempty"`
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func syntaxError(loc Location, format string, a ...any) *Error {
+	return &Error{Message: "syntax error: " + fmt.Sprintf(format, a...), Locations: []Location{loc}}
+}
+
+// Errors of a request that couldn't be executed, e.g. because it isn't valid
+type Errors []*Error
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Built-in scalars
+var (
+	Int = &Scalar{
+		Name:        "Int",
+		Description: "A signed 32-bit integer",
+		Serialize: func(v any) (any, error) {
+			n, ok := toInt(v)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int can't represent %v", v)
+			}
+			return n, nil
+		},
+		Parse: func(v any) (any, error) {
+			n, ok := toInt(v)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int can't represent %s", describe(v))
+			}
+			return int(n), nil
+		},
+	}
+
+	Float = &Scalar{
+		Name:        "Float",
+		Description: "A double-precision floating point number",
+		Serialize: func(v any) (any, error) {
+			f, ok := toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("Float can't represent %v", v)
+			}
+			return f, nil
+		},
+		Parse: func(v any) (any, error) {
+			switch v := v.(type) {
+			case int64:
+				return float64(v), nil
+			case float64:
+				return v, nil
+			}
+			return nil, fmt.Errorf("Float can't represent %s", describe(v))
+		},
+	}
+
+	String = &Scalar{
+		Name:        "String",
+		Description: "A UTF-8 character sequence",
+		Serialize: func(v any) (any, error) {
+			value := reflect.ValueOf(v)
+			if value.Kind() != reflect.String {
+				return nil, fmt.Errorf("String can't represent %v", v)
+			}
+			return value.String(), nil
+		},
+		Parse: func(v any) (any, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("String can't represent %s", describe(v))
+			}
+			return s, nil
+		},
+	}
+
+	Boolean = &Scalar{
+		Name:        "Boolean",
+		Description: "true or false",
+		Serialize: func(v any) (any, error) {
+			value := reflect.ValueOf(v)
+			if value.Kind() != reflect.Bool {
+				return nil, fmt.Errorf("Boolean can't represent %v", v)
+			}
+			return value.Bool(), nil
+		},
+		Parse: func(v any) (any, error) {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("Boolean can't represent %s", describe(v))
+			}
+			return b, nil
+		},
+	}
+
+	ID = &Scalar{
+		Name:        "ID",
+		Description: "A unique identifier, serialized as a string",
+		Serialize: func(v any) (any, error) {
+			if n, ok := toInt(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			return String.Serialize(v)
+		},
+		Parse: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			return nil, fmt.Errorf("ID can't represent %s", describe(v))
+		},
+	}
+)
+
+// Convert integers, and floats without a fractional part, to an int64
+func toInt(v any) (int64, bool) {
+	value := reflect.ValueOf(v)
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+
+	return 0, false
+}
+
+func toFloat(v any) (float64, bool) {
+	value := reflect.ValueOf(v)
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		return f, !math.IsInf(f, 0) && !math.IsNaN(f)
+	}
+
+	return 0, false
+}
+
+// Describe an input value for error messages
+func describe(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case nil:
+		return "null"
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,513 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Arguments of the @skip and @include directives
+var directiveArgs = []*Argument{{Name: "if", Type: NonNullOf(Boolean)}}
+
+// A variable used by an operation or fragment, along with the type expected where
+// it's used
+type variableUsage struct {
+	name string
+	typ  Type
+	// Whether the argument or input field where the variable is used has a default
+	hasDefault bool
+	loc        Location
+}
+
+// The variables a selection set uses and the fragments it spreads, directly
+type usages struct {
+	variables []variableUsage
+	spreads   []string
+}
+
+// Checks a document against the schema before it's executed, as described by the
+// validation section of the specification
+type validation struct {
+	schema    *Schema
+	fragments map[string]*fragmentDefinition
+	errors    Errors
+}
+
+func (v *validation) addError(loc Location, format string, a ...any) {
+	v.errors = append(v.errors, &Error{Message: fmt.Sprintf(format, a...), Locations: []Location{loc}})
+}
+
+// Validate a document, returning the errors found
+func (s *Schema) validate(doc *document) Errors {
+	v := &validation{schema: s, fragments: map[string]*fragmentDefinition{}}
+
+	operationNames := map[string]bool{}
+	for _, op := range doc.operations {
+		if op.name == "" && len(doc.operations) > 1 {
+			v.addError(op.loc, "an anonymous operation must be the only operation of the document")
+		}
+		if op.name != "" && operationNames[op.name] {
+			v.addError(op.loc, "there can be only one operation named %q", op.name)
+		}
+		operationNames[op.name] = true
+	}
+
+	for _, fragment := range doc.fragments {
+		if _, ok := v.fragments[fragment.name]; ok {
+			v.addError(fragment.loc, "there can be only one fragment named %q", fragment.name)
+			continue
+		}
+		v.fragments[fragment.name] = fragment
+	}
+
+	fragmentUsages := map[string]*usages{}
+	for _, fragment := range doc.fragments {
+		if v.fragments[fragment.name] != fragment {
+			continue
+		}
+
+		for _, d := range fragment.directives {
+			v.addError(d.loc, "directive @%s may not be used on fragment definitions", d.name)
+		}
+
+		u := &usages{}
+		fragmentUsages[fragment.name] = u
+
+		object, ok := v.typeCondition(fragment.typeCondition, fragment.loc)
+		if ok {
+			v.validateSelectionSet(object, fragment.selectionSet, u)
+		}
+	}
+
+	v.validateFragmentCycles(fragmentUsages)
+
+	usedFragments := map[string]bool{}
+	for _, op := range doc.operations {
+		var root *Object
+		switch op.operation {
+		case "query":
+			root = s.Query
+		case "mutation":
+			root = s.Mutation
+			if root == nil {
+				v.addError(op.loc, "the schema has no mutations")
+				continue
+			}
+		default:
+			v.addError(op.loc, "%s operations aren't supported", op.operation)
+			continue
+		}
+
+		for _, d := range op.directives {
+			v.addError(d.loc, "directive @%s may not be used on operations", d.name)
+		}
+
+		definitions := map[string]*variableDefinition{}
+		for _, definition := range op.variables {
+			if _, ok := definitions[definition.name]; ok {
+				v.addError(definition.loc, "there can be only one variable named $%s", definition.name)
+				continue
+			}
+			definitions[definition.name] = definition
+
+			t := s.typeOf(definition.typ)
+			switch {
+			case t == nil:
+				v.addError(definition.loc, "unknown type %s", definition.typ)
+			case !isInputType(t):
+				v.addError(definition.loc, "variable $%s can't be of non-input type %s", definition.name, t)
+			case definition.defaultValue != nil:
+				v.validateValue(definition.defaultValue, t, false, &usages{})
+			}
+		}
+
+		u := &usages{}
+		v.validateSelectionSet(root, op.selectionSet, u)
+
+		// Variables are those of the operation and of every fragment it spreads
+		variables := u.variables
+		pending := u.spreads
+		seen := map[string]bool{}
+		for len(pending) > 0 {
+			name := pending[0]
+			pending = pending[1:]
+			if seen[name] || fragmentUsages[name] == nil {
+				continue
+			}
+			seen[name] = true
+			usedFragments[name] = true
+
+			variables = append(variables, fragmentUsages[name].variables...)
+			pending = append(pending, fragmentUsages[name].spreads...)
+		}
+
+		used := map[string]bool{}
+		for _, usage := range variables {
+			used[usage.name] = true
+
+			definition, ok := definitions[usage.name]
+			if !ok {
+				if op.name != "" {
+					v.addError(usage.loc, "variable $%s is not defined by operation %q", usage.name, op.name)
+				} else {
+					v.addError(usage.loc, "variable $%s is not defined", usage.name)
+				}
+				continue
+			}
+
+			if t := s.typeOf(definition.typ); t != nil && !allowedVariablePosition(definition, t, usage) {
+				v.addError(
+					usage.loc,
+					"variable $%s of type %s is used in position expecting type %s",
+					usage.name, t, usage.typ,
+				)
+			}
+		}
+
+		for _, definition := range op.variables {
+			if !used[definition.name] {
+				v.addError(definition.loc, "variable $%s is never used", definition.name)
+			}
+		}
+	}
+
+	for _, fragment := range doc.fragments {
+		if !usedFragments[fragment.name] && v.fragments[fragment.name] == fragment {
+			v.addError(fragment.loc, "fragment %q is never used", fragment.name)
+		}
+	}
+
+	return v.errors
+}
+
+// Return the object type a fragment applies to
+func (v *validation) typeCondition(name string, loc Location) (*Object, bool) {
+	t, ok := v.schema.types[name]
+	if !ok {
+		v.addError(loc, "unknown type %q", name)
+		return nil, false
+	}
+
+	object, ok := t.(*Object)
+	if !ok {
+		v.addError(loc, "fragment can't condition on non-object type %q", name)
+		return nil, false
+	}
+
+	return object, true
+}
+
+// Check that fragments don't spread themselves, directly or not
+func (v *validation) validateFragmentCycles(fragmentUsages map[string]*usages) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		switch state[name] {
+		case visiting:
+			return false
+		case visited:
+			return true
+		}
+
+		state[name] = visiting
+		if u := fragmentUsages[name]; u != nil {
+			for _, spread := range u.spreads {
+				if !visit(spread) {
+					state[name] = visited
+					return false
+				}
+			}
+		}
+		state[name] = visited
+		return true
+	}
+
+	for name := range fragmentUsages {
+		if state[name] == unvisited && !visit(name) {
+			v.addError(v.fragments[name].loc, "fragment %q can't spread itself", name)
+		}
+	}
+}
+
+func (v *validation) validateSelectionSet(object *Object, selections []selection, u *usages) {
+	for _, s := range selections {
+		switch s := s.(type) {
+		case *fieldSelection:
+			v.validateDirectives(s.directives, u)
+			v.validateField(object, s, u)
+
+		case *fragmentSpread:
+			v.validateDirectives(s.directives, u)
+
+			fragment, ok := v.fragments[s.name]
+			if !ok {
+				v.addError(s.loc, "unknown fragment %q", s.name)
+				continue
+			}
+			u.spreads = append(u.spreads, s.name)
+
+			if fragment.typeCondition != object.Name {
+				v.addError(
+					s.loc,
+					"fragment %q can't be spread here as objects of type %q can never be of type %q",
+					s.name, object.Name, fragment.typeCondition,
+				)
+			}
+
+		case *inlineFragment:
+			v.validateDirectives(s.directives, u)
+
+			if s.typeCondition != "" {
+				condition, ok := v.typeCondition(s.typeCondition, s.loc)
+				if !ok {
+					continue
+				}
+				if condition != object {
+					v.addError(
+						s.loc,
+						"fragment can't be spread here as objects of type %q can never be of type %q",
+						object.Name, s.typeCondition,
+					)
+					continue
+				}
+			}
+
+			v.validateSelectionSet(object, s.selectionSet, u)
+		}
+	}
+}
+
+func (v *validation) validateField(object *Object, field *fieldSelection, u *usages) {
+	// Every object has a __typename meta-field
+	if field.name == "__typename" {
+		v.validateArguments(nil, field.arguments, field.loc, u)
+		if len(field.selectionSet) > 0 {
+			v.addError(field.loc, "field \"__typename\" must not have a selection since type \"String!\" has no subfields")
+		}
+		return
+	}
+
+	definition := object.field(field.name)
+	if definition == nil {
+		v.addError(field.loc, "cannot query field %q on type %q", field.name, object.Name)
+		return
+	}
+
+	v.validateArguments(definition.Args, field.arguments, field.loc, u)
+
+	if sub, ok := namedType(definition.Type).(*Object); ok {
+		if len(field.selectionSet) == 0 {
+			v.addError(
+				field.loc,
+				"field %q of type %q must have a selection of subfields",
+				field.name, definition.Type,
+			)
+			return
+		}
+		v.validateSelectionSet(sub, field.selectionSet, u)
+		return
+	}
+
+	if len(field.selectionSet) > 0 {
+		v.addError(
+			field.loc,
+			"field %q must not have a selection since type %q has no subfields",
+			field.name, definition.Type,
+		)
+	}
+}
+
+// Check that only @skip and @include are used, once at most, with their if argument
+func (v *validation) validateDirectives(directives []*directive, u *usages) {
+	seen := map[string]bool{}
+
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			v.addError(d.loc, "unknown directive @%s", d.name)
+			continue
+		}
+		if seen[d.name] {
+			v.addError(d.loc, "directive @%s can only be used once at this location", d.name)
+		}
+		seen[d.name] = true
+
+		v.validateArguments(directiveArgs, d.arguments, d.loc, u)
+	}
+}
+
+// Check the arguments of a field or directive at loc against their definitions
+func (v *validation) validateArguments(definitions []*Argument, arguments []*argument, loc Location, u *usages) {
+	seen := map[string]bool{}
+
+	for _, arg := range arguments {
+		if seen[arg.name] {
+			v.addError(arg.loc, "there can be only one argument named %q", arg.name)
+			continue
+		}
+		seen[arg.name] = true
+
+		definition := findArgument(definitions, arg.name)
+		if definition == nil {
+			v.addError(arg.loc, "unknown argument %q", arg.name)
+			continue
+		}
+
+		v.validateValue(arg.value, definition.Type, definition.Default != nil, u)
+	}
+
+	for _, definition := range definitions {
+		if _, ok := definition.Type.(*NonNull); ok && definition.Default == nil && !seen[definition.Name] {
+			v.addError(loc, "argument %q of type %q is required, but it was not provided", definition.Name, definition.Type)
+		}
+	}
+}
+
+func findArgument(definitions []*Argument, name string) *Argument {
+	for _, definition := range definitions {
+		if definition.Name == name {
+			return definition
+		}
+	}
+	return nil
+}
+
+// Check that a value given in the document can be coerced to the type t. Variables
+// are recorded, to check their types against the positions they're used in
+func (v *validation) validateValue(val *value, t Type, hasDefault bool, u *usages) {
+	if val.kind == valueVariable {
+		u.variables = append(u.variables, variableUsage{name: val.raw, typ: t, hasDefault: hasDefault, loc: val.loc})
+		return
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if val.kind == valueNull {
+			v.addError(val.loc, "expected value of type %q, found null", t)
+			return
+		}
+		t = nonNull.OfType
+	}
+
+	if val.kind == valueNull {
+		return
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if val.kind != valueList {
+			v.validateValue(val, t.OfType, false, u)
+			return
+		}
+		for _, item := range val.list {
+			v.validateValue(item, t.OfType, false, u)
+		}
+
+	case *InputObject:
+		if val.kind != valueObject {
+			v.addError(val.loc, "expected value of type %q, found %s", t.Name, val)
+			return
+		}
+
+		seen := map[string]bool{}
+		for _, field := range val.fields {
+			if seen[field.name] {
+				v.addError(field.loc, "there can be only one input field named %q", field.name)
+				continue
+			}
+			seen[field.name] = true
+
+			definition := t.field(field.name)
+			if definition == nil {
+				v.addError(field.loc, "field %q is not defined by type %q", field.name, t.Name)
+				continue
+			}
+			v.validateValue(field.value, definition.Type, definition.Default != nil, u)
+		}
+
+		for _, definition := range t.Fields {
+			if _, ok := definition.Type.(*NonNull); ok && definition.Default == nil && !seen[definition.Name] {
+				v.addError(
+					val.loc,
+					"field \"%s.%s\" of required type %q was not provided",
+					t.Name, definition.Name, definition.Type,
+				)
+			}
+		}
+
+	case *Scalar:
+		literal, err := literalValue(val)
+		if err == nil {
+			_, err = t.Parse(literal)
+		}
+		if err != nil {
+			v.addError(val.loc, "expected value of type %q, found %s; %s", t.Name, val, err)
+		}
+	}
+}
+
+// Return the Go value of a scalar literal, an int64 for Int literals that fit
+func literalValue(val *value) (any, error) {
+	switch val.kind {
+	case valueInt:
+		if n, err := strconv.ParseInt(val.raw, 10, 64); err == nil {
+			return n, nil
+		}
+		return strconv.ParseFloat(val.raw, 64)
+	case valueFloat:
+		return strconv.ParseFloat(val.raw, 64)
+	case valueString:
+		return val.raw, nil
+	case valueBoolean:
+		return val.raw == "true", nil
+	}
+	return nil, fmt.Errorf("%s isn't a scalar value", val)
+}
+
+// Whether a variable can be used where it is, as described by the "All Variable
+// Usages Are Allowed" rule
+func allowedVariablePosition(definition *variableDefinition, variableType Type, usage variableUsage) bool {
+	locationType := usage.typ
+
+	if nonNull, ok := locationType.(*NonNull); ok {
+		if _, ok := variableType.(*NonNull); !ok {
+			hasNonNullDefault := definition.defaultValue != nil && definition.defaultValue.kind != valueNull
+			if !hasNonNullDefault && !usage.hasDefault {
+				return false
+			}
+			locationType = nonNull.OfType
+		}
+	}
+
+	return isSubType(variableType, locationType)
+}
+
+// Whether values of the type t can be used where values of the type of are expected
+func isSubType(t, of Type) bool {
+	if nonNull, ok := of.(*NonNull); ok {
+		if tNonNull, ok := t.(*NonNull); ok {
+			return isSubType(tNonNull.OfType, nonNull.OfType)
+		}
+		return false
+	}
+
+	if tNonNull, ok := t.(*NonNull); ok {
+		return isSubType(tNonNull.OfType, of)
+	}
+
+	if list, ok := of.(*List); ok {
+		if tList, ok := t.(*List); ok {
+			return isSubType(tList.OfType, list.OfType)
+		}
+		return false
+	}
+
+	if _, ok := t.(*List); ok {
+		return false
+	}
+
+	return t == of
+}
@@ -0,0 +1,351 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+// documents to JSON values decoded into any (map[string]any, []any, etc).
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// A patch operation can't be applied to the document, e.g. its path doesn't exist
+	ErrInvalidOperation = errors.New("invalid patch operation")
+	// A test operation didn't match the document
+	ErrTestFailed = errors.New("patch test failed")
+)
+
+// Return the result of applying a merge patch to doc, as described by RFC 7396.
+// Objects are merged recursively, null removes a key and any other value replaces it.
+func MergePatch(doc any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	docObject, ok := doc.(map[string]any)
+	if !ok {
+		docObject = map[string]any{}
+	}
+
+	result := make(map[string]any, len(docObject))
+	for key, value := range docObject {
+		result[key] = value
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		result[key] = MergePatch(result[key], value)
+	}
+
+	return result
+}
+
+// A single JSON Patch operation. Value is kept raw to tell a null value from a missing one
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (op Operation) value() (any, error) {
+	if op.Value == nil {
+		return nil, fmt.Errorf("%w: %s %q is missing a value", ErrInvalidOperation, op.Op, op.Path)
+	}
+
+	var value any
+	err := json.Unmarshal(op.Value, &value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %q has an invalid value", ErrInvalidOperation, op.Op, op.Path)
+	}
+
+	return value, nil
+}
+
+// Apply a JSON Patch to doc as described by RFC 6902. Operations are applied in
+// order, and doc is left untouched if any of them fails.
+func Apply(doc any, operations []Operation) (any, error) {
+	// Work on a copy so a failing operation leaves nothing half applied
+	doc = deepCopy(doc)
+
+	for _, op := range operations {
+		var err error
+
+		switch op.Op {
+		case "add":
+			var value any
+			if value, err = op.value(); err == nil {
+				doc, err = add(doc, op.Path, value)
+			}
+
+		case "remove":
+			doc, _, err = remove(doc, op.Path)
+
+		case "replace":
+			var value any
+			if value, err = op.value(); err == nil && op.Path == "" {
+				doc = value
+			} else if err == nil {
+				if doc, _, err = remove(doc, op.Path); err == nil {
+					doc, err = add(doc, op.Path, value)
+				}
+			}
+
+		case "move":
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("%w: can't move %q into one of its children", ErrInvalidOperation, op.From)
+			}
+			var value any
+			if doc, value, err = remove(doc, op.From); err == nil {
+				doc, err = add(doc, op.Path, value)
+			}
+
+		case "copy":
+			var value any
+			if value, err = get(doc, op.From); err == nil {
+				doc, err = add(doc, op.Path, deepCopy(value))
+			}
+
+		case "test":
+			var expected, actual any
+			if expected, err = op.value(); err == nil {
+				if actual, err = get(doc, op.Path); err == nil && !reflect.DeepEqual(expected, actual) {
+					err = fmt.Errorf("%w: value at %q is not the expected one", ErrTestFailed, op.Path)
+				}
+			}
+
+		default:
+			err = fmt.Errorf("%w: unknown op %q", ErrInvalidOperation, op.Op)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// Split a JSON Pointer (RFC 6901) into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidOperation, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// Parse an array index, allowing "-" (one past the end) when adding
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	// Leading zeros and signs are not allowed by RFC 6901
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.ContainsAny(token, "+-") {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidOperation, token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidOperation, token)
+	}
+
+	last := length - 1
+	if allowEnd {
+		last = length
+	}
+	if i > last {
+		return 0, fmt.Errorf("%w: array index %d out of bounds", ErrInvalidOperation, i)
+	}
+
+	return i, nil
+}
+
+func get(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidOperation, pointer)
+			}
+			current = value
+
+		case []any:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[i]
+
+		default:
+			return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidOperation, pointer)
+		}
+	}
+
+	return current, nil
+}
+
+// Add value at pointer, returning the updated document. Arrays are replaced by
+// new slices, so parents must be updated with the returned value
+func add(doc any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	return addAt(doc, tokens, value, pointer)
+}
+
+func addAt(node any, tokens []string, value any, pointer string) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	token, rest := tokens[0], tokens[1:]
+
+	switch n := node.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidOperation, pointer)
+		}
+
+		updated, err := addAt(child, rest, value, pointer)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+		return n, nil
+
+	case []any:
+		if len(rest) == 0 {
+			i, err := arrayIndex(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+
+			result := make([]any, 0, len(n)+1)
+			result = append(result, n[:i]...)
+			result = append(result, value)
+			return append(result, n[i:]...), nil
+		}
+
+		i, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := addAt(n[i], rest, value, pointer)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidOperation, pointer)
+}
+
+// Remove the value at pointer, returning the updated document and the removed value
+func remove(doc any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("%w: can't remove the whole document", ErrInvalidOperation)
+	}
+
+	return removeAt(doc, tokens, pointer)
+}
+
+func removeAt(node any, tokens []string, pointer string) (any, any, error) {
+	token, rest := tokens[0], tokens[1:]
+
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidOperation, pointer)
+		}
+
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+
+		updated, removed, err := removeAt(child, rest, pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = updated
+		return n, removed, nil
+
+	case []any:
+		i, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(rest) == 0 {
+			result := make([]any, 0, len(n)-1)
+			result = append(result, n[:i]...)
+			return append(result, n[i+1:]...), n[i], nil
+		}
+
+		updated, removed, err := removeAt(n[i], rest, pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = updated
+		return n, removed, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: path %q does not exist", ErrInvalidOperation, pointer)
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, child := range v {
+			result[key] = deepCopy(child)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, child := range v {
+			result[i] = deepCopy(child)
+		}
+		return result
+	}
+	return value
+}
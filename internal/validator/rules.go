@@ -0,0 +1,281 @@
+package validator
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Rule checks a single field value against an optional tag parameter
+// (the "500" in "max=500"). It returns false and a message if the check failed.
+type Rule func(value reflect.Value, param string) (ok bool, message string)
+
+var emailRX = regexp.MustCompile(EmailRX)
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]Rule{
+		"required":        requiredRule,
+		"min":             minRule,
+		"max":             maxRule,
+		"unique":          uniqueRule,
+		"oneof":           oneOfRule,
+		"email":           emailRule,
+		"year_not_future": yearNotFutureRule,
+		"http_url":        httpURLRule,
+	}
+)
+
+// Register a custom rule to be used in `validate` struct tags.
+// Registering a name that already exists replaces the previous rule.
+func RegisterRule(name string, rule Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules[name] = rule
+}
+
+func lookupRule(name string) Rule {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return rules[name]
+}
+
+type tagRule struct {
+	name  string
+	param string
+}
+
+type fieldRules struct {
+	index []int
+	key   string
+	rules []tagRule
+}
+
+// Parsed `validate` tags, cached per struct type
+var structCache sync.Map
+
+// Check every field of a struct (or pointer to struct) against the rules declared
+// in its `validate` tag, e.g. `validate:"required,max=500"`. Errors are keyed by
+// the field's JSON name. Nil pointer fields are only checked by "required".
+func (v *Validator) Struct(s any) {
+	value := reflect.ValueOf(s)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: Struct called with non-struct type %s", value.Type()))
+	}
+
+	for _, field := range cachedFieldRules(value.Type()) {
+		fieldValue := value.FieldByIndex(field.index)
+
+		for _, tr := range field.rules {
+			rule := lookupRule(tr.name)
+			if rule == nil {
+				panic(fmt.Sprintf("validator: unknown rule %q on field %q", tr.name, field.key))
+			}
+
+			// Optional values are only checked when present
+			if fieldValue.Kind() == reflect.Pointer && tr.name != "required" {
+				if fieldValue.IsNil() {
+					continue
+				}
+				if ok, message := rule(fieldValue.Elem(), tr.param); !ok {
+					v.AddError(field.key, message)
+				}
+				continue
+			}
+
+			if ok, message := rule(fieldValue, tr.param); !ok {
+				v.AddError(field.key, message)
+			}
+		}
+	}
+}
+
+func cachedFieldRules(t reflect.Type) []fieldRules {
+	if cached, ok := structCache.Load(t); ok {
+		return cached.([]fieldRules)
+	}
+
+	fields := parseFieldRules(t, nil)
+	cached, _ := structCache.LoadOrStore(t, fields)
+	return cached.([]fieldRules)
+}
+
+func parseFieldRules(t reflect.Type, parentIndex []int) []fieldRules {
+	var fields []fieldRules
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		index := append(append([]int{}, parentIndex...), i)
+
+		// Promote the rules of embedded structs, like data.Filters in input structs
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, parseFieldRules(field.Type, index)...)
+			continue
+		}
+
+		tag := field.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		fr := fieldRules{index: index, key: fieldKey(field)}
+		for _, part := range strings.Split(tag, ",") {
+			name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "unique" {
+				checkUniqueField(field)
+			}
+			fr.rules = append(fr.rules, tagRule{name: name, param: param})
+		}
+
+		fields = append(fields, fr)
+	}
+
+	return fields
+}
+
+// Use the JSON name of a field as its error key, so errors match the request body
+func fieldKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func requiredRule(value reflect.Value, _ string) (bool, string) {
+	return !value.IsZero(), "must be provided"
+}
+
+func minRule(value reflect.Value, param string) (bool, string) {
+	switch value.Kind() {
+	case reflect.String:
+		n := mustParseInt(param)
+		return int64(len(value.String())) >= n, fmt.Sprintf("must be at least %d bytes long", n)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n := mustParseInt(param)
+		return int64(value.Len()) >= n, fmt.Sprintf("must contain at least %d %s", n, elements(n))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := mustParseInt(param)
+		return value.Int() >= n, fmt.Sprintf("must be greater than or equal to %d", n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := mustParseInt(param)
+		return value.Uint() >= uint64(n), fmt.Sprintf("must be greater than or equal to %d", n)
+	case reflect.Float32, reflect.Float64:
+		f := mustParseFloat(param)
+		return value.Float() >= f, fmt.Sprintf("must be greater than or equal to %s", param)
+	}
+	panic(fmt.Sprintf("validator: min rule not supported on %s", value.Kind()))
+}
+
+func maxRule(value reflect.Value, param string) (bool, string) {
+	switch value.Kind() {
+	case reflect.String:
+		n := mustParseInt(param)
+		return int64(len(value.String())) <= n, fmt.Sprintf("must not be more than %d bytes long", n)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n := mustParseInt(param)
+		return int64(value.Len()) <= n, fmt.Sprintf("must not contain more than %d %s", n, elements(n))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := mustParseInt(param)
+		return value.Int() <= n, fmt.Sprintf("must be less than or equal to %d", n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n := mustParseInt(param)
+		return value.Uint() <= uint64(n), fmt.Sprintf("must be less than or equal to %d", n)
+	case reflect.Float32, reflect.Float64:
+		f := mustParseFloat(param)
+		return value.Float() <= f, fmt.Sprintf("must be less than or equal to %s", param)
+	}
+	panic(fmt.Sprintf("validator: max rule not supported on %s", value.Kind()))
+}
+
+// Elements are used as map keys, so a struct declaring "unique" on a slice of
+// slices or maps panics when its rules are parsed instead of on a request
+func checkUniqueField(field reflect.StructField) {
+	t := field.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		panic(fmt.Sprintf("validator: unique rule not supported on %s field %q", t.Kind(), field.Name))
+	}
+	if !t.Elem().Comparable() {
+		panic(fmt.Sprintf("validator: unique rule on field %q of non-comparable %s elements", field.Name, t.Elem()))
+	}
+}
+
+func uniqueRule(value reflect.Value, _ string) (bool, string) {
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		panic(fmt.Sprintf("validator: unique rule not supported on %s", value.Kind()))
+	}
+
+	seen := make(map[any]bool, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		// Interface elements can still hold values that aren't comparable
+		if !value.Index(i).Comparable() {
+			return false, "must only contain values which can be compared"
+		}
+		element := value.Index(i).Interface()
+		if seen[element] {
+			return false, "must not contain duplicate values"
+		}
+		seen[element] = true
+	}
+
+	return true, ""
+}
+
+// Permitted values are separated by spaces, e.g. `validate:"oneof=asc desc"`
+func oneOfRule(value reflect.Value, param string) (bool, string) {
+	permitted := strings.Fields(param)
+	message := fmt.Sprintf("must be one of: %s", strings.Join(permitted, ", "))
+	return PermittedValue(fmt.Sprint(value.Interface()), permitted...), message
+}
+
+func emailRule(value reflect.Value, _ string) (bool, string) {
+	return Matches(value.String(), emailRX), "must be a valid email address"
+}
+
+func yearNotFutureRule(value reflect.Value, _ string) (bool, string) {
+	return value.Int() <= int64(time.Now().Year()), "must not be in the future"
+}
+
+func httpURLRule(value reflect.Value, _ string) (bool, string) {
+	u, err := url.Parse(value.String())
+	ok := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	return ok, "must be an absolute http or https URL"
+}
+
+func elements(n int64) string {
+	if n == 1 {
+		return "element"
+	}
+	return "elements"
+}
+
+func mustParseInt(param string) int64 {
+	n, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validator: invalid integer rule parameter %q", param))
+	}
+	return n
+}
+
+func mustParseFloat(param string) float64 {
+	f, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validator: invalid number rule parameter %q", param))
+	}
+	return f
+}
@@ -0,0 +1,224 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ruleInput struct {
+	Title    string   `json:"title" validate:"required,max=10"`
+	Year     int32    `json:"year" validate:"min=1888,year_not_future"`
+	Genres   []string `json:"genres" validate:"required,min=1,max=2,unique"`
+	Email    *string  `json:"email" validate:"email"`
+	Nickname *string  `json:"nickname" validate:"required"`
+	Order    string   `json:"order" validate:"oneof=asc desc"`
+	Score    float64  `json:"score" validate:"min=0.5,max=5"`
+	Hook     string   `json:"hook" validate:"http_url"`
+	Untagged string
+	Embedded
+}
+
+type Embedded struct {
+	Page int `json:"page" validate:"min=1"`
+}
+
+func validRuleInput() ruleInput {
+	nickname := "nick"
+	return ruleInput{
+		Title:    "Moana",
+		Year:     2016,
+		Genres:   []string{"animation"},
+		Nickname: &nickname,
+		Order:    "asc",
+		Score:    4.5,
+		Hook:     "https://example.com/hook",
+		Embedded: Embedded{Page: 1},
+	}
+}
+
+func TestStruct(t *testing.T) {
+	badEmail := "not an email"
+
+	tests := []struct {
+		name   string
+		change func(input *ruleInput)
+		errors map[string]string
+	}{
+		{
+			name:   "valid",
+			change: func(input *ruleInput) {},
+			errors: map[string]string{},
+		},
+		{
+			name:   "required",
+			change: func(input *ruleInput) { input.Title = ""; input.Genres = nil; input.Nickname = nil },
+			errors: map[string]string{
+				"title":    "must be provided",
+				"genres":   "must be provided",
+				"nickname": "must be provided",
+			},
+		},
+		{
+			name:   "string length",
+			change: func(input *ruleInput) { input.Title = strings.Repeat("a", 11) },
+			errors: map[string]string{"title": "must not be more than 10 bytes long"},
+		},
+		{
+			name:   "integer bounds",
+			change: func(input *ruleInput) { input.Year = 1700 },
+			errors: map[string]string{"year": "must be greater than or equal to 1888"},
+		},
+		{
+			name:   "year in the future",
+			change: func(input *ruleInput) { input.Year = int32(time.Now().Year() + 1) },
+			errors: map[string]string{"year": "must not be in the future"},
+		},
+		{
+			name:   "slice length and duplicates",
+			change: func(input *ruleInput) { input.Genres = []string{"a", "a", "b"} },
+			errors: map[string]string{"genres": "must not contain more than 2 elements"},
+		},
+		{
+			name:   "duplicates",
+			change: func(input *ruleInput) { input.Genres = []string{"a", "a"} },
+			errors: map[string]string{"genres": "must not contain duplicate values"},
+		},
+		{
+			name:   "optional value checked when present",
+			change: func(input *ruleInput) { input.Email = &badEmail },
+			errors: map[string]string{"email": "must be a valid email address"},
+		},
+		{
+			name:   "oneof",
+			change: func(input *ruleInput) { input.Order = "up" },
+			errors: map[string]string{"order": "must be one of: asc, desc"},
+		},
+		{
+			name:   "float bounds",
+			change: func(input *ruleInput) { input.Score = 0.1 },
+			errors: map[string]string{"score": "must be greater than or equal to 0.5"},
+		},
+		{
+			name:   "http url",
+			change: func(input *ruleInput) { input.Hook = "ftp://example.com" },
+			errors: map[string]string{"hook": "must be an absolute http or https URL"},
+		},
+		{
+			name:   "embedded struct",
+			change: func(input *ruleInput) { input.Page = 0 },
+			errors: map[string]string{"page": "must be greater than or equal to 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := validRuleInput()
+			tt.change(&input)
+
+			v := New()
+			v.Struct(&input)
+
+			if !reflect.DeepEqual(v.Errors, tt.errors) {
+				t.Errorf("got errors %v, want %v", v.Errors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestStructKeepsFirstError(t *testing.T) {
+	input := validRuleInput()
+	input.Title = ""
+
+	v := New()
+	v.AddError("title", "already checked")
+	v.Struct(input)
+
+	if got := v.Errors["title"]; got != "already checked" {
+		t.Errorf("got %q, want the first error to be kept", got)
+	}
+}
+
+func TestRegisterRule(t *testing.T) {
+	RegisterRule("even", func(value reflect.Value, _ string) (bool, string) {
+		return value.Int()%2 == 0, "must be even"
+	})
+
+	var input struct {
+		N int `json:"n" validate:"even"`
+	}
+	input.N = 3
+
+	v := New()
+	v.Struct(input)
+
+	if got := v.Errors["n"]; got != "must be even" {
+		t.Errorf("got %q, want the registered rule's message", got)
+	}
+}
+
+func TestStructPanicsOnUnknownRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	var input struct {
+		N int `validate:"no_such_rule"`
+	}
+	New().Struct(input)
+}
+
+func TestStructPanicsOnNonComparableUnique(t *testing.T) {
+	tests := []struct {
+		name  string
+		input any
+	}{
+		{
+			name: "slice of slices",
+			input: struct {
+				Tags [][]string `validate:"unique"`
+			}{},
+		},
+		{
+			name: "slice of maps",
+			input: &struct {
+				Tags *[]map[string]int `validate:"unique"`
+			}{},
+		},
+		{
+			name: "not a slice",
+			input: struct {
+				Tag string `validate:"unique"`
+			}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+
+			// Empty values, so only the type can trigger the panic
+			New().Struct(tt.input)
+		})
+	}
+}
+
+func TestUniqueInterfaceElements(t *testing.T) {
+	input := struct {
+		Values []any `validate:"unique"`
+	}{Values: []any{"a", []string{"b"}}}
+
+	v := New()
+	v.Struct(input)
+
+	if _, ok := v.Errors["values"]; !ok {
+		t.Error("got no error for a value which can't be compared")
+	}
+}
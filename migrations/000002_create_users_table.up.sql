@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    role TEXT NOT NULL DEFAULT 'user',
+    token_hash BLOB NOT NULL UNIQUE,    -- SHA-256 of the API token
+    version INTEGER NOT NULL DEFAULT 1,
+
+    CHECK (role IN ('user', 'admin'))
+);
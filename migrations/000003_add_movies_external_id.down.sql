@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_external_id_idx;
+
+ALTER TABLE movies DROP COLUMN external_id;
@@ -0,0 +1,4 @@
+-- Identifier of the movie in an external source, set by clients syncing from it
+ALTER TABLE movies ADD COLUMN external_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS movies_external_id_idx ON movies (external_id);
@@ -0,0 +1,4 @@
+-- Set when a movie is moved to the trash, it's purged once the retention period is over
+ALTER TABLE movies ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS movies_delete_revisions;
+
+DROP TABLE IF EXISTS movie_revisions;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    user_id INTEGER,                    -- NULL for anonymous and direct database changes
+    movie TEXT NOT NULL,                -- JSON snapshot of the movie at this version
+
+    PRIMARY KEY (movie_id, version)
+);
+
+-- Revisions go along with their movie when it's purged from the trash
+CREATE TRIGGER IF NOT EXISTS movies_delete_revisions
+AFTER DELETE ON movies
+BEGIN
+    DELETE FROM movie_revisions WHERE movie_id = OLD.id;
+END;
+
+-- Movies created before revisions were recorded start with their current version
+INSERT INTO movie_revisions (movie_id, version, created_at, movie)
+SELECT id, version, created_at, json_object(
+    'id', id,
+    'title', title,
+    'year', year,
+    'runtime', runtime || ' minutes',
+    'genres', json(genres),
+    'external_id', external_id,
+    'version', version
+)
+FROM movies;
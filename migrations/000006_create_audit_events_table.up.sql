@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    user_id INTEGER,                    -- NULL for anonymous and direct database changes
+    request_id TEXT NOT NULL DEFAULT '',
+    remote_ip TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    resource TEXT NOT NULL,             -- kind of record changed, e.g. movie
+    resource_id INTEGER NOT NULL,
+    before TEXT,                        -- JSON of the record before the change, NULL when created
+    after TEXT                          -- JSON of the record after the change, NULL when purged
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_resource_idx ON audit_events (resource, resource_id);
+CREATE INDEX IF NOT EXISTS audit_events_user_id_idx ON audit_events (user_id);
+
+-- Events are append-only
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update
+BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit events are append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete
+BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit events are append-only');
+END;
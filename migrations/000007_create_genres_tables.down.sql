@@ -0,0 +1,8 @@
+-- movies.genres keeps the canonical slugs, so there's nothing to migrate back
+DROP TRIGGER IF EXISTS movies_delete_genres;
+
+DROP TABLE IF EXISTS movie_genres;
+
+DROP TABLE IF EXISTS genre_aliases;
+
+DROP TABLE IF EXISTS genres;
@@ -0,0 +1,160 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    slug TEXT NOT NULL UNIQUE,          -- canonical identifier, e.g. science-fiction
+    name TEXT NOT NULL                  -- display name, e.g. Science Fiction
+);
+
+-- Alternative slugs mapped to a genre, e.g. sci-fi
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    alias TEXT PRIMARY KEY,
+    genre_id INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS genre_aliases_genre_id_idx ON genre_aliases (genre_id);
+
+-- movies.genres keeps the canonical slugs of a movie's genres, in order, for reads.
+-- This table is kept in sync with it for filtering and counts
+CREATE TABLE IF NOT EXISTS movie_genres (
+    movie_id INTEGER NOT NULL,
+    genre_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+
+    PRIMARY KEY (movie_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_genres_genre_id_idx ON movie_genres (genre_id);
+
+CREATE TRIGGER IF NOT EXISTS movies_delete_genres
+AFTER DELETE ON movies
+BEGIN
+    DELETE FROM movie_genres WHERE movie_id = OLD.id;
+END;
+
+-- Controlled vocabulary, a superset of the IMDb genres so its dump can be imported
+INSERT INTO genres (slug, name) VALUES
+    ('action', 'Action'),
+    ('adult', 'Adult'),
+    ('adventure', 'Adventure'),
+    ('animation', 'Animation'),
+    ('biography', 'Biography'),
+    ('comedy', 'Comedy'),
+    ('crime', 'Crime'),
+    ('documentary', 'Documentary'),
+    ('drama', 'Drama'),
+    ('family', 'Family'),
+    ('fantasy', 'Fantasy'),
+    ('film-noir', 'Film Noir'),
+    ('game-show', 'Game Show'),
+    ('history', 'History'),
+    ('horror', 'Horror'),
+    ('music', 'Music'),
+    ('musical', 'Musical'),
+    ('mystery', 'Mystery'),
+    ('news', 'News'),
+    ('reality-tv', 'Reality TV'),
+    ('romance', 'Romance'),
+    ('science-fiction', 'Science Fiction'),
+    ('sport', 'Sport'),
+    ('talk-show', 'Talk Show'),
+    ('thriller', 'Thriller'),
+    ('war', 'War'),
+    ('western', 'Western');
+
+INSERT INTO genre_aliases (alias, genre_id)
+SELECT aliases.column1, genres.id
+FROM (VALUES
+    ('sci-fi', 'science-fiction'),
+    ('scifi', 'science-fiction'),
+    ('animated', 'animation'),
+    ('biopic', 'biography'),
+    ('sports', 'sport'),
+    ('documentaries', 'documentary')
+) AS aliases
+JOIN genres ON genres.slug = aliases.column2;
+
+-- Existing free-text genres are slugged, mapped to the vocabulary through slugs or
+-- aliases, and added to it when they match neither.
+-- Slugs follow data.Slugify: lowercase letters and digits, with every run of other
+-- characters turned into a single dash. Past Latin-1, SQLite can't tell letters
+-- apart or lowercase them: characters other than general punctuation (dashes,
+-- quotes) count as letters and keep their case
+CREATE TEMP TABLE existing_genres AS
+WITH RECURSIVE
+    names(name) AS (
+        SELECT DISTINCT trim(genre.value)
+        FROM movies, json_each(movies.genres) AS genre
+    ),
+    chars(name, i, c) AS (
+        SELECT name, 1, substr(name, 1, 1) FROM names WHERE name != ''
+        UNION ALL
+        SELECT name, i + 1, substr(name, i + 1, 1) FROM chars WHERE i < length(name)
+    ),
+    classified(name, i, c, alnum) AS (
+        SELECT
+            name,
+            i,
+            CASE
+                WHEN c GLOB '[A-Z]' THEN lower(c)
+                WHEN unicode(c) BETWEEN 192 AND 222 AND unicode(c) != 215 THEN char(unicode(c) + 32)
+                ELSE c
+            END,
+            c GLOB '[A-Za-z0-9]'
+                OR unicode(c) IN (170, 181, 186)
+                OR (unicode(c) >= 192 AND unicode(c) NOT IN (215, 247)
+                    AND unicode(c) NOT BETWEEN 8192 AND 8303)
+        FROM chars
+    ),
+    slugs(name, i, slug, dash) AS (
+        SELECT name, 0, '', 0 FROM names
+        UNION ALL
+        SELECT
+            slugs.name,
+            classified.i,
+            CASE
+                WHEN classified.alnum AND slugs.dash AND slugs.slug != '' THEN slugs.slug || '-' || classified.c
+                WHEN classified.alnum THEN slugs.slug || classified.c
+                ELSE slugs.slug
+            END,
+            NOT classified.alnum
+        FROM slugs
+        JOIN classified ON classified.name = slugs.name AND classified.i = slugs.i + 1
+    )
+SELECT DISTINCT
+    movies.id AS movie_id,
+    genre.key AS position,
+    trim(genre.value) AS name,
+    slugs.slug AS slug
+FROM movies, json_each(movies.genres) AS genre
+JOIN slugs ON slugs.name = trim(genre.value) AND slugs.i = length(slugs.name)
+WHERE slugs.slug != '';
+
+INSERT OR IGNORE INTO genres (slug, name)
+SELECT slug, min(name)
+FROM existing_genres
+WHERE slug NOT IN (SELECT alias FROM genre_aliases)
+GROUP BY slug;
+
+INSERT OR IGNORE INTO movie_genres (movie_id, genre_id, position)
+SELECT existing_genres.movie_id, genres.id, min(existing_genres.position)
+FROM existing_genres
+JOIN genres ON genres.id = coalesce(
+    (SELECT genre_id FROM genre_aliases WHERE alias = existing_genres.slug),
+    (SELECT id FROM genres WHERE slug = existing_genres.slug)
+)
+GROUP BY existing_genres.movie_id, genres.id;
+
+UPDATE movies
+SET genres = (
+    SELECT json_group_array(slug)
+    FROM (
+        SELECT genres.slug
+        FROM movie_genres
+        JOIN genres ON genres.id = movie_genres.genre_id
+        WHERE movie_genres.movie_id = movies.id
+        ORDER BY movie_genres.position
+    )
+)
+WHERE id IN (SELECT movie_id FROM movie_genres);
+
+DROP TABLE existing_genres;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS movies_delete_credits;
+
+DROP TABLE IF EXISTS movie_credits;
+
+DROP TABLE IF EXISTS people;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS people (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    name TEXT NOT NULL,
+    birth_year INTEGER,
+    external_ids TEXT NOT NULL DEFAULT '{}',   -- JSON object, e.g. {"imdb": "nm0000138"}
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people (name);
+
+CREATE TABLE IF NOT EXISTS movie_credits (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    movie_id INTEGER NOT NULL,
+    person_id INTEGER NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('director', 'actor', 'writer')),
+    character TEXT,                           -- only for actors
+    position INTEGER NOT NULL                 -- billing order within the role
+);
+
+-- An actor may play several characters in a movie, but only once each
+CREATE UNIQUE INDEX IF NOT EXISTS movie_credits_unique_idx
+ON movie_credits (movie_id, person_id, role, IFNULL(character, ''));
+
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
+
+CREATE TRIGGER IF NOT EXISTS movies_delete_credits
+AFTER DELETE ON movies
+BEGIN
+    DELETE FROM movie_credits WHERE movie_id = OLD.id;
+END;
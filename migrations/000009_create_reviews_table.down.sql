@@ -0,0 +1,12 @@
+DROP TRIGGER IF EXISTS users_delete_reviews;
+DROP TRIGGER IF EXISTS movies_delete_reviews;
+DROP TRIGGER IF EXISTS reviews_delete_rating;
+DROP TRIGGER IF EXISTS reviews_update_rating;
+DROP TRIGGER IF EXISTS reviews_insert_rating;
+
+DROP INDEX IF EXISTS movies_rating_avg_idx;
+
+ALTER TABLE movies DROP COLUMN rating_count;
+ALTER TABLE movies DROP COLUMN rating_avg;
+
+DROP TABLE IF EXISTS reviews;
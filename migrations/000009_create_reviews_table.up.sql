@@ -0,0 +1,59 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    updated_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    movie_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    score INTEGER NOT NULL CHECK (score BETWEEN 1 AND 10),
+    text TEXT CHECK (length(text) <= 5000),
+    version INTEGER NOT NULL DEFAULT 1,
+
+    UNIQUE (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+-- Aggregated scores of the reviews, kept up to date by the triggers below.
+-- rating_avg is NULL until the movie is reviewed
+ALTER TABLE movies ADD COLUMN rating_avg REAL;
+ALTER TABLE movies ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_rating_avg_idx ON movies (rating_avg);
+
+CREATE TRIGGER IF NOT EXISTS reviews_insert_rating
+AFTER INSERT ON reviews
+BEGIN
+    UPDATE movies
+    SET rating_avg = (SELECT ROUND(AVG(score), 2) FROM reviews WHERE movie_id = NEW.movie_id),
+        rating_count = (SELECT COUNT(*) FROM reviews WHERE movie_id = NEW.movie_id)
+    WHERE id = NEW.movie_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS reviews_update_rating
+AFTER UPDATE OF score ON reviews
+BEGIN
+    UPDATE movies
+    SET rating_avg = (SELECT ROUND(AVG(score), 2) FROM reviews WHERE movie_id = NEW.movie_id)
+    WHERE id = NEW.movie_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS reviews_delete_rating
+AFTER DELETE ON reviews
+BEGIN
+    UPDATE movies
+    SET rating_avg = (SELECT ROUND(AVG(score), 2) FROM reviews WHERE movie_id = OLD.movie_id),
+        rating_count = (SELECT COUNT(*) FROM reviews WHERE movie_id = OLD.movie_id)
+    WHERE id = OLD.movie_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS movies_delete_reviews
+AFTER DELETE ON movies
+BEGIN
+    DELETE FROM reviews WHERE movie_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_delete_reviews
+AFTER DELETE ON users
+BEGIN
+    DELETE FROM reviews WHERE user_id = OLD.id;
+END;
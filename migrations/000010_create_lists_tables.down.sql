@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS users_delete_lists;
+DROP TRIGGER IF EXISTS lists_delete_items;
+DROP TRIGGER IF EXISTS movies_delete_list_items;
+
+DROP TABLE IF EXISTS list_items;
+
+DROP TABLE IF EXISTS lists;
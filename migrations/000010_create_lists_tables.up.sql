@@ -0,0 +1,51 @@
+-- Movie collections curated by users, e.g. "Watch later"
+CREATE TABLE IF NOT EXISTS lists (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    updated_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT,
+    public BOOLEAN NOT NULL DEFAULT 0,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS lists_user_id_idx ON lists (user_id);
+
+CREATE TABLE IF NOT EXISTS list_items (
+    list_id INTEGER NOT NULL,
+    movie_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,          -- 0 for the first item, without gaps
+    added_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+
+    PRIMARY KEY (list_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS list_items_movie_id_idx ON list_items (movie_id);
+
+-- Movies in the trash stay in lists, hidden, until they're purged
+CREATE TRIGGER IF NOT EXISTS movies_delete_list_items
+AFTER DELETE ON movies
+BEGIN
+    UPDATE list_items
+    SET position = position - 1
+    WHERE list_id IN (SELECT list_id FROM list_items WHERE movie_id = OLD.id)
+    AND position > (
+        SELECT position FROM list_items AS purged
+        WHERE purged.list_id = list_items.list_id AND purged.movie_id = OLD.id
+    );
+
+    DELETE FROM list_items WHERE movie_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS lists_delete_items
+AFTER DELETE ON lists
+BEGIN
+    DELETE FROM list_items WHERE list_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_delete_lists
+AFTER DELETE ON users
+BEGIN
+    DELETE FROM lists WHERE user_id = OLD.id;
+END;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS users_delete_idempotency_keys;
+
+DROP INDEX IF EXISTS idempotency_keys_expires_at_idx;
+
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,23 @@
+-- Responses of requests sent with an Idempotency-Key header, replayed when they're
+-- retried. status is NULL while the first request with the key is in flight.
+-- Anonymous requests share user_id 0
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INTEGER NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status INTEGER,
+    header TEXT,
+    body BLOB,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    expires_at TIMESTAMP NOT NULL,
+
+    PRIMARY KEY (user_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+CREATE TRIGGER IF NOT EXISTS users_delete_idempotency_keys
+AFTER DELETE ON users
+BEGIN
+    DELETE FROM idempotency_keys WHERE user_id = OLD.id;
+END;
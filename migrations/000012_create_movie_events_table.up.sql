@@ -0,0 +1,9 @@
+-- Log of the changes to movies, streamed by GET /v1/movies/events. Ids always
+-- increase, so clients can resume after the last event they received
+CREATE TABLE IF NOT EXISTS movie_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    type TEXT NOT NULL,                 -- created, updated, deleted, restored or purged
+    movie_id INTEGER NOT NULL,
+    version INTEGER NOT NULL            -- version of the movie after the change
+);
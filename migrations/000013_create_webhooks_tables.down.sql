@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS movie_events_delete_deliveries;
+DROP TRIGGER IF EXISTS webhooks_delete_deliveries;
+
+DROP INDEX IF EXISTS webhook_deliveries_next_attempt_at_idx;
+DROP INDEX IF EXISTS webhook_deliveries_event_id_idx;
+DROP INDEX IF EXISTS webhook_deliveries_webhook_id_idx;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
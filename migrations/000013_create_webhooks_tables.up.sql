@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    updated_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    url TEXT NOT NULL,
+    events TEXT NOT NULL DEFAULT '[]',  -- JSON array of the event types sent, all of them if empty
+    secret TEXT NOT NULL,               -- key of the HMAC signatures of the deliveries
+    active BOOLEAN NOT NULL DEFAULT 1,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+-- Outbox of the events to send to webhooks, written in the same transaction as the
+-- change to the movie. Pending deliveries are sent once next_attempt_at is past,
+-- and become dead once they failed too many times
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT current_timestamp,
+    webhook_id INTEGER NOT NULL,
+    event_id INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP,          -- NULL unless pending
+    last_status INTEGER,                -- HTTP status of the last attempt, NULL if it got none
+    last_error TEXT,
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, status);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_event_id_idx ON webhook_deliveries (event_id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_next_attempt_at_idx ON webhook_deliveries (next_attempt_at)
+    WHERE status = 'pending';
+
+CREATE TRIGGER IF NOT EXISTS webhooks_delete_deliveries
+AFTER DELETE ON webhooks
+BEGIN
+    DELETE FROM webhook_deliveries WHERE webhook_id = OLD.id;
+END;
+
+-- Deliveries go with their event once it's pruned from the change log
+CREATE TRIGGER IF NOT EXISTS movie_events_delete_deliveries
+AFTER DELETE ON movie_events
+BEGIN
+    DELETE FROM webhook_deliveries WHERE event_id = OLD.id;
+END;
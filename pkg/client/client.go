@@ -0,0 +1,217 @@
+// Package client is a Go client for the Greenlight JSON API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("greenlight: record not found")
+	ErrEditConflict = errors.New("greenlight: edit conflict")
+)
+
+// Returned when the API rejects the input with a 422, Errors is keyed by field name
+type ValidationError struct {
+	Errors map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Errors))
+	for field, message := range e.Errors {
+		fields = append(fields, fmt.Sprintf("%s %s", field, message))
+	}
+	return "greenlight: validation failed: " + strings.Join(fields, "; ")
+}
+
+// Any other error response returned by the API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("greenlight: %d %s", e.StatusCode, e.Message)
+}
+
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// API token sent as a bearer token, requests are anonymous if empty.
+	// Changes to the catalog require the token of an admin
+	Token string
+	// Number of times a failed idempotent request is retried
+	MaxRetries int
+	// Initial wait between retries, doubled on every attempt
+	RetryWait time.Duration
+}
+
+// Create a new Client for the API served at baseURL, e.g. "http://localhost:4000"
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: 3,
+		RetryWait:  100 * time.Millisecond,
+	}
+}
+
+// Send a request to the API and decode the response envelope into dst.
+// Idempotent requests are retried on network errors and temporary failures.
+func (c *Client) do(
+	ctx context.Context,
+	method, path string,
+	query url.Values,
+	headers http.Header,
+	body any,
+	dst any,
+) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	retries := 0
+	if isIdempotent(method) {
+		retries = c.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		for key, values := range headers {
+			req.Header[key] = values
+		}
+		req.Header.Set("Accept", "application/json")
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		res, err := c.HTTPClient.Do(req)
+		if err != nil {
+			if attempt < retries && ctx.Err() == nil {
+				if err := c.wait(ctx, attempt, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+
+		if attempt < retries && isTemporary(res.StatusCode) {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if err := c.wait(ctx, attempt, res); err != nil {
+				return err
+			}
+			continue
+		}
+
+		return decodeResponse(res, dst)
+	}
+}
+
+// Sleep before the next attempt, honoring the Retry-After header if present
+func (c *Client) wait(ctx context.Context, attempt int, res *http.Response) error {
+	delay := c.RetryWait << attempt
+	// Add up to 50% of jitter so clients don't retry in lockstep
+	delay += time.Duration(rand.Int64N(int64(delay)/2 + 1))
+
+	if res != nil {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			delay = time.Duration(seconds) * time.Second
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func decodeResponse(res *http.Response, dst any) error {
+	defer res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		if dst == nil {
+			return nil
+		}
+		return json.NewDecoder(res.Body).Decode(dst)
+	}
+
+	var errorEnvelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+
+	err := json.NewDecoder(res.Body).Decode(&errorEnvelope)
+	if err != nil {
+		return &APIError{StatusCode: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+	}
+
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrEditConflict
+	case http.StatusUnprocessableEntity:
+		var fieldErrors map[string]string
+		if json.Unmarshal(errorEnvelope.Error, &fieldErrors) == nil {
+			return &ValidationError{Errors: fieldErrors}
+		}
+	}
+
+	var message string
+	if json.Unmarshal(errorEnvelope.Error, &message) != nil {
+		message = string(errorEnvelope.Error)
+	}
+
+	return &APIError{StatusCode: res.StatusCode, Message: message}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isTemporary(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,357 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Runtime of a movie in minutes
+type Runtime int32
+
+// Encode the runtime in the "<runtime> mins" format expected by the API
+func (r Runtime) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(fmt.Sprintf("%d mins", r))), nil
+}
+
+// Decode the "<runtime> minutes" format returned by the API
+func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
+	unquoted, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return fmt.Errorf("greenlight: invalid runtime %s", jsonValue)
+	}
+
+	number, unit, _ := strings.Cut(unquoted, " ")
+	if unit != "minutes" && unit != "mins" {
+		return fmt.Errorf("greenlight: invalid runtime %s", jsonValue)
+	}
+
+	i, err := strconv.ParseInt(number, 10, 32)
+	if err != nil {
+		return fmt.Errorf("greenlight: invalid runtime %s", jsonValue)
+	}
+
+	*r = Runtime(i)
+	return nil
+}
+
+type Movie struct {
+	ID      int64    `json:"id,omitempty"`
+	Title   string   `json:"title"`
+	Year    int32    `json:"year,omitempty"`
+	Runtime Runtime  `json:"runtime,omitempty"`
+	Genres  []string `json:"genres,omitempty"`
+	// Identifier of the movie in an external source, empty if there's none
+	ExternalID string `json:"external_id,omitempty"`
+	// Average score of the movie's reviews and their number, RatingAvg is nil until
+	// the movie is reviewed
+	RatingAvg   *float64 `json:"rating_avg,omitempty"`
+	RatingCount int32    `json:"rating_count,omitempty"`
+	// When the movie was moved to the trash, nil unless it was deleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Version   int32      `json:"version,omitempty"`
+}
+
+// Body of create and replace requests, which must not carry the id
+type movieInput struct {
+	Title      string   `json:"title"`
+	Year       int32    `json:"year"`
+	Runtime    Runtime  `json:"runtime"`
+	Genres     []string `json:"genres"`
+	ExternalID string   `json:"external_id,omitempty"`
+}
+
+func newMovieInput(movie Movie) movieInput {
+	return movieInput{movie.Title, movie.Year, movie.Runtime, movie.Genres, movie.ExternalID}
+}
+
+// Partial update of a movie, only non-nil fields are sent
+type MovieUpdate struct {
+	Title   *string  `json:"title,omitempty"`
+	Year    *int32   `json:"year,omitempty"`
+	Runtime *Runtime `json:"runtime,omitempty"`
+	Genres  []string `json:"genres,omitempty"`
+}
+
+// Pagination information of a page of movies
+type Metadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+type ListMoviesParams struct {
+	Title    string
+	Genres   []string
+	Sort     string
+	PageSize int
+	// First page to fetch, defaults to 1
+	Page int
+}
+
+func (c *Client) CreateMovie(ctx context.Context, movie Movie) (*Movie, error) {
+	input := newMovieInput(movie)
+
+	var output struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	err := c.do(ctx, http.MethodPost, "/v1/movies", nil, nil, input, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Movie, nil
+}
+
+func (c *Client) GetMovie(ctx context.Context, id int64) (*Movie, error) {
+	var output struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/v1/movies/%d", id), nil, nil, nil, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Movie, nil
+}
+
+// Apply a partial update to a movie. If expectedVersion is not zero, the update
+// fails with ErrEditConflict unless the movie is still at that version.
+func (c *Client) UpdateMovie(
+	ctx context.Context,
+	id int64,
+	expectedVersion int32,
+	update MovieUpdate,
+) (*Movie, error) {
+	headers := make(http.Header)
+	if expectedVersion != 0 {
+		headers.Set("X-Expected-Version", strconv.FormatInt(int64(expectedVersion), 10))
+	}
+
+	var output struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	path := fmt.Sprintf("/v1/movies/%d", id)
+	err := c.do(ctx, http.MethodPatch, path, nil, headers, update, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Movie, nil
+}
+
+// Replace a movie with the given one, leaving its id, and clearing any field not set
+// on movie. If expectedVersion is not zero, it fails with ErrEditConflict unless the
+// movie is still at that version.
+func (c *Client) ReplaceMovie(ctx context.Context, id int64, expectedVersion int32, movie Movie) (*Movie, error) {
+	return c.putMovie(ctx, fmt.Sprintf("/v1/movies/%d", id), expectedVersion, movie)
+}
+
+// Create the movie with movie.ExternalID, or replace the movie that already has it.
+// If expectedVersion is not zero, the movie must exist and still be at that version,
+// otherwise it fails with ErrEditConflict. Safe to retry, unlike CreateMovie.
+func (c *Client) UpsertMovie(ctx context.Context, expectedVersion int32, movie Movie) (*Movie, error) {
+	if movie.ExternalID == "" {
+		return nil, errors.New("greenlight: upserting a movie requires an external id")
+	}
+
+	path := "/v1/movies/external/" + url.PathEscape(movie.ExternalID)
+	return c.putMovie(ctx, path, expectedVersion, movie)
+}
+
+func (c *Client) putMovie(ctx context.Context, path string, expectedVersion int32, movie Movie) (*Movie, error) {
+	headers := make(http.Header)
+	if expectedVersion != 0 {
+		headers.Set("X-Expected-Version", strconv.FormatInt(int64(expectedVersion), 10))
+	}
+
+	var output struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	err := c.do(ctx, http.MethodPut, path, nil, headers, newMovieInput(movie), &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Movie, nil
+}
+
+// Move a movie to the trash, it can be restored with RestoreMovie until it's purged
+func (c *Client) DeleteMovie(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/v1/movies/%d", id), nil, nil, nil, nil)
+}
+
+// Take a deleted movie out of the trash, ErrNotFound if it isn't there. Requires an admin token
+func (c *Client) RestoreMovie(ctx context.Context, id int64) (*Movie, error) {
+	var output struct {
+		Movie *Movie `json:"movie"`
+	}
+
+	path := fmt.Sprintf("/v1/movies/%d/restore", id)
+	err := c.do(ctx, http.MethodPost, path, nil, nil, nil, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Movie, nil
+}
+
+// Iterate over the pages of movies matching params:
+//
+//	pages := c.ListMovies(ctx, client.ListMoviesParams{Sort: "-year"})
+//	for pages.Next() {
+//		for _, movie := range pages.Movies() { ... }
+//	}
+//	if err := pages.Err(); err != nil { ... }
+func (c *Client) ListMovies(ctx context.Context, params ListMoviesParams) *MoviePages {
+	return &MoviePages{client: c, ctx: ctx, params: params}
+}
+
+// Iterator over the pages of a movie listing
+type MoviePages struct {
+	client   *Client
+	ctx      context.Context
+	params   ListMoviesParams
+	page     int
+	started  bool
+	movies   []*Movie
+	metadata Metadata
+	err      error
+}
+
+// Fetch the next page, returns false when there are no more pages or on error
+func (p *MoviePages) Next() bool {
+	if p.err != nil {
+		return false
+	}
+	if p.started && p.page >= p.metadata.LastPage {
+		return false
+	}
+
+	if p.started {
+		p.page++
+	} else {
+		p.page = max(p.params.Page, 1)
+		p.started = true
+	}
+
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(p.page))
+	if p.params.Title != "" {
+		query.Set("title", p.params.Title)
+	}
+	if len(p.params.Genres) > 0 {
+		query.Set("genres", strings.Join(p.params.Genres, ","))
+	}
+	if p.params.Sort != "" {
+		query.Set("sort", p.params.Sort)
+	}
+	if p.params.PageSize > 0 {
+		query.Set("page_size", strconv.Itoa(p.params.PageSize))
+	}
+
+	var output struct {
+		Movies   []*Movie `json:"movies"`
+		Metadata Metadata `json:"metadata"`
+	}
+
+	err := p.client.do(p.ctx, http.MethodGet, "/v1/movies", query, nil, nil, &output)
+	if err != nil {
+		p.err = err
+		return false
+	}
+
+	p.movies = output.Movies
+	p.metadata = output.Metadata
+
+	return len(p.movies) > 0
+}
+
+// Movies of the current page
+func (p *MoviePages) Movies() []*Movie {
+	return p.movies
+}
+
+// Pagination information of the current page
+func (p *MoviePages) Metadata() Metadata {
+	return p.metadata
+}
+
+// First error faced while iterating, if any
+func (p *MoviePages) Err() error {
+	return p.err
+}
+
+// Collect the movies of every page
+func (p *MoviePages) All() ([]*Movie, error) {
+	var movies []*Movie
+	for p.Next() {
+		movies = append(movies, p.movies...)
+	}
+	return movies, p.Err()
+}
+
+// Formats accepted by ImportMovies
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+	ImportIMDb   = "imdb"
+)
+
+type ImportRow struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"` // created, skipped or invalid
+	ID     int64             `json:"id,omitempty"`
+	Reason string            `json:"reason,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+type ImportReport struct {
+	Created int         `json:"created"`
+	Skipped int         `json:"skipped"`
+	Invalid int         `json:"invalid"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// Stream movies in the given format to the import endpoint. The body is sent as
+// it's read, so imports are never retried.
+func (c *Client) ImportMovies(ctx context.Context, body io.Reader, format string) (*ImportReport, error) {
+	target := c.BaseURL + "/v1/movies/import?" + url.Values{"format": {format}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var output struct {
+		Report *ImportReport `json:"report"`
+	}
+
+	err = decodeResponse(res, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Report, nil
+}